@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"container-manager/services"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/spf13/cobra"
+)
+
+// caCmd groups the cluster certificate authority commands
+var caCmd = &cobra.Command{
+	Use:   "ca",
+	Short: "manage the cluster certificate authority",
+}
+
+// caInitCmd generates a new cluster CA key pair
+var caInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "generate a new cluster CA key pair",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outDir, _ := cmd.Flags().GetString("out-dir")
+
+		privKey, pubKey, err := crypto.GenerateEd25519Key(rand.Reader)
+		if err != nil {
+			return fmt.Errorf("failed to generate CA key: %w", err)
+		}
+
+		privBytes, err := crypto.MarshalPrivateKey(privKey)
+		if err != nil {
+			return fmt.Errorf("failed to marshal CA private key: %w", err)
+		}
+		pubBytes, err := crypto.MarshalPublicKey(pubKey)
+		if err != nil {
+			return fmt.Errorf("failed to marshal CA public key: %w", err)
+		}
+
+		if err := os.MkdirAll(outDir, 0o700); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+		keyPath := filepath.Join(outDir, "ca.key")
+		if err := os.WriteFile(keyPath, privBytes, 0o600); err != nil {
+			return fmt.Errorf("failed to write CA private key: %w", err)
+		}
+		pubPath := filepath.Join(outDir, "ca.pub")
+		if err := os.WriteFile(pubPath, pubBytes, 0o644); err != nil {
+			return fmt.Errorf("failed to write CA public key: %w", err)
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "CA private key: %s\nCA public key: %s\n", keyPath, pubPath)
+		return nil
+	},
+}
+
+// caSignCmd issues a certificate for a peer ID
+var caSignCmd = &cobra.Command{
+	Use:   "sign <peer-id>",
+	Short: "issue a node certificate for a peer ID",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keyPath, _ := cmd.Flags().GetString("ca-key")
+		outPath, _ := cmd.Flags().GetString("out")
+
+		id, err := peer.Decode(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid peer ID: %w", err)
+		}
+
+		caKey, err := services.LoadPrivateKey(keyPath)
+		if err != nil {
+			return fmt.Errorf("failed to load CA key: %w", err)
+		}
+
+		certificate, err := services.IssuePeerCertificate(caKey, id)
+		if err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(outPath, certificate, 0o644); err != nil {
+			return fmt.Errorf("failed to write certificate: %w", err)
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "certificate for %s written to %s\n", id, outPath)
+		return nil
+	},
+}
+
+// init initializes the ca commands and their flags
+func init() {
+	caInitCmd.Flags().String("out-dir", ".", "the directory to write the CA key pair to")
+	caSignCmd.Flags().String("ca-key", "ca.key", "the CA private key file")
+	caSignCmd.Flags().String("out", "node.cert", "the file to write the certificate to")
+
+	caCmd.AddCommand(caInitCmd, caSignCmd)
+	rootCmd.AddCommand(caCmd)
+}
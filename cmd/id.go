@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"container-manager/services"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/spf13/cobra"
+)
+
+// idCmd prints the identity of the node
+var idCmd = &cobra.Command{
+	Use:   "id",
+	Short: "print the peer ID and multiaddrs of the node",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		identity, err := services.LoadOrCreateIdentity(config.DataDir)
+		if err != nil {
+			return err
+		}
+
+		id, err := peer.IDFromPrivateKey(identity)
+		if err != nil {
+			return fmt.Errorf("failed to derive peer ID: %w", err)
+		}
+
+		listenAddr, err := services.ListenMultiaddr(config.P2PPort)
+		if err != nil {
+			return err
+		}
+
+		addrs, err := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{ID: id, Addrs: []multiaddr.Multiaddr{listenAddr}})
+		if err != nil {
+			return fmt.Errorf("failed to build multiaddrs: %w", err)
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Peer ID: %s\nAddresses:\n", id)
+		for _, addr := range addrs {
+			fmt.Fprintf(cmd.OutOrStdout(), "  %s\n", addr)
+		}
+		return nil
+	},
+}
+
+// init registers the id command
+func init() {
+	rootCmd.AddCommand(idCmd)
+}
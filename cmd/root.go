@@ -4,11 +4,16 @@ import (
 	cfg "container-manager/config"
 	"container-manager/handler"
 	"container-manager/services"
+	"container-manager/types"
 	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
 
 	"github.com/gorilla/rpc/v2"
 	"github.com/gorilla/rpc/v2/json"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -55,6 +60,18 @@ func init() {
 		config.WorkerCount,
 		"the number of workers to run",
 	)
+	rootCmd.Flags().IntVar(
+		&config.JobRetries,
+		"job-retries",
+		config.JobRetries,
+		"the number of times a failed or unhealthy job is restarted before it fails",
+	)
+	rootCmd.Flags().IntVar(
+		&config.CrashLoopThreshold,
+		"crash-loop-threshold",
+		config.CrashLoopThreshold,
+		"the number of consecutive crashes of the containers of a job before it fails",
+	)
 	rootCmd.Flags().StringVar(
 		&config.ListenAddress,
 		"listen-address",
@@ -62,7 +79,193 @@ func init() {
 		"the address to listen on",
 	)
 	rootCmd.Flags().IntVar(&config.JRPCPort, "jrpc-port", config.JRPCPort, "the jrpc-port to listen on")
-	rootCmd.Flags().IntVar(&config.P2PPort, "p2p-port", config.P2PPort, "the p2p-port to listen on")
+	rootCmd.PersistentFlags().IntVar(&config.P2PPort, "p2p-port", config.P2PPort, "the p2p-port to listen on")
+	rootCmd.PersistentFlags().StringVar(
+		&config.DataDir,
+		"data-dir",
+		config.DataDir,
+		"the directory the node keeps its identity and state in",
+	)
+	rootCmd.Flags().StringSliceVar(
+		&config.ImageAllowlist,
+		"image-allow",
+		config.ImageAllowlist,
+		"image patterns allowed to run, all images are allowed when empty",
+	)
+	rootCmd.Flags().StringSliceVar(
+		&config.ImageDenylist,
+		"image-deny",
+		config.ImageDenylist,
+		"image patterns that are never allowed to run",
+	)
+	rootCmd.Flags().BoolVar(
+		&config.RequireImageDigest,
+		"require-image-digest",
+		config.RequireImageDigest,
+		"reject images that are not pinned to a digest",
+	)
+	rootCmd.Flags().BoolVar(
+		&config.PinImageDigests,
+		"pin-image-digests",
+		config.PinImageDigests,
+		"resolve image tags to digests at admission time",
+	)
+	rootCmd.Flags().StringSliceVar(
+		&config.AllowedCapabilities,
+		"allowed-capability",
+		config.AllowedCapabilities,
+		"capabilities containers may add, none when empty and every one with ALL",
+	)
+	rootCmd.Flags().BoolVar(
+		&config.AllowUnconfined,
+		"allow-unconfined",
+		config.AllowUnconfined,
+		"allow containers to run without the seccomp or AppArmor profile of the runtime",
+	)
+	rootCmd.Flags().BoolVar(
+		&config.RequireNonRoot,
+		"require-non-root",
+		config.RequireNonRoot,
+		"reject containers that don't run as a user other than root",
+	)
+	rootCmd.Flags().BoolVar(
+		&config.RequireNoNewPrivileges,
+		"require-no-new-privileges",
+		config.RequireNoNewPrivileges,
+		"reject containers that don't set no_new_privileges",
+	)
+	rootCmd.Flags().BoolVar(
+		&config.RequireReadOnlyRootFS,
+		"require-read-only-rootfs",
+		config.RequireReadOnlyRootFS,
+		"reject containers that don't mount their root filesystem read-only",
+	)
+	rootCmd.Flags().StringVar(
+		&config.SeccompProfileDir,
+		"seccomp-profile-dir",
+		config.SeccompProfileDir,
+		"the directory of the seccomp profiles containers reference by name, as <name>.json",
+	)
+	rootCmd.Flags().StringSliceVar(
+		&config.TrustedPeers,
+		"trusted-peer",
+		config.TrustedPeers,
+		"peer IDs trusted to connect and send jobs",
+	)
+	rootCmd.Flags().StringVar(
+		&config.ClusterCAFile,
+		"cluster-ca",
+		config.ClusterCAFile,
+		"the cluster CA public key file, peers certified by it are trusted",
+	)
+	rootCmd.Flags().StringVar(
+		&config.NodeCertificateFile,
+		"node-cert",
+		config.NodeCertificateFile,
+		"the certificate issued to this node by the cluster CA",
+	)
+	rootCmd.Flags().StringVar(
+		&config.PSKFile,
+		"psk-file",
+		config.PSKFile,
+		"the pre-shared key file of the private network",
+	)
+	rootCmd.Flags().StringSliceVar(
+		&config.BootstrapPeers,
+		"bootstrap-peer",
+		config.BootstrapPeers,
+		"multiaddrs of the peers to connect to on start",
+	)
+	rootCmd.Flags().StringSliceVar(
+		&config.Discovery,
+		"discovery",
+		config.Discovery,
+		"the peer discovery mechanisms to run (mdns, dht)",
+	)
+	rootCmd.Flags().StringVar(
+		&config.PlacementStrategy,
+		"placement-strategy",
+		config.PlacementStrategy,
+		"the strategy used to place jobs on nodes (least-loaded, bin-pack, image-locality)",
+	)
+	rootCmd.Flags().StringToStringVar(
+		&config.NodeLabels,
+		"node-label",
+		config.NodeLabels,
+		"labels of the node advertised to peers, as key=value",
+	)
+	rootCmd.Flags().StringVar(
+		&config.Runtime,
+		"runtime",
+		config.Runtime,
+		"the container runtime that runs the containers of jobs (docker, containerd, podman, fake)",
+	)
+	rootCmd.Flags().StringVar(
+		&config.RuntimeEndpoint,
+		"runtime-endpoint",
+		config.RuntimeEndpoint,
+		"the address of the container runtime API, the default of the runtime when empty",
+	)
+	rootCmd.Flags().StringVar(
+		&config.RuntimeNamespace,
+		"runtime-namespace",
+		config.RuntimeNamespace,
+		"the namespace the containers are created in, for containerd",
+	)
+	rootCmd.Flags().StringVar(
+		&config.OrphanPolicy,
+		"orphan-policy",
+		config.OrphanPolicy,
+		"what to do on startup with the containers the node created before it restarted (adopt, remove, ignore)",
+	)
+	rootCmd.Flags().Int64Var(
+		&config.MaxCopySize,
+		"max-copy-size",
+		config.MaxCopySize,
+		"the size limit of the archives copied into and out of the containers of jobs, in bytes",
+	)
+	rootCmd.Flags().StringVar(
+		&config.SecretsKeyFile,
+		"secrets-key-file",
+		config.SecretsKeyFile,
+		"the path of the key the secrets are encrypted with, secrets.key in the data directory when empty",
+	)
+	rootCmd.Flags().StringVar(
+		&config.SecretsDir,
+		"secrets-dir",
+		config.SecretsDir,
+		"the directory on a tmpfs the secret files of containers are written to, shared with the container runtime",
+	)
+	rootCmd.Flags().DurationVar(
+		&config.FakePullLatency,
+		"fake-pull-latency",
+		config.FakePullLatency,
+		"how long pulling an image takes with the fake runtime",
+	)
+	rootCmd.Flags().Float64Var(
+		&config.FakeFailureRate,
+		"fake-failure-rate",
+		config.FakeFailureRate,
+		"the probability of a deploy failing with the fake runtime, between 0 and 1",
+	)
+	rootCmd.Flags().IntVar(
+		&config.FakeExitCode,
+		"fake-exit-code",
+		config.FakeExitCode,
+		"the exit code of the containers of the fake runtime",
+	)
+	rootCmd.Flags().DurationVar(
+		&config.FakeRunDuration,
+		"fake-run-duration",
+		config.FakeRunDuration,
+		"how long the containers of the fake runtime run before they exit, until they are stopped when zero",
+	)
+	rootCmd.Flags().StringVar(
+		&config.FakeLogOutput,
+		"fake-log-output",
+		config.FakeLogOutput,
+		"the output logged by the containers of the fake runtime",
+	)
 }
 
 // Execute runs the root command
@@ -77,30 +280,174 @@ const serviceName = "container-manager"
 
 // runNode runs the container manager node
 func runNode() error {
-	ds, err := services.NewDockerService()
+	identity, err := services.LoadOrCreateIdentity(config.DataDir)
+	if err != nil {
+		return err
+	}
+	nodeID, err := peer.IDFromPrivateKey(identity)
+	if err != nil {
+		return fmt.Errorf("failed to derive peer ID: %w", err)
+	}
+
+	artifacts, err := services.NewArtifactStore(filepath.Join(config.DataDir, "artifacts"))
+	if err != nil {
+		return err
+	}
+
+	secretKeyFile := config.SecretsKeyFile
+	if secretKeyFile == "" {
+		secretKeyFile = filepath.Join(config.DataDir, services.SecretKeyFile)
+	}
+	secretKey, err := services.LoadOrCreateSecretKey(secretKeyFile)
+	if err != nil {
+		return err
+	}
+	secrets, err := services.NewSecretStore(filepath.Join(config.DataDir, "secrets"), secretKey)
+	if err != nil {
+		return err
+	}
+
+	ds, err := services.NewRuntime(config.Runtime, services.RuntimeOptions{
+		Endpoint:        config.RuntimeEndpoint,
+		Namespace:       config.RuntimeNamespace,
+		Artifacts:       artifacts,
+		Secrets:         services.NewSecretInjector(secrets, config.SecretsDir),
+		SeccompProfiles: config.SeccompProfileDir,
+		Fake: services.FakeRuntimeOptions{
+			PullLatency: config.FakePullLatency,
+			FailureRate: config.FakeFailureRate,
+			ExitCode:    config.FakeExitCode,
+			RunDuration: config.FakeRunDuration,
+			LogOutput:   config.FakeLogOutput,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// setup admission control
+	var resolver services.ImageResolver
+	if config.PinImageDigests {
+		resolver, _ = ds.(services.ImageResolver)
+	}
+	admission, err := services.NewAdmissionController(services.AdmissionPolicy{
+		Allow:         config.ImageAllowlist,
+		Deny:          config.ImageDenylist,
+		RequireDigest: config.RequireImageDigest,
+		Security: services.SecurityPolicy{
+			AllowedCapabilities:    config.AllowedCapabilities,
+			AllowUnconfined:        config.AllowUnconfined,
+			RequireNonRoot:         config.RequireNonRoot,
+			RequireNoNewPrivileges: config.RequireNoNewPrivileges,
+			RequireReadOnlyRootFS:  config.RequireReadOnlyRootFS,
+		},
+	}, resolver)
+	if err != nil {
+		return fmt.Errorf("failed to create admission controller: %w", err)
+	}
+
+	strategy, err := services.GetScoringStrategy(config.PlacementStrategy)
 	if err != nil {
-		return fmt.Errorf("failed to create docker service: %w", err)
+		return err
 	}
 
-	jobQueue := services.NewQueue(config.QueueSize, ds)
+	jobQueue := services.NewQueue(
+		config.QueueSize,
+		ds,
+		services.WithNodeID(nodeID.String()),
+		services.WithRetries(config.JobRetries),
+		services.WithCrashLoopThreshold(config.CrashLoopThreshold),
+		services.WithArtifacts(artifacts),
+		services.WithMaxOutputSize(config.MaxCopySize),
+	)
 	jobQueue.Run(config.WorkerCount)
 
 	// setup p2p service
 	logrus.Infof("Starting P2P service")
-	p2pService, err := services.NewP2PService(jobQueue, config.P2PPort)
+	capacity := services.NewCapacityReporter(nodeID.String(), config.NodeLabels, jobQueue, ds)
+	capacityStore := services.NewCapacityStore(services.CapacityRecordTTL)
+	scheduler := services.NewScheduler(capacity, capacityStore, jobQueue, strategy)
+	serviceStore := services.NewServiceStore()
+	p2pOptions, err := p2pOptionsFromConfig(identity)
+	if err != nil {
+		return err
+	}
+	execRouter := services.NewExecRouter(jobQueue, ds, nodeID.String())
+	copyRouter := services.NewCopyRouter(jobQueue, nodeID.String(), config.MaxCopySize)
+	statsRouter := services.NewStatsRouter(jobQueue, nodeID.String())
+	p2pOptions = append(p2pOptions,
+		services.WithAdmission(admission),
+		services.WithCapacityGossip(capacity, capacityStore),
+		services.WithScheduler(scheduler),
+		services.WithServiceStore(serviceStore),
+		services.WithArtifactStore(artifacts),
+		services.WithCopyRouter(copyRouter),
+		services.WithStatsRouter(statsRouter),
+	)
+	// commands are only served to peers of a closed cluster
+	if len(config.TrustedPeers) > 0 || config.ClusterCAFile != "" || config.PSKFile != "" {
+		p2pOptions = append(p2pOptions, services.WithExecRouter(execRouter))
+	}
+	// secrets are only served to trusted peers, a pre-shared key alone doesn't tell the peers apart
+	if len(config.TrustedPeers) > 0 || config.ClusterCAFile != "" {
+		p2pOptions = append(p2pOptions, services.WithSecretStore(secrets))
+	}
+	p2pService, err := services.NewP2PService(jobQueue, config.P2PPort, p2pOptions...)
 	if err != nil {
 		return fmt.Errorf("failed to create P2P service: %w", err)
 	}
+	jobQueue.OnStatusChange(p2pService.PublishJobStatus)
+	artifacts.SetFetcher(p2pService)
+	execRouter.SetRemote(p2pService)
+	copyRouter.SetRemote(p2pService)
+	statsRouter.SetRemote(p2pService)
+	secrets.SetFetcher(p2pService)
 	p2pService.Start(serviceName)
 
+	dispatcher := services.NewDispatcher(jobQueue, p2pService, scheduler)
+	reconciler := services.NewServiceReconciler(serviceStore, jobQueue, p2pService, dispatcher)
+	reconciler.Run()
+	workflows := services.NewWorkflowEngine(jobQueue, p2pService, dispatcher)
+	jobQueue.OnStatusChange(workflows.OnJobStatus)
+	workflows.Run()
+	batches := services.NewBatchManager(jobQueue, dispatcher)
+	batches.Run()
+
+	// take back the containers of the jobs the node ran before it restarted
+	if err := jobQueue.ReconcileOrphans(types.OrphanPolicy(config.OrphanPolicy)); err != nil {
+		return err
+	}
+
 	// setup jrpc handler
 	jrpcHandler := rpc.NewServer()
 	jrpcHandler.RegisterCodec(json.NewCodec(), "application/json")
-	err = jrpcHandler.RegisterService(handler.NewContainerService(jobQueue, p2pService), "")
+	err = jrpcHandler.RegisterService(handler.NewContainerService(
+		jobQueue,
+		dispatcher,
+		admission,
+		batches,
+		execRouter,
+		copyRouter,
+		statsRouter,
+	), "")
 	if err != nil {
 		return fmt.Errorf("failed to register container service: %w", err)
 	}
+	err = jrpcHandler.RegisterService(handler.NewServiceHandler(reconciler, admission), "Service")
+	if err != nil {
+		return fmt.Errorf("failed to register service handler: %w", err)
+	}
+	err = jrpcHandler.RegisterService(handler.NewWorkflowHandler(workflows, admission), "Workflow")
+	if err != nil {
+		return fmt.Errorf("failed to register workflow handler: %w", err)
+	}
+	err = jrpcHandler.RegisterService(handler.NewSecretHandler(secrets), "Secret")
+	if err != nil {
+		return fmt.Errorf("failed to register secret handler: %w", err)
+	}
 	http.Handle("/jrpc", jrpcHandler)
+	http.Handle("/stats", handler.NewStatsStreamHandler(statsRouter))
+	http.Handle("/exec", handler.NewExecStreamHandler(execRouter))
 
 	logrus.Infof("JRPC server listening on port %d", config.JRPCPort)
 	address := fmt.Sprintf("%s:%d", config.ListenAddress, config.JRPCPort)
@@ -110,3 +457,58 @@ func runNode() error {
 
 	return nil
 }
+
+// p2pOptionsFromConfig builds the P2P service options from the config
+func p2pOptionsFromConfig(identity crypto.PrivKey) ([]services.P2POption, error) {
+	var ca crypto.PubKey
+	if config.ClusterCAFile != "" {
+		var err error
+		ca, err = services.LoadPublicKey(config.ClusterCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load cluster CA: %w", err)
+		}
+	}
+
+	trust, err := services.NewPeerTrust(config.TrustedPeers, ca)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create peer trust: %w", err)
+	}
+	opts := []services.P2POption{
+		services.WithIdentity(identity),
+		services.WithPeerTrust(trust),
+	}
+
+	if config.NodeCertificateFile != "" {
+		certificate, err := os.ReadFile(config.NodeCertificateFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read node certificate: %w", err)
+		}
+		opts = append(opts, services.WithCertificate(certificate))
+	}
+
+	if config.PSKFile != "" {
+		psk, err := services.LoadPrivateNetworkKey(config.PSKFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load pre-shared key: %w", err)
+		}
+		opts = append(opts, services.WithPrivateNetwork(psk))
+	}
+
+	bootstrapPeers, err := services.ParseBootstrapPeers(config.BootstrapPeers)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, services.WithBootstrapPeers(bootstrapPeers))
+
+	discovery := make([]services.DiscoveryMode, 0, len(config.Discovery))
+	for _, name := range config.Discovery {
+		mode, err := services.ParseDiscoveryMode(name)
+		if err != nil {
+			return nil, err
+		}
+		discovery = append(discovery, mode)
+	}
+	opts = append(opts, services.WithDiscovery(discovery...))
+
+	return opts, nil
+}
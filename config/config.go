@@ -1,6 +1,10 @@
 package config
 
-import "fmt"
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
 
 // Config is the configuration for the container manager
 type Config struct {
@@ -8,6 +12,10 @@ type Config struct {
 	QueueSize int
 	// The number of workers to run
 	WorkerCount int
+	// The number of times a failed or unhealthy job is restarted before it fails
+	JobRetries int
+	// The number of consecutive crashes of the containers of a job before it fails
+	CrashLoopThreshold int
 	// The address to listen on
 	ListenAddress string
 	// The JRPCPort to listen on
@@ -16,6 +24,68 @@ type Config struct {
 	P2PPort int
 	// The log level
 	LogLevel string
+	// The directory the node keeps its identity and state in
+	DataDir string
+	// The image patterns allowed to run, an empty list allows every image
+	ImageAllowlist []string
+	// The image patterns that are never allowed to run
+	ImageDenylist []string
+	// Reject images that are not pinned to a digest
+	RequireImageDigest bool
+	// Resolve image tags to digests at admission time
+	PinImageDigests bool
+	// The capabilities containers may add, none when empty
+	AllowedCapabilities []string
+	// Allow containers to run without the seccomp or AppArmor profile of the runtime
+	AllowUnconfined bool
+	// Reject containers that don't run as a user other than root
+	RequireNonRoot bool
+	// Reject containers that don't set no_new_privileges
+	RequireNoNewPrivileges bool
+	// Reject containers that don't mount their root filesystem read-only
+	RequireReadOnlyRootFS bool
+	// The directory of the seccomp profiles containers reference by name, as <name>.json
+	SeccompProfileDir string
+	// The peer IDs trusted to connect and send jobs
+	TrustedPeers []string
+	// The path of the cluster CA public key, peers certified by it are trusted
+	ClusterCAFile string
+	// The path of the certificate issued to this node by the cluster CA
+	NodeCertificateFile string
+	// The path of the pre-shared key of the private network, empty joins the public network
+	PSKFile string
+	// The multiaddrs of the peers to connect to on start
+	BootstrapPeers []string
+	// The peer discovery mechanisms to run, mdns and/or dht
+	Discovery []string
+	// The strategy used to place jobs on nodes
+	PlacementStrategy string
+	// The labels of the node advertised to peers and matched by placement constraints
+	NodeLabels map[string]string
+	// The container runtime that runs the containers of jobs, docker, containerd, podman or fake
+	Runtime string
+	// The address of the container runtime API, the default of the runtime when empty
+	RuntimeEndpoint string
+	// The namespace the containers are created in, for runtimes that have namespaces
+	RuntimeNamespace string
+	// What to do on startup with the containers the node created before it restarted, adopt, remove or ignore
+	OrphanPolicy string
+	// The size limit of the archives copied into and out of the containers of jobs, in bytes
+	MaxCopySize int64
+	// The path of the key the secrets are encrypted with, secrets.key in the data directory when empty
+	SecretsKeyFile string
+	// The directory on a tmpfs the secret files of containers are written to, empty disables secret files
+	SecretsDir string
+	// How long pulling an image takes with the fake runtime
+	FakePullLatency time.Duration
+	// The probability of a deploy failing with the fake runtime, between 0 and 1
+	FakeFailureRate float64
+	// The exit code of the containers of the fake runtime
+	FakeExitCode int
+	// How long the containers of the fake runtime run before they exit, until they are stopped when zero
+	FakeRunDuration time.Duration
+	// The output logged by the containers of the fake runtime
+	FakeLogOutput string
 }
 
 // ValidateBasic a basic validation of the config
@@ -26,6 +96,12 @@ func (c *Config) ValidateBasic() error {
 	if c.WorkerCount <= 0 {
 		return fmt.Errorf("worker count must be greater than 0")
 	}
+	if c.JobRetries < 0 {
+		return fmt.Errorf("job retries must not be negative")
+	}
+	if c.CrashLoopThreshold <= 0 {
+		return fmt.Errorf("crash loop threshold must be greater than 0")
+	}
 	if c.ListenAddress == "" {
 		return fmt.Errorf("listen address is required")
 	}
@@ -38,17 +114,67 @@ func (c *Config) ValidateBasic() error {
 	if c.LogLevel == "" {
 		return fmt.Errorf("log level is required")
 	}
+	if c.DataDir == "" {
+		return fmt.Errorf("data directory is required")
+	}
+	if len(c.Discovery) == 0 {
+		return fmt.Errorf("at least one discovery mechanism is required")
+	}
+	for _, discovery := range c.Discovery {
+		if discovery != "mdns" && discovery != "dht" {
+			return fmt.Errorf("unknown discovery mechanism %q", discovery)
+		}
+	}
+	if c.PlacementStrategy == "" {
+		return fmt.Errorf("placement strategy is required")
+	}
+	if c.Runtime == "" {
+		return fmt.Errorf("container runtime is required")
+	}
+	switch c.OrphanPolicy {
+	case "adopt", "remove", "ignore":
+	default:
+		return fmt.Errorf("unknown orphan policy %q", c.OrphanPolicy)
+	}
+	if c.MaxCopySize < 0 {
+		return fmt.Errorf("max copy size must not be negative")
+	}
+	if c.SecretsDir != "" && !filepath.IsAbs(c.SecretsDir) {
+		return fmt.Errorf("secrets directory must be an absolute path")
+	}
+	if c.FakeFailureRate < 0 || c.FakeFailureRate > 1 {
+		return fmt.Errorf("fake failure rate must be between 0 and 1")
+	}
+	if c.FakePullLatency < 0 || c.FakeRunDuration < 0 {
+		return fmt.Errorf("fake pull latency and run duration must not be negative")
+	}
+	for key := range c.NodeLabels {
+		if key == "" {
+			return fmt.Errorf("node label key is required")
+		}
+	}
 	return nil
 }
 
 // DefaultConfig returns the default config
 func DefaultConfig() *Config {
 	return &Config{
-		QueueSize:     100,
-		WorkerCount:   10,
-		ListenAddress: "0.0.0.0",
-		JRPCPort:      8080,
-		P2PPort:       4001,
-		LogLevel:      "info",
+		QueueSize:          100,
+		WorkerCount:        10,
+		JobRetries:         3,
+		CrashLoopThreshold: 5,
+		ListenAddress:      "0.0.0.0",
+		JRPCPort:           8080,
+		P2PPort:            4001,
+		LogLevel:           "info",
+		DataDir:            "data",
+		PinImageDigests:    true,
+		Discovery:          []string{"mdns"},
+		PlacementStrategy:  "least-loaded",
+		Runtime:            "docker",
+		RuntimeNamespace:   "container-manager",
+		OrphanPolicy:       "adopt",
+		MaxCopySize:        64 << 20,
+		SecretsDir:         "/run/container-manager/secrets",
 	}
 }
@@ -2,16 +2,23 @@ package config
 
 import (
 	"testing"
+	"time"
 )
 
 func TestConfig_Validate_WithValidConfig(t *testing.T) {
 	c := &Config{
-		QueueSize:     100,
-		WorkerCount:   10,
-		ListenAddress: "0.0.0.0",
-		JRPCPort:      8080,
-		P2PPort:       4001,
-		LogLevel:      "info",
+		QueueSize:          100,
+		WorkerCount:        10,
+		CrashLoopThreshold: 5,
+		ListenAddress:      "0.0.0.0",
+		JRPCPort:           8080,
+		P2PPort:            4001,
+		LogLevel:           "info",
+		DataDir:            "data",
+		Discovery:          []string{"mdns"},
+		PlacementStrategy:  "least-loaded",
+		Runtime:            "docker",
+		OrphanPolicy:       "adopt",
 	}
 	err := c.ValidateBasic()
 	if err != nil {
@@ -21,12 +28,18 @@ func TestConfig_Validate_WithValidConfig(t *testing.T) {
 
 func TestConfig_Validate_WithNegativeQueueSize(t *testing.T) {
 	c := &Config{
-		QueueSize:     -1,
-		WorkerCount:   10,
-		ListenAddress: "0.0.0.0",
-		JRPCPort:      8080,
-		P2PPort:       4001,
-		LogLevel:      "info",
+		QueueSize:          -1,
+		WorkerCount:        10,
+		CrashLoopThreshold: 5,
+		ListenAddress:      "0.0.0.0",
+		JRPCPort:           8080,
+		P2PPort:            4001,
+		LogLevel:           "info",
+		DataDir:            "data",
+		Discovery:          []string{"mdns"},
+		PlacementStrategy:  "least-loaded",
+		Runtime:            "docker",
+		OrphanPolicy:       "adopt",
 	}
 	err := c.ValidateBasic()
 	if err == nil {
@@ -36,12 +49,17 @@ func TestConfig_Validate_WithNegativeQueueSize(t *testing.T) {
 
 func TestConfig_Validate_WithZeroWorkerCount(t *testing.T) {
 	c := &Config{
-		QueueSize:     100,
-		WorkerCount:   0,
-		ListenAddress: "0.0.0.0",
-		JRPCPort:      8080,
-		P2PPort:       4001,
-		LogLevel:      "info",
+		QueueSize:         100,
+		WorkerCount:       0,
+		ListenAddress:     "0.0.0.0",
+		JRPCPort:          8080,
+		P2PPort:           4001,
+		LogLevel:          "info",
+		DataDir:           "data",
+		Discovery:         []string{"mdns"},
+		PlacementStrategy: "least-loaded",
+		Runtime:           "docker",
+		OrphanPolicy:      "adopt",
 	}
 	err := c.ValidateBasic()
 	if err == nil {
@@ -51,12 +69,18 @@ func TestConfig_Validate_WithZeroWorkerCount(t *testing.T) {
 
 func TestConfig_Validate_WithEmptyListenAddress(t *testing.T) {
 	c := &Config{
-		QueueSize:     100,
-		WorkerCount:   10,
-		ListenAddress: "",
-		JRPCPort:      8080,
-		P2PPort:       4001,
-		LogLevel:      "info",
+		QueueSize:          100,
+		WorkerCount:        10,
+		CrashLoopThreshold: 5,
+		ListenAddress:      "",
+		JRPCPort:           8080,
+		P2PPort:            4001,
+		LogLevel:           "info",
+		DataDir:            "data",
+		Discovery:          []string{"mdns"},
+		PlacementStrategy:  "least-loaded",
+		Runtime:            "docker",
+		OrphanPolicy:       "adopt",
 	}
 	err := c.ValidateBasic()
 	if err == nil {
@@ -66,12 +90,18 @@ func TestConfig_Validate_WithEmptyListenAddress(t *testing.T) {
 
 func TestConfig_Validate_WithEmptyPort(t *testing.T) {
 	c := &Config{
-		QueueSize:     100,
-		WorkerCount:   10,
-		ListenAddress: "0.0.0.0",
-		JRPCPort:      0,
-		P2PPort:       4001,
-		LogLevel:      "info",
+		QueueSize:          100,
+		WorkerCount:        10,
+		CrashLoopThreshold: 5,
+		ListenAddress:      "0.0.0.0",
+		JRPCPort:           0,
+		P2PPort:            4001,
+		LogLevel:           "info",
+		DataDir:            "data",
+		Discovery:          []string{"mdns"},
+		PlacementStrategy:  "least-loaded",
+		Runtime:            "docker",
+		OrphanPolicy:       "adopt",
 	}
 	err := c.ValidateBasic()
 	if err == nil {
@@ -81,12 +111,18 @@ func TestConfig_Validate_WithEmptyPort(t *testing.T) {
 
 func TestConfig_Validate_WithEmptyLogLevel(t *testing.T) {
 	c := &Config{
-		QueueSize:     100,
-		WorkerCount:   10,
-		ListenAddress: "0.0.0.0",
-		JRPCPort:      8080,
-		P2PPort:       4001,
-		LogLevel:      "",
+		QueueSize:          100,
+		WorkerCount:        10,
+		CrashLoopThreshold: 5,
+		ListenAddress:      "0.0.0.0",
+		JRPCPort:           8080,
+		P2PPort:            4001,
+		LogLevel:           "",
+		DataDir:            "data",
+		Discovery:          []string{"mdns"},
+		PlacementStrategy:  "least-loaded",
+		Runtime:            "docker",
+		OrphanPolicy:       "adopt",
 	}
 	err := c.ValidateBasic()
 	if err == nil {
@@ -96,12 +132,248 @@ func TestConfig_Validate_WithEmptyLogLevel(t *testing.T) {
 
 func TestConfig_ValidateWithEmptyP2PPort(t *testing.T) {
 	c := &Config{
-		QueueSize:     100,
-		WorkerCount:   10,
-		ListenAddress: "0.0.0.0",
-		JRPCPort:      8080,
-		P2PPort:       0,
-		LogLevel:      "info",
+		QueueSize:          100,
+		WorkerCount:        10,
+		CrashLoopThreshold: 5,
+		ListenAddress:      "0.0.0.0",
+		JRPCPort:           8080,
+		P2PPort:            0,
+		LogLevel:           "info",
+		DataDir:            "data",
+		Discovery:          []string{"mdns"},
+		PlacementStrategy:  "least-loaded",
+		Runtime:            "docker",
+		OrphanPolicy:       "adopt",
+	}
+	err := c.ValidateBasic()
+	if err == nil {
+		t.Errorf("Expected an error, but got none")
+	}
+}
+
+func TestConfig_ValidateWithEmptyDataDir(t *testing.T) {
+	c := &Config{
+		QueueSize:          100,
+		WorkerCount:        10,
+		CrashLoopThreshold: 5,
+		ListenAddress:      "0.0.0.0",
+		JRPCPort:           8080,
+		P2PPort:            4001,
+		LogLevel:           "info",
+		DataDir:            "",
+		Discovery:          []string{"mdns"},
+		PlacementStrategy:  "least-loaded",
+		Runtime:            "docker",
+		OrphanPolicy:       "adopt",
+	}
+	err := c.ValidateBasic()
+	if err == nil {
+		t.Errorf("Expected an error, but got none")
+	}
+}
+
+func TestConfig_ValidateWithEmptyDiscovery(t *testing.T) {
+	c := &Config{
+		QueueSize:          100,
+		WorkerCount:        10,
+		CrashLoopThreshold: 5,
+		ListenAddress:      "0.0.0.0",
+		JRPCPort:           8080,
+		P2PPort:            4001,
+		LogLevel:           "info",
+		DataDir:            "data",
+		Discovery:          []string{},
+		PlacementStrategy:  "least-loaded",
+		Runtime:            "docker",
+		OrphanPolicy:       "adopt",
+	}
+	err := c.ValidateBasic()
+	if err == nil {
+		t.Errorf("Expected an error, but got none")
+	}
+}
+
+func TestConfig_ValidateWithUnknownDiscovery(t *testing.T) {
+	c := &Config{
+		QueueSize:          100,
+		WorkerCount:        10,
+		CrashLoopThreshold: 5,
+		ListenAddress:      "0.0.0.0",
+		JRPCPort:           8080,
+		P2PPort:            4001,
+		LogLevel:           "info",
+		DataDir:            "data",
+		Discovery:          []string{"mdns", "gossip"},
+		PlacementStrategy:  "least-loaded",
+		Runtime:            "docker",
+		OrphanPolicy:       "adopt",
+	}
+	err := c.ValidateBasic()
+	if err == nil {
+		t.Errorf("Expected an error, but got none")
+	}
+}
+
+func TestConfig_ValidateWithEmptyPlacementStrategy(t *testing.T) {
+	c := &Config{
+		QueueSize:          100,
+		WorkerCount:        10,
+		CrashLoopThreshold: 5,
+		ListenAddress:      "0.0.0.0",
+		JRPCPort:           8080,
+		P2PPort:            4001,
+		LogLevel:           "info",
+		DataDir:            "data",
+		Discovery:          []string{"mdns"},
+		PlacementStrategy:  "",
+	}
+	err := c.ValidateBasic()
+	if err == nil {
+		t.Errorf("Expected an error, but got none")
+	}
+}
+
+func TestConfig_ValidateWithEmptyNodeLabelKey(t *testing.T) {
+	c := &Config{
+		QueueSize:          100,
+		WorkerCount:        10,
+		CrashLoopThreshold: 5,
+		ListenAddress:      "0.0.0.0",
+		JRPCPort:           8080,
+		P2PPort:            4001,
+		LogLevel:           "info",
+		DataDir:            "data",
+		Discovery:          []string{"mdns"},
+		PlacementStrategy:  "least-loaded",
+		Runtime:            "docker",
+		OrphanPolicy:       "adopt",
+		NodeLabels:         map[string]string{"": "eu-west-1a"},
+	}
+	err := c.ValidateBasic()
+	if err == nil {
+		t.Errorf("Expected an error, but got none")
+	}
+}
+
+func TestConfig_ValidateWithNegativeJobRetries(t *testing.T) {
+	c := &Config{
+		QueueSize:          100,
+		WorkerCount:        10,
+		CrashLoopThreshold: 5,
+		JobRetries:         -1,
+		ListenAddress:      "0.0.0.0",
+		JRPCPort:           8080,
+		P2PPort:            4001,
+		LogLevel:           "info",
+		DataDir:            "data",
+		Discovery:          []string{"mdns"},
+		PlacementStrategy:  "least-loaded",
+		Runtime:            "docker",
+		OrphanPolicy:       "adopt",
+	}
+	err := c.ValidateBasic()
+	if err == nil {
+		t.Errorf("Expected an error, but got none")
+	}
+}
+
+func TestConfig_ValidateWithZeroCrashLoopThreshold(t *testing.T) {
+	c := &Config{
+		QueueSize:         100,
+		WorkerCount:       10,
+		ListenAddress:     "0.0.0.0",
+		JRPCPort:          8080,
+		P2PPort:           4001,
+		LogLevel:          "info",
+		DataDir:           "data",
+		Discovery:         []string{"mdns"},
+		PlacementStrategy: "least-loaded",
+		Runtime:           "docker",
+		OrphanPolicy:      "adopt",
+	}
+	err := c.ValidateBasic()
+	if err == nil {
+		t.Errorf("Expected an error, but got none")
+	}
+}
+
+func TestConfig_ValidateWithEmptyRuntime(t *testing.T) {
+	c := &Config{
+		QueueSize:          100,
+		WorkerCount:        10,
+		CrashLoopThreshold: 5,
+		ListenAddress:      "0.0.0.0",
+		JRPCPort:           8080,
+		P2PPort:            4001,
+		LogLevel:           "info",
+		DataDir:            "data",
+		Discovery:          []string{"mdns"},
+		PlacementStrategy:  "least-loaded",
+	}
+	err := c.ValidateBasic()
+	if err == nil {
+		t.Errorf("Expected an error, but got none")
+	}
+}
+
+func TestConfig_ValidateWithInvalidFakeFailureRate(t *testing.T) {
+	c := &Config{
+		QueueSize:          100,
+		WorkerCount:        10,
+		CrashLoopThreshold: 5,
+		ListenAddress:      "0.0.0.0",
+		JRPCPort:           8080,
+		P2PPort:            4001,
+		LogLevel:           "info",
+		DataDir:            "data",
+		Discovery:          []string{"mdns"},
+		PlacementStrategy:  "least-loaded",
+		Runtime:            "fake",
+		OrphanPolicy:       "adopt",
+		FakeFailureRate:    1.5,
+	}
+	err := c.ValidateBasic()
+	if err == nil {
+		t.Errorf("Expected an error, but got none")
+	}
+}
+
+func TestConfig_ValidateWithNegativeFakeRunDuration(t *testing.T) {
+	c := &Config{
+		QueueSize:          100,
+		WorkerCount:        10,
+		CrashLoopThreshold: 5,
+		ListenAddress:      "0.0.0.0",
+		JRPCPort:           8080,
+		P2PPort:            4001,
+		LogLevel:           "info",
+		DataDir:            "data",
+		Discovery:          []string{"mdns"},
+		PlacementStrategy:  "least-loaded",
+		Runtime:            "fake",
+		OrphanPolicy:       "adopt",
+		FakeRunDuration:    -time.Second,
+	}
+	err := c.ValidateBasic()
+	if err == nil {
+		t.Errorf("Expected an error, but got none")
+	}
+}
+
+func TestConfig_ValidateWithUnknownOrphanPolicy(t *testing.T) {
+	c := &Config{
+		QueueSize:          100,
+		WorkerCount:        10,
+		CrashLoopThreshold: 5,
+		ListenAddress:      "0.0.0.0",
+		JRPCPort:           8080,
+		P2PPort:            4001,
+		LogLevel:           "info",
+		DataDir:            "data",
+		Discovery:          []string{"mdns"},
+		PlacementStrategy:  "least-loaded",
+		Runtime:            "docker",
+		OrphanPolicy:       "delete",
 	}
 	err := c.ValidateBasic()
 	if err == nil {
@@ -1,15 +1,52 @@
 #!/bin/bash
 
-# Start the containers
+# The container runtime of the nodes. With RUNTIME=fake the nodes run as local processes with the
+# simulated runtime, so no Docker daemon is needed.
+RUNTIME=${RUNTIME:-docker}
+E2E_DIR=$(mktemp -d)
+
+# Send a JRPC request to a node
+jrpc() {
+  local container_name=$1
+  local port=$2
+  shift 2
+
+  if [ "$RUNTIME" == "fake" ]; then
+    curl -s "$@" "http://localhost:$port/jrpc"
+  else
+    docker exec "$container_name" curl -s "$@" "http://localhost:$port/jrpc"
+  fi
+}
+
+# Start two nodes as local processes, the second bootstrapping from the first
+start_local_cluster() {
+  go build -o "$E2E_DIR/container-manager" . || exit 1
+
+  local bootstrap
+  bootstrap=$("$E2E_DIR/container-manager" id --data-dir="$E2E_DIR/manager1" --p2p-port=4041 | grep -m1 "/p2p/" | tr -d ' ')
+
+  "$E2E_DIR/container-manager" --runtime=fake --data-dir="$E2E_DIR/manager1" \
+    --jrpc-port=8080 --p2p-port=4041 > "$E2E_DIR/manager1.log" 2>&1 &
+  echo $! > "$E2E_DIR/manager1.pid"
+  "$E2E_DIR/container-manager" --runtime=fake --data-dir="$E2E_DIR/manager2" \
+    --jrpc-port=8081 --p2p-port=4042 --bootstrap-peer="$bootstrap" > "$E2E_DIR/manager2.log" 2>&1 &
+  echo $! > "$E2E_DIR/manager2.pid"
+}
+
+# Start the nodes
 echo "Starting the local cluster..."
-docker-compose up -d
+if [ "$RUNTIME" == "fake" ]; then
+  start_local_cluster
+else
+  docker-compose up -d
+fi
 
 # Wait until the service is up
 wait_for_jrpc() {
   local container_name=$1
   local port=$2
 
-  while ! docker exec "$container_name" curl -s "http://localhost:$port/jrpc" > /dev/null; do
+  while ! jrpc "$container_name" "$port" > /dev/null; do
     echo "Waiting for JRPC service in $container_name..."
     sleep 5
   done
@@ -30,12 +67,12 @@ check_job_status() {
   local port=$2
   local jobID=$3
 
-  response=$(docker exec "$container_name" curl -s -X POST -H "Content-Type: application/json" -d "{
+  response=$(jrpc "$container_name" "$port" -X POST -H "Content-Type: application/json" -d "{
       \"jsonrpc\": \"2.0\",
       \"method\": \"ContainerService.Status\",
       \"params\": [{\"job_id\":\"$jobID\"}],
       \"id\": 1
-    }" "http://localhost:$port/jrpc")
+    }")
     status=$(echo "$response" | grep -o '"status":"[^"]*"' | sed 's/"status":"\([^"]*\)"/\1/')
     echo "$status"
 }
@@ -87,7 +124,16 @@ monitor_job manager1 8080 "$jobID"
 echo "Monitoring the job status for manager2"
 monitor_job manager2 8081 "$jobID"
 
-# Stop and cleanup the containers
+# Stop and cleanup the nodes
+if [ "$RUNTIME" == "fake" ]; then
+  # the containers of the fake runtime are in memory, so nothing is left behind
+  kill -KILL "$(cat "$E2E_DIR/manager1.pid")" "$(cat "$E2E_DIR/manager2.pid")"
+  wait 2>/dev/null
+  rm -rf "$E2E_DIR"
+  exit 0
+fi
+
+rm -rf "$E2E_DIR"
 docker-compose down
 
 container_ids=$(docker ps -a -q --filter ancestor=nginx)
@@ -1,16 +1,24 @@
 package handler
 
 import (
+	"archive/tar"
+	"bytes"
 	"container-manager/services"
 	"container-manager/types"
-	"encoding/json"
+	"context"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
 
+// DefaultExecTimeout is the time a command run by ContainerService.Exec may run when the request sets no limit
+const DefaultExecTimeout = time.Minute
+
 // ContainerCreateRequest is the request object for the ContainerService.Create method.
 type ContainerCreateRequest struct {
 	types.Container
@@ -18,9 +26,13 @@ type ContainerCreateRequest struct {
 
 // ContainerCreateResponse is the response object for the ContainerService.Create method.
 // JobID: The ID of the job that was created
+// Image: The admitted image, pinned to a digest when pinning is enabled
+// Node: The ID of the node the job was placed on
 // Message: A response message
 type ContainerCreateResponse struct {
 	JobID   string `json:"job_id"`
+	Image   string `json:"image"`
+	Node    string `json:"node"`
 	Message string `json:"message"`
 }
 
@@ -31,23 +43,145 @@ type ContainerStatusRequest struct {
 
 // ContainerStatusResponse is the response object for the ContainerService.Status method.
 // JobID: The ID of the job
-// Message: The status of the job
+// Status: The status of the job
+// Node: The ID of the node that runs the job
+// Restarts: The number of times the container of the job was restarted
+// Artifacts: The outputs collected from the container of the job
+// Usage: The resource use of the containers of the job that ended
 type ContainerStatusResponse struct {
-	JobID  string `json:"job_id"`
-	Status string `json:"status"`
+	JobID     string               `json:"job_id"`
+	Status    string               `json:"status"`
+	Node      string               `json:"node"`
+	Restarts  int                  `json:"restarts"`
+	Artifacts []types.Artifact     `json:"artifacts,omitempty"`
+	Usage     *types.ResourceUsage `json:"usage,omitempty"`
+}
+
+// ContainerStatsRequest is the request object for the ContainerService.Stats method.
+type ContainerStatsRequest struct {
+	JobID string `json:"job_id"`
+}
+
+// ContainerStatsResponse is the response object for the ContainerService.Stats method.
+// JobID: The ID of the job
+// Stats: The resource use of the container of the job
+type ContainerStatsResponse struct {
+	JobID string               `json:"job_id"`
+	Stats types.ContainerStats `json:"stats"`
+}
+
+// ContainerExecRequest is the request object for the ContainerService.Exec method.
+// JobID: The ID of the job whose container runs the command
+// Command: The command and its arguments
+// Env: The environment variables of the command, as KEY=value
+// Stdin: The input of the command
+// Timeout: The time the command may run, DefaultExecTimeout when empty
+type ContainerExecRequest struct {
+	JobID   string         `json:"job_id"`
+	Command []string       `json:"command"`
+	Env     []string       `json:"env,omitempty"`
+	Stdin   string         `json:"stdin,omitempty"`
+	Timeout types.Duration `json:"timeout,omitempty"`
+}
+
+// ContainerExecResponse is the response object for the ContainerService.Exec method.
+// Stdout: The output of the command
+// Stderr: The error output of the command
+// ExitCode: The exit code of the command
+type ContainerExecResponse struct {
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// ContainerUploadRequest is the request object for the ContainerService.Upload method.
+// JobID: The ID of the job whose container the files are copied into
+// Path: The absolute path the file or the contents of the archive are copied to
+// Archive: A tar archive of the contents of the directory at the path, instead of a single file
+// Content: The content of the single file at the path
+// Mode: The permissions of the single file, 0644 when empty
+type ContainerUploadRequest struct {
+	JobID   string `json:"job_id"`
+	Path    string `json:"path"`
+	Archive []byte `json:"archive,omitempty"`
+	Content []byte `json:"content,omitempty"`
+	Mode    int64  `json:"mode,omitempty"`
+}
+
+// ContainerDownloadRequest is the request object for the ContainerService.Download method.
+// JobID: The ID of the job whose container the files are copied out of
+// Path: The absolute path of the file or directory to copy
+type ContainerDownloadRequest struct {
+	JobID string `json:"job_id"`
+	Path  string `json:"path"`
+}
+
+// ContainerDownloadResponse is the response object for the ContainerService.Download method.
+// Archive: A tar archive of the path, rooted at its base name
+// Content: The content of the path when it is a single file
+type ContainerDownloadResponse struct {
+	Archive []byte `json:"archive"`
+	Content []byte `json:"content,omitempty"`
+}
+
+// ContainerCreateBatchRequest is the request object for the ContainerService.CreateBatch method.
+// Template: The container every job of the batch runs
+// Count: The number of copies of the template
+// Matrix: The values of each parameter, one job is created per combination
+type ContainerCreateBatchRequest struct {
+	Template types.Container     `json:"template"`
+	Count    int                 `json:"count,omitempty"`
+	Matrix   map[string][]string `json:"matrix,omitempty"`
+}
+
+// BatchRequest is the request object for the ContainerService.BatchStatus and ContainerService.CancelBatch methods.
+type BatchRequest struct {
+	BatchID string `json:"batch_id"`
+}
+
+// BatchResponse is the response object for the batch methods of the ContainerService.
+// BatchID: The ID of the batch
+// Image: The admitted image of the template
+// Status: The aggregate status of the batch
+// Counts: The number of jobs of the batch in each status
+// JobIDs: The IDs of the jobs of the batch, by index
+type BatchResponse struct {
+	BatchID string            `json:"batch_id"`
+	Image   string            `json:"image"`
+	Status  string            `json:"status"`
+	Counts  types.BatchCounts `json:"counts"`
+	JobIDs  []string          `json:"job_ids"`
 }
 
 // ContainerService is the service that handles container creation.
 type ContainerService struct {
 	jobQueue   services.Queue
-	p2pService services.P2PService
+	dispatcher *services.Dispatcher
+	admission  *services.AdmissionController
+	batches    *services.BatchManager
+	exec       *services.ExecRouter
+	copier     *services.CopyRouter
+	stats      *services.StatsRouter
 }
 
 // NewContainerService creates a new container service.
-func NewContainerService(jobQueue services.Queue, p2pService services.P2PService) *ContainerService {
+func NewContainerService(
+	jobQueue services.Queue,
+	dispatcher *services.Dispatcher,
+	admission *services.AdmissionController,
+	batches *services.BatchManager,
+	exec *services.ExecRouter,
+	copier *services.CopyRouter,
+	stats *services.StatsRouter,
+) *ContainerService {
 	return &ContainerService{
 		jobQueue:   jobQueue,
-		p2pService: p2pService,
+		dispatcher: dispatcher,
+		admission:  admission,
+		batches:    batches,
+		exec:       exec,
+		copier:     copier,
+		stats:      stats,
 	}
 }
 
@@ -60,9 +194,10 @@ func (cs *ContainerService) Create(r *http.Request, req *ContainerCreateRequest,
 	logrus.WithFields(logrus.Fields{
 		"image":     req.Image,
 		"arguments": req.Arguments,
-		"env":       req.Env,
-	}).Debugf("queueing and broadcasting job")
-	if err := req.Container.Validate(); err != nil {
+		"env":       types.RedactedEnv(req.Env),
+	}).Debugf("placing job")
+	container, err := cs.admission.Admit(r.Context(), req.Container)
+	if err != nil {
 		return fmt.Errorf("invalid request: %w", err)
 	}
 
@@ -71,26 +206,15 @@ func (cs *ContainerService) Create(r *http.Request, req *ContainerCreateRequest,
 		return fmt.Errorf("failed to generate job ID: %w", err)
 	}
 
-	// Enqueue the job
-	if err := cs.jobQueue.Enqueue(jobID.String(), req.Container); err != nil {
-		return fmt.Errorf("failed to enqueue job: %w", err)
-	}
-
-	// forward the job to the p2p network
-	containerData, err := json.Marshal(req.Container)
+	// place the job on the best node of the cluster
+	nodeID, err := cs.dispatcher.Dispatch(jobID.String(), container, clientAddr(r))
 	if err != nil {
-		return fmt.Errorf("failed to marshal container data: %w", err)
-	}
-	msg := services.Message{
-		Type:  types.P2PMessageTypeDeployContainer,
-		JobID: jobID.String(),
-		Data:  containerData,
-	}
-	if err := cs.p2pService.Broadcast(msg); err != nil {
-		return fmt.Errorf("failed to send job to p2p network: %w", err)
+		return fmt.Errorf("failed to dispatch job: %w", err)
 	}
 
 	res.JobID = jobID.String()
+	res.Image = container.Image
+	res.Node = nodeID
 	res.Message = "Job created successfully"
 
 	return nil
@@ -104,13 +228,227 @@ func (cs *ContainerService) Status(r *http.Request, req *ContainerStatusRequest,
 
 	logrus.WithField("job_id", req.JobID).Debug("getting job status")
 
-	status, ok := cs.jobQueue.GetStatus(req.JobID)
+	job, ok := cs.jobQueue.GetJob(req.JobID)
 	if !ok {
 		return fmt.Errorf("job not found")
 	}
 
 	res.JobID = req.JobID
-	res.Status = status.String()
+	res.Status = job.Status.String()
+	res.Node = job.Node
+	res.Restarts = job.Restarts
+	res.Artifacts = job.Artifacts
+	res.Usage = job.Usage
+
+	return nil
+}
+
+// Stats returns the CPU, memory, network and block IO use of the container of a job, from the node that runs
+// the job.
+func (cs *ContainerService) Stats(r *http.Request, req *ContainerStatsRequest, res *ContainerStatsResponse) error {
+	if req == nil {
+		return fmt.Errorf("invalid request")
+	}
+
+	logrus.WithField("job_id", req.JobID).Debug("getting job stats")
+	stats, err := cs.stats.ContainerStats(r.Context(), req.JobID)
+	if err != nil {
+		return fmt.Errorf("failed to get job stats: %w", err)
+	}
+
+	res.JobID = req.JobID
+	res.Stats = stats
+	return nil
+}
+
+// Exec runs a command in the container of a job, on the node that runs the job, and returns its output
+// once it exited.
+func (cs *ContainerService) Exec(r *http.Request, req *ContainerExecRequest, res *ContainerExecResponse) error {
+	if req == nil {
+		return fmt.Errorf("invalid request")
+	}
+	if req.Timeout < 0 {
+		return fmt.Errorf("invalid request: timeout must not be negative")
+	}
+	timeout := time.Duration(req.Timeout)
+	if timeout == 0 {
+		timeout = DefaultExecTimeout
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"job_id":  req.JobID,
+		"command": req.Command,
+		"client":  clientAddr(r),
+	}).Info("running command in job container")
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	session, err := cs.exec.Exec(ctx, req.JobID, services.ExecOptions{
+		Command: req.Command,
+		Env:     req.Env,
+		Stdin:   req.Stdin != "",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to exec: %w", err)
+	}
+	result, err := services.CollectExec(ctx, session, req.Stdin)
+	if err != nil {
+		return fmt.Errorf("failed to exec: %w", err)
+	}
+
+	res.Stdout = result.Stdout
+	res.Stderr = result.Stderr
+	res.ExitCode = result.ExitCode
+	return nil
+}
+
+// Upload copies a file or the contents of a tar archive into the container of a job, on the node that runs the
+// job. Files uploaded before the job starts are in place when its container is created.
+func (cs *ContainerService) Upload(r *http.Request, req *ContainerUploadRequest, res *ContainerStatusResponse) error {
+	if req == nil {
+		return fmt.Errorf("invalid request")
+	}
+
+	var archive []byte
+	var err error
+	if req.Archive != nil {
+		archive, err = services.RootArchive(req.Archive, req.Path)
+	} else {
+		archive, err = services.FileArchive(req.Path, req.Content, req.Mode)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid request: %w", err)
+	}
 
+	logrus.WithFields(logrus.Fields{
+		"job_id": req.JobID,
+		"path":   req.Path,
+		"client": clientAddr(r),
+	}).Info("copying files to job container")
+	if err := cs.copier.CopyTo(r.Context(), req.JobID, req.Path, archive); err != nil {
+		return fmt.Errorf("failed to upload: %w", err)
+	}
+	return cs.Status(r, &ContainerStatusRequest{JobID: req.JobID}, res)
+}
+
+// Download copies a file or directory out of the container of a job, on the node that runs the job.
+func (cs *ContainerService) Download(r *http.Request, req *ContainerDownloadRequest, res *ContainerDownloadResponse) error {
+	if req == nil {
+		return fmt.Errorf("invalid request")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"job_id": req.JobID,
+		"path":   req.Path,
+		"client": clientAddr(r),
+	}).Debug("copying files from job container")
+	archive, err := cs.copier.CopyFrom(r.Context(), req.JobID, req.Path)
+	if err != nil {
+		return fmt.Errorf("failed to download: %w", err)
+	}
+
+	res.Archive = archive
+	res.Content = singleFile(archive)
 	return nil
 }
+
+// singleFile returns the content of an archive made of a single regular file, nil for other archives
+func singleFile(archive []byte) []byte {
+	tr := tar.NewReader(bytes.NewReader(archive))
+	header, err := tr.Next()
+	if err != nil || header.Typeflag != tar.TypeReg {
+		return nil
+	}
+	content, err := io.ReadAll(tr)
+	if err != nil {
+		return nil
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		return nil
+	}
+	return content
+}
+
+// CreateBatch expands a template into one job per copy or matrix combination and places each of them.
+func (cs *ContainerService) CreateBatch(r *http.Request, req *ContainerCreateBatchRequest, res *BatchResponse) error {
+	if req == nil {
+		return fmt.Errorf("invalid request")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"image":  req.Template.Image,
+		"count":  req.Count,
+		"matrix": types.RedactedMatrix(req.Matrix),
+	}).Debug("placing batch")
+	template, err := cs.admission.Admit(r.Context(), req.Template)
+	if err != nil {
+		return fmt.Errorf("invalid request: %w", err)
+	}
+
+	batch, err := cs.batches.Submit(types.Batch{
+		Template: template,
+		Count:    req.Count,
+		Matrix:   req.Matrix,
+	}, clientAddr(r))
+	if err != nil {
+		return fmt.Errorf("failed to create batch: %w", err)
+	}
+
+	*res = batchResponse(batch)
+	return nil
+}
+
+// BatchStatus returns the status of a batch and the counts of its jobs.
+func (cs *ContainerService) BatchStatus(_ *http.Request, req *BatchRequest, res *BatchResponse) error {
+	if req == nil {
+		return fmt.Errorf("invalid request")
+	}
+
+	batch, err := cs.batches.Get(req.BatchID)
+	if err != nil {
+		return err
+	}
+
+	*res = batchResponse(batch)
+	return nil
+}
+
+// CancelBatch cancels the jobs of a batch that are waiting to run or running.
+func (cs *ContainerService) CancelBatch(_ *http.Request, req *BatchRequest, res *BatchResponse) error {
+	if req == nil {
+		return fmt.Errorf("invalid request")
+	}
+
+	logrus.WithField("batch_id", req.BatchID).Debug("cancelling batch")
+	batch, err := cs.batches.Cancel(req.BatchID)
+	if err != nil {
+		return fmt.Errorf("failed to cancel batch: %w", err)
+	}
+
+	*res = batchResponse(batch)
+	return nil
+}
+
+// batchResponse builds the response of a batch
+func batchResponse(batch types.Batch) BatchResponse {
+	return BatchResponse{
+		BatchID: batch.ID,
+		Image:   batch.Template.Image,
+		Status:  string(batch.Status),
+		Counts:  batch.Counts,
+		JobIDs:  batch.Jobs,
+	}
+}
+
+// clientAddr returns the remote host of the client that sent the request. The JRPC API doesn't authenticate
+// its clients, so the host is only recorded to trace jobs back to where they came from.
+func clientAddr(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"container-manager/services"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// ExecStreamHandler runs an interactive command in the container of a job over a WebSocket, e.g.
+// /exec?job_id=...&command=sh&tty=true. Every message is binary and starts with the channel of the
+// session, services.ExecChannelStdin to services.ExecChannelResize, followed by its data: the client
+// sends input and resize messages, the node sends the output and, once the command exited, its status.
+type ExecStreamHandler struct {
+	exec     *services.ExecRouter
+	upgrader websocket.Upgrader
+}
+
+// NewExecStreamHandler creates a new exec stream handler.
+func NewExecStreamHandler(exec *services.ExecRouter) *ExecStreamHandler {
+	return &ExecStreamHandler{exec: exec}
+}
+
+// ServeHTTP runs the command of the command query parameters, one per argument, in the container of the
+// job of the job_id query parameter.
+func (eh *ExecStreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	jobID := query.Get("job_id")
+	if jobID == "" || len(query["command"]) == 0 {
+		http.Error(w, "job_id and command are required", http.StatusBadRequest)
+		return
+	}
+	tty, _ := strconv.ParseBool(query.Get("tty"))
+
+	logrus.WithFields(logrus.Fields{
+		"job_id":  jobID,
+		"command": query["command"],
+		"client":  clientAddr(r),
+	}).Info("running interactive command in job container")
+	session, err := eh.exec.Exec(r.Context(), jobID, services.ExecOptions{
+		Command: query["command"],
+		Env:     query["env"],
+		TTY:     tty,
+		Stdin:   true,
+	})
+	switch {
+	case errors.Is(err, services.ErrJobNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrJobNotRunning):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer session.Close()
+
+	conn, err := eh.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logrus.WithField("job_id", jobID).Warnf("failed to upgrade exec connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// the session ends when the client goes away
+	go func() {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				session.Close()
+				return
+			}
+			if len(message) == 0 {
+				continue
+			}
+			if err := services.HandleExecInput(r.Context(), session, message[0], message[1:]); err != nil {
+				logrus.WithField("job_id", jobID).Debugf("failed to handle exec input: %v", err)
+			}
+		}
+	}()
+
+	var mutex sync.Mutex
+	err = services.CopyExecOutput(r.Context(), session, func(channel byte, data []byte) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return conn.WriteMessage(websocket.BinaryMessage, append([]byte{channel}, data...))
+	})
+	if err != nil {
+		logrus.WithField("job_id", jobID).Debugf("failed to write exec output: %v", err)
+		return
+	}
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"container-manager/services"
+	"container-manager/types"
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SecretPutRequest is the request object for the Secret.Put method.
+// Name: The name of the secret
+// Value: The value of the secret, never returned by the API
+type SecretPutRequest struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// SecretRequest is the request object for the Secret.Delete method.
+type SecretRequest struct {
+	Name string `json:"name"`
+}
+
+// SecretListRequest is the request object for the Secret.List method.
+type SecretListRequest struct{}
+
+// SecretResponse is the response object for the Secret.Put and Secret.Delete methods.
+type SecretResponse struct {
+	Name string `json:"name"`
+}
+
+// SecretListResponse is the response object for the Secret.List method.
+type SecretListResponse struct {
+	Secrets []types.SecretInfo `json:"secrets"`
+}
+
+// SecretHandler is the service that manages the secrets of the node, registered as "Secret".
+type SecretHandler struct {
+	store *services.SecretStore
+}
+
+// NewSecretHandler creates a new secret handler.
+func NewSecretHandler(store *services.SecretStore) *SecretHandler {
+	return &SecretHandler{store: store}
+}
+
+// Put sets the value of a secret, encrypted at rest on the node.
+func (sh *SecretHandler) Put(r *http.Request, req *SecretPutRequest, res *SecretResponse) error {
+	if req == nil {
+		return fmt.Errorf("invalid request")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"name":   req.Name,
+		"client": clientAddr(r),
+	}).Info("setting secret")
+	if err := sh.store.Put(req.Name, []byte(req.Value)); err != nil {
+		return fmt.Errorf("failed to set secret: %w", err)
+	}
+
+	res.Name = req.Name
+	return nil
+}
+
+// Delete deletes a secret of the node.
+func (sh *SecretHandler) Delete(r *http.Request, req *SecretRequest, res *SecretResponse) error {
+	if req == nil {
+		return fmt.Errorf("invalid request")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"name":   req.Name,
+		"client": clientAddr(r),
+	}).Info("deleting secret")
+	if err := sh.store.Delete(req.Name); err != nil {
+		return fmt.Errorf("failed to delete secret: %w", err)
+	}
+
+	res.Name = req.Name
+	return nil
+}
+
+// List returns the names of the secrets of the node, without their values.
+func (sh *SecretHandler) List(_ *http.Request, _ *SecretListRequest, res *SecretListResponse) error {
+	secrets, err := sh.store.List()
+	if err != nil {
+		return err
+	}
+
+	res.Secrets = secrets
+	return nil
+}
@@ -0,0 +1,249 @@
+package handler
+
+import (
+	"container-manager/services"
+	"container-manager/types"
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ServiceCreateRequest is the request object for the Service.Create method.
+// Name: The unique name of the service
+// Container: The container each replica runs
+// Replicas: The desired number of replicas
+// Update: How replicas are replaced on updates, one replica is surged at a time when empty
+type ServiceCreateRequest struct {
+	Name      string             `json:"name"`
+	Container types.Container    `json:"container"`
+	Replicas  int                `json:"replicas"`
+	Update    types.UpdateConfig `json:"update"`
+}
+
+// ServiceUpdateRequest is the request object for the Service.Update method.
+// Name: The name of the service
+// Container: The new container of the replicas
+// Update: How replicas are replaced, the current config is kept when empty
+type ServiceUpdateRequest struct {
+	Name      string              `json:"name"`
+	Container types.Container     `json:"container"`
+	Update    *types.UpdateConfig `json:"update"`
+}
+
+// ServiceScaleRequest is the request object for the Service.Scale method.
+type ServiceScaleRequest struct {
+	Name     string `json:"name"`
+	Replicas int    `json:"replicas"`
+}
+
+// ServiceRequest is the request object for the Service.Get and Service.Delete methods.
+type ServiceRequest struct {
+	Name string `json:"name"`
+}
+
+// ServiceListRequest is the request object for the Service.List method.
+type ServiceListRequest struct{}
+
+// ReplicaStatus is the status of a replica of a service.
+// JobID: The ID of the job that runs the replica
+// Node: The ID of the node that runs the replica
+// Status: The status of the replica
+// Revision: The revision of the service the replica runs
+type ReplicaStatus struct {
+	JobID    string `json:"job_id"`
+	Node     string `json:"node"`
+	Status   string `json:"status"`
+	Revision string `json:"revision"`
+}
+
+// ServiceResponse is the response object for the Service.Create, Service.Update, Service.Scale and Service.Get methods.
+// Name: The name of the service
+// Image: The image of the replicas, pinned to a digest when pinning is enabled
+// Replicas: The desired number of replicas
+// Running: The number of running replicas that passed their health check
+// Revision: The revision of the container spec
+// UpdateStatus: The status of the latest update
+// Owner: The ID of the node that reconciles the service
+// Version: The version of the service spec
+// Instances: The replicas of the service known to the node
+type ServiceResponse struct {
+	Name         string          `json:"name"`
+	Image        string          `json:"image"`
+	Replicas     int             `json:"replicas"`
+	Running      int             `json:"running"`
+	Revision     uint64          `json:"revision"`
+	UpdateStatus string          `json:"update_status,omitempty"`
+	Owner        string          `json:"owner"`
+	Version      uint64          `json:"version"`
+	Instances    []ReplicaStatus `json:"instances"`
+}
+
+// ServiceListResponse is the response object for the Service.List method.
+type ServiceListResponse struct {
+	Services []ServiceResponse `json:"services"`
+}
+
+// ServiceDeleteResponse is the response object for the Service.Delete method.
+type ServiceDeleteResponse struct {
+	Message string `json:"message"`
+}
+
+// ServiceHandler is the service that manages long-running services, registered as "Service".
+type ServiceHandler struct {
+	reconciler *services.ServiceReconciler
+	admission  *services.AdmissionController
+}
+
+// NewServiceHandler creates a new service handler.
+func NewServiceHandler(
+	reconciler *services.ServiceReconciler,
+	admission *services.AdmissionController,
+) *ServiceHandler {
+	return &ServiceHandler{
+		reconciler: reconciler,
+		admission:  admission,
+	}
+}
+
+// Create creates a new service.
+func (sh *ServiceHandler) Create(r *http.Request, req *ServiceCreateRequest, res *ServiceResponse) error {
+	if req == nil {
+		return fmt.Errorf("invalid request")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"name":     req.Name,
+		"image":    req.Container.Image,
+		"replicas": req.Replicas,
+	}).Debug("creating service")
+	container, err := sh.admission.Admit(r.Context(), req.Container)
+	if err != nil {
+		return fmt.Errorf("invalid request: %w", err)
+	}
+
+	spec, err := sh.reconciler.Create(types.Service{
+		Name:      req.Name,
+		Container: container,
+		Replicas:  req.Replicas,
+		Update:    req.Update,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create service: %w", err)
+	}
+
+	*res = sh.response(spec)
+	return nil
+}
+
+// Update replaces the container of a service with a rolling update, which is rolled back if it fails.
+func (sh *ServiceHandler) Update(r *http.Request, req *ServiceUpdateRequest, res *ServiceResponse) error {
+	if req == nil {
+		return fmt.Errorf("invalid request")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"name":  req.Name,
+		"image": req.Container.Image,
+	}).Debug("updating service")
+	container, err := sh.admission.Admit(r.Context(), req.Container)
+	if err != nil {
+		return fmt.Errorf("invalid request: %w", err)
+	}
+
+	spec, err := sh.reconciler.Update(req.Name, container, req.Update)
+	if err != nil {
+		return fmt.Errorf("failed to update service: %w", err)
+	}
+
+	*res = sh.response(spec)
+	return nil
+}
+
+// Scale changes the desired number of replicas of a service.
+func (sh *ServiceHandler) Scale(_ *http.Request, req *ServiceScaleRequest, res *ServiceResponse) error {
+	if req == nil {
+		return fmt.Errorf("invalid request")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"name":     req.Name,
+		"replicas": req.Replicas,
+	}).Debug("scaling service")
+	spec, err := sh.reconciler.Scale(req.Name, req.Replicas)
+	if err != nil {
+		return fmt.Errorf("failed to scale service: %w", err)
+	}
+
+	*res = sh.response(spec)
+	return nil
+}
+
+// Get returns a service and its replicas.
+func (sh *ServiceHandler) Get(_ *http.Request, req *ServiceRequest, res *ServiceResponse) error {
+	if req == nil {
+		return fmt.Errorf("invalid request")
+	}
+
+	spec, err := sh.reconciler.Get(req.Name)
+	if err != nil {
+		return err
+	}
+
+	*res = sh.response(spec)
+	return nil
+}
+
+// List returns all services.
+func (sh *ServiceHandler) List(_ *http.Request, _ *ServiceListRequest, res *ServiceListResponse) error {
+	res.Services = []ServiceResponse{}
+	for _, spec := range sh.reconciler.List() {
+		res.Services = append(res.Services, sh.response(spec))
+	}
+	return nil
+}
+
+// Delete deletes a service and stops its replicas.
+func (sh *ServiceHandler) Delete(_ *http.Request, req *ServiceRequest, res *ServiceDeleteResponse) error {
+	if req == nil {
+		return fmt.Errorf("invalid request")
+	}
+
+	logrus.WithField("name", req.Name).Debug("deleting service")
+	if err := sh.reconciler.Delete(req.Name); err != nil {
+		return fmt.Errorf("failed to delete service: %w", err)
+	}
+
+	res.Message = "Service deleted successfully"
+	return nil
+}
+
+// response builds the response of a service with its active replicas
+func (sh *ServiceHandler) response(spec types.Service) ServiceResponse {
+	res := ServiceResponse{
+		Name:         spec.Name,
+		Image:        spec.Container.Image,
+		Replicas:     spec.Replicas,
+		Revision:     spec.Revision,
+		UpdateStatus: string(spec.UpdateStatus),
+		Owner:        spec.Owner,
+		Version:      spec.Version,
+		Instances:    []ReplicaStatus{},
+	}
+
+	for _, job := range sh.reconciler.Replicas(spec.Name) {
+		if !job.Status.Active() {
+			continue
+		}
+		if job.Ready() {
+			res.Running++
+		}
+		res.Instances = append(res.Instances, ReplicaStatus{
+			JobID:    job.ID,
+			Node:     job.Node,
+			Status:   job.Status.String(),
+			Revision: job.Container.Labels[types.ServiceRevisionLabel],
+		})
+	}
+	return res
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"container-manager/services"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// StatsStreamHandler streams the resource use of the container of a job as newline delimited JSON,
+// one ContainerStatsResponse per sample, until the client disconnects or the container is gone. The samples
+// come from the node that runs the job.
+// JSON-RPC answers each request once, so the stream is served on its own path, e.g. /stats?job_id=...
+type StatsStreamHandler struct {
+	stats *services.StatsRouter
+}
+
+// NewStatsStreamHandler creates a new stats stream handler.
+func NewStatsStreamHandler(stats *services.StatsRouter) *StatsStreamHandler {
+	return &StatsStreamHandler{stats: stats}
+}
+
+// ServeHTTP streams the stats of the job of the job_id query parameter.
+func (sh *StatsStreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	jobID := r.URL.Query().Get("job_id")
+	if jobID == "" {
+		http.Error(w, "job_id is required", http.StatusBadRequest)
+		return
+	}
+
+	logrus.WithField("job_id", jobID).Debug("streaming job stats")
+	samples, errs, err := sh.stats.StreamContainerStats(r.Context(), jobID)
+	switch {
+	case errors.Is(err, services.ErrJobNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrJobNotRunning):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	for stats := range samples {
+		if err := encoder.Encode(ContainerStatsResponse{JobID: jobID, Stats: stats}); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	select {
+	case err := <-errs:
+		logrus.WithField("job_id", jobID).Debugf("job stats stream ended: %v", err)
+	default:
+	}
+}
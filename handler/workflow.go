@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"container-manager/services"
+	"container-manager/types"
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WorkflowSubmitRequest is the request object for the Workflow.Submit method.
+// Steps: The steps of the workflow and their dependencies
+type WorkflowSubmitRequest struct {
+	Steps []types.WorkflowStep `json:"steps"`
+}
+
+// WorkflowRequest is the request object for the Workflow.Get and Workflow.Cancel methods.
+type WorkflowRequest struct {
+	ID string `json:"id"`
+}
+
+// WorkflowListRequest is the request object for the Workflow.List method.
+type WorkflowListRequest struct{}
+
+// StepStatus is the status of a step of a workflow.
+// Name: The name of the step
+// JobID: The ID of the job that runs the step once it was released
+// Node: The ID of the node that runs the step
+// Status: The status of the step
+type StepStatus struct {
+	Name   string `json:"name"`
+	JobID  string `json:"job_id,omitempty"`
+	Node   string `json:"node,omitempty"`
+	Status string `json:"status"`
+}
+
+// WorkflowResponse is the response object for the Workflow.Submit, Workflow.Get and Workflow.Cancel methods.
+// ID: The ID of the workflow
+// Status: The aggregate status of the workflow
+// Steps: The status of each step
+type WorkflowResponse struct {
+	ID     string       `json:"id"`
+	Status string       `json:"status"`
+	Steps  []StepStatus `json:"steps"`
+}
+
+// WorkflowListResponse is the response object for the Workflow.List method.
+type WorkflowListResponse struct {
+	Workflows []WorkflowResponse `json:"workflows"`
+}
+
+// WorkflowHandler is the service that runs workflows of container steps, registered as "Workflow".
+type WorkflowHandler struct {
+	engine    *services.WorkflowEngine
+	admission *services.AdmissionController
+}
+
+// NewWorkflowHandler creates a new workflow handler.
+func NewWorkflowHandler(
+	engine *services.WorkflowEngine,
+	admission *services.AdmissionController,
+) *WorkflowHandler {
+	return &WorkflowHandler{
+		engine:    engine,
+		admission: admission,
+	}
+}
+
+// Submit submits a workflow, the steps without dependencies are released right away.
+func (wh *WorkflowHandler) Submit(r *http.Request, req *WorkflowSubmitRequest, res *WorkflowResponse) error {
+	if req == nil {
+		return fmt.Errorf("invalid request")
+	}
+
+	logrus.WithField("steps", len(req.Steps)).Debug("submitting workflow")
+	steps := make([]types.WorkflowStep, 0, len(req.Steps))
+	for _, step := range req.Steps {
+		step = step.WithDefaults()
+		container, err := wh.admission.Admit(r.Context(), step.Container)
+		if err != nil {
+			return fmt.Errorf("invalid request: step %q: %w", step.Name, err)
+		}
+		step.Container = container
+		steps = append(steps, step)
+	}
+
+	workflow, err := wh.engine.Submit(types.Workflow{Steps: steps})
+	if err != nil {
+		return fmt.Errorf("failed to submit workflow: %w", err)
+	}
+
+	*res = workflowResponse(workflow)
+	return nil
+}
+
+// Get returns the status of a workflow and its steps.
+func (wh *WorkflowHandler) Get(_ *http.Request, req *WorkflowRequest, res *WorkflowResponse) error {
+	if req == nil {
+		return fmt.Errorf("invalid request")
+	}
+
+	workflow, err := wh.engine.Get(req.ID)
+	if err != nil {
+		return err
+	}
+
+	*res = workflowResponse(workflow)
+	return nil
+}
+
+// List returns the workflows submitted to the node.
+func (wh *WorkflowHandler) List(_ *http.Request, _ *WorkflowListRequest, res *WorkflowListResponse) error {
+	res.Workflows = []WorkflowResponse{}
+	for _, workflow := range wh.engine.List() {
+		res.Workflows = append(res.Workflows, workflowResponse(workflow))
+	}
+	return nil
+}
+
+// Cancel cancels a running workflow and its steps.
+func (wh *WorkflowHandler) Cancel(_ *http.Request, req *WorkflowRequest, res *WorkflowResponse) error {
+	if req == nil {
+		return fmt.Errorf("invalid request")
+	}
+
+	logrus.WithField("id", req.ID).Debug("cancelling workflow")
+	workflow, err := wh.engine.Cancel(req.ID)
+	if err != nil {
+		return fmt.Errorf("failed to cancel workflow: %w", err)
+	}
+
+	*res = workflowResponse(workflow)
+	return nil
+}
+
+// workflowResponse builds the response of a workflow
+func workflowResponse(workflow types.Workflow) WorkflowResponse {
+	res := WorkflowResponse{
+		ID:     workflow.ID,
+		Status: string(workflow.Status),
+		Steps:  make([]StepStatus, 0, len(workflow.Steps)),
+	}
+	for _, step := range workflow.Steps {
+		res.Steps = append(res.Steps, StepStatus{
+			Name:   step.Name,
+			JobID:  step.JobID,
+			Node:   step.Node,
+			Status: string(step.Status),
+		})
+	}
+	return res
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"container-manager/types"
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrImageNotAllowed is the error returned when an image is rejected by the admission policy
+	ErrImageNotAllowed = fmt.Errorf("image is not allowed by the admission policy")
+	// ErrImageDigestRequired is the error returned when an image is not pinned to a digest
+	ErrImageDigestRequired = fmt.Errorf("image must be pinned to a digest")
+)
+
+// ImageResolver resolves an image reference to the digest of its manifest
+type ImageResolver interface {
+	ResolveImageDigest(ctx context.Context, image string) (string, error)
+}
+
+// AdmissionPolicy is the policy applied to every container before it is queued.
+// Allow: Patterns of repositories allowed to run, an empty list allows every repository
+// Deny: Patterns of repositories never allowed to run, checked before Allow
+// RequireDigest: Reject images that are not pinned to a digest after admission
+// Security: Caps the security options of containers
+//
+// Patterns are globs matched against the fully qualified repository name
+// (e.g. docker.io/library/nginx) or any of its parent paths, so "ghcr.io/*"
+// matches every repository hosted on ghcr.io and "docker.io/library" matches
+// every official image.
+type AdmissionPolicy struct {
+	Allow         []string
+	Deny          []string
+	RequireDigest bool
+	Security      SecurityPolicy
+}
+
+// AdmissionController applies the admission policy to containers and pins their images.
+// policy: The admission policy
+// resolver: The resolver used to pin image tags to digests, nil disables pinning
+type AdmissionController struct {
+	policy   AdmissionPolicy
+	resolver ImageResolver
+}
+
+// NewAdmissionController creates a new admission controller
+func NewAdmissionController(policy AdmissionPolicy, resolver ImageResolver) (*AdmissionController, error) {
+	for _, pattern := range append(append([]string{}, policy.Allow...), policy.Deny...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid image pattern %q: %w", pattern, err)
+		}
+	}
+	if err := policy.Security.validate(); err != nil {
+		return nil, fmt.Errorf("invalid security policy: %w", err)
+	}
+
+	return &AdmissionController{
+		policy:   policy,
+		resolver: resolver,
+	}, nil
+}
+
+// Admit validates the container against the admission policy and returns it with
+// its image pinned to a digest. A nil controller only validates the container.
+func (ac *AdmissionController) Admit(ctx context.Context, container types.Container) (types.Container, error) {
+	if err := container.Validate(); err != nil {
+		return container, err
+	}
+	if ac == nil {
+		return container, nil
+	}
+
+	named, err := reference.ParseNormalizedNamed(container.Image)
+	if err != nil {
+		return container, fmt.Errorf("invalid image reference %q: %w", container.Image, err)
+	}
+
+	if matchImagePatterns(ac.policy.Deny, named.Name()) {
+		return container, fmt.Errorf("%w: %s is denied", ErrImageNotAllowed, named.Name())
+	}
+	if len(ac.policy.Allow) > 0 && !matchImagePatterns(ac.policy.Allow, named.Name()) {
+		return container, fmt.Errorf("%w: %s is not allowlisted", ErrImageNotAllowed, named.Name())
+	}
+	if err := ac.policy.Security.check(container.Security); err != nil {
+		return container, err
+	}
+
+	if _, pinned := named.(reference.Canonical); !pinned && ac.resolver != nil {
+		tagged := reference.TagNameOnly(named)
+		dgst, err := ac.resolver.ResolveImageDigest(ctx, tagged.String())
+		if err != nil {
+			return container, fmt.Errorf("failed to resolve digest for %s: %w", tagged, err)
+		}
+
+		parsed, err := digest.Parse(dgst)
+		if err != nil {
+			return container, fmt.Errorf("invalid digest %q for %s: %w", dgst, tagged, err)
+		}
+
+		named, err = reference.WithDigest(reference.TrimNamed(named), parsed)
+		if err != nil {
+			return container, fmt.Errorf("failed to pin %s to digest: %w", tagged, err)
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"image":  container.Image,
+			"pinned": named.String(),
+		}).Debug("pinned image to digest")
+		container.Image = named.String()
+	}
+
+	if _, pinned := named.(reference.Canonical); !pinned && ac.policy.RequireDigest {
+		return container, fmt.Errorf("%w: %s", ErrImageDigestRequired, container.Image)
+	}
+
+	return container, nil
+}
+
+// matchImagePatterns reports whether any pattern matches the repository name or one of its parent paths
+func matchImagePatterns(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		candidate := name
+		for {
+			if ok, _ := path.Match(pattern, candidate); ok {
+				return true
+			}
+
+			i := strings.LastIndex(candidate, "/")
+			if i < 0 {
+				break
+			}
+			candidate = candidate[:i]
+		}
+	}
+
+	return false
+}
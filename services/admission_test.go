@@ -0,0 +1,223 @@
+package services
+
+import (
+	"container-manager/types"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// staticResolver resolves every image to the same digest
+type staticResolver struct {
+	digest string
+	err    error
+	calls  []string
+}
+
+func (sr *staticResolver) ResolveImageDigest(_ context.Context, image string) (string, error) {
+	sr.calls = append(sr.calls, image)
+	return sr.digest, sr.err
+}
+
+func TestAdmissionController_NilAdmitsValidContainer(t *testing.T) {
+	t.Parallel()
+	var ac *AdmissionController
+
+	container, err := ac.Admit(context.Background(), types.Container{Image: "nginx"})
+	require.NoError(t, err)
+	require.Equal(t, "nginx", container.Image)
+
+	_, err = ac.Admit(context.Background(), types.Container{})
+	require.Error(t, err)
+}
+
+func TestAdmissionController_InvalidPlacementConstraints(t *testing.T) {
+	t.Parallel()
+	var ac *AdmissionController
+
+	_, err := ac.Admit(context.Background(), types.Container{
+		Image: "nginx",
+		Affinity: &types.Affinity{
+			Required: []types.LabelRequirement{{Key: "zone", Operator: types.LabelOpIn}},
+		},
+	})
+	require.Error(t, err)
+
+	_, err = ac.Admit(context.Background(), types.Container{
+		Image:  "nginx",
+		Spread: []types.SpreadConstraint{{TopologyKey: "zone", Selector: map[string]string{"app": "web"}}},
+	})
+	require.Error(t, err)
+
+	_, err = ac.Admit(context.Background(), types.Container{
+		Image:        "nginx",
+		AntiAffinity: []types.AntiAffinityTerm{{TopologyKey: "zone"}},
+	})
+	require.Error(t, err)
+}
+
+func TestAdmissionController_InvalidPattern(t *testing.T) {
+	t.Parallel()
+	_, err := NewAdmissionController(AdmissionPolicy{Allow: []string{"docker.io/["}}, nil)
+	require.Error(t, err)
+}
+
+func TestAdmissionController_AllowAndDeny(t *testing.T) {
+	t.Parallel()
+	ac, err := NewAdmissionController(AdmissionPolicy{
+		Allow: []string{"docker.io/library", "ghcr.io/*"},
+		Deny:  []string{"docker.io/library/busybox"},
+	}, nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		image   string
+		allowed bool
+	}{
+		{image: "nginx", allowed: true},
+		{image: "nginx:1.25", allowed: true},
+		{image: "ghcr.io/acme/app:v1", allowed: true},
+		{image: "busybox", allowed: false},
+		{image: "quay.io/acme/app", allowed: false},
+		{image: "acme/app", allowed: false},
+	}
+	for _, tt := range tests {
+		_, err := ac.Admit(context.Background(), types.Container{Image: tt.image})
+		if tt.allowed {
+			require.NoError(t, err, tt.image)
+		} else {
+			require.ErrorIs(t, err, ErrImageNotAllowed, tt.image)
+		}
+	}
+}
+
+func TestAdmissionController_PinsDigest(t *testing.T) {
+	t.Parallel()
+	resolver := &staticResolver{digest: testDigest}
+	ac, err := NewAdmissionController(AdmissionPolicy{RequireDigest: true}, resolver)
+	require.NoError(t, err)
+
+	container, err := ac.Admit(context.Background(), types.Container{Image: "nginx"})
+	require.NoError(t, err)
+	require.Equal(t, "docker.io/library/nginx@"+testDigest, container.Image)
+	require.Equal(t, []string{"docker.io/library/nginx:latest"}, resolver.calls)
+
+	// pinned images are not resolved again
+	pinned, err := ac.Admit(context.Background(), container)
+	require.NoError(t, err)
+	require.Equal(t, container.Image, pinned.Image)
+	require.Len(t, resolver.calls, 1)
+}
+
+func TestAdmissionController_ResolveFailure(t *testing.T) {
+	t.Parallel()
+	ac, err := NewAdmissionController(AdmissionPolicy{}, &staticResolver{err: fmt.Errorf("registry unavailable")})
+	require.NoError(t, err)
+
+	_, err = ac.Admit(context.Background(), types.Container{Image: "nginx"})
+	require.Error(t, err)
+}
+
+func TestAdmissionController_RequireDigestWithoutResolver(t *testing.T) {
+	t.Parallel()
+	ac, err := NewAdmissionController(AdmissionPolicy{RequireDigest: true}, nil)
+	require.NoError(t, err)
+
+	_, err = ac.Admit(context.Background(), types.Container{Image: "nginx:latest"})
+	require.ErrorIs(t, err, ErrImageDigestRequired)
+
+	_, err = ac.Admit(context.Background(), types.Container{Image: "nginx@" + testDigest})
+	require.NoError(t, err)
+}
+
+func TestAdmissionController_InvalidSecurity(t *testing.T) {
+	t.Parallel()
+	var ac *AdmissionController
+
+	tests := []types.SecurityContext{
+		{User: "Root"},
+		{Group: "1000"},
+		{User: "1000", Group: "wheel;"},
+		{CapAdd: []string{"net-admin"}},
+		{CapAdd: []string{"NET_ADMIN"}, CapDrop: []string{"CAP_NET_ADMIN"}},
+		{SeccompProfile: "../profile"},
+		{AppArmorProfile: "docker default"},
+	}
+	for _, security := range tests {
+		_, err := ac.Admit(context.Background(), types.Container{Image: "nginx", Security: &security})
+		require.Error(t, err, security)
+	}
+
+	_, err := ac.Admit(context.Background(), types.Container{
+		Image:    "nginx",
+		Inputs:   []types.ArtifactInput{{Digest: testDigest, Node: "node", Path: "/data"}},
+		Security: &types.SecurityContext{ReadOnlyRootFS: true},
+	})
+	require.Error(t, err)
+
+	_, err = NewAdmissionController(AdmissionPolicy{Security: SecurityPolicy{AllowedCapabilities: []string{"net admin"}}}, nil)
+	require.Error(t, err)
+}
+
+func TestAdmissionController_SecurityPolicy(t *testing.T) {
+	t.Parallel()
+	ac, err := NewAdmissionController(AdmissionPolicy{Security: SecurityPolicy{
+		AllowedCapabilities:    []string{"CAP_NET_BIND_SERVICE"},
+		RequireNonRoot:         true,
+		RequireNoNewPrivileges: true,
+	}}, nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		security *types.SecurityContext
+		allowed  bool
+	}{
+		{security: nil, allowed: false},
+		{security: &types.SecurityContext{User: "1000", NoNewPrivileges: true}, allowed: true},
+		{security: &types.SecurityContext{User: "0", NoNewPrivileges: true}, allowed: false},
+		{security: &types.SecurityContext{User: "000", NoNewPrivileges: true}, allowed: false},
+		{security: &types.SecurityContext{User: "root", Group: "1000", NoNewPrivileges: true}, allowed: false},
+		{security: &types.SecurityContext{User: "01000", NoNewPrivileges: true}, allowed: true},
+		{security: &types.SecurityContext{User: "app"}, allowed: false},
+		{security: &types.SecurityContext{
+			User:            "app",
+			NoNewPrivileges: true,
+			CapAdd:          []string{"net_bind_service"},
+			CapDrop:         []string{"ALL"},
+		}, allowed: true},
+		{security: &types.SecurityContext{User: "app", NoNewPrivileges: true, CapAdd: []string{"SYS_ADMIN"}}, allowed: false},
+		{security: &types.SecurityContext{User: "app", NoNewPrivileges: true, CapAdd: []string{"ALL"}}, allowed: false},
+		{security: &types.SecurityContext{User: "app", NoNewPrivileges: true, SeccompProfile: "strict"}, allowed: true},
+		{security: &types.SecurityContext{User: "app", NoNewPrivileges: true, SeccompProfile: "unconfined"}, allowed: false},
+		{security: &types.SecurityContext{User: "app", NoNewPrivileges: true, AppArmorProfile: "unconfined"}, allowed: false},
+	}
+	for _, tt := range tests {
+		_, err := ac.Admit(context.Background(), types.Container{Image: "nginx", Security: tt.security})
+		if tt.allowed {
+			require.NoError(t, err, tt.security)
+		} else {
+			require.ErrorIs(t, err, ErrSecurityNotAllowed, tt.security)
+		}
+	}
+
+	// the default policy allows no capabilities and no unconfined containers, and the rest of the defaults of the runtime
+	ac, err = NewAdmissionController(AdmissionPolicy{}, nil)
+	require.NoError(t, err)
+	_, err = ac.Admit(context.Background(), types.Container{Image: "nginx"})
+	require.NoError(t, err)
+	_, err = ac.Admit(context.Background(), types.Container{Image: "nginx", Security: &types.SecurityContext{CapAdd: []string{"NET_ADMIN"}}})
+	require.ErrorIs(t, err, ErrSecurityNotAllowed)
+
+	ac, err = NewAdmissionController(AdmissionPolicy{Security: SecurityPolicy{AllowedCapabilities: []string{"ALL"}, AllowUnconfined: true}}, nil)
+	require.NoError(t, err)
+	_, err = ac.Admit(context.Background(), types.Container{Image: "nginx", Security: &types.SecurityContext{
+		CapAdd:          []string{"SYS_ADMIN"},
+		SeccompProfile:  "unconfined",
+		AppArmorProfile: "unconfined",
+	}})
+	require.NoError(t, err)
+}
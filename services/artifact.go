@@ -0,0 +1,336 @@
+package services
+
+import (
+	"archive/tar"
+	"bufio"
+	"container-manager/types"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrArtifactNotFound is the error returned when an artifact isn't in the store
+	ErrArtifactNotFound = fmt.Errorf("artifact not found")
+	// ErrArtifactDigestMismatch is the error returned when a fetched artifact doesn't match its digest
+	ErrArtifactDigestMismatch = fmt.Errorf("artifact digest mismatch")
+)
+
+const (
+	// maxDigestLineSize is the maximum size of an artifact request
+	maxDigestLineSize = 128
+	// artifactStatusOK is the status byte that precedes an artifact served to a peer
+	artifactStatusOK = 0
+	// artifactStatusNotFound is the status byte of an artifact that isn't in the store
+	artifactStatusNotFound = 1
+)
+
+// ArtifactFetcher fetches artifacts from the nodes that hold them
+type ArtifactFetcher interface {
+	FetchArtifact(nodeID string, digest string) (io.ReadCloser, error)
+}
+
+// ArtifactStore keeps the artifacts collected from containers, addressed by the SHA-256 digest of their tar archive.
+// dir: The directory the artifacts are stored in
+// fetcher: Fetches the artifacts held by other nodes
+// mutex: The mutex to protect the fetcher
+type ArtifactStore struct {
+	dir     string
+	fetcher ArtifactFetcher
+	mutex   sync.Mutex
+}
+
+// NewArtifactStore creates a new artifact store in the directory
+func NewArtifactStore(dir string) (*ArtifactStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create artifact directory: %w", err)
+	}
+	return &ArtifactStore{dir: dir}, nil
+}
+
+// SetFetcher sets the fetcher used for artifacts held by other nodes
+func (as *ArtifactStore) SetFetcher(fetcher ArtifactFetcher) {
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+
+	as.fetcher = fetcher
+}
+
+// Put stores a tar archive and returns its digest and size
+func (as *ArtifactStore) Put(archive io.Reader) (string, int64, error) {
+	file, err := os.CreateTemp(as.dir, "upload-*")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create artifact file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), archive)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to write artifact: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", 0, fmt.Errorf("failed to write artifact: %w", err)
+	}
+
+	digest := "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	target, _ := as.path(digest)
+	if err := os.Rename(file.Name(), target); err != nil {
+		return "", 0, fmt.Errorf("failed to store artifact: %w", err)
+	}
+	return digest, size, nil
+}
+
+// sizeLimitReader reads from a reader until more than the remaining bytes were read, then fails with ErrCopyTooLarge
+type sizeLimitReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+// Read reads from the underlying reader, failing once the archive exceeds the size limit
+func (r *sizeLimitReader) Read(p []byte) (int, error) {
+	if r.remaining < 0 {
+		return 0, ErrCopyTooLarge
+	}
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, ErrCopyTooLarge
+	}
+	return n, err
+}
+
+// Open opens the tar archive of an artifact
+func (as *ArtifactStore) Open(digest string) (io.ReadCloser, error) {
+	artifactPath, err := as.path(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(artifactPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrArtifactNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open artifact: %w", err)
+	}
+	return file, nil
+}
+
+// Has reports whether the artifact is in the store
+func (as *ArtifactStore) Has(digest string) bool {
+	artifactPath, err := as.path(digest)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(artifactPath)
+	return err == nil
+}
+
+// Ensure fetches the artifact of an input from the node that holds it, unless it is already in the store
+func (as *ArtifactStore) Ensure(input types.ArtifactInput) error {
+	if err := types.ValidateArtifactDigest(input.Digest); err != nil {
+		return err
+	}
+	if as.Has(input.Digest) {
+		return nil
+	}
+
+	as.mutex.Lock()
+	fetcher := as.fetcher
+	as.mutex.Unlock()
+	if fetcher == nil {
+		return fmt.Errorf("%w: %s", ErrArtifactNotFound, input.Digest)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"digest": input.Digest,
+		"node":   input.Node,
+	}).Info("fetching artifact")
+	archive, err := fetcher.FetchArtifact(input.Node, input.Digest)
+	if err != nil {
+		return fmt.Errorf("failed to fetch artifact: %w", err)
+	}
+	defer archive.Close()
+
+	digest, _, err := as.Put(archive)
+	if err != nil {
+		return err
+	}
+	if digest != input.Digest {
+		as.remove(digest)
+		return fmt.Errorf("%w: expected %s, got %s", ErrArtifactDigestMismatch, input.Digest, digest)
+	}
+	return nil
+}
+
+// remove removes an artifact from the store
+func (as *ArtifactStore) remove(digest string) {
+	if artifactPath, err := as.path(digest); err == nil {
+		os.Remove(artifactPath)
+	}
+}
+
+// path returns the path of an artifact in the store
+func (as *ArtifactStore) path(digest string) (string, error) {
+	if err := types.ValidateArtifactDigest(digest); err != nil {
+		return "", err
+	}
+	return filepath.Join(as.dir, strings.TrimPrefix(digest, "sha256:")), nil
+}
+
+// handleArtifactStream answers an artifact request, a digest on a line, with a status byte followed by the artifact
+func (s *Service) handleArtifactStream(stream network.Stream) {
+	defer stream.Close()
+
+	line, err := bufio.NewReader(io.LimitReader(stream, maxDigestLineSize)).ReadString('\n')
+	if err != nil {
+		logrus.Errorf("failed to read artifact request: %v", err)
+		return
+	}
+	digest := strings.TrimSpace(line)
+	remote := stream.Conn().RemotePeer()
+
+	input, allowed := s.artifactGrant(remote, digest)
+	if !allowed {
+		logrus.WithFields(logrus.Fields{
+			"digest": digest,
+			"peer":   remote,
+		}).Warn("rejected artifact request of a peer without a job that uses it")
+		stream.Write([]byte{artifactStatusNotFound})
+		return
+	}
+	// the inputs of the jobs handed to the peer are relayed from the nodes that hold them
+	if input.Node != "" && input.Node != s.ID() {
+		if err := s.artifacts.Ensure(input); err != nil {
+			logrus.WithField("digest", digest).Warnf("failed to relay artifact: %v", err)
+		}
+	}
+
+	archive, err := s.artifacts.Open(digest)
+	if err != nil {
+		logrus.WithField("digest", digest).Warnf("failed to serve artifact: %v", err)
+		stream.Write([]byte{artifactStatusNotFound})
+		return
+	}
+	defer archive.Close()
+
+	if _, err := stream.Write([]byte{artifactStatusOK}); err != nil {
+		logrus.Errorf("failed to write artifact to stream: %v", err)
+		return
+	}
+	if _, err := io.Copy(stream, archive); err != nil {
+		logrus.Errorf("failed to write artifact to stream: %v", err)
+	}
+}
+
+// artifactGrant reports whether the peer may fetch the artifact: the peer handed over the job of this node that
+// produced it, runs a job this node handed over that takes it as input, or is trusted. The input is returned for
+// the jobs handed to the peer, so the artifact can be relayed from the node that holds it.
+func (s *Service) artifactGrant(remote peer.ID, digest string) (types.ArtifactInput, bool) {
+	for _, job := range s.jobQueue.Jobs() {
+		if job.Node == s.ID() && job.Origin == remote.String() &&
+			slices.ContainsFunc(job.Artifacts, func(artifact types.Artifact) bool { return artifact.Digest == digest }) {
+			return types.ArtifactInput{}, true
+		}
+		if job.Node == remote.String() {
+			index := slices.IndexFunc(job.Container.Inputs, func(input types.ArtifactInput) bool { return input.Digest == digest })
+			if index >= 0 {
+				return job.Container.Inputs[index], true
+			}
+		}
+	}
+	return types.ArtifactInput{}, s.trust.Trusted(remote)
+}
+
+// FetchArtifact fetches an artifact from the node that holds it
+func (s *Service) FetchArtifact(nodeID string, digest string) (io.ReadCloser, error) {
+	id, err := peer.Decode(nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode peer ID: %w", err)
+	}
+
+	stream, err := s.host.NewStream(s.ctx, id, ArtifactProtocolID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open artifact stream: %w", err)
+	}
+	if _, err := stream.Write([]byte(digest + "\n")); err != nil {
+		stream.Reset()
+		return nil, fmt.Errorf("failed to write artifact request: %w", err)
+	}
+	stream.CloseWrite()
+
+	status := make([]byte, 1)
+	if _, err := io.ReadFull(stream, status); err != nil {
+		stream.Reset()
+		return nil, fmt.Errorf("failed to read artifact response: %w", err)
+	}
+	if status[0] != artifactStatusOK {
+		stream.Close()
+		return nil, fmt.Errorf("%w: %s on peer %s", ErrArtifactNotFound, digest, nodeID)
+	}
+	return stream, nil
+}
+
+// relocateArchive rewrites a tar archive from CopyFromContainer, whose entries are rooted at the base name
+// of the copied path, so its entries are rooted at the target path relative to the root of the container
+func relocateArchive(archive io.Reader, target string) io.ReadCloser {
+	root := strings.TrimPrefix(path.Clean(target), "/")
+	reader, writer := io.Pipe()
+
+	go func() {
+		tr := tar.NewReader(archive)
+		tw := tar.NewWriter(writer)
+
+		relocate := func(name string) string {
+			name = strings.TrimPrefix(name, "./")
+			if _, rest, ok := strings.Cut(name, "/"); ok {
+				return path.Join(root, rest)
+			}
+			return root
+		}
+
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				writer.CloseWithError(fmt.Errorf("failed to read artifact: %w", err))
+				return
+			}
+
+			header.Name = relocate(header.Name)
+			if header.Typeflag == tar.TypeLink {
+				header.Linkname = relocate(header.Linkname)
+			}
+			if err := tw.WriteHeader(header); err != nil {
+				writer.CloseWithError(fmt.Errorf("failed to write artifact: %w", err))
+				return
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				writer.CloseWithError(fmt.Errorf("failed to write artifact: %w", err))
+				return
+			}
+		}
+		writer.CloseWithError(tw.Close())
+	}()
+
+	return reader
+}
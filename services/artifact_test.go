@@ -0,0 +1,208 @@
+package services
+
+import (
+	"archive/tar"
+	"bytes"
+	"container-manager/types"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestArchive builds a tar archive with the files, keyed by name
+func newTestArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+// staticFetcher serves the same archive for every artifact
+type staticFetcher []byte
+
+func (sf staticFetcher) FetchArtifact(_ string, _ string) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(sf)), nil
+}
+
+func TestArtifactStore(t *testing.T) {
+	t.Parallel()
+
+	store, err := NewArtifactStore(t.TempDir())
+	require.NoError(t, err)
+
+	archive := newTestArchive(t, map[string]string{"result.txt": "42"})
+	digest, size, err := store.Put(bytes.NewReader(archive))
+	require.NoError(t, err)
+	require.NoError(t, types.ValidateArtifactDigest(digest))
+	require.Equal(t, int64(len(archive)), size)
+	require.True(t, store.Has(digest))
+
+	reader, err := store.Open(digest)
+	require.NoError(t, err)
+	defer reader.Close()
+	stored, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, archive, stored)
+
+	_, err = store.Open("sha256:" + strings.Repeat("0", 64))
+	require.ErrorIs(t, err, ErrArtifactNotFound)
+	_, err = store.Open("../identity.key")
+	require.Error(t, err)
+}
+
+func TestArtifactStoreEnsure(t *testing.T) {
+	t.Parallel()
+
+	archive := newTestArchive(t, map[string]string{"result.txt": "42"})
+	source, err := NewArtifactStore(t.TempDir())
+	require.NoError(t, err)
+	digest, _, err := source.Put(bytes.NewReader(archive))
+	require.NoError(t, err)
+
+	store, err := NewArtifactStore(t.TempDir())
+	require.NoError(t, err)
+
+	// artifacts that aren't in the store are fetched from the node that holds them
+	store.SetFetcher(staticFetcher(archive))
+	require.NoError(t, store.Ensure(types.ArtifactInput{Digest: digest, Node: "peer", Path: "/in"}))
+	require.True(t, store.Has(digest))
+
+	// fetched artifacts must match their digest
+	other := "sha256:" + strings.Repeat("0", 64)
+	err = store.Ensure(types.ArtifactInput{Digest: other, Node: "peer", Path: "/in"})
+	require.ErrorIs(t, err, ErrArtifactDigestMismatch)
+	require.False(t, store.Has(other))
+}
+
+func TestRelocateArchive(t *testing.T) {
+	t.Parallel()
+
+	archive := newTestArchive(t, map[string]string{
+		"data/":          "",
+		"data/part-1":    "a",
+		"data/nested/p2": "b",
+	})
+
+	reader := relocateArchive(bytes.NewReader(archive), "/in/dataset/")
+	defer reader.Close()
+
+	names := map[string]bool{}
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names[header.Name] = true
+	}
+	require.Equal(t, map[string]bool{
+		"in/dataset":           true,
+		"in/dataset/part-1":    true,
+		"in/dataset/nested/p2": true,
+	}, names)
+}
+
+func TestP2PServiceFetchArtifact(t *testing.T) {
+	t.Parallel()
+
+	store1, err := NewArtifactStore(t.TempDir())
+	require.NoError(t, err)
+	archive := newTestArchive(t, map[string]string{"result.txt": "42"})
+	digest, _, err := store1.Put(bytes.NewReader(archive))
+	require.NoError(t, err)
+	store2, err := NewArtifactStore(t.TempDir())
+	require.NoError(t, err)
+	dataset := newTestArchive(t, map[string]string{"dataset/part-1": "a"})
+	datasetDigest, _, err := store2.Put(bytes.NewReader(dataset))
+	require.NoError(t, err)
+
+	jobQueue1 := NewQueue(10, nil)
+	jobQueue2 := NewQueue(10, nil)
+	service1, err := NewP2PService(jobQueue1, 4056, WithArtifactStore(store1))
+	require.NoError(t, err)
+	service2, err := NewP2PService(jobQueue2, 4057, WithArtifactStore(store2))
+	require.NoError(t, err)
+	service3, err := NewP2PService(NewQueue(10, nil), 4067)
+	require.NoError(t, err)
+	store1.SetFetcher(service1)
+
+	go service1.Start(t.Name())
+	go service2.Start(t.Name())
+	go service3.Start(t.Name())
+	defer service1.Stop()
+	defer service2.Stop()
+	defer service3.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, service := range []*Service{service2, service3} {
+		require.NoError(t, service.host.Connect(ctx, peer.AddrInfo{
+			ID:    service1.host.ID(),
+			Addrs: service1.host.Addrs(),
+		}))
+	}
+	require.NoError(t, service2.host.Connect(ctx, peer.AddrInfo{
+		ID:    service3.host.ID(),
+		Addrs: service3.host.Addrs(),
+	}))
+
+	// the node that handed over a job fetches its outputs
+	jobQueue1.Track(types.Job{
+		ID:        "producer",
+		Status:    types.JobStatusComplete,
+		Node:      service1.ID(),
+		Origin:    service2.ID(),
+		Artifacts: []types.Artifact{{Name: "result", Digest: digest, Node: service1.ID()}},
+	})
+	reader, err := service2.FetchArtifact(service1.ID(), digest)
+	require.NoError(t, err)
+	fetched, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, archive, fetched)
+
+	// other peers can't fetch it
+	_, err = service3.FetchArtifact(service1.ID(), digest)
+	require.ErrorIs(t, err, ErrArtifactNotFound)
+	_, err = service2.FetchArtifact(service1.ID(), "sha256:"+strings.Repeat("0", 64))
+	require.ErrorIs(t, err, ErrArtifactNotFound)
+
+	// the inputs of a job handed to a peer are relayed from the node that holds them
+	jobQueue2.Track(types.Job{
+		ID:        "dataset",
+		Status:    types.JobStatusComplete,
+		Node:      service2.ID(),
+		Origin:    service1.ID(),
+		Artifacts: []types.Artifact{{Name: "dataset", Digest: datasetDigest, Node: service2.ID()}},
+	})
+	jobQueue1.Track(types.Job{
+		ID:     "consumer",
+		Status: types.JobStatusPending,
+		Node:   service3.ID(),
+		Container: types.Container{
+			Image:  "busybox",
+			Inputs: []types.ArtifactInput{{Digest: datasetDigest, Node: service2.ID(), Path: "/in/dataset"}},
+		},
+	})
+	_, err = service3.FetchArtifact(service2.ID(), datasetDigest)
+	require.ErrorIs(t, err, ErrArtifactNotFound)
+	reader, err = service3.FetchArtifact(service1.ID(), datasetDigest)
+	require.NoError(t, err)
+	fetched, err = io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, dataset, fetched)
+}
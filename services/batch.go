@@ -0,0 +1,237 @@
+package services
+
+import (
+	"container-manager/types"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrBatchNotFound is the error returned when a batch doesn't exist
+	ErrBatchNotFound = fmt.Errorf("batch not found")
+	// ErrBatchFinished is the error returned when cancelling a batch that already finished
+	ErrBatchFinished = fmt.Errorf("batch already finished")
+)
+
+const (
+	// batchRefreshInterval is the interval at which the batches are refreshed and pruned
+	batchRefreshInterval = time.Minute
+	// batchRetention is how long finished batches are kept
+	batchRetention = time.Hour
+)
+
+// BatchManager expands the batches submitted to the node into jobs and tracks them.
+// batches: The batches submitted to the node
+// queue: The job queue, which also tracks the jobs on other nodes
+// dispatcher: The dispatcher that places and cancels the jobs
+// placing: The batches whose jobs are being placed
+// mutex: The mutex to protect the batches
+// quit: The channel to signal the loop to quit
+// wg: The wait group to wait for the loop to finish
+type BatchManager struct {
+	batches    map[string]*types.Batch
+	queue      Queue
+	dispatcher *Dispatcher
+	placing    map[string]struct{}
+	mutex      sync.Mutex
+	quit       chan bool
+	wg         sync.WaitGroup
+}
+
+// NewBatchManager creates a new batch manager
+func NewBatchManager(queue Queue, dispatcher *Dispatcher) *BatchManager {
+	return &BatchManager{
+		batches:    make(map[string]*types.Batch),
+		queue:      queue,
+		dispatcher: dispatcher,
+		placing:    make(map[string]struct{}),
+		quit:       make(chan bool),
+	}
+}
+
+// Run starts the loop that records the counts of the batches that finished, before the records of their jobs
+// on other nodes are pruned, and prunes the batches that finished more than batchRetention ago
+func (bm *BatchManager) Run() {
+	bm.wg.Add(1)
+	go func() {
+		defer bm.wg.Done()
+
+		ticker := time.NewTicker(batchRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				bm.prune()
+			case <-bm.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the loop
+func (bm *BatchManager) Stop() {
+	close(bm.quit)
+	bm.wg.Wait()
+}
+
+// prune refreshes the batches and removes the ones that finished more than batchRetention ago
+func (bm *BatchManager) prune() {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	for id, batch := range bm.batches {
+		bm.snapshot(batch)
+		if !batch.FinishedAt.IsZero() && time.Since(batch.FinishedAt) > batchRetention {
+			logrus.WithField("batch", id).Debug("pruned finished batch")
+			delete(bm.batches, id)
+		}
+	}
+}
+
+// Submit validates a batch and places each of its jobs. Jobs that can't be placed are tracked as failed.
+func (bm *BatchManager) Submit(batch types.Batch, client string) (types.Batch, error) {
+	if err := batch.Validate(); err != nil {
+		return types.Batch{}, err
+	}
+
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return types.Batch{}, fmt.Errorf("failed to generate batch ID: %w", err)
+	}
+	batch.ID = id.String()
+	batch.CreatedAt = time.Now()
+
+	containers := batch.Expand()
+	batch.Jobs = make([]string, 0, len(containers))
+	for range containers {
+		jobID, err := uuid.NewUUID()
+		if err != nil {
+			return types.Batch{}, fmt.Errorf("failed to generate job ID: %w", err)
+		}
+		batch.Jobs = append(batch.Jobs, jobID.String())
+	}
+
+	// the batch is registered before its jobs are placed, so none of them is left without a batch
+	bm.mutex.Lock()
+	bm.batches[batch.ID] = &batch
+	bm.placing[batch.ID] = struct{}{}
+	bm.mutex.Unlock()
+
+	for index, container := range containers {
+		jobID := batch.Jobs[index]
+		if _, err := bm.dispatcher.Dispatch(jobID, container, client); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"batch": batch.ID,
+				"index": index,
+			}).Warnf("failed to place job of batch: %v", err)
+			bm.queue.Track(types.Job{
+				ID:        jobID,
+				Container: container,
+				Status:    types.JobStatusFailed,
+			})
+		}
+	}
+
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	delete(bm.placing, batch.ID)
+	logrus.WithFields(logrus.Fields{
+		"batch": batch.ID,
+		"jobs":  len(batch.Jobs),
+	}).Info("submitted batch")
+	return bm.snapshot(&batch), nil
+}
+
+// Get returns a batch with the counts of its jobs
+func (bm *BatchManager) Get(id string) (types.Batch, error) {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	batch, ok := bm.batches[id]
+	if !ok {
+		return types.Batch{}, ErrBatchNotFound
+	}
+	return bm.snapshot(batch), nil
+}
+
+// List returns the batches, sorted by submission time
+func (bm *BatchManager) List() []types.Batch {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	batches := make([]types.Batch, 0, len(bm.batches))
+	for _, batch := range bm.batches {
+		batches = append(batches, bm.snapshot(batch))
+	}
+	sort.Slice(batches, func(i, j int) bool {
+		return batches[i].CreatedAt.Before(batches[j].CreatedAt)
+	})
+	return batches
+}
+
+// Cancel cancels the jobs of a batch that are waiting to run or running
+func (bm *BatchManager) Cancel(id string) (types.Batch, error) {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	batch, ok := bm.batches[id]
+	if !ok {
+		return types.Batch{}, ErrBatchNotFound
+	}
+	if bm.snapshot(batch).Status != types.BatchStatusRunning {
+		return types.Batch{}, ErrBatchFinished
+	}
+
+	for _, jobID := range batch.Jobs {
+		job, ok := bm.queue.GetJob(jobID)
+		if !ok || !job.Status.Active() {
+			continue
+		}
+		if err := bm.dispatcher.Cancel(job); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"batch":  batch.ID,
+				"job_id": jobID,
+			}).Warnf("failed to cancel job of batch: %v", err)
+		}
+	}
+
+	logrus.WithField("batch", batch.ID).Info("cancelled batch")
+	return bm.snapshot(batch), nil
+}
+
+// snapshot returns a copy of a batch with the counts and status of its jobs. The jobs of a batch that are
+// still being placed are pending. The counts of a batch are recorded once it finished, they are kept when
+// the records of its jobs are pruned.
+func (bm *BatchManager) snapshot(batch *types.Batch) types.Batch {
+	if batch.FinishedAt.IsZero() {
+		_, placing := bm.placing[batch.ID]
+		batch.Counts = types.BatchCounts{}
+		for _, jobID := range batch.Jobs {
+			status := types.JobStatusFailed
+			if placing {
+				status = types.JobStatusPending
+			}
+			if job, ok := bm.queue.GetJob(jobID); ok {
+				status = job.Status
+			}
+			batch.Counts.Add(status)
+		}
+		batch.Status = batch.Counts.Status()
+		if batch.Status != types.BatchStatusRunning {
+			batch.FinishedAt = time.Now()
+		}
+	}
+
+	snapshot := *batch
+	snapshot.Jobs = slices.Clone(batch.Jobs)
+	return snapshot
+}
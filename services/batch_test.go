@@ -0,0 +1,156 @@
+package services
+
+import (
+	"container-manager/types"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+// newTestBatchManager creates a batch manager whose jobs are enqueued on the local node
+func newTestBatchManager(ctrl *gomock.Controller) (*BatchManager, *MockQueue, map[string]*types.Job) {
+	reconciler, mockQueue, _ := newTestReconciler(ctrl)
+	manager := NewBatchManager(mockQueue, reconciler.dispatcher)
+	jobs := map[string]*types.Job{}
+
+	mockQueue.EXPECT().Enqueue(gomock.Any(), gomock.Any()).DoAndReturn(func(jobID string, container types.Container) error {
+		jobs[jobID] = &types.Job{
+			ID:        jobID,
+			Container: container,
+			Status:    types.JobStatusPending,
+			Node:      "local",
+		}
+		return nil
+	}).AnyTimes()
+	mockQueue.EXPECT().GetJob(gomock.Any()).DoAndReturn(func(jobID string) (types.Job, bool) {
+		job, ok := jobs[jobID]
+		if !ok {
+			return types.Job{}, false
+		}
+		return *job, true
+	}).AnyTimes()
+	return manager, mockQueue, jobs
+}
+
+func TestBatchExpandMatrix(t *testing.T) {
+	t.Parallel()
+
+	batch := types.Batch{
+		ID:       "batch",
+		Template: types.Container{Image: "busybox", Env: map[string]string{"MODE": "fast"}},
+		Matrix: map[string][]string{
+			"SIZE":  {"small", "large"},
+			"COLOR": {"red", "green", "blue"},
+		},
+	}
+	require.NoError(t, batch.Validate())
+
+	// one container per combination, the last parameter changing fastest
+	containers := batch.Expand()
+	require.Len(t, containers, 6)
+	require.Equal(t, map[string]string{"MODE": "fast", "COLOR": "red", "SIZE": "small", "JOB_INDEX": "0"}, containers[0].Env)
+	require.Equal(t, map[string]string{"MODE": "fast", "COLOR": "red", "SIZE": "large", "JOB_INDEX": "1"}, containers[1].Env)
+	require.Equal(t, map[string]string{"MODE": "fast", "COLOR": "blue", "SIZE": "large", "JOB_INDEX": "5"}, containers[5].Env)
+	require.Equal(t, "batch", containers[5].Labels[types.BatchLabel])
+	require.Equal(t, "5", containers[5].Labels[types.BatchIndexLabel])
+
+	// the template is left untouched
+	require.Equal(t, map[string]string{"MODE": "fast"}, batch.Template.Env)
+}
+
+func TestBatchValidate(t *testing.T) {
+	t.Parallel()
+
+	template := types.Container{Image: "busybox"}
+	require.Error(t, types.Batch{Template: template}.Validate())
+	require.Error(t, types.Batch{Template: template, Count: -1}.Validate())
+	require.Error(t, types.Batch{Template: template, Count: types.MaxBatchSize + 1}.Validate())
+	require.Error(t, types.Batch{Template: template, Count: 2, Matrix: map[string][]string{"A": {"1"}}}.Validate())
+	require.Error(t, types.Batch{Template: template, Matrix: map[string][]string{"A": {}}}.Validate())
+	require.Error(t, types.Batch{Template: template, Matrix: map[string][]string{"JOB_INDEX": {"1"}}}.Validate())
+	require.Error(t, types.Batch{Template: template, Matrix: map[string][]string{"NOT-VALID": {"1"}}}.Validate())
+	require.NoError(t, types.Batch{Template: template, Count: types.MaxBatchSize}.Validate())
+}
+
+func TestBatchManagerSubmit(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	manager, _, jobs := newTestBatchManager(ctrl)
+
+	batch, err := manager.Submit(types.Batch{Template: types.Container{Image: "busybox"}, Count: 3}, "alice")
+	require.NoError(t, err)
+	require.Len(t, batch.Jobs, 3)
+	require.Equal(t, types.BatchStatusRunning, batch.Status)
+	require.Equal(t, types.BatchCounts{Total: 3, Pending: 3}, batch.Counts)
+	for index, jobID := range batch.Jobs {
+		require.Equal(t, batch.ID, jobs[jobID].Container.Labels[types.BatchLabel])
+		require.Equal(t, strconv.Itoa(index), jobs[jobID].Container.Env[types.JobIndexEnv])
+	}
+
+	// the batch finishes once all its jobs finished
+	jobs[batch.Jobs[0]].Status = types.JobStatusComplete
+	jobs[batch.Jobs[1]].Status = types.JobStatusRunning
+	jobs[batch.Jobs[2]].Status = types.JobStatusFailed
+	batch, err = manager.Get(batch.ID)
+	require.NoError(t, err)
+	require.Equal(t, types.BatchCounts{Total: 3, Running: 1, Complete: 1, Failed: 1}, batch.Counts)
+	require.Equal(t, types.BatchStatusRunning, batch.Status)
+
+	jobs[batch.Jobs[1]].Status = types.JobStatusComplete
+	batch, err = manager.Get(batch.ID)
+	require.NoError(t, err)
+	require.Equal(t, types.BatchStatusFailed, batch.Status)
+	require.Len(t, manager.List(), 1)
+
+	// the counts of a finished batch are kept once the records of its jobs are pruned
+	for _, jobID := range batch.Jobs {
+		delete(jobs, jobID)
+	}
+	manager.prune()
+	batch, err = manager.Get(batch.ID)
+	require.NoError(t, err)
+	require.Equal(t, types.BatchCounts{Total: 3, Complete: 2, Failed: 1}, batch.Counts)
+
+	// finished batches are pruned after they were kept long enough
+	manager.batches[batch.ID].FinishedAt = time.Now().Add(-batchRetention - time.Second)
+	manager.prune()
+	require.Empty(t, manager.List())
+
+	_, err = manager.Get("unknown")
+	require.ErrorIs(t, err, ErrBatchNotFound)
+}
+
+func TestBatchManagerCancel(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	manager, mockQueue, jobs := newTestBatchManager(ctrl)
+
+	batch, err := manager.Submit(types.Batch{Template: types.Container{Image: "busybox"}, Count: 3}, "alice")
+	require.NoError(t, err)
+	jobs[batch.Jobs[0]].Status = types.JobStatusComplete
+
+	// only the jobs that are still active are cancelled
+	for _, jobID := range batch.Jobs[1:] {
+		jobID := jobID
+		mockQueue.EXPECT().Cancel(jobID).DoAndReturn(func(string) error {
+			jobs[jobID].Status = types.JobStatusCancelled
+			return nil
+		})
+	}
+	batch, err = manager.Cancel(batch.ID)
+	require.NoError(t, err)
+	require.Equal(t, types.BatchStatusCancelled, batch.Status)
+	require.Equal(t, types.BatchCounts{Total: 3, Complete: 1, Cancelled: 2}, batch.Counts)
+
+	_, err = manager.Cancel(batch.ID)
+	require.ErrorIs(t, err, ErrBatchFinished)
+}
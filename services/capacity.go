@@ -0,0 +1,225 @@
+package services
+
+import (
+	"bufio"
+	"container-manager/types"
+	"context"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// capacityGossipInterval is the interval between capacity records sent to peers
+	capacityGossipInterval = 5 * time.Second
+	// CapacityRecordTTL is how long a capacity record is used for placement after it was received
+	CapacityRecordTTL = 3 * capacityGossipInterval
+	// imageListTimeout bounds the time the runtime takes to list the cached images
+	imageListTimeout = 5 * time.Second
+)
+
+// CapacitySource provides the capacity record of the local node
+type CapacitySource interface {
+	Capacity() types.NodeCapacity
+}
+
+// CapacityRefresher is implemented by the capacity sources that cache the parts of the record that are slow to
+// get, which are refreshed before each gossip
+type CapacityRefresher interface {
+	Refresh(ctx context.Context)
+}
+
+// CapacityReporter builds the capacity record of the local node.
+// nodeID: The ID of the local node
+// labels: The labels of the local node
+// queue: The job queue of the node
+// dockerService: The Docker service used to list cached images
+// images: The images cached by the runtime when they were last listed
+// mutex: The mutex to protect the images
+type CapacityReporter struct {
+	nodeID        string
+	labels        map[string]string
+	queue         Queue
+	dockerService DockerService
+	images        []string
+	mutex         sync.Mutex
+}
+
+// NewCapacityReporter creates a new capacity reporter
+func NewCapacityReporter(nodeID string, labels map[string]string, queue Queue, ds DockerService) *CapacityReporter {
+	return &CapacityReporter{
+		nodeID:        nodeID,
+		labels:        labels,
+		queue:         queue,
+		dockerService: ds,
+	}
+}
+
+// Refresh lists the images cached by the runtime, the previous list is kept when they can't be listed
+func (cr *CapacityReporter) Refresh(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, imageListTimeout)
+	defer cancel()
+
+	images, err := cr.dockerService.ListImages(ctx)
+	if err != nil {
+		logrus.Warnf("failed to list cached images: %v", err)
+		return
+	}
+
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	cr.images = normalizeImages(images)
+}
+
+// Capacity returns the current capacity record of the local node, with the images of the last refresh
+func (cr *CapacityReporter) Capacity() types.NodeCapacity {
+	stats := cr.queue.Stats()
+	resources := readHostResources()
+
+	cr.mutex.Lock()
+	images := cr.images
+	cr.mutex.Unlock()
+
+	return types.NodeCapacity{
+		NodeID:         cr.nodeID,
+		Workers:        stats.Workers,
+		FreeWorkers:    stats.Workers - stats.BusyWorkers,
+		QueueDepth:     stats.Depth,
+		QueueSize:      stats.Size,
+		CPUs:           resources.cpus,
+		CPUHeadroom:    resources.cpuHeadroom,
+		MemoryTotal:    resources.memoryTotal,
+		MemoryHeadroom: resources.memoryAvailable,
+		Images:         images,
+		Labels:         cr.labels,
+		UpdatedAt:      time.Now(),
+	}
+}
+
+// CapacityStore keeps the latest capacity record of each peer.
+// records: The latest capacity record of each node
+// ttl: How long a record is valid after it was taken
+// mutex: The mutex to protect the records
+type CapacityStore struct {
+	records map[string]types.NodeCapacity
+	ttl     time.Duration
+	mutex   sync.Mutex
+}
+
+// NewCapacityStore creates a new capacity store
+func NewCapacityStore(ttl time.Duration) *CapacityStore {
+	return &CapacityStore{
+		records: make(map[string]types.NodeCapacity),
+		ttl:     ttl,
+	}
+}
+
+// Update stores a capacity record, replacing older records of the node
+func (cs *CapacityStore) Update(record types.NodeCapacity) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	if current, ok := cs.records[record.NodeID]; ok && current.UpdatedAt.After(record.UpdatedAt) {
+		return
+	}
+	cs.records[record.NodeID] = record
+}
+
+// Records returns the records that haven't expired, sorted by node ID
+func (cs *CapacityStore) Records() []types.NodeCapacity {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	records := make([]types.NodeCapacity, 0, len(cs.records))
+	for nodeID, record := range cs.records {
+		if time.Since(record.UpdatedAt) > cs.ttl {
+			delete(cs.records, nodeID)
+			continue
+		}
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].NodeID < records[j].NodeID
+	})
+	return records
+}
+
+// hostResources are the CPU and memory resources of the host.
+type hostResources struct {
+	cpus            float64
+	cpuHeadroom     float64
+	memoryTotal     uint64
+	memoryAvailable uint64
+}
+
+// readHostResources reads the host resources from procfs. Values that can't be read are left at zero.
+func readHostResources() hostResources {
+	resources := hostResources{
+		cpus:        float64(runtime.NumCPU()),
+		cpuHeadroom: float64(runtime.NumCPU()),
+	}
+
+	if data, err := os.ReadFile("/proc/loadavg"); err == nil {
+		if fields := strings.Fields(string(data)); len(fields) > 0 {
+			if load, err := strconv.ParseFloat(fields[0], 64); err == nil {
+				resources.cpuHeadroom = max(resources.cpus-load, 0)
+			}
+		}
+	}
+
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return resources
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		switch fields[0] {
+		case "MemTotal:":
+			resources.memoryTotal = kb * 1024
+		case "MemAvailable:":
+			resources.memoryAvailable = kb * 1024
+		}
+	}
+
+	return resources
+}
+
+// normalizeImages returns the fully qualified form of the image references, skipping invalid ones
+func normalizeImages(images []string) []string {
+	normalized := make([]string, 0, len(images))
+	for _, image := range images {
+		if name := normalizeImage(image); name != "" {
+			normalized = append(normalized, name)
+		}
+	}
+	return normalized
+}
+
+// normalizeImage returns the fully qualified form of an image reference, or an empty string if it is invalid
+func normalizeImage(image string) string {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return ""
+	}
+	return reference.TagNameOnly(named).String()
+}
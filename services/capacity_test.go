@@ -0,0 +1,72 @@
+package services
+
+import (
+	"container-manager/types"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestCapacityStore(t *testing.T) {
+	t.Parallel()
+
+	store := NewCapacityStore(time.Minute)
+	now := time.Now()
+
+	store.Update(types.NodeCapacity{NodeID: "b", FreeWorkers: 1, UpdatedAt: now})
+	store.Update(types.NodeCapacity{NodeID: "a", FreeWorkers: 2, UpdatedAt: now})
+	// older records don't replace newer ones
+	store.Update(types.NodeCapacity{NodeID: "b", FreeWorkers: 3, UpdatedAt: now.Add(-time.Second)})
+	// expired records are dropped
+	store.Update(types.NodeCapacity{NodeID: "c", UpdatedAt: now.Add(-2 * time.Minute)})
+
+	records := store.Records()
+	require.Len(t, records, 2)
+	require.Equal(t, "a", records[0].NodeID)
+	require.Equal(t, "b", records[1].NodeID)
+	require.Equal(t, 1, records[1].FreeWorkers)
+}
+
+func TestCapacityReporter(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockQueue := NewMockQueue(ctrl)
+	mockQueue.EXPECT().Stats().Return(QueueStats{Workers: 4, BusyWorkers: 1, Depth: 2, Size: 10})
+	mockDockerService := NewMockDockerService(ctrl)
+	// the images are listed with a bounded context on refresh, not for every record
+	mockDockerService.EXPECT().ListImages(gomock.Any()).DoAndReturn(func(ctx context.Context) ([]string, error) {
+		_, ok := ctx.Deadline()
+		require.True(t, ok)
+		return []string{"nginx:latest", "redis", "invalid image"}, nil
+	})
+
+	reporter := NewCapacityReporter("node", map[string]string{"zone": "a"}, mockQueue, mockDockerService)
+	reporter.Refresh(context.Background())
+	capacity := reporter.Capacity()
+	require.Equal(t, "node", capacity.NodeID)
+	require.Equal(t, map[string]string{"zone": "a"}, capacity.Labels)
+	require.Equal(t, 4, capacity.Workers)
+	require.Equal(t, 3, capacity.FreeWorkers)
+	require.Equal(t, 2, capacity.QueueDepth)
+	require.Equal(t, 10, capacity.QueueSize)
+	require.Positive(t, capacity.CPUs)
+	require.Equal(t, []string{"docker.io/library/nginx:latest", "docker.io/library/redis:latest"}, capacity.Images)
+	require.True(t, capacity.HasImage("docker.io/library/redis:latest"))
+
+	// a failure to list images doesn't fail the report, which keeps the images listed before
+	mockQueue.EXPECT().Stats().Return(QueueStats{}).Times(2)
+	mockDockerService.EXPECT().ListImages(gomock.Any()).Return(nil, fmt.Errorf("docker unavailable")).Times(2)
+	reporter.Refresh(context.Background())
+	capacity = reporter.Capacity()
+	require.Equal(t, []string{"docker.io/library/nginx:latest", "docker.io/library/redis:latest"}, capacity.Images)
+	other := NewCapacityReporter("node", nil, mockQueue, mockDockerService)
+	other.Refresh(context.Background())
+	require.Empty(t, other.Capacity().Images)
+}
@@ -0,0 +1,699 @@
+package services
+
+import (
+	"archive/tar"
+	"container-manager/types"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/archive"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/containers"
+	ctrdapparmor "github.com/containerd/containerd/contrib/apparmor"
+	"github.com/containerd/containerd/contrib/seccomp"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/oci"
+	"github.com/containerd/containerd/pkg/apparmor"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/google/uuid"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultContainerdEndpoint is the socket of containerd
+	defaultContainerdEndpoint = "/run/containerd/containerd.sock"
+	// defaultContainerdNamespace is the namespace the containers are created in
+	defaultContainerdNamespace = "container-manager"
+	// defaultAppArmorProfile is the AppArmor profile loaded for containers that don't set one
+	defaultAppArmorProfile = "container-manager-default"
+	// containerdStopTimeout is how long a container has to exit after SIGTERM before it is killed
+	containerdStopTimeout = 10 * time.Second
+)
+
+// ContainerdServiceHandler is the implementation of the DockerService interface with containerd.
+// Containers run in a network namespace of their own with only a loopback interface, so the manager can't probe them.
+// client: The containerd client
+// artifacts: The store the input artifacts of containers are copied from
+// secrets: Resolves the secrets of containers, nil rejects containers with secrets
+// seccompProfiles: The directory of the seccomp profiles containers reference by name
+type ContainerdServiceHandler struct {
+	client          *containerd.Client
+	artifacts       *ArtifactStore
+	secrets         *SecretInjector
+	seccompProfiles string
+}
+
+// NewContainerdService creates a new ContainerdServiceHandler connected to the containerd socket
+func NewContainerdService(
+	endpoint string,
+	namespace string,
+	artifacts *ArtifactStore,
+	secrets *SecretInjector,
+	seccompProfiles string,
+) (*ContainerdServiceHandler, error) {
+	if endpoint == "" {
+		endpoint = defaultContainerdEndpoint
+	}
+	if namespace == "" {
+		namespace = defaultContainerdNamespace
+	}
+
+	cli, err := containerd.New(endpoint, containerd.WithDefaultNamespace(namespace))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create containerd client: %w", err)
+	}
+	return &ContainerdServiceHandler{
+		client:          cli,
+		artifacts:       artifacts,
+		secrets:         secrets,
+		seccompProfiles: seccompProfiles,
+	}, nil
+}
+
+// newContainerdRuntime creates the containerd runtime
+func newContainerdRuntime(opts RuntimeOptions) (DockerService, error) {
+	return NewContainerdService(opts.Endpoint, opts.Namespace, opts.Artifacts, opts.Secrets, opts.SeccompProfiles)
+}
+
+// DeployContainer deploys a container with containerd. The image is pulled and unpacked within the pull
+// timeout of the container, and the container is created and its task started within its start timeout.
+func (cs *ContainerdServiceHandler) DeployContainer(ctx context.Context, container types.Container) (string, error) {
+	logrus.WithField("container", container.Redacted()).Debug("Deploying container")
+
+	if container.HealthCheck != nil {
+		return "", fmt.Errorf("health checks are not supported by the containerd runtime")
+	}
+
+	pullCtx, cancelPull := context.WithTimeout(ctx, container.PullTimeout())
+	defer cancelPull()
+	image, err := cs.client.Pull(pullCtx, container.Image, containerd.WithPullUnpack)
+	if err != nil {
+		return "", deployError(pullCtx, "failed to pull image", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, container.StartTimeout())
+	defer cancel()
+
+	securityOpts, err := cs.securityOpts(container.Security)
+	if err != nil {
+		return "", err
+	}
+
+	// the values of the secrets are only resolved here, on the node that runs the container
+	secrets, err := cs.secrets.mount(ctx, container.Secrets)
+	if err != nil {
+		return "", deployError(ctx, "failed to resolve secrets", err)
+	}
+
+	var envVars []string
+	for key, value := range container.Env {
+		envVars = append(envVars, key+"="+value)
+	}
+	envVars = append(envVars, secrets.env...)
+
+	labels := container.Labels
+	var mounts []specs.Mount
+	if secrets.id != "" {
+		labels = maps.Clone(container.Labels)
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[secretsLabel] = secrets.id
+		for _, file := range secrets.files {
+			mounts = append(mounts, specs.Mount{
+				Type:        "bind",
+				Source:      file.source,
+				Destination: file.target,
+				Options:     []string{"rbind", "ro"},
+			})
+		}
+	}
+
+	id := uuid.NewString()
+	created, err := cs.client.NewContainer(ctx, id,
+		containerd.WithImage(image),
+		containerd.WithNewSnapshot(id, image),
+		containerd.WithContainerLabels(labels),
+		containerd.WithNewSpec(append([]oci.SpecOpts{
+			oci.WithImageConfigArgs(image, container.Arguments),
+			oci.WithEnv(envVars),
+			oci.WithMounts(mounts),
+		}, securityOpts...)...),
+	)
+	if err != nil {
+		cs.secrets.release(secrets.id)
+		return "", deployError(ctx, "failed to create container", err)
+	}
+
+	// inputs are copied into the snapshot before the task starts, so they are there when its command runs
+	for _, input := range container.Inputs {
+		if err := cs.copyArtifact(ctx, created, input); err != nil {
+			cs.forceRemove(ctx, created)
+			return "", deployError(ctx, "failed to copy input", err)
+		}
+	}
+
+	task, err := created.NewTask(ctx, cio.NullIO)
+	if err != nil {
+		cs.forceRemove(ctx, created)
+		return "", deployError(ctx, "failed to create task", err)
+	}
+	if err := task.Start(ctx); err != nil {
+		cs.forceRemove(ctx, created)
+		return "", deployError(ctx, "failed to start container", err)
+	}
+
+	return id, nil
+}
+
+// securityOpts returns the spec options that apply the security options of a container, after the config of its image.
+// Like Docker, containers without a seccomp or AppArmor profile run with the default profile of the runtime.
+func (cs *ContainerdServiceHandler) securityOpts(security *types.SecurityContext) ([]oci.SpecOpts, error) {
+	if security == nil {
+		security = &types.SecurityContext{}
+	}
+
+	var opts []oci.SpecOpts
+	if user := security.RunAs(); user != "" {
+		opts = append(opts, oci.WithUser(user))
+	}
+	if security.ReadOnlyRootFS {
+		opts = append(opts, oci.WithRootFSReadonly())
+	}
+	// capabilities are dropped before they are added, like Docker does
+	if drop := specCapabilities(security.CapDrop); slices.Contains(drop, types.CapabilityAll) {
+		opts = append(opts, oci.WithCapabilities([]string{}))
+	} else if len(drop) > 0 {
+		opts = append(opts, oci.WithDroppedCapabilities(drop))
+	}
+	if add := specCapabilities(security.CapAdd); slices.Contains(add, types.CapabilityAll) {
+		opts = append(opts, oci.WithAllKnownCapabilities)
+	} else if len(add) > 0 {
+		opts = append(opts, oci.WithAddedCapabilities(add))
+	}
+	if security.NoNewPrivileges {
+		opts = append(opts, oci.WithNoNewPrivileges)
+	}
+
+	// the default seccomp profile allows the syscalls of the capabilities set above, so it comes after them
+	switch security.SeccompProfile {
+	case types.ProfileUnconfined:
+	case "":
+		opts = append(opts, seccomp.WithDefaultProfile())
+	default:
+		profile, err := loadSeccompProfile(cs.seccompProfiles, security.SeccompProfile)
+		if err != nil {
+			return nil, err
+		}
+		var custom specs.LinuxSeccomp
+		if err := json.Unmarshal(profile, &custom); err != nil {
+			return nil, fmt.Errorf("invalid seccomp profile %q: %w", security.SeccompProfile, err)
+		}
+		opts = append(opts, func(_ context.Context, _ oci.Client, _ *containers.Container, s *oci.Spec) error {
+			if s.Linux == nil {
+				s.Linux = &specs.Linux{}
+			}
+			s.Linux.Seccomp = &custom
+			return nil
+		})
+	}
+	switch security.AppArmorProfile {
+	case types.ProfileUnconfined:
+	case "":
+		if apparmor.HostSupports() {
+			opts = append(opts, ctrdapparmor.WithDefaultProfile(defaultAppArmorProfile))
+		}
+	default:
+		opts = append(opts, func(_ context.Context, _ oci.Client, _ *containers.Container, s *oci.Spec) error {
+			if s.Process == nil {
+				s.Process = &specs.Process{}
+			}
+			s.Process.ApparmorProfile = security.AppArmorProfile
+			return nil
+		})
+	}
+	return opts, nil
+}
+
+// specCapabilities returns the names of capabilities in the form of the runtime spec, e.g. CAP_NET_ADMIN
+func specCapabilities(capabilities []string) []string {
+	names := make([]string, 0, len(capabilities))
+	for _, capability := range capabilities {
+		capability = types.NormalizeCapability(capability)
+		if capability != types.CapabilityAll {
+			capability = "CAP_" + capability
+		}
+		names = append(names, capability)
+	}
+	return names
+}
+
+// GetContainerStatus gets the status of a container by container ID
+func (cs *ContainerdServiceHandler) GetContainerStatus(ctx context.Context, containerID string) (string, error) {
+	logrus.WithField("container_id", containerID).Debug("Getting container status")
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	status, _, err := cs.status(ctx, containerID)
+	return status, err
+}
+
+// InspectContainer gets the state of a container by container ID
+func (cs *ContainerdServiceHandler) InspectContainer(ctx context.Context, containerID string) (ContainerInfo, error) {
+	logrus.WithField("container_id", containerID).Debug("Inspecting container")
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	status, exitCode, err := cs.status(ctx, containerID)
+	if err != nil {
+		return ContainerInfo{}, err
+	}
+	return ContainerInfo{
+		Status:   status,
+		ExitCode: exitCode,
+	}, nil
+}
+
+// CopyFromContainer returns a tar archive of a path in a container, rooted at the base name of the path.
+// The path is read through the root of the task while it runs, and from the snapshot once it stopped.
+func (cs *ContainerdServiceHandler) CopyFromContainer(ctx context.Context, containerID string, path string) (io.ReadCloser, error) {
+	logrus.WithFields(logrus.Fields{
+		"container_id": containerID,
+		"path":         path,
+	}).Debug("Copying from container")
+
+	loaded, err := cs.client.LoadContainer(ctx, containerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load container: %w", err)
+	}
+
+	file, err := os.CreateTemp("", "container-copy-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive: %w", err)
+	}
+	archived := func(root string) error {
+		return tarPath(file, root, path)
+	}
+
+	if pid, running := cs.runningPid(ctx, loaded); running {
+		err = archived(fmt.Sprintf("/proc/%d/root", pid))
+	} else {
+		err = cs.withSnapshot(ctx, loaded, true, archived)
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("failed to copy from container: %w", err)
+	}
+	return &tempFile{File: file}, nil
+}
+
+// CopyToContainer extracts a tar archive rooted at the base name of a path into a container at the path
+func (cs *ContainerdServiceHandler) CopyToContainer(ctx context.Context, containerID string, path string, content io.Reader) error {
+	logrus.WithFields(logrus.Fields{
+		"container_id": containerID,
+		"path":         path,
+	}).Debug("Copying to container")
+
+	loaded, err := cs.client.LoadContainer(ctx, containerID)
+	if err != nil {
+		return fmt.Errorf("failed to load container: %w", err)
+	}
+
+	relocated := relocateArchive(content, path)
+	defer relocated.Close()
+	extract := func(root string) error {
+		_, err := archive.Apply(ctx, root, relocated)
+		return err
+	}
+
+	if pid, running := cs.runningPid(ctx, loaded); running {
+		err = extract(fmt.Sprintf("/proc/%d/root", pid))
+	} else {
+		err = cs.withSnapshot(ctx, loaded, false, extract)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy to container: %w", err)
+	}
+	return nil
+}
+
+// StopContainer stops a container by container ID, it is killed if it doesn't exit in time
+func (cs *ContainerdServiceHandler) StopContainer(ctx context.Context, containerID string) error {
+	logrus.WithField("container_id", containerID).Debug("Stopping container")
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	if err := cs.signal(ctx, containerID, syscall.SIGTERM, containerdStopTimeout); err == nil {
+		return nil
+	}
+	if err := cs.signal(ctx, containerID, syscall.SIGKILL, containerdStopTimeout); err != nil {
+		return fmt.Errorf("failed to stop container: %w", err)
+	}
+	return nil
+}
+
+// KillContainer kills a container by container ID without waiting for it to stop
+func (cs *ContainerdServiceHandler) KillContainer(ctx context.Context, containerID string) error {
+	logrus.WithField("container_id", containerID).Debug("Killing container")
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if err := cs.signal(ctx, containerID, syscall.SIGKILL, 0); err != nil {
+		return fmt.Errorf("failed to kill container: %w", err)
+	}
+	return nil
+}
+
+// RemoveContainer removes a stopped container by container ID, with its task and snapshot
+func (cs *ContainerdServiceHandler) RemoveContainer(ctx context.Context, containerID string) error {
+	logrus.WithField("container_id", containerID).Debug("Removing container")
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	loaded, err := cs.client.LoadContainer(ctx, containerID)
+	if err != nil {
+		return fmt.Errorf("failed to remove container: %w", err)
+	}
+	if task, err := loaded.Task(ctx, nil); err == nil {
+		if _, err := task.Delete(ctx); err != nil {
+			return fmt.Errorf("failed to remove container: %w", err)
+		}
+	} else if !errdefs.IsNotFound(err) {
+		return fmt.Errorf("failed to remove container: %w", err)
+	}
+	// the secret files of the container are removed with it
+	labels, _ := loaded.Labels(ctx)
+	if err := loaded.Delete(ctx, containerd.WithSnapshotCleanup); err != nil {
+		return fmt.Errorf("failed to remove container: %w", err)
+	}
+	cs.secrets.release(labels[secretsLabel])
+	return nil
+}
+
+// ListContainers lists the containers in the namespace with the label, including the stopped containers
+func (cs *ContainerdServiceHandler) ListContainers(ctx context.Context, label string) ([]ContainerSummary, error) {
+	logrus.WithField("label", label).Debug("Listing containers")
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	list, err := cs.client.Containers(ctx, fmt.Sprintf("labels.%q", label))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	containers := make([]ContainerSummary, 0, len(list))
+	for _, container := range list {
+		labels, err := container.Labels(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list containers: %w", err)
+		}
+		status, _, err := cs.status(ctx, container.ID())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list containers: %w", err)
+		}
+		containers = append(containers, ContainerSummary{
+			ID:     container.ID(),
+			Status: status,
+			Labels: labels,
+		})
+	}
+	return containers, nil
+}
+
+// ResolveImageDigest resolves an image reference to the digest of its manifest in the registry
+func (cs *ContainerdServiceHandler) ResolveImageDigest(ctx context.Context, image string) (string, error) {
+	logrus.WithField("image", image).Debug("Resolving image digest")
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	_, desc, err := docker.NewResolver(docker.ResolverOptions{}).Resolve(ctx, image)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve image: %w", err)
+	}
+	return desc.Digest.String(), nil
+}
+
+// ListImages lists the references of the images in the namespace
+func (cs *ContainerdServiceHandler) ListImages(ctx context.Context) ([]string, error) {
+	logrus.Debug("Listing images")
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	list, err := cs.client.ImageService().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+
+	var images []string
+	for _, image := range list {
+		images = append(images, image.Name)
+		if named, _, found := cutDigest(image.Name); !found {
+			images = append(images, named+"@"+image.Target.Digest.String())
+		}
+	}
+	return images, nil
+}
+
+// status returns the status of a container in the terms of Docker, and the exit code of its task once it stopped
+func (cs *ContainerdServiceHandler) status(ctx context.Context, containerID string) (string, int, error) {
+	loaded, err := cs.client.LoadContainer(ctx, containerID)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to inspect container: %w", err)
+	}
+	task, err := loaded.Task(ctx, nil)
+	if errdefs.IsNotFound(err) {
+		return "created", 0, nil
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	status, err := task.Status(ctx)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to inspect container: %w", err)
+	}
+	switch status.Status {
+	case containerd.Stopped:
+		return "exited", int(status.ExitStatus), nil
+	case containerd.Paused, containerd.Pausing:
+		return "paused", 0, nil
+	default:
+		return string(status.Status), 0, nil
+	}
+}
+
+// runningPid returns the process ID of the task of a container while it runs
+func (cs *ContainerdServiceHandler) runningPid(ctx context.Context, container containerd.Container) (uint32, bool) {
+	task, err := container.Task(ctx, nil)
+	if err != nil {
+		return 0, false
+	}
+	status, err := task.Status(ctx)
+	if err != nil || status.Status != containerd.Running {
+		return 0, false
+	}
+	return task.Pid(), true
+}
+
+// signal sends a signal to the task of a container and waits up to the timeout for it to exit,
+// without waiting when the timeout is zero. Containers without a running task are stopped already.
+func (cs *ContainerdServiceHandler) signal(ctx context.Context, containerID string, signal syscall.Signal, timeout time.Duration) error {
+	loaded, err := cs.client.LoadContainer(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	task, err := loaded.Task(ctx, nil)
+	if errdefs.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	exited, err := task.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	if err := task.Kill(ctx, signal); err != nil && !errdefs.IsNotFound(err) {
+		if status, statusErr := task.Status(ctx); statusErr == nil && status.Status == containerd.Stopped {
+			return nil
+		}
+		return err
+	}
+	if timeout == 0 {
+		return nil
+	}
+
+	select {
+	case <-exited:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("container didn't exit within %s", timeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// copyArtifact copies the artifact of an input into the snapshot of a created container
+func (cs *ContainerdServiceHandler) copyArtifact(ctx context.Context, container containerd.Container, input types.ArtifactInput) error {
+	if cs.artifacts == nil {
+		return fmt.Errorf("artifacts are not enabled")
+	}
+
+	artifact, err := cs.artifacts.Open(input.Digest)
+	if err != nil {
+		return fmt.Errorf("failed to open artifact: %w", err)
+	}
+	defer artifact.Close()
+
+	relocated := relocateArchive(artifact, input.Path)
+	defer relocated.Close()
+
+	return cs.withSnapshot(ctx, container, false, func(root string) error {
+		if _, err := archive.Apply(ctx, root, relocated); err != nil {
+			return fmt.Errorf("failed to copy artifact to container: %w", err)
+		}
+		return nil
+	})
+}
+
+// withSnapshot mounts the snapshot of a container in a temporary directory while f runs
+func (cs *ContainerdServiceHandler) withSnapshot(ctx context.Context, container containerd.Container, readonly bool, f func(root string) error) error {
+	info, err := container.Info(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load container: %w", err)
+	}
+	mounts, err := cs.client.SnapshotService(info.Snapshotter).Mounts(ctx, info.SnapshotKey)
+	if err != nil {
+		return fmt.Errorf("failed to mount snapshot: %w", err)
+	}
+
+	if readonly {
+		return mount.WithReadonlyTempMount(ctx, mounts, f)
+	}
+	return mount.WithTempMount(ctx, mounts, f)
+}
+
+// forceRemove removes a container that failed to deploy, failures are logged. The removal isn't
+// interrupted when the deploy was cancelled, so the container isn't left behind.
+func (cs *ContainerdServiceHandler) forceRemove(ctx context.Context, container containerd.Container) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+
+	if task, err := container.Task(ctx, nil); err == nil {
+		if _, err := task.Delete(ctx, containerd.WithProcessKill); err != nil {
+			logrus.WithField("container_id", container.ID()).Warnf("failed to remove task: %v", err)
+		}
+	}
+	labels, _ := container.Labels(ctx)
+	if err := container.Delete(ctx, containerd.WithSnapshotCleanup); err != nil {
+		logrus.WithField("container_id", container.ID()).Warnf("failed to remove container: %v", err)
+	}
+	cs.secrets.release(labels[secretsLabel])
+}
+
+// tarPath writes a tar archive of a path under the root, with its entries rooted at the base name of the path
+func tarPath(w io.Writer, root string, target string) error {
+	target = path.Clean("/" + target)
+	source := filepath.Join(root, filepath.FromSlash(target))
+	base := path.Base(target)
+	tw := tar.NewWriter(w)
+
+	err := filepath.WalkDir(source, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(name); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(source, name)
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(base, filepath.ToSlash(relative))
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s: no such file or directory", target)
+	}
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// cutDigest splits an image reference pinned to a digest into its name and digest
+func cutDigest(ref string) (string, string, bool) {
+	for i := len(ref) - 1; i >= 0; i-- {
+		if ref[i] == '@' {
+			return ref[:i], ref[i+1:], true
+		}
+	}
+	return ref, "", false
+}
+
+// tempFile is a temporary file removed once it is closed
+type tempFile struct {
+	*os.File
+}
+
+// Close closes and removes the file
+func (tf *tempFile) Close() error {
+	err := tf.File.Close()
+	os.Remove(tf.File.Name())
+	return err
+}
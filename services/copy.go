@@ -0,0 +1,510 @@
+package services
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"container-manager/types"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrCopyTooLarge is the error returned when an archive copied into or out of a container exceeds the size limit
+	ErrCopyTooLarge = fmt.Errorf("archive exceeds the copy size limit")
+	// ErrJobDeploying is the error returned when copying into a job whose container is being created
+	ErrJobDeploying = fmt.Errorf("job is being deployed")
+	// ErrReadOnlyRootFS is the error returned when copying into a container with a read-only root filesystem
+	ErrReadOnlyRootFS = fmt.Errorf("the root filesystem of the container is read-only")
+)
+
+const (
+	// DefaultMaxCopySize is the default size limit of the archives copied into and out of containers
+	DefaultMaxCopySize = 64 << 20
+	// maxCopyRequestSize is the maximum size of the request and response lines of a copy stream
+	maxCopyRequestSize = 64 << 10
+)
+
+// RemoteCopier copies files into and out of the containers of the jobs of other nodes
+type RemoteCopier interface {
+	CopyToRemote(ctx context.Context, nodeID string, jobID string, target string, archive []byte) error
+	CopyFromRemote(ctx context.Context, nodeID string, jobID string, target string) ([]byte, error)
+}
+
+// CopyRouter copies files into and out of the containers of jobs, on the node that owns the job.
+// The archives are tar archives rooted at the base name of the path they are copied to or from.
+// jobQueue: The queue of the jobs
+// nodeID: The ID of the node
+// maxSize: The size limit of the archives
+// remote: Copies the files of the jobs of other nodes
+// mutex: The mutex to protect the remote copier
+type CopyRouter struct {
+	jobQueue Queue
+	nodeID   string
+	maxSize  int64
+	remote   RemoteCopier
+	mutex    sync.Mutex
+}
+
+// NewCopyRouter creates a new copy router, DefaultMaxCopySize limits the archives when the limit is zero
+func NewCopyRouter(jobQueue Queue, nodeID string, maxSize int64) *CopyRouter {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxCopySize
+	}
+	return &CopyRouter{
+		jobQueue: jobQueue,
+		nodeID:   nodeID,
+		maxSize:  maxSize,
+	}
+}
+
+// SetRemote sets the copier of the files of the jobs of other nodes
+func (cr *CopyRouter) SetRemote(remote RemoteCopier) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	cr.remote = remote
+}
+
+// MaxSize returns the size limit of the archives
+func (cr *CopyRouter) MaxSize() int64 {
+	return cr.maxSize
+}
+
+// CopyTo copies an archive into the container of a job at the path, on the node that owns the job
+func (cr *CopyRouter) CopyTo(ctx context.Context, jobID string, target string, archive []byte) error {
+	if err := validateCopyArchive(archive, target, cr.maxSize); err != nil {
+		return err
+	}
+	remote, nodeID, err := cr.route(jobID)
+	if err != nil {
+		return err
+	}
+	if remote == nil {
+		return cr.jobQueue.CopyToJob(ctx, jobID, target, archive)
+	}
+	logrus.WithFields(logrus.Fields{"job_id": jobID, "node": nodeID}).Debug("routing copy to the node of the job")
+	return remote.CopyToRemote(ctx, nodeID, jobID, target, archive)
+}
+
+// CopyToLocal copies an archive into the container of a job of the node at the path
+func (cr *CopyRouter) CopyToLocal(ctx context.Context, jobID string, target string, archive []byte) error {
+	if err := validateCopyArchive(archive, target, cr.maxSize); err != nil {
+		return err
+	}
+	return cr.jobQueue.CopyToJob(ctx, jobID, target, archive)
+}
+
+// CopyFrom returns an archive of a path in the container of a job, from the node that owns the job
+func (cr *CopyRouter) CopyFrom(ctx context.Context, jobID string, target string) ([]byte, error) {
+	if err := ValidateCopyPath(target); err != nil {
+		return nil, err
+	}
+	remote, nodeID, err := cr.route(jobID)
+	if err != nil {
+		return nil, err
+	}
+	if remote == nil {
+		return cr.CopyFromLocal(ctx, jobID, target)
+	}
+	logrus.WithFields(logrus.Fields{"job_id": jobID, "node": nodeID}).Debug("routing copy to the node of the job")
+	archive, err := remote.CopyFromRemote(ctx, nodeID, jobID, target)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(archive)) > cr.maxSize {
+		return nil, ErrCopyTooLarge
+	}
+	return archive, nil
+}
+
+// CopyFromLocal returns an archive of a path in the container of a job of the node
+func (cr *CopyRouter) CopyFromLocal(ctx context.Context, jobID string, target string) ([]byte, error) {
+	if err := ValidateCopyPath(target); err != nil {
+		return nil, err
+	}
+	reader, err := cr.jobQueue.CopyFromJob(ctx, jobID, target)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	archive, err := io.ReadAll(io.LimitReader(reader, cr.maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy from container: %w", err)
+	}
+	if int64(len(archive)) > cr.maxSize {
+		return nil, ErrCopyTooLarge
+	}
+	return archive, nil
+}
+
+// route returns the remote copier and the node of a job of another node, and no copier for the jobs of the node
+func (cr *CopyRouter) route(jobID string) (RemoteCopier, string, error) {
+	job, ok := cr.jobQueue.GetJob(jobID)
+	if !ok {
+		return nil, "", ErrJobNotFound
+	}
+	if job.Node == cr.nodeID {
+		return nil, "", nil
+	}
+
+	cr.mutex.Lock()
+	remote := cr.remote
+	cr.mutex.Unlock()
+	if remote == nil {
+		return nil, "", fmt.Errorf("%w: the job runs on node %s", ErrJobNotRunning, job.Node)
+	}
+	return remote, job.Node, nil
+}
+
+// CopyToJob copies an archive into the container of a job of the node at the path. With an artifact store the
+// archive becomes an input of the job, so the containers the job starts later get it too, and jobs that
+// are still pending get it when their container is created.
+func (q *QueueHandler) CopyToJob(ctx context.Context, jobID string, target string, archive []byte) error {
+	var input *types.ArtifactInput
+	if q.artifacts != nil {
+		digest, _, err := q.artifacts.Put(bytes.NewReader(archive))
+		if err != nil {
+			return fmt.Errorf("failed to store archive: %w", err)
+		}
+		input = &types.ArtifactInput{Digest: digest, Node: q.nodeID, Path: target}
+	}
+
+	q.mutex.Lock()
+	record, exists := q.jobRecords[jobID]
+	if !exists {
+		q.mutex.Unlock()
+		return ErrJobNotFound
+	}
+	_, deploying := q.deploys[jobID]
+	var err error
+	switch {
+	case record.Node != q.nodeID || record.Status == types.JobStatusCancelled:
+		err = ErrJobNotRunning
+	case record.Container.ReadOnlyRootFS():
+		err = ErrReadOnlyRootFS
+	case record.ContainerID != "":
+	case record.Status != types.JobStatusPending:
+		err = ErrJobNotRunning
+	case deploying:
+		err = ErrJobDeploying
+	case input == nil:
+		err = fmt.Errorf("artifacts are not enabled")
+	}
+	if err != nil {
+		q.mutex.Unlock()
+		return err
+	}
+	if input != nil {
+		record.Container.Inputs = append(slices.Clip(record.Container.Inputs), *input)
+	}
+	containerID := record.ContainerID
+	q.mutex.Unlock()
+
+	logger := logrus.WithFields(logrus.Fields{
+		"job_id": jobID,
+		"path":   target,
+	})
+	if containerID == "" {
+		logger.Info("added input to pending job")
+		return nil
+	}
+	if err := q.dockerService.CopyToContainer(ctx, containerID, target, bytes.NewReader(archive)); err != nil {
+		return err
+	}
+	logger.Info("copied archive to job container")
+	return nil
+}
+
+// CopyFromJob returns a tar archive of a path in the container of a job of the node, rooted at the base name
+// of the path
+func (q *QueueHandler) CopyFromJob(ctx context.Context, jobID string, target string) (io.ReadCloser, error) {
+	job, ok := q.GetJob(jobID)
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	if job.Node != q.nodeID || job.ContainerID == "" || job.Status == types.JobStatusCancelled {
+		return nil, ErrJobNotRunning
+	}
+	return q.dockerService.CopyFromContainer(ctx, job.ContainerID, target)
+}
+
+// ValidateCopyPath checks a path files are copied to or from in a container: it is absolute, below the root
+// and doesn't climb out of its directory.
+func ValidateCopyPath(target string) error {
+	if !path.IsAbs(target) {
+		return fmt.Errorf("path %q must be absolute", target)
+	}
+	if slices.Contains(strings.Split(target, "/"), "..") {
+		return fmt.Errorf("path %q must not contain ..", target)
+	}
+	if path.Clean(target) == "/" {
+		return fmt.Errorf("path must not be the root")
+	}
+	return nil
+}
+
+// FileArchive returns the archive of a single file to copy to the path
+func FileArchive(target string, content []byte, mode int64) ([]byte, error) {
+	if err := ValidateCopyPath(target); err != nil {
+		return nil, err
+	}
+	if mode == 0 {
+		mode = 0o644
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	header := &tar.Header{
+		Name:     path.Base(target),
+		Typeflag: tar.TypeReg,
+		Mode:     mode & 0o7777,
+		Size:     int64(len(content)),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return nil, fmt.Errorf("failed to write archive: %w", err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		return nil, fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write archive: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// RootArchive rewrites an archive of the contents of a directory, so its entries are rooted at the base name
+// of the path the directory is copied to
+func RootArchive(archive []byte, target string) ([]byte, error) {
+	if err := ValidateCopyPath(target); err != nil {
+		return nil, err
+	}
+	base := path.Base(target)
+
+	var buf bytes.Buffer
+	tr := tar.NewReader(bytes.NewReader(archive))
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: base + "/", Typeflag: tar.TypeDir, Mode: 0o755}); err != nil {
+		return nil, fmt.Errorf("failed to write archive: %w", err)
+	}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+		if name == "." {
+			continue
+		}
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("archive entry %q is outside of the directory", header.Name)
+		}
+		header.Name = path.Join(base, name)
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, fmt.Errorf("failed to write archive: %w", err)
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return nil, fmt.Errorf("failed to write archive: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write archive: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// validateCopyArchive checks an archive copied into a container at the path: it is within the size limit, its
+// entries are regular files, directories or symlinks rooted at the base name of the path, and none of them
+// climb out of it.
+func validateCopyArchive(archive []byte, target string, maxSize int64) error {
+	if err := ValidateCopyPath(target); err != nil {
+		return err
+	}
+	if int64(len(archive)) > maxSize {
+		return ErrCopyTooLarge
+	}
+	base := path.Base(target)
+
+	tr := tar.NewReader(bytes.NewReader(archive))
+	entries := 0
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid archive: %w", err)
+		}
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeDir, tar.TypeSymlink:
+		default:
+			return fmt.Errorf("invalid archive: entry %q is not a file, directory or symlink", header.Name)
+		}
+		name := strings.TrimPrefix(header.Name, "./")
+		if slices.Contains(strings.Split(name, "/"), "..") {
+			return fmt.Errorf("invalid archive: entry %q must not contain ..", header.Name)
+		}
+		if root, _, _ := strings.Cut(name, "/"); root != base {
+			return fmt.Errorf("invalid archive: entry %q isn't rooted at %s", header.Name, base)
+		}
+		entries++
+	}
+	if entries == 0 {
+		return fmt.Errorf("invalid archive: it is empty")
+	}
+	return nil
+}
+
+// copyRequest is the request of a copy stream, a JSON line followed by the archive copied into the container.
+// job_id: The ID of the job whose container the files are copied into or out of
+// path: The path in the container
+// upload: Whether the archive is copied into the container rather than out of it
+// size: The size of the archive copied into the container
+type copyRequest struct {
+	JobID  string `json:"job_id"`
+	Path   string `json:"path"`
+	Upload bool   `json:"upload,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+}
+
+// copyResponse is the answer to a copy request, a JSON line followed by the archive copied out of the container.
+// error: Why the files couldn't be copied, empty once they were
+// size: The size of the archive copied out of the container
+type copyResponse struct {
+	Error string `json:"error,omitempty"`
+	Size  int64  `json:"size,omitempty"`
+}
+
+// WithCopyRouter copies the files peers route into and out of the containers of the jobs of this node
+func WithCopyRouter(router *CopyRouter) P2POption {
+	return func(o *p2pOptions) {
+		o.copier = router
+	}
+}
+
+// handleCopyStream copies the files of a copy request into or out of the container of a job of this node
+func (s *Service) handleCopyStream(stream network.Stream) {
+	defer stream.Close()
+	remote := stream.Conn().RemotePeer()
+
+	reader := bufio.NewReaderSize(stream, maxCopyRequestSize)
+	var request copyRequest
+	if err := readJSONLine(reader, &request); err != nil {
+		logrus.WithField("peer", remote).Errorf("failed to read copy request: %v", err)
+		return
+	}
+
+	var archive []byte
+	err := func() error {
+		if !request.Upload {
+			var err error
+			archive, err = s.copier.CopyFromLocal(s.ctx, request.JobID, request.Path)
+			return err
+		}
+		if request.Size < 0 || request.Size > s.copier.MaxSize() {
+			return ErrCopyTooLarge
+		}
+		upload := make([]byte, request.Size)
+		if _, err := io.ReadFull(reader, upload); err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		return s.copier.CopyToLocal(s.ctx, request.JobID, request.Path, upload)
+	}()
+	if err != nil {
+		logrus.WithField("job_id", request.JobID).Warnf("failed to copy for peer %s: %v", remote, err)
+		json.NewEncoder(stream).Encode(copyResponse{Error: err.Error()})
+		return
+	}
+
+	if err := json.NewEncoder(stream).Encode(copyResponse{Size: int64(len(archive))}); err != nil {
+		logrus.WithField("peer", remote).Errorf("failed to write copy response: %v", err)
+		return
+	}
+	if _, err := stream.Write(archive); err != nil {
+		logrus.WithField("peer", remote).Errorf("failed to write archive: %v", err)
+	}
+}
+
+// CopyToRemote copies an archive into the container of a job of another node, over a copy stream to the node
+func (s *Service) CopyToRemote(ctx context.Context, nodeID string, jobID string, target string, archive []byte) error {
+	request := copyRequest{JobID: jobID, Path: target, Upload: true, Size: int64(len(archive))}
+	_, err := s.copyRemote(ctx, nodeID, request, archive)
+	return err
+}
+
+// CopyFromRemote returns an archive of a path in the container of a job of another node, over a copy stream
+// to the node
+func (s *Service) CopyFromRemote(ctx context.Context, nodeID string, jobID string, target string) ([]byte, error) {
+	return s.copyRemote(ctx, nodeID, copyRequest{JobID: jobID, Path: target}, nil)
+}
+
+// copyRemote sends a copy request and the archive copied into the container to a node, and returns the
+// archive copied out of it
+func (s *Service) copyRemote(ctx context.Context, nodeID string, request copyRequest, archive []byte) ([]byte, error) {
+	id, err := peer.Decode(nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode peer ID: %w", err)
+	}
+
+	stream, err := s.host.NewStream(ctx, id, CopyProtocolID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open copy stream: %w", err)
+	}
+	defer stream.Close()
+	stop := context.AfterFunc(ctx, func() {
+		stream.Reset()
+	})
+	defer stop()
+
+	if err := json.NewEncoder(stream).Encode(request); err != nil {
+		stream.Reset()
+		return nil, fmt.Errorf("failed to write copy request: %w", err)
+	}
+	if _, err := stream.Write(archive); err != nil {
+		stream.Reset()
+		return nil, fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	reader := bufio.NewReaderSize(stream, maxCopyRequestSize)
+	var response copyResponse
+	if err := readJSONLine(reader, &response); err != nil {
+		stream.Reset()
+		return nil, fmt.Errorf("failed to read copy response: %w", err)
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("failed to copy on node %s: %s", nodeID, response.Error)
+	}
+	if response.Size < 0 || response.Size > maxCopyResponseSize(s.copier) {
+		stream.Reset()
+		return nil, ErrCopyTooLarge
+	}
+	copied := make([]byte, response.Size)
+	if _, err := io.ReadFull(reader, copied); err != nil {
+		stream.Reset()
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+	return copied, nil
+}
+
+// maxCopyResponseSize returns the size limit of the archives peers send back, the limit of the copier of
+// the node or DefaultMaxCopySize without one
+func maxCopyResponseSize(copier *CopyRouter) int64 {
+	if copier == nil {
+		return DefaultMaxCopySize
+	}
+	return copier.MaxSize()
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"archive/tar"
+	"bytes"
+	"container-manager/types"
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+// archiveContents returns the content of the regular files of an archive by name
+func archiveContents(t *testing.T, archive []byte) map[string]string {
+	t.Helper()
+
+	contents := make(map[string]string)
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return contents
+		}
+		require.NoError(t, err)
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		contents[header.Name] = string(content)
+	}
+}
+
+func TestCopyArchives(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, ValidateCopyPath("/etc/app/config.yaml"))
+	require.Error(t, ValidateCopyPath("etc/app"))
+	require.Error(t, ValidateCopyPath("/"))
+	require.Error(t, ValidateCopyPath("/etc/../root"))
+
+	// single files and the contents of directories are rooted at the base name of their path
+	archive, err := FileArchive("/etc/app/config.yaml", []byte("debug: true"), 0)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"config.yaml": "debug: true"}, archiveContents(t, archive))
+	require.NoError(t, validateCopyArchive(archive, "/etc/app/config.yaml", DefaultMaxCopySize))
+	require.ErrorIs(t, validateCopyArchive(archive, "/etc/app/config.yaml", 16), ErrCopyTooLarge)
+	require.Error(t, validateCopyArchive(archive, "/etc/other.yaml", DefaultMaxCopySize))
+
+	archive, err = RootArchive(newTestArchive(t, map[string]string{"./a.conf": "a", "sub/b.conf": "b"}), "/etc/app")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"app/a.conf": "a", "app/sub/b.conf": "b"}, archiveContents(t, archive))
+	require.NoError(t, validateCopyArchive(archive, "/etc/app", DefaultMaxCopySize))
+
+	_, err = RootArchive(newTestArchive(t, map[string]string{"../escape": "x"}), "/etc/app")
+	require.Error(t, err)
+	require.Error(t, validateCopyArchive(newTestArchive(t, map[string]string{"app/../../escape": "x"}), "/etc/app", DefaultMaxCopySize))
+	require.Error(t, validateCopyArchive(newTestArchive(t, nil), "/etc/app", DefaultMaxCopySize))
+}
+
+func TestCopyRouter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store, err := NewArtifactStore(t.TempDir())
+	require.NoError(t, err)
+	runtime, err := NewFakeRuntime(FakeRuntimeOptions{}, store)
+	require.NoError(t, err)
+	jobQueue := NewQueue(10, runtime, WithNodeID("local"), WithArtifacts(store))
+	router := NewCopyRouter(jobQueue, "local", 0)
+
+	config, err := FileArchive("/etc/app/config.yaml", []byte("debug: true"), 0o600)
+	require.NoError(t, err)
+	require.ErrorIs(t, router.CopyTo(ctx, "job", "/etc/app/config.yaml", config), ErrJobNotFound)
+
+	// files copied before the job starts are in place when its container is created
+	require.NoError(t, jobQueue.Enqueue("job", types.Container{
+		Image:         "busybox",
+		RestartPolicy: &types.RestartPolicy{Name: types.RestartPolicyNever},
+	}))
+	require.NoError(t, router.CopyTo(ctx, "job", "/etc/app/config.yaml", config))
+	jobQueue.executeJob(<-jobQueue.jobs)
+	record, _ := jobQueue.GetJob("job")
+	require.Equal(t, types.JobStatusRunning, record.Status)
+	require.Len(t, record.Container.Inputs, 1)
+
+	archive, err := router.CopyFrom(ctx, "job", "/etc/app")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"app/config.yaml": "debug: true"}, archiveContents(t, archive))
+
+	// files copied once it runs go straight into its container
+	secrets, err := RootArchive(newTestArchive(t, map[string]string{"token": "secret"}), "/run/secrets")
+	require.NoError(t, err)
+	require.NoError(t, router.CopyTo(ctx, "job", "/run/secrets", secrets))
+	archive, err = router.CopyFrom(ctx, "job", "/run/secrets/token")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"token": "secret"}, archiveContents(t, archive))
+
+	_, err = router.CopyFrom(ctx, "job", "/missing")
+	require.Error(t, err)
+	_, err = router.CopyFrom(ctx, "job", "relative")
+	require.Error(t, err)
+	_, err = NewCopyRouter(jobQueue, "local", 16).CopyFrom(ctx, "job", "/etc/app")
+	require.ErrorIs(t, err, ErrCopyTooLarge)
+	require.ErrorIs(t, NewCopyRouter(jobQueue, "local", 16).CopyTo(ctx, "job", "/etc/app/config.yaml", config), ErrCopyTooLarge)
+
+	// jobs that ended and jobs of other nodes can't be reached
+	require.NoError(t, jobQueue.Cancel("job"))
+	require.ErrorIs(t, router.CopyTo(ctx, "job", "/etc/app/config.yaml", config), ErrJobNotRunning)
+	jobQueue.Track(types.Job{ID: "remote", Node: "remote", Status: types.JobStatusRunning, ContainerID: "container-id"})
+	_, err = router.CopyFrom(ctx, "remote", "/etc/app")
+	require.ErrorIs(t, err, ErrJobNotRunning)
+}
+
+func TestP2PServiceCopyRemote(t *testing.T) {
+	t.Parallel()
+
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	nodeID, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+
+	runtime, err := NewFakeRuntime(FakeRuntimeOptions{}, nil)
+	require.NoError(t, err)
+	jobQueue1 := NewQueue(10, runtime, WithNodeID(nodeID.String()))
+	record := runningJob(t, jobQueue1, "job")
+	jobQueue2 := NewQueue(10, nil, WithNodeID("other"))
+	jobQueue2.Track(record)
+
+	router1 := NewCopyRouter(jobQueue1, nodeID.String(), 0)
+	service1, err := NewP2PService(jobQueue1, 4060, WithIdentity(key), WithCopyRouter(router1))
+	require.NoError(t, err)
+	service2, err := NewP2PService(jobQueue2, 4061)
+	require.NoError(t, err)
+	router2 := NewCopyRouter(jobQueue2, "other", 0)
+	router2.SetRemote(service2)
+
+	go service1.Start(t.Name())
+	go service2.Start(t.Name())
+	defer service1.Stop()
+	defer service2.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, service2.host.Connect(ctx, peer.AddrInfo{
+		ID:    service1.host.ID(),
+		Addrs: service1.host.Addrs(),
+	}))
+
+	// the files are copied on the node of the job, with the archives sent over the stream
+	config, err := FileArchive("/etc/app/config.yaml", []byte("debug: true"), 0)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return router2.CopyTo(ctx, "job", "/etc/app/config.yaml", config) == nil
+	}, time.Second, 10*time.Millisecond)
+	archive, err := router2.CopyFrom(ctx, "job", "/etc/app/config.yaml")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"config.yaml": "debug: true"}, archiveContents(t, archive))
+
+	// the node of the job reports why the files can't be copied
+	_, err = router2.CopyFrom(ctx, "job", "/missing")
+	require.Error(t, err)
+	require.NoError(t, jobQueue1.Cancel("job"))
+	_, err = router2.CopyFrom(ctx, "job", "/etc/app/config.yaml")
+	require.ErrorContains(t, err, ErrJobNotRunning.Error())
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+)
+
+// DiscoveryMode is a peer discovery mechanism of the P2P service
+type DiscoveryMode string
+
+const (
+	// DiscoveryMDNS discovers peers on the local broadcast domain with mDNS
+	DiscoveryMDNS DiscoveryMode = "mdns"
+	// DiscoveryDHT discovers peers through rendezvous on a Kademlia DHT
+	DiscoveryDHT DiscoveryMode = "dht"
+)
+
+const (
+	// dhtProtocolPrefix keeps the cluster DHT separate from the public IPFS DHT
+	dhtProtocolPrefix = "/container-manager"
+	// dhtDiscoveryInterval is the interval between DHT rendezvous lookups
+	dhtDiscoveryInterval = 10 * time.Second
+	// bootstrapTimeout is the timeout to connect to a bootstrap peer
+	bootstrapTimeout = 10 * time.Second
+)
+
+// ParseDiscoveryMode parses a discovery mode name
+func ParseDiscoveryMode(mode string) (DiscoveryMode, error) {
+	switch DiscoveryMode(mode) {
+	case DiscoveryMDNS, DiscoveryDHT:
+		return DiscoveryMode(mode), nil
+	default:
+		return "", fmt.Errorf("unknown discovery mode %q", mode)
+	}
+}
+
+// ParseBootstrapPeers parses a list of bootstrap multiaddrs ending in /p2p/<peer-id>
+func ParseBootstrapPeers(addrs []string) ([]peer.AddrInfo, error) {
+	maddrs := make([]multiaddr.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		maddr, err := multiaddr.NewMultiaddr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootstrap peer %q: %w", addr, err)
+		}
+		maddrs = append(maddrs, maddr)
+	}
+
+	peers, err := peer.AddrInfosFromP2pAddrs(maddrs...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bootstrap peers: %w", err)
+	}
+	return peers, nil
+}
+
+// startDiscovery starts the configured discovery mechanisms
+func (s *Service) startDiscovery(serviceName string) error {
+	s.connectBootstrapPeers()
+
+	for _, mode := range s.discovery {
+		switch mode {
+		case DiscoveryMDNS:
+			service := mdns.NewMdnsService(s.host, serviceName, newPeerNotifee(s))
+			if err := service.Start(); err != nil {
+				return fmt.Errorf("failed to start mDNS service: %w", err)
+			}
+			s.mdns = service
+		case DiscoveryDHT:
+			if err := s.startDHT(serviceName); err != nil {
+				return fmt.Errorf("failed to start DHT discovery: %w", err)
+			}
+		default:
+			return fmt.Errorf("unknown discovery mode %q", mode)
+		}
+		logrus.WithField("mode", mode).Debug("Started peer discovery")
+	}
+
+	return nil
+}
+
+// connectBootstrapPeers connects to the static bootstrap peers
+func (s *Service) connectBootstrapPeers() {
+	for _, pi := range s.bootstrapPeers {
+		if pi.ID == s.host.ID() {
+			continue
+		}
+		s.host.Peerstore().AddAddrs(pi.ID, pi.Addrs, peerstore.PermanentAddrTTL)
+
+		ctx, cancel := context.WithTimeout(s.ctx, bootstrapTimeout)
+		err := s.host.Connect(ctx, pi)
+		cancel()
+		if err != nil {
+			logrus.WithField("peer", pi.ID).Warnf("failed to connect to bootstrap peer: %v", err)
+			continue
+		}
+		logrus.WithField("peer", pi.ID).Info("Connected to bootstrap peer")
+	}
+}
+
+// startDHT joins the cluster DHT and advertises the node under the service name
+func (s *Service) startDHT(serviceName string) error {
+	kdht, err := dht.New(
+		s.ctx,
+		s.host,
+		dht.Mode(dht.ModeServer),
+		dht.ProtocolPrefix(dhtProtocolPrefix),
+		dht.BootstrapPeers(s.bootstrapPeers...),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create DHT: %w", err)
+	}
+	s.dht = kdht
+
+	if err := kdht.Bootstrap(s.ctx); err != nil {
+		return fmt.Errorf("failed to bootstrap DHT: %w", err)
+	}
+
+	routingDiscovery := drouting.NewRoutingDiscovery(kdht)
+	dutil.Advertise(s.ctx, routingDiscovery, serviceName)
+	go s.discoverDHTPeers(routingDiscovery, serviceName)
+
+	return nil
+}
+
+// discoverDHTPeers periodically looks up the peers advertised under the service name
+func (s *Service) discoverDHTPeers(routingDiscovery *drouting.RoutingDiscovery, serviceName string) {
+	notifee := newPeerNotifee(s)
+	ticker := time.NewTicker(dhtDiscoveryInterval)
+	defer ticker.Stop()
+
+	for {
+		peers, err := dutil.FindPeers(s.ctx, routingDiscovery, serviceName)
+		if err != nil {
+			logrus.Debugf("failed to find peers in DHT: %v", err)
+		}
+		for _, pi := range peers {
+			if pi.ID == s.host.ID() || len(pi.Addrs) == 0 {
+				continue
+			}
+			if len(s.host.Peerstore().Addrs(pi.ID)) == 0 {
+				notifee.HandlePeerFound(pi)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
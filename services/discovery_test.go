@@ -0,0 +1,79 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestParseDiscoveryMode(t *testing.T) {
+	t.Parallel()
+	mode, err := ParseDiscoveryMode("dht")
+	require.NoError(t, err)
+	require.Equal(t, DiscoveryDHT, mode)
+
+	_, err = ParseDiscoveryMode("gossip")
+	require.Error(t, err)
+}
+
+func TestParseBootstrapPeers(t *testing.T) {
+	t.Parallel()
+	id := newTestPeerID(t)
+
+	peers, err := ParseBootstrapPeers([]string{
+		"/ip4/10.0.0.1/tcp/4001/p2p/" + id.String(),
+		"/ip4/10.0.0.2/tcp/4001/p2p/" + id.String(),
+	})
+	require.NoError(t, err)
+	require.Len(t, peers, 1)
+	require.Equal(t, id, peers[0].ID)
+	require.Len(t, peers[0].Addrs, 2)
+
+	_, err = ParseBootstrapPeers([]string{"/ip4/10.0.0.1/tcp/4001"})
+	require.Error(t, err)
+
+	_, err = ParseBootstrapPeers([]string{"not-a-multiaddr"})
+	require.Error(t, err)
+}
+
+func TestP2PServiceDHTDiscovery(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	bootstrap, err := NewP2PService(NewMockQueue(ctrl), 4053, WithDiscovery(DiscoveryDHT))
+	require.NoError(t, err)
+	bootstrap.Start(t.Name())
+	defer bootstrap.Stop()
+
+	bootstrapPeers := []peer.AddrInfo{{ID: bootstrap.host.ID(), Addrs: bootstrap.host.Addrs()}}
+
+	service1, err := NewP2PService(
+		NewMockQueue(ctrl),
+		4054,
+		WithDiscovery(DiscoveryDHT),
+		WithBootstrapPeers(bootstrapPeers),
+	)
+	require.NoError(t, err)
+	service1.Start(t.Name())
+	defer service1.Stop()
+
+	service2, err := NewP2PService(
+		NewMockQueue(ctrl),
+		4055,
+		WithDiscovery(DiscoveryDHT),
+		WithBootstrapPeers(bootstrapPeers),
+	)
+	require.NoError(t, err)
+	service2.Start(t.Name())
+	defer service2.Stop()
+
+	// the nodes only know the bootstrap peer and find each other through the DHT
+	require.Eventually(t, func() bool {
+		return len(service1.host.Peerstore().Addrs(service2.host.ID())) > 0 &&
+			len(service2.host.Peerstore().Addrs(service1.host.ID())) > 0
+	}, 30*time.Second, 500*time.Millisecond)
+}
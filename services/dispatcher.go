@@ -0,0 +1,128 @@
+package services
+
+import (
+	"container-manager/types"
+	"encoding/json"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Dispatcher places jobs on a node of the cluster and hands them over to it.
+// nodeID: The ID of the local node
+// queue: The job queue of the local node
+// p2pService: The P2P service used to reach other nodes
+// scheduler: The scheduler that picks the node of each job
+type Dispatcher struct {
+	nodeID     string
+	queue      Queue
+	p2pService P2PService
+	scheduler  *Scheduler
+}
+
+// NewDispatcher creates a new dispatcher
+func NewDispatcher(queue Queue, p2pService P2PService, scheduler *Scheduler) *Dispatcher {
+	return &Dispatcher{
+		nodeID:     p2pService.ID(),
+		queue:      queue,
+		p2pService: p2pService,
+		scheduler:  scheduler,
+	}
+}
+
+// Dispatch places the job and returns the ID of the node it was handed to.
+// Jobs that can't be handed to the chosen peer are enqueued locally if the local node satisfies their
+// placement constraints, and fail otherwise.
+func (d *Dispatcher) Dispatch(jobID string, container types.Container, client string) (string, error) {
+	nodeID, err := d.scheduler.Place(container)
+	if err != nil {
+		return "", fmt.Errorf("failed to place job: %w", err)
+	}
+
+	if nodeID != d.nodeID {
+		err := d.send(nodeID, jobID, container, client)
+		if err == nil {
+			return nodeID, nil
+		}
+		// the job is tracked as failed on the peer, so it doesn't count against its own constraints
+		d.track(jobID, container, nodeID, types.JobStatusFailed)
+		if fitErr := d.scheduler.Fits(container); fitErr != nil {
+			return "", fmt.Errorf("failed to hand job to node %s (%v): %w", nodeID, err, fitErr)
+		}
+		logrus.WithFields(logrus.Fields{
+			"job_id": jobID,
+			"node":   nodeID,
+		}).Warnf("failed to hand job to peer, running it locally: %v", err)
+	}
+
+	if err := d.queue.Enqueue(jobID, container); err != nil {
+		return "", fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	return d.nodeID, nil
+}
+
+// Cancel cancels a job on the node that runs it. Jobs of peers are tracked as cancelled once the peer was asked to cancel them.
+func (d *Dispatcher) Cancel(job types.Job) error {
+	if job.Node == d.nodeID {
+		return d.queue.Cancel(job.ID)
+	}
+
+	msg := Message{
+		Type:  types.P2PMessageTypeCancelJob,
+		JobID: job.ID,
+	}
+	if err := d.p2pService.Send(job.Node, msg); err != nil {
+		return fmt.Errorf("failed to send cancellation: %w", err)
+	}
+	job.Status = types.JobStatusCancelled
+	d.queue.Track(job)
+	return nil
+}
+
+// track records a job handed to a peer
+func (d *Dispatcher) track(jobID string, container types.Container, nodeID string, status types.JobStatus) {
+	d.queue.Track(types.Job{
+		ID:        jobID,
+		Container: container,
+		Status:    status,
+		Node:      nodeID,
+	})
+}
+
+// send hands the job to a peer, it is tracked as pending until the peer reports its status.
+// The peer fetches the inputs held by other nodes through this node, which relays them.
+func (d *Dispatcher) send(nodeID string, jobID string, container types.Container, client string) error {
+	sent := container
+	sent.Inputs = relayInputs(container.Inputs, d.nodeID, nodeID)
+	containerData, err := json.Marshal(sent)
+	if err != nil {
+		return fmt.Errorf("failed to marshal container data: %w", err)
+	}
+
+	msg := Message{
+		Type:   types.P2PMessageTypeDeployContainer,
+		JobID:  jobID,
+		Data:   containerData,
+		Client: client,
+	}
+
+	// track the job before sending it, so a status reported by the peer isn't overwritten
+	d.track(jobID, container, nodeID, types.JobStatusPending)
+	return d.p2pService.Send(nodeID, msg)
+}
+
+// relayInputs returns the inputs of a job handed to a peer, the inputs held by other nodes than the peer are
+// fetched from the node that relays them
+func relayInputs(inputs []types.ArtifactInput, relay string, nodeID string) []types.ArtifactInput {
+	if len(inputs) == 0 {
+		return inputs
+	}
+	relayed := make([]types.ArtifactInput, 0, len(inputs))
+	for _, input := range inputs {
+		if input.Node != nodeID {
+			input.Node = relay
+		}
+		relayed = append(relayed, input)
+	}
+	return relayed
+}
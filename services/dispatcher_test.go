@@ -0,0 +1,191 @@
+package services
+
+import (
+	"container-manager/types"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+// newTestDispatcher creates a dispatcher that places every job on the given node
+func newTestDispatcher(t *testing.T, queue Queue, p2pService P2PService, target string) *Dispatcher {
+	t.Helper()
+
+	local := types.NodeCapacity{NodeID: "local", Workers: 1, QueueSize: 10}
+	peer := types.NodeCapacity{NodeID: "peer", Workers: 1, QueueSize: 10}
+	strategy := ScoringStrategyFunc(func(_ types.Container, node types.NodeCapacity) float64 {
+		if node.NodeID == target {
+			return 1
+		}
+		return 0
+	})
+	return NewDispatcher(queue, p2pService, newTestSchedulerWithStrategy(local, strategy, peer))
+}
+
+// newTestSchedulerWithStrategy creates a scheduler with a custom strategy
+func newTestSchedulerWithStrategy(
+	local types.NodeCapacity,
+	strategy ScoringStrategy,
+	peers ...types.NodeCapacity,
+) *Scheduler {
+	store := NewCapacityStore(CapacityRecordTTL)
+	for _, record := range peers {
+		record.UpdatedAt = time.Now()
+		store.Update(record)
+	}
+	return NewScheduler(staticCapacity(local), store, staticJobs(nil), strategy)
+}
+
+func TestDispatcherLocal(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockQueue := NewMockQueue(ctrl)
+	mockP2PService := NewMockP2PService(ctrl)
+	mockP2PService.EXPECT().ID().Return("local")
+	mockQueue.EXPECT().Enqueue("job", types.Container{Image: "nginx"}).Return(nil)
+
+	dispatcher := newTestDispatcher(t, mockQueue, mockP2PService, "local")
+	nodeID, err := dispatcher.Dispatch("job", types.Container{Image: "nginx"}, "alice")
+	require.NoError(t, err)
+	require.Equal(t, "local", nodeID)
+}
+
+func TestDispatcherRemote(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	container := types.Container{Image: "nginx", Inputs: []types.ArtifactInput{
+		{Digest: "sha256:" + strings.Repeat("a", 64), Node: "other", Path: "/in/a"},
+		{Digest: "sha256:" + strings.Repeat("b", 64), Node: "peer", Path: "/in/b"},
+	}}
+	mockQueue := NewMockQueue(ctrl)
+	mockP2PService := NewMockP2PService(ctrl)
+	mockP2PService.EXPECT().ID().Return("local")
+	gomock.InOrder(
+		mockQueue.EXPECT().Track(types.Job{
+			ID:        "job",
+			Container: container,
+			Status:    types.JobStatusPending,
+			Node:      "peer",
+		}),
+		mockP2PService.EXPECT().Send("peer", gomock.Any()).DoAndReturn(func(_ string, msg Message) error {
+			require.Equal(t, types.P2PMessageTypeDeployContainer, msg.Type)
+			require.Equal(t, "job", msg.JobID)
+			require.Equal(t, "alice", msg.Client)
+
+			// the inputs held by other nodes than the peer are relayed by the dispatching node
+			var sent types.Container
+			require.NoError(t, json.Unmarshal(msg.Data, &sent))
+			require.Equal(t, "local", sent.Inputs[0].Node)
+			require.Equal(t, "peer", sent.Inputs[1].Node)
+			return nil
+		}),
+	)
+
+	dispatcher := newTestDispatcher(t, mockQueue, mockP2PService, "peer")
+	nodeID, err := dispatcher.Dispatch("job", container, "alice")
+	require.NoError(t, err)
+	require.Equal(t, "peer", nodeID)
+}
+
+func TestDispatcherFallsBackToLocal(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	container := types.Container{Image: "nginx"}
+	mockQueue := NewMockQueue(ctrl)
+	mockP2PService := NewMockP2PService(ctrl)
+	mockP2PService.EXPECT().ID().Return("local")
+	mockQueue.EXPECT().Track(gomock.Any()).Times(2)
+	mockP2PService.EXPECT().Send("peer", gomock.Any()).Return(fmt.Errorf("peer unreachable"))
+	mockQueue.EXPECT().Enqueue("job", container).Return(nil)
+
+	dispatcher := newTestDispatcher(t, mockQueue, mockP2PService, "peer")
+	nodeID, err := dispatcher.Dispatch("job", container, "alice")
+	require.NoError(t, err)
+	require.Equal(t, "local", nodeID)
+}
+
+func TestDispatcherFallbackConstraints(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// jobs pinned to the peer don't run on the local node when the peer can't be reached
+	container := types.Container{Image: "nginx", NodeSelector: map[string]string{types.NodeIDLabel: "peer"}}
+	mockQueue := NewMockQueue(ctrl)
+	mockP2PService := NewMockP2PService(ctrl)
+	mockP2PService.EXPECT().ID().Return("local")
+	gomock.InOrder(
+		mockQueue.EXPECT().Track(gomock.Any()),
+		mockP2PService.EXPECT().Send("peer", gomock.Any()).Return(fmt.Errorf("peer unreachable")),
+		mockQueue.EXPECT().Track(types.Job{
+			ID:        "job",
+			Container: container,
+			Status:    types.JobStatusFailed,
+			Node:      "peer",
+		}),
+	)
+
+	dispatcher := newTestDispatcher(t, mockQueue, mockP2PService, "peer")
+	_, err := dispatcher.Dispatch("job", container, "alice")
+	require.ErrorIs(t, err, ErrUnschedulable)
+}
+
+func TestDispatcherFallbackQueueFull(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	container := types.Container{Image: "nginx"}
+	mockQueue := NewMockQueue(ctrl)
+	mockP2PService := NewMockP2PService(ctrl)
+	mockP2PService.EXPECT().ID().Return("local")
+	gomock.InOrder(
+		mockQueue.EXPECT().Track(gomock.Any()),
+		mockP2PService.EXPECT().Send("peer", gomock.Any()).Return(fmt.Errorf("peer unreachable")),
+		mockQueue.EXPECT().Track(types.Job{
+			ID:        "job",
+			Container: container,
+			Status:    types.JobStatusFailed,
+			Node:      "peer",
+		}),
+		mockQueue.EXPECT().Enqueue("job", container).Return(ErrQueueFull),
+	)
+
+	dispatcher := newTestDispatcher(t, mockQueue, mockP2PService, "peer")
+	_, err := dispatcher.Dispatch("job", container, "alice")
+	require.ErrorIs(t, err, ErrQueueFull)
+}
+
+func TestDispatcherCancelRemote(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockQueue := NewMockQueue(ctrl)
+	mockP2PService := NewMockP2PService(ctrl)
+	mockP2PService.EXPECT().ID().Return("local")
+
+	job := types.Job{ID: "job", Node: "peer", Status: types.JobStatusRunning}
+	mockP2PService.EXPECT().Send("peer", Message{Type: types.P2PMessageTypeCancelJob, JobID: "job"}).Return(nil)
+	mockQueue.EXPECT().Track(types.Job{ID: "job", Node: "peer", Status: types.JobStatusCancelled})
+
+	dispatcher := newTestDispatcher(t, mockQueue, mockP2PService, "peer")
+	require.NoError(t, dispatcher.Cancel(job))
+}
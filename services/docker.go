@@ -3,81 +3,295 @@ package services
 import (
 	"container-manager/types"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"io"
+	"maps"
+	"strconv"
+	"strings"
 	"time"
 
+	dockerTypes "github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/image"
 
 	dockerContainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 )
 
-// DockerService service interface to deploy and get container status
+// ErrDeployTimeout is the error returned when pulling the image or starting a container hits its time limit
+var ErrDeployTimeout = fmt.Errorf("deploy timed out")
+
+// execPollInterval is the interval between checks of an exec whose output ended, until it exited
+const execPollInterval = 50 * time.Millisecond
+
+// ContainerInfo is the state of a deployed container.
+// Status: The status of the container, e.g. running or exited
+// Health: The status of the Docker health check, empty without one
+// IPAddress: The IP address of the container
+// ExitCode: The exit code of the container once it exited
+type ContainerInfo struct {
+	Status    string
+	Health    string
+	IPAddress string
+	ExitCode  int
+}
+
+// ContainerSummary is a container found on the host.
+// ID: The ID of the container
+// Status: The status of the container, e.g. running or exited
+// Labels: The labels of the container
+type ContainerSummary struct {
+	ID     string
+	Status string
+	Labels map[string]string
+}
+
+// DockerService service interface to deploy and get container status.
+// Every call stops once its context is done.
 type DockerService interface {
-	DeployContainer(container types.Container) (string, error)
-	GetContainerStatus(containerID string) (string, error)
+	DeployContainer(ctx context.Context, container types.Container) (string, error)
+	GetContainerStatus(ctx context.Context, containerID string) (string, error)
+	InspectContainer(ctx context.Context, containerID string) (ContainerInfo, error)
+	CopyFromContainer(ctx context.Context, containerID string, path string) (io.ReadCloser, error)
+	CopyToContainer(ctx context.Context, containerID string, path string, archive io.Reader) error
+	StopContainer(ctx context.Context, containerID string) error
+	KillContainer(ctx context.Context, containerID string) error
+	RemoveContainer(ctx context.Context, containerID string) error
+	ListContainers(ctx context.Context, label string) ([]ContainerSummary, error)
+	ListImages(ctx context.Context) ([]string, error)
+}
+
+// DockerOption configures optional behaviour of the Docker service
+type DockerOption func(*DockerServiceHandler)
+
+// WithInputArtifacts sets the store the input artifacts of containers are copied from
+func WithInputArtifacts(store *ArtifactStore) DockerOption {
+	return func(ds *DockerServiceHandler) {
+		ds.artifacts = store
+	}
+}
+
+// WithSecrets sets the injector that resolves the secrets of containers
+func WithSecrets(secrets *SecretInjector) DockerOption {
+	return func(ds *DockerServiceHandler) {
+		ds.secrets = secrets
+	}
+}
+
+// WithSeccompProfiles sets the directory of the seccomp profiles containers reference by name
+func WithSeccompProfiles(dir string) DockerOption {
+	return func(ds *DockerServiceHandler) {
+		ds.seccompProfiles = dir
+	}
+}
+
+// WithHost sets the address of the Docker API, DOCKER_HOST is used when empty
+func WithHost(host string) DockerOption {
+	return func(ds *DockerServiceHandler) {
+		ds.host = host
+	}
 }
 
 // DockerServiceHandler is the implementation of the DockerService interface
 // client: The Docker client
+// host: The address of the Docker API
+// artifacts: The store the input artifacts of containers are copied from
+// secrets: Resolves the secrets of containers, nil rejects containers with secrets
+// seccompProfiles: The directory of the seccomp profiles containers reference by name
 type DockerServiceHandler struct {
-	client *client.Client
+	client          *client.Client
+	host            string
+	artifacts       *ArtifactStore
+	secrets         *SecretInjector
+	seccompProfiles string
 }
 
 // NewDockerService creates a new DockerServiceHandler instance
-func NewDockerService() (*DockerServiceHandler, error) {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+func NewDockerService(opts ...DockerOption) (*DockerServiceHandler, error) {
+	ds := &DockerServiceHandler{}
+	for _, opt := range opts {
+		opt(ds)
+	}
+
+	clientOpts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+	if ds.host != "" {
+		clientOpts = append(clientOpts, client.WithHost(ds.host))
+	}
+	cli, err := client.NewClientWithOpts(clientOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Docker client: %w", err)
 	}
-	return &DockerServiceHandler{
-		client: cli,
-	}, nil
+	ds.client = cli
+	return ds, nil
 }
 
-// DeployContainer deploys a container using Docker
-func (ds *DockerServiceHandler) DeployContainer(container types.Container) (string, error) {
-	logrus.WithField("container", container).Debug("Deploying container")
+// DeployContainer deploys a container using Docker. The image is pulled within the pull timeout of the
+// container, and the container is created and started within its start timeout.
+func (ds *DockerServiceHandler) DeployContainer(ctx context.Context, container types.Container) (string, error) {
+	logrus.WithField("container", container.Redacted()).Debug("Deploying container")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	if err := ds.pullImage(ctx, container.Image, container.PullTimeout()); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, container.StartTimeout())
 	defer cancel()
 
+	hostConfig, err := ds.hostConfig(container.Security)
+	if err != nil {
+		return "", err
+	}
+
+	// the values of the secrets are only resolved here, on the node that runs the container
+	secrets, err := ds.secrets.mount(ctx, container.Secrets)
+	if err != nil {
+		return "", deployError(ctx, "failed to resolve secrets", err)
+	}
+
 	var envVars []string
 	for key, value := range container.Env {
 		envVars = append(envVars, key+"="+value)
 	}
+	envVars = append(envVars, secrets.env...)
 
-	reader, err := ds.client.ImagePull(ctx, container.Image, image.PullOptions{})
-	if err != nil {
-		return "", fmt.Errorf("failed to pull image: %w", err)
+	labels := container.Labels
+	if secrets.id != "" {
+		labels = maps.Clone(container.Labels)
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[secretsLabel] = secrets.id
+		for _, file := range secrets.files {
+			hostConfig.Mounts = append(hostConfig.Mounts, mount.Mount{
+				Type:     mount.TypeBind,
+				Source:   file.source,
+				Target:   file.target,
+				ReadOnly: true,
+			})
+		}
 	}
-	defer reader.Close()
-
-	io.Copy(io.Discard, reader)
 
 	resp, err := ds.client.ContainerCreate(ctx, &dockerContainer.Config{
-		Image: container.Image,
-		Cmd:   container.Arguments,
-		Env:   envVars,
-	}, nil, nil, nil, "")
+		Image:       container.Image,
+		Cmd:         container.Arguments,
+		Env:         envVars,
+		Labels:      labels,
+		User:        runAs(container.Security),
+		Healthcheck: healthConfig(container.HealthCheck),
+	}, hostConfig, nil, nil, "")
 	if err != nil {
-		return "", fmt.Errorf("failed to create container: %w", err)
+		ds.secrets.release(secrets.id)
+		return "", deployError(ctx, "failed to create container", err)
+	}
+
+	// inputs are copied before the container starts, so they are there when its command runs
+	for _, input := range container.Inputs {
+		if err := ds.copyArtifact(ctx, resp.ID, input); err != nil {
+			ds.forceRemove(ctx, resp.ID, secrets.id)
+			return "", deployError(ctx, "failed to copy input", err)
+		}
 	}
 
 	if err := ds.client.ContainerStart(ctx, resp.ID, dockerContainer.StartOptions{}); err != nil {
-		return "", fmt.Errorf("failed to start container: %w", err)
+		ds.forceRemove(ctx, resp.ID, secrets.id)
+		return "", deployError(ctx, "failed to start container", err)
 	}
 
 	return resp.ID, nil
 }
 
+// hostConfig returns the host config of a container with its security options applied
+func (ds *DockerServiceHandler) hostConfig(security *types.SecurityContext) (*dockerContainer.HostConfig, error) {
+	hostConfig := &dockerContainer.HostConfig{}
+	if security == nil {
+		return hostConfig, nil
+	}
+
+	hostConfig.ReadonlyRootfs = security.ReadOnlyRootFS
+	for _, capability := range security.CapAdd {
+		hostConfig.CapAdd = append(hostConfig.CapAdd, types.NormalizeCapability(capability))
+	}
+	for _, capability := range security.CapDrop {
+		hostConfig.CapDrop = append(hostConfig.CapDrop, types.NormalizeCapability(capability))
+	}
+	if security.NoNewPrivileges {
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "no-new-privileges:true")
+	}
+	switch security.SeccompProfile {
+	case "":
+	case types.ProfileUnconfined:
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "seccomp="+types.ProfileUnconfined)
+	default:
+		// the daemon takes the content of the profile, like the Docker CLI sends it
+		profile, err := loadSeccompProfile(ds.seccompProfiles, security.SeccompProfile)
+		if err != nil {
+			return nil, err
+		}
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "seccomp="+string(profile))
+	}
+	if security.AppArmorProfile != "" {
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "apparmor="+security.AppArmorProfile)
+	}
+	return hostConfig, nil
+}
+
+// runAs returns the user a container runs as, the user of its image when empty
+func runAs(security *types.SecurityContext) string {
+	if security == nil {
+		return ""
+	}
+	return security.RunAs()
+}
+
+// pullImage pulls an image within the timeout
+func (ds *DockerServiceHandler) pullImage(ctx context.Context, ref string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	reader, err := ds.client.ImagePull(ctx, ref, image.PullOptions{})
+	if err != nil {
+		return deployError(ctx, "failed to pull image", err)
+	}
+	defer reader.Close()
+
+	// the pull runs until its progress stream is drained
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return deployError(ctx, "failed to pull image", err)
+	}
+	return nil
+}
+
+// forceRemove removes a container that failed to deploy and its secret files, failures are logged. The removal
+// isn't interrupted when the deploy was cancelled, so the container isn't left behind.
+func (ds *DockerServiceHandler) forceRemove(ctx context.Context, containerID string, secretsID string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+
+	if err := ds.client.ContainerRemove(ctx, containerID, dockerContainer.RemoveOptions{Force: true}); err != nil {
+		logrus.WithField("container_id", containerID).Warnf("failed to remove container: %v", err)
+	}
+	ds.secrets.release(secretsID)
+}
+
+// deployError wraps an error of a deploy step, marking it as a timeout when the deadline of the step passed
+func deployError(ctx context.Context, msg string, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s: %w: %v", msg, ErrDeployTimeout, err)
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
 // GetContainerStatus gets the status of a container by container ID
-func (ds *DockerServiceHandler) GetContainerStatus(containerID string) (string, error) {
+func (ds *DockerServiceHandler) GetContainerStatus(ctx context.Context, containerID string) (string, error) {
 	logrus.WithField("container_id", containerID).Debug("Getting container status")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	containerJSON, err := ds.client.ContainerInspect(ctx, containerID)
@@ -87,3 +301,449 @@ func (ds *DockerServiceHandler) GetContainerStatus(containerID string) (string,
 
 	return containerJSON.State.Status, nil
 }
+
+// InspectContainer gets the state of a container by container ID
+func (ds *DockerServiceHandler) InspectContainer(ctx context.Context, containerID string) (ContainerInfo, error) {
+	logrus.WithField("container_id", containerID).Debug("Inspecting container")
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	containerJSON, err := ds.client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return ContainerInfo{}, fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	info := ContainerInfo{
+		Status:   containerJSON.State.Status,
+		ExitCode: containerJSON.State.ExitCode,
+	}
+	if containerJSON.State.Health != nil {
+		info.Health = containerJSON.State.Health.Status
+	}
+	if containerJSON.NetworkSettings != nil {
+		info.IPAddress = containerJSON.NetworkSettings.IPAddress
+		for _, network := range containerJSON.NetworkSettings.Networks {
+			if info.IPAddress == "" && network != nil {
+				info.IPAddress = network.IPAddress
+			}
+		}
+	}
+	return info, nil
+}
+
+// CopyFromContainer returns a tar archive of a path in a container, rooted at the base name of the path
+func (ds *DockerServiceHandler) CopyFromContainer(ctx context.Context, containerID string, path string) (io.ReadCloser, error) {
+	logrus.WithFields(logrus.Fields{
+		"container_id": containerID,
+		"path":         path,
+	}).Debug("Copying from container")
+
+	reader, _, err := ds.client.CopyFromContainer(ctx, containerID, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy from container: %w", err)
+	}
+	return reader, nil
+}
+
+// CopyToContainer extracts a tar archive rooted at the base name of a path into a container at the path
+func (ds *DockerServiceHandler) CopyToContainer(ctx context.Context, containerID string, path string, archive io.Reader) error {
+	logrus.WithFields(logrus.Fields{
+		"container_id": containerID,
+		"path":         path,
+	}).Debug("Copying to container")
+
+	relocated := relocateArchive(archive, path)
+	defer relocated.Close()
+
+	err := ds.client.CopyToContainer(ctx, containerID, "/", relocated, dockerTypes.CopyToContainerOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to copy to container: %w", err)
+	}
+	return nil
+}
+
+// copyArtifact copies the artifact of an input into a created container
+func (ds *DockerServiceHandler) copyArtifact(ctx context.Context, containerID string, input types.ArtifactInput) error {
+	if ds.artifacts == nil {
+		return fmt.Errorf("artifacts are not enabled")
+	}
+
+	archive, err := ds.artifacts.Open(input.Digest)
+	if err != nil {
+		return fmt.Errorf("failed to open artifact: %w", err)
+	}
+	defer archive.Close()
+
+	if err := ds.CopyToContainer(ctx, containerID, input.Path, archive); err != nil {
+		return fmt.Errorf("failed to copy artifact: %w", err)
+	}
+	return nil
+}
+
+// StopContainer stops a container by container ID
+func (ds *DockerServiceHandler) StopContainer(ctx context.Context, containerID string) error {
+	logrus.WithField("container_id", containerID).Debug("Stopping container")
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	if err := ds.client.ContainerStop(ctx, containerID, dockerContainer.StopOptions{}); err != nil {
+		return fmt.Errorf("failed to stop container: %w", err)
+	}
+	return nil
+}
+
+// KillContainer kills a container by container ID without waiting for it to stop
+func (ds *DockerServiceHandler) KillContainer(ctx context.Context, containerID string) error {
+	logrus.WithField("container_id", containerID).Debug("Killing container")
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if err := ds.client.ContainerKill(ctx, containerID, "SIGKILL"); err != nil {
+		return fmt.Errorf("failed to kill container: %w", err)
+	}
+	return nil
+}
+
+// RemoveContainer removes a stopped container by container ID
+func (ds *DockerServiceHandler) RemoveContainer(ctx context.Context, containerID string) error {
+	logrus.WithField("container_id", containerID).Debug("Removing container")
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	// the secret files of the container are removed with it
+	var secretsID string
+	if ds.secrets != nil {
+		if info, err := ds.client.ContainerInspect(ctx, containerID); err == nil && info.Config != nil {
+			secretsID = info.Config.Labels[secretsLabel]
+		}
+	}
+	if err := ds.client.ContainerRemove(ctx, containerID, dockerContainer.RemoveOptions{}); err != nil {
+		return fmt.Errorf("failed to remove container: %w", err)
+	}
+	ds.secrets.release(secretsID)
+	return nil
+}
+
+// ListContainers lists the containers with the label, including the stopped containers
+func (ds *DockerServiceHandler) ListContainers(ctx context.Context, label string) ([]ContainerSummary, error) {
+	logrus.WithField("label", label).Debug("Listing containers")
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	list, err := ds.client.ContainerList(ctx, dockerContainer.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", label)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	containers := make([]ContainerSummary, 0, len(list))
+	for _, summary := range list {
+		containers = append(containers, ContainerSummary{
+			ID:     summary.ID,
+			Status: summary.State,
+			Labels: summary.Labels,
+		})
+	}
+	return containers, nil
+}
+
+// ContainerEvents streams the die, oom, kill and health_status events of the containers with the label
+func (ds *DockerServiceHandler) ContainerEvents(ctx context.Context, label string) (<-chan ContainerEvent, <-chan error) {
+	logrus.WithField("label", label).Debug("Subscribing to container events")
+
+	messages, errs := ds.client.Events(ctx, dockerTypes.EventsOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("type", string(events.ContainerEventType)),
+			filters.Arg("label", label),
+			filters.Arg("event", string(events.ActionDie)),
+			filters.Arg("event", string(events.ActionOOM)),
+			filters.Arg("event", string(events.ActionKill)),
+			filters.Arg("event", string(events.ActionHealthStatus)),
+		),
+	})
+
+	containerEvents := make(chan ContainerEvent)
+	go func() {
+		defer close(containerEvents)
+		for {
+			select {
+			case message := <-messages:
+				select {
+				case containerEvents <- containerEvent(message):
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return containerEvents, errs
+}
+
+// containerEvent converts a Docker event to a container event
+func containerEvent(message events.Message) ContainerEvent {
+	event := ContainerEvent{
+		ContainerID: message.Actor.ID,
+		Action:      string(message.Action),
+		Signal:      message.Actor.Attributes["signal"],
+	}
+	if exitCode, err := strconv.Atoi(message.Actor.Attributes["exitCode"]); err == nil {
+		event.ExitCode = exitCode
+	}
+	// health events carry the health in their action, e.g. "health_status: healthy"
+	if action, health, ok := strings.Cut(event.Action, ":"); ok && action == string(events.ActionHealthStatus) {
+		event.Action = action
+		event.Health = strings.TrimSpace(health)
+	}
+	return event
+}
+
+// ContainerStats gets a sample of the resource use of a container, Docker waits for a second sample to
+// report the CPU use
+func (ds *DockerServiceHandler) ContainerStats(ctx context.Context, containerID string) (types.ContainerStats, error) {
+	response, err := ds.client.ContainerStats(ctx, containerID, false)
+	if err != nil {
+		return types.ContainerStats{}, fmt.Errorf("failed to get container stats: %w", err)
+	}
+	defer response.Body.Close()
+
+	var stats dockerTypes.StatsJSON
+	if err := json.NewDecoder(response.Body).Decode(&stats); err != nil {
+		return types.ContainerStats{}, fmt.Errorf("failed to decode container stats: %w", err)
+	}
+	return containerStats(stats), nil
+}
+
+// StreamContainerStats streams the samples of the resource use of a container Docker takes every second
+func (ds *DockerServiceHandler) StreamContainerStats(
+	ctx context.Context,
+	containerID string,
+) (<-chan types.ContainerStats, <-chan error) {
+	samples := make(chan types.ContainerStats)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(samples)
+
+		response, err := ds.client.ContainerStats(ctx, containerID, true)
+		if err != nil {
+			errs <- fmt.Errorf("failed to get container stats: %w", err)
+			return
+		}
+		defer response.Body.Close()
+
+		decoder := json.NewDecoder(response.Body)
+		for {
+			var stats dockerTypes.StatsJSON
+			if err := decoder.Decode(&stats); err != nil {
+				if ctx.Err() == nil && err != io.EOF {
+					errs <- fmt.Errorf("failed to decode container stats: %w", err)
+				}
+				return
+			}
+			select {
+			case samples <- containerStats(stats):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return samples, errs
+}
+
+// containerStats converts Docker stats to container stats
+func containerStats(stats dockerTypes.StatsJSON) types.ContainerStats {
+	sample := types.ContainerStats{
+		Time:             stats.Read,
+		CPUSeconds:       float64(stats.CPUStats.CPUUsage.TotalUsage) / float64(time.Second),
+		MemoryBytes:      stats.MemoryStats.Usage,
+		MemoryLimitBytes: stats.MemoryStats.Limit,
+	}
+
+	// the CPU use is the share of the time of the host the container used between the two samples
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	cpus := float64(stats.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta > 0 && systemDelta > 0 {
+		sample.CPUPercent = cpuDelta / systemDelta * cpus * 100
+	}
+
+	// the page cache can be reclaimed, so it doesn't count, as in docker stats
+	cache := stats.MemoryStats.Stats["inactive_file"]
+	if cache == 0 {
+		cache = stats.MemoryStats.Stats["total_inactive_file"]
+	}
+	if cache < sample.MemoryBytes {
+		sample.MemoryBytes -= cache
+	}
+
+	for _, network := range stats.Networks {
+		sample.NetworkRxBytes += network.RxBytes
+		sample.NetworkTxBytes += network.TxBytes
+	}
+	for _, entry := range stats.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			sample.BlockReadBytes += entry.Value
+		case "write":
+			sample.BlockWriteBytes += entry.Value
+		}
+	}
+	return sample
+}
+
+// Exec runs a command in a container
+func (ds *DockerServiceHandler) Exec(ctx context.Context, containerID string, opts ExecOptions) (ExecSession, error) {
+	created, err := ds.client.ContainerExecCreate(ctx, containerID, dockerTypes.ExecConfig{
+		Cmd:          opts.Command,
+		Env:          opts.Env,
+		Tty:          opts.TTY,
+		AttachStdin:  opts.Stdin,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exec: %w", err)
+	}
+	attach, err := ds.client.ContainerExecAttach(ctx, created.ID, dockerTypes.ExecStartCheck{Tty: opts.TTY})
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach to exec: %w", err)
+	}
+
+	// without a TTY, the output and error output are multiplexed on the connection
+	stdout, stdoutWriter := io.Pipe()
+	stderr, stderrWriter := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var err error
+		if opts.TTY {
+			_, err = io.Copy(stdoutWriter, attach.Reader)
+		} else {
+			_, err = stdcopy.StdCopy(stdoutWriter, stderrWriter, attach.Reader)
+		}
+		stdoutWriter.CloseWithError(err)
+		stderrWriter.CloseWithError(err)
+	}()
+
+	session := &execSession{
+		stdout: stdout,
+		stderr: stderr,
+		resize: func(ctx context.Context, size TerminalSize) error {
+			return ds.client.ContainerExecResize(ctx, created.ID, dockerContainer.ResizeOptions{
+				Height: size.Height,
+				Width:  size.Width,
+			})
+		},
+		wait: func(ctx context.Context) (int, error) {
+			return ds.waitExec(ctx, created.ID, done)
+		},
+		close: func() error {
+			attach.Close()
+			return nil
+		},
+	}
+	if opts.Stdin {
+		session.stdin = &dockerExecInput{attach: attach}
+	}
+	return session, nil
+}
+
+// waitExec waits for the output of an exec to end, and for Docker to record its exit code
+func (ds *DockerServiceHandler) waitExec(ctx context.Context, execID string, done <-chan struct{}) (int, error) {
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return exitCodeUnknown, ctx.Err()
+	}
+
+	for {
+		inspect, err := ds.client.ContainerExecInspect(ctx, execID)
+		if err != nil {
+			return exitCodeUnknown, fmt.Errorf("failed to inspect exec: %w", err)
+		}
+		if !inspect.Running {
+			return inspect.ExitCode, nil
+		}
+		select {
+		case <-time.After(execPollInterval):
+		case <-ctx.Done():
+			return exitCodeUnknown, ctx.Err()
+		}
+	}
+}
+
+// dockerExecInput is the input of an exec, closing it closes the write side of the connection
+type dockerExecInput struct {
+	attach dockerTypes.HijackedResponse
+}
+
+// Write writes the input to the exec
+func (di *dockerExecInput) Write(data []byte) (int, error) {
+	return di.attach.Conn.Write(data)
+}
+
+// Close closes the input of the exec
+func (di *dockerExecInput) Close() error {
+	return di.attach.CloseWrite()
+}
+
+// ResolveImageDigest resolves an image reference to the digest of its manifest in the registry
+func (ds *DockerServiceHandler) ResolveImageDigest(ctx context.Context, image string) (string, error) {
+	logrus.WithField("image", image).Debug("Resolving image digest")
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	inspect, err := ds.client.DistributionInspect(ctx, image, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image distribution: %w", err)
+	}
+
+	return inspect.Descriptor.Digest.String(), nil
+}
+
+// ListImages lists the references of the images cached on the host
+func (ds *DockerServiceHandler) ListImages(ctx context.Context) ([]string, error) {
+	logrus.Debug("Listing images")
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	summaries, err := ds.client.ImageList(ctx, image.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+
+	var images []string
+	for _, summary := range summaries {
+		images = append(images, summary.RepoTags...)
+		images = append(images, summary.RepoDigests...)
+	}
+	return images, nil
+}
+
+// healthConfig returns the Docker health check of a command health check, nil for probes run by the manager
+func healthConfig(check *types.HealthCheck) *dockerContainer.HealthConfig {
+	if check == nil || len(check.Command) == 0 {
+		return nil
+	}
+
+	return &dockerContainer.HealthConfig{
+		Test:        append([]string{"CMD"}, check.Command...),
+		Interval:    time.Duration(check.Interval),
+		Timeout:     time.Duration(check.Timeout),
+		Retries:     check.Retries,
+		StartPeriod: time.Duration(check.StartPeriod),
+	}
+}
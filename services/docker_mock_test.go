@@ -11,6 +11,8 @@ package services
 
 import (
 	types "container-manager/types"
+	context "context"
+	io "io"
 	reflect "reflect"
 
 	gomock "go.uber.org/mock/gomock"
@@ -40,38 +42,187 @@ func (m *MockDockerService) EXPECT() *MockDockerServiceMockRecorder {
 }
 
 // DeployContainer mocks base method.
-func (m *MockDockerService) DeployContainer(container types.Container) (string, error) {
+func (m *MockDockerService) DeployContainer(ctx context.Context, container types.Container) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeployContainer", container)
+	ret := m.ctrl.Call(m, "DeployContainer", ctx, container)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // DeployContainer indicates an expected call of DeployContainer.
-func (mr *MockDockerServiceMockRecorder) DeployContainer(container any) *gomock.Call {
+func (mr *MockDockerServiceMockRecorder) DeployContainer(ctx, container any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(
 		mr.mock,
 		"DeployContainer",
 		reflect.TypeOf((*MockDockerService)(nil).DeployContainer),
+		ctx,
 		container)
 }
 
 // GetContainerStatus mocks base method.
-func (m *MockDockerService) GetContainerStatus(containerID string) (string, error) {
+func (m *MockDockerService) GetContainerStatus(ctx context.Context, containerID string) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetContainerStatus", containerID)
+	ret := m.ctrl.Call(m, "GetContainerStatus", ctx, containerID)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetContainerStatus indicates an expected call of GetContainerStatus.
-func (mr *MockDockerServiceMockRecorder) GetContainerStatus(containerID any) *gomock.Call {
+func (mr *MockDockerServiceMockRecorder) GetContainerStatus(ctx, containerID any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock,
 		"GetContainerStatus",
 		reflect.TypeOf((*MockDockerService)(nil).GetContainerStatus),
+		ctx,
 		containerID)
 }
+
+// InspectContainer mocks base method.
+func (m *MockDockerService) InspectContainer(ctx context.Context, containerID string) (ContainerInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InspectContainer", ctx, containerID)
+	ret0, _ := ret[0].(ContainerInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// InspectContainer indicates an expected call of InspectContainer.
+func (mr *MockDockerServiceMockRecorder) InspectContainer(ctx, containerID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock,
+		"InspectContainer",
+		reflect.TypeOf((*MockDockerService)(nil).InspectContainer),
+		ctx,
+		containerID)
+}
+
+// CopyFromContainer mocks base method.
+func (m *MockDockerService) CopyFromContainer(ctx context.Context, containerID, path string) (io.ReadCloser, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CopyFromContainer", ctx, containerID, path)
+	ret0, _ := ret[0].(io.ReadCloser)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CopyFromContainer indicates an expected call of CopyFromContainer.
+func (mr *MockDockerServiceMockRecorder) CopyFromContainer(ctx, containerID, path any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock,
+		"CopyFromContainer",
+		reflect.TypeOf((*MockDockerService)(nil).CopyFromContainer),
+		ctx,
+		containerID,
+		path)
+}
+
+// CopyToContainer mocks base method.
+func (m *MockDockerService) CopyToContainer(ctx context.Context, containerID, path string, archive io.Reader) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CopyToContainer", ctx, containerID, path, archive)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CopyToContainer indicates an expected call of CopyToContainer.
+func (mr *MockDockerServiceMockRecorder) CopyToContainer(ctx, containerID, path, archive any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock,
+		"CopyToContainer",
+		reflect.TypeOf((*MockDockerService)(nil).CopyToContainer),
+		ctx,
+		containerID,
+		path,
+		archive)
+}
+
+// StopContainer mocks base method.
+func (m *MockDockerService) StopContainer(ctx context.Context, containerID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StopContainer", ctx, containerID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// StopContainer indicates an expected call of StopContainer.
+func (mr *MockDockerServiceMockRecorder) StopContainer(ctx, containerID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock,
+		"StopContainer",
+		reflect.TypeOf((*MockDockerService)(nil).StopContainer),
+		ctx,
+		containerID)
+}
+
+// KillContainer mocks base method.
+func (m *MockDockerService) KillContainer(ctx context.Context, containerID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "KillContainer", ctx, containerID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// KillContainer indicates an expected call of KillContainer.
+func (mr *MockDockerServiceMockRecorder) KillContainer(ctx, containerID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock,
+		"KillContainer",
+		reflect.TypeOf((*MockDockerService)(nil).KillContainer),
+		ctx,
+		containerID)
+}
+
+// RemoveContainer mocks base method.
+func (m *MockDockerService) RemoveContainer(ctx context.Context, containerID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveContainer", ctx, containerID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveContainer indicates an expected call of RemoveContainer.
+func (mr *MockDockerServiceMockRecorder) RemoveContainer(ctx, containerID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock,
+		"RemoveContainer",
+		reflect.TypeOf((*MockDockerService)(nil).RemoveContainer),
+		ctx,
+		containerID)
+}
+
+// ListContainers mocks base method.
+func (m *MockDockerService) ListContainers(ctx context.Context, label string) ([]ContainerSummary, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListContainers", ctx, label)
+	ret0, _ := ret[0].([]ContainerSummary)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListContainers indicates an expected call of ListContainers.
+func (mr *MockDockerServiceMockRecorder) ListContainers(ctx, label any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock,
+		"ListContainers",
+		reflect.TypeOf((*MockDockerService)(nil).ListContainers),
+		ctx,
+		label)
+}
+
+// ListImages mocks base method.
+func (m *MockDockerService) ListImages(ctx context.Context) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListImages", ctx)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListImages indicates an expected call of ListImages.
+func (mr *MockDockerServiceMockRecorder) ListImages(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListImages", reflect.TypeOf((*MockDockerService)(nil).ListImages), ctx)
+}
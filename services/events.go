@@ -0,0 +1,149 @@
+package services
+
+import (
+	"container-manager/types"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrEventStreamClosed is the error of an event stream closed by the runtime
+var ErrEventStreamClosed = fmt.Errorf("event stream closed")
+
+const (
+	// ContainerEventDie is the event of a container that exited
+	ContainerEventDie = "die"
+	// ContainerEventOOM is the event of a container that ran out of memory
+	ContainerEventOOM = "oom"
+	// ContainerEventKill is the event of a container sent a signal
+	ContainerEventKill = "kill"
+	// ContainerEventHealthStatus is the event of a container whose health check changed its health
+	ContainerEventHealthStatus = "health_status"
+)
+
+const (
+	// eventsBackoff is the delay before the first renewal of a dropped event stream, doubled on every drop
+	eventsBackoff = time.Second
+	// maxEventsBackoff is the longest delay before a dropped event stream is renewed
+	maxEventsBackoff = time.Minute
+)
+
+// ContainerEvent is a change of the state of a container reported by its runtime.
+// ContainerID: The ID of the container
+// Action: What happened, die, oom, kill or health_status
+// ExitCode: The exit code of a container that died
+// Signal: The signal a container was killed with
+// Health: The health of the container after a health_status event
+type ContainerEvent struct {
+	ContainerID string
+	Action      string
+	ExitCode    int
+	Signal      string
+	Health      string
+}
+
+// EventSource is implemented by the runtimes that stream the events of their containers.
+// ContainerEvents streams the events of the containers with the label until the context is done,
+// errors end the stream.
+type EventSource interface {
+	ContainerEvents(ctx context.Context, label string) (<-chan ContainerEvent, <-chan error)
+}
+
+// watchEvents updates the jobs of the queue as soon as their containers die or change health. The stream is
+// renewed when it drops, and the running jobs are resynced with their containers, catching the missed events.
+func (q *QueueHandler) watchEvents(source EventSource) {
+	defer q.wg.Done()
+
+	backoff := eventsBackoff
+	for {
+		subscribed := time.Now()
+		ctx, cancel := context.WithCancel(q.ctx)
+		events, errs := source.ContainerEvents(ctx, types.ManagedJobLabel)
+		q.checkRunningJobs()
+		err := q.handleEvents(events, errs)
+		cancel()
+		if q.ctx.Err() != nil {
+			return
+		}
+
+		// streams that were up for a while drop for a new reason
+		if time.Since(subscribed) > maxEventsBackoff {
+			backoff = eventsBackoff
+		}
+		logrus.Warnf("container event stream dropped, resubscribing in %s: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-q.quit:
+			return
+		}
+		backoff = min(backoff*2, maxEventsBackoff)
+	}
+}
+
+// handleEvents handles the events of a stream until it drops or the queue stops, it returns the error
+// that ended the stream
+func (q *QueueHandler) handleEvents(events <-chan ContainerEvent, errs <-chan error) error {
+	// the reasons containers are about to die for, reported by the events that precede their die event
+	reasons := make(map[string]string)
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return ErrEventStreamClosed
+			}
+			q.handleEvent(event, reasons)
+		case err := <-errs:
+			return err
+		case <-q.quit:
+			return nil
+		}
+	}
+}
+
+// handleEvent updates the running job of the container of an event
+func (q *QueueHandler) handleEvent(event ContainerEvent, reasons map[string]string) {
+	logrus.WithFields(logrus.Fields{
+		"container_id": event.ContainerID,
+		"action":       event.Action,
+	}).Debug("container event")
+
+	switch event.Action {
+	case ContainerEventOOM:
+		reasons[event.ContainerID] = "container ran out of memory"
+	case ContainerEventKill:
+		if _, ok := reasons[event.ContainerID]; !ok {
+			reasons[event.ContainerID] = fmt.Sprintf("container was killed with signal %s", event.Signal)
+		}
+	case ContainerEventHealthStatus:
+		job, ok := q.runningJob(event.ContainerID)
+		if ok && job.Container.HealthCheck != nil && len(job.Container.HealthCheck.Command) > 0 {
+			q.applyHealth(job, types.HealthStatus(event.Health))
+		}
+	case ContainerEventDie:
+		reason, ok := reasons[event.ContainerID]
+		if !ok {
+			reason = "container died"
+		}
+		delete(reasons, event.ContainerID)
+
+		if job, ok := q.runningJob(event.ContainerID); ok {
+			q.restart(job.ID, event.ContainerID, event.ExitCode, fmt.Sprintf("%s with exit code %d", reason, event.ExitCode))
+		}
+	}
+}
+
+// runningJob returns the running job of the node that runs the container
+func (q *QueueHandler) runningJob(containerID string) (types.Job, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for _, record := range q.jobRecords {
+		if record.Node == q.nodeID && record.Status == types.JobStatusRunning && record.ContainerID == containerID {
+			return *record, true
+		}
+	}
+	return types.Job{}, false
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"container-manager/types"
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+// droppingEventSource is a fake runtime whose first event stream misses every event and drops on demand
+type droppingEventSource struct {
+	*FakeRuntime
+	subscriptions atomic.Int32
+	drop          chan error
+}
+
+func (s *droppingEventSource) ContainerEvents(ctx context.Context, label string) (<-chan ContainerEvent, <-chan error) {
+	if s.subscriptions.Add(1) == 1 {
+		return make(chan ContainerEvent), s.drop
+	}
+	return s.FakeRuntime.ContainerEvents(ctx, label)
+}
+
+func TestJobQueueHandlesEvents(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDockerService := NewMockDockerService(ctrl)
+	jobQueue := NewQueue(10, mockDockerService, WithNodeID("local"))
+
+	replica := types.Service{Name: "web", Container: types.Container{Image: "nginx"}}.ReplicaContainer()
+	mockDockerService.EXPECT().DeployContainer(gomock.Any(), deployOf(replica)).Return("container-id", nil)
+	mockDockerService.EXPECT().GetContainerStatus(gomock.Any(), "container-id").Return("running", nil)
+	require.NoError(t, jobQueue.Enqueue("replica", replica))
+	jobQueue.executeJob(<-jobQueue.jobs)
+
+	// events of other containers are ignored, the reason a container dies for is kept until it dies
+	reasons := make(map[string]string)
+	jobQueue.handleEvent(ContainerEvent{ContainerID: "other-id", Action: ContainerEventDie, ExitCode: 1}, reasons)
+	jobQueue.handleEvent(ContainerEvent{ContainerID: "container-id", Action: ContainerEventOOM}, reasons)
+	jobQueue.handleEvent(ContainerEvent{ContainerID: "container-id", Action: ContainerEventKill, Signal: "SIGKILL"}, reasons)
+	require.Equal(t, "container ran out of memory", reasons["container-id"])
+	status, _ := jobQueue.GetStatus("replica")
+	require.Equal(t, types.JobStatusRunning, status)
+
+	// the job fails as soon as its container dies, a die reported twice is handled once
+	mockDockerService.EXPECT().StopContainer(gomock.Any(), "container-id").Return(nil)
+	mockDockerService.EXPECT().RemoveContainer(gomock.Any(), "container-id").Return(nil)
+	jobQueue.handleEvent(ContainerEvent{ContainerID: "container-id", Action: ContainerEventDie, ExitCode: 137}, reasons)
+	jobQueue.handleEvent(ContainerEvent{ContainerID: "container-id", Action: ContainerEventDie, ExitCode: 137}, reasons)
+	require.Empty(t, reasons)
+	record, _ := jobQueue.GetJob("replica")
+	require.Equal(t, types.JobStatusFailed, record.Status)
+	require.Equal(t, 137, record.ExitCode)
+
+	// the stream ends with the error that dropped it
+	events := make(chan ContainerEvent)
+	errs := make(chan error, 1)
+	errs <- fmt.Errorf("connection reset")
+	require.EqualError(t, jobQueue.handleEvents(events, errs), "connection reset")
+	close(events)
+	require.ErrorIs(t, jobQueue.handleEvents(events, make(chan error)), ErrEventStreamClosed)
+}
+
+func TestJobQueueWatchesEvents(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	runtime, err := NewFakeRuntime(FakeRuntimeOptions{}, nil)
+	require.NoError(t, err)
+	jobQueue := NewQueue(10, runtime, WithNodeID("local"))
+	jobQueue.Run(1)
+	defer jobQueue.Stop()
+
+	container := types.Container{
+		Image:         "busybox",
+		RestartPolicy: &types.RestartPolicy{Name: types.RestartPolicyNever},
+	}
+	require.NoError(t, jobQueue.Enqueue("job", container))
+	var record types.Job
+	require.Eventually(t, func() bool {
+		record, _ = jobQueue.GetJob("job")
+		return record.Status == types.JobStatusRunning
+	}, time.Second, 10*time.Millisecond)
+
+	// the job fails long before the monitor would look at its killed container
+	require.NoError(t, runtime.KillContainer(ctx, record.ContainerID))
+	require.Eventually(t, func() bool {
+		record, _ = jobQueue.GetJob("job")
+		return record.Status == types.JobStatusFailed && record.ExitCode == fakeKillExitCode
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestJobQueueResyncsDroppedEvents(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	runtime, err := NewFakeRuntime(FakeRuntimeOptions{}, nil)
+	require.NoError(t, err)
+	source := &droppingEventSource{FakeRuntime: runtime, drop: make(chan error, 1)}
+	jobQueue := NewQueue(10, source, WithNodeID("local"))
+	jobQueue.Run(1)
+	defer jobQueue.Stop()
+
+	container := types.Container{
+		Image:         "busybox",
+		RestartPolicy: &types.RestartPolicy{Name: types.RestartPolicyNever},
+	}
+	require.NoError(t, jobQueue.Enqueue("job", container))
+	var record types.Job
+	require.Eventually(t, func() bool {
+		record, _ = jobQueue.GetJob("job")
+		return record.Status == types.JobStatusRunning
+	}, time.Second, 10*time.Millisecond)
+
+	// the die event is missed, the resync after the stream drops catches up with the container
+	require.NoError(t, runtime.KillContainer(ctx, record.ContainerID))
+	source.drop <- fmt.Errorf("connection reset")
+	require.Eventually(t, func() bool {
+		record, _ = jobQueue.GetJob("job")
+		return record.Status == types.JobStatusFailed
+	}, eventsBackoff+time.Second, 10*time.Millisecond)
+	require.GreaterOrEqual(t, source.subscriptions.Load(), int32(2))
+}
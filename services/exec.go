@@ -0,0 +1,615 @@
+package services
+
+import (
+	"bufio"
+	"container-manager/types"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrExecNotSupported is the error returned when the container runtime can't run commands in containers
+var ErrExecNotSupported = fmt.Errorf("container runtime doesn't support exec")
+
+// The channels of the frames of an exec session, the first byte of every frame
+const (
+	// ExecChannelStdin carries the input of the command, an empty frame closes it
+	ExecChannelStdin byte = iota
+	// ExecChannelStdout carries the output of the command, and everything it writes with a TTY
+	ExecChannelStdout
+	// ExecChannelStderr carries the error output of the command
+	ExecChannelStderr
+	// ExecChannelStatus carries the ExecStatus of the command once it exited, the last frame of a session
+	ExecChannelStatus
+	// ExecChannelResize carries the new TerminalSize of the TTY of the command
+	ExecChannelResize
+)
+
+const (
+	// maxExecFrameSize is the maximum size of the data of an exec frame
+	maxExecFrameSize = 1 << 20
+	// maxExecOutput is the most output of each stream of a command kept by CollectExec
+	maxExecOutput = 1 << 20
+	// maxExecRequestSize is the maximum size of the request and response lines of an exec stream
+	maxExecRequestSize = 64 << 10
+)
+
+// ExecOptions are the options of a command run in a container.
+// command: The command and its arguments
+// env: The environment variables of the command, as KEY=value
+// tty: Whether the command runs in a TTY, its error output is then part of its output
+// stdin: Whether the command reads an input
+type ExecOptions struct {
+	Command []string `json:"command"`
+	Env     []string `json:"env,omitempty"`
+	TTY     bool     `json:"tty,omitempty"`
+	Stdin   bool     `json:"stdin,omitempty"`
+}
+
+// ExecResult is the result of a command run to completion.
+// Stdout: The output of the command
+// Stderr: The error output of the command
+// ExitCode: The exit code of the command
+type ExecResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// ExecStatus is how a command ended.
+// exit_code: The exit code of the command
+// error: Why the exit code of the command is unknown
+type ExecStatus struct {
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+}
+
+// TerminalSize is the size of the TTY of a command.
+// height: The number of rows
+// width: The number of columns
+type TerminalSize struct {
+	Height uint `json:"height"`
+	Width  uint `json:"width"`
+}
+
+// ExecSession is a command running in a container. Its output and error output must be read concurrently
+// until they end, and the session closed once done.
+// Stdin: The input of the command, nil unless it reads one
+// Stdout: The output of the command
+// Stderr: The error output of the command, empty with a TTY
+// Resize: Resizes the TTY of the command
+// Wait: Waits for the command to exit and returns its exit code
+// Close: Ends the session, the command is left to the runtime
+type ExecSession interface {
+	Stdin() io.WriteCloser
+	Stdout() io.Reader
+	Stderr() io.Reader
+	Resize(ctx context.Context, size TerminalSize) error
+	Wait(ctx context.Context) (int, error)
+	Close() error
+}
+
+// Executor is implemented by the runtimes that run commands in containers
+type Executor interface {
+	Exec(ctx context.Context, containerID string, opts ExecOptions) (ExecSession, error)
+}
+
+// RemoteExecutor runs commands in the containers of the jobs of other nodes
+type RemoteExecutor interface {
+	ExecRemote(ctx context.Context, nodeID string, jobID string, opts ExecOptions) (ExecSession, error)
+}
+
+// execSession is an exec session made of pipes and the functions of the runtime that runs the command
+type execSession struct {
+	stdin  io.WriteCloser
+	stdout io.Reader
+	stderr io.Reader
+	resize func(ctx context.Context, size TerminalSize) error
+	wait   func(ctx context.Context) (int, error)
+	close  func() error
+}
+
+// Stdin returns the input of the command, nil unless it reads one
+func (es *execSession) Stdin() io.WriteCloser {
+	return es.stdin
+}
+
+// Stdout returns the output of the command
+func (es *execSession) Stdout() io.Reader {
+	return es.stdout
+}
+
+// Stderr returns the error output of the command
+func (es *execSession) Stderr() io.Reader {
+	return es.stderr
+}
+
+// Resize resizes the TTY of the command
+func (es *execSession) Resize(ctx context.Context, size TerminalSize) error {
+	return es.resize(ctx, size)
+}
+
+// Wait waits for the command to exit
+func (es *execSession) Wait(ctx context.Context) (int, error) {
+	return es.wait(ctx)
+}
+
+// Close ends the session
+func (es *execSession) Close() error {
+	return es.close()
+}
+
+// ExecRouter runs commands in the containers of jobs, on the node that owns the job.
+// jobQueue: The queue of the jobs
+// runtime: The container runtime of the node
+// nodeID: The ID of the node
+// remote: Runs the commands of the jobs of other nodes
+// mutex: The mutex to protect the remote executor
+type ExecRouter struct {
+	jobQueue Queue
+	runtime  DockerService
+	nodeID   string
+	remote   RemoteExecutor
+	mutex    sync.Mutex
+}
+
+// NewExecRouter creates a new exec router
+func NewExecRouter(jobQueue Queue, runtime DockerService, nodeID string) *ExecRouter {
+	return &ExecRouter{
+		jobQueue: jobQueue,
+		runtime:  runtime,
+		nodeID:   nodeID,
+	}
+}
+
+// SetRemote sets the executor of the commands of the jobs of other nodes
+func (er *ExecRouter) SetRemote(remote RemoteExecutor) {
+	er.mutex.Lock()
+	defer er.mutex.Unlock()
+
+	er.remote = remote
+}
+
+// Exec runs a command in the container of a job, on the node that owns the job
+func (er *ExecRouter) Exec(ctx context.Context, jobID string, opts ExecOptions) (ExecSession, error) {
+	if len(opts.Command) == 0 {
+		return nil, fmt.Errorf("command is required")
+	}
+	job, ok := er.jobQueue.GetJob(jobID)
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	if job.Node == er.nodeID {
+		return er.ExecLocal(ctx, jobID, opts)
+	}
+
+	er.mutex.Lock()
+	remote := er.remote
+	er.mutex.Unlock()
+	if remote == nil {
+		return nil, fmt.Errorf("%w: the job runs on node %s", ErrJobNotRunning, job.Node)
+	}
+	logrus.WithFields(logrus.Fields{"job_id": jobID, "node": job.Node}).Debug("routing exec to the node of the job")
+	return remote.ExecRemote(ctx, job.Node, jobID, opts)
+}
+
+// ExecLocal runs a command in the container of a job of the node
+func (er *ExecRouter) ExecLocal(ctx context.Context, jobID string, opts ExecOptions) (ExecSession, error) {
+	if len(opts.Command) == 0 {
+		return nil, fmt.Errorf("command is required")
+	}
+	job, ok := er.jobQueue.GetJob(jobID)
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	// jobs that completed once their container started keep it running
+	if job.Node != er.nodeID || job.ContainerID == "" || job.Status == types.JobStatusCancelled {
+		return nil, ErrJobNotRunning
+	}
+	executor, ok := er.runtime.(Executor)
+	if !ok {
+		return nil, ErrExecNotSupported
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"job_id":       jobID,
+		"container_id": job.ContainerID,
+		"command":      opts.Command,
+	}).Info("running command in container")
+	return executor.Exec(ctx, job.ContainerID, opts)
+}
+
+// CollectExec writes the input to a command that reads one, and waits for the command to exit. The output
+// of each stream beyond maxExecOutput is dropped. The session is closed.
+func CollectExec(ctx context.Context, session ExecSession, stdin string) (ExecResult, error) {
+	defer session.Close()
+	stop := context.AfterFunc(ctx, func() {
+		session.Close()
+	})
+	defer stop()
+
+	if input := session.Stdin(); input != nil {
+		go func() {
+			io.WriteString(input, stdin)
+			input.Close()
+		}()
+	}
+
+	var wg sync.WaitGroup
+	var result ExecResult
+	collect := func(output *string, stream io.Reader) {
+		defer wg.Done()
+		content, _ := io.ReadAll(io.LimitReader(stream, maxExecOutput))
+		io.Copy(io.Discard, stream)
+		*output = string(content)
+	}
+	wg.Add(2)
+	go collect(&result.Stdout, session.Stdout())
+	go collect(&result.Stderr, session.Stderr())
+	wg.Wait()
+
+	exitCode, err := session.Wait(ctx)
+	if err != nil {
+		return ExecResult{}, fmt.Errorf("failed to wait for command: %w", err)
+	}
+	result.ExitCode = exitCode
+	return result, nil
+}
+
+// CopyExecOutput writes the output and error output of a session as frames until both end, then waits for
+// the command and writes its status frame
+func CopyExecOutput(ctx context.Context, session ExecSession, write func(channel byte, data []byte) error) error {
+	var wg sync.WaitGroup
+	copyStream := func(channel byte, stream io.Reader) {
+		defer wg.Done()
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := stream.Read(buf)
+			if n > 0 {
+				if write(channel, buf[:n]) != nil {
+					session.Close()
+					io.Copy(io.Discard, stream)
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	wg.Add(2)
+	go copyStream(ExecChannelStdout, session.Stdout())
+	go copyStream(ExecChannelStderr, session.Stderr())
+	wg.Wait()
+
+	var status ExecStatus
+	exitCode, err := session.Wait(ctx)
+	status.ExitCode = exitCode
+	if err != nil {
+		status.Error = err.Error()
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return write(ExecChannelStatus, data)
+}
+
+// HandleExecInput applies a frame of a client to a session, an input frame is written to the input of the
+// command, an empty one closes it, and a resize frame resizes the TTY
+func HandleExecInput(ctx context.Context, session ExecSession, channel byte, data []byte) error {
+	switch channel {
+	case ExecChannelStdin:
+		input := session.Stdin()
+		if input == nil {
+			return nil
+		}
+		if len(data) == 0 {
+			return input.Close()
+		}
+		_, err := input.Write(data)
+		return err
+	case ExecChannelResize:
+		var size TerminalSize
+		if err := json.Unmarshal(data, &size); err != nil {
+			return fmt.Errorf("failed to decode terminal size: %w", err)
+		}
+		return session.Resize(ctx, size)
+	default:
+		return fmt.Errorf("unexpected exec channel %d", channel)
+	}
+}
+
+// writeExecFrame writes a frame, its channel, the length of its data and its data
+func writeExecFrame(w io.Writer, channel byte, data []byte) error {
+	header := make([]byte, 5)
+	header[0] = channel
+	binary.BigEndian.PutUint32(header[1:], uint32(len(data)))
+	if _, err := w.Write(append(header, data...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readExecFrame reads a frame written by writeExecFrame
+func readExecFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxExecFrameSize {
+		return 0, nil, fmt.Errorf("exec frame of %d bytes is too large", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+	return header[0], data, nil
+}
+
+// execRequest is the request of an exec stream, a JSON line followed by the input frames of the client.
+// job_id: The ID of the job whose container runs the command
+// options: The command and how it runs
+type execRequest struct {
+	JobID   string      `json:"job_id"`
+	Options ExecOptions `json:"options"`
+}
+
+// execResponse is the answer to an exec request, a JSON line followed by the output frames of the command.
+// error: Why the command couldn't be started, empty once it started
+type execResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// WithExecRouter runs the commands peers route to the containers of the jobs of this node
+func WithExecRouter(router *ExecRouter) P2POption {
+	return func(o *p2pOptions) {
+		o.exec = router
+	}
+}
+
+// handleExecStream runs the command of an exec request in the container of a job of this node
+func (s *Service) handleExecStream(stream network.Stream) {
+	defer stream.Close()
+	remote := stream.Conn().RemotePeer()
+
+	reader := bufio.NewReaderSize(stream, maxExecRequestSize)
+	var request execRequest
+	if err := readJSONLine(reader, &request); err != nil {
+		logrus.WithField("peer", remote).Errorf("failed to read exec request: %v", err)
+		return
+	}
+
+	// only the peer that handed the job to this node and trusted peers run commands in its container
+	job, ok := s.jobQueue.GetJob(request.JobID)
+	if !ok || !s.mayAccess(job, remote) {
+		logrus.WithField("job_id", request.JobID).Warnf("rejected exec request of peer %s", remote)
+		json.NewEncoder(stream).Encode(execResponse{Error: ErrJobNotFound.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	session, err := s.exec.ExecLocal(ctx, request.JobID, request.Options)
+	if err != nil {
+		logrus.WithField("job_id", request.JobID).Warnf("failed to exec for peer %s: %v", remote, err)
+		json.NewEncoder(stream).Encode(execResponse{Error: err.Error()})
+		return
+	}
+	defer session.Close()
+	if err := json.NewEncoder(stream).Encode(execResponse{}); err != nil {
+		logrus.WithField("peer", remote).Errorf("failed to write exec response: %v", err)
+		return
+	}
+
+	// the session ends when the peer goes away
+	go func() {
+		for {
+			channel, data, err := readExecFrame(reader)
+			if err != nil {
+				cancel()
+				session.Close()
+				return
+			}
+			if err := HandleExecInput(ctx, session, channel, data); err != nil {
+				logrus.WithField("job_id", request.JobID).Debugf("failed to handle exec input: %v", err)
+			}
+		}
+	}()
+
+	var mutex sync.Mutex
+	err = CopyExecOutput(ctx, session, func(channel byte, data []byte) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return writeExecFrame(stream, channel, data)
+	})
+	if err != nil {
+		logrus.WithField("job_id", request.JobID).Debugf("failed to write exec output: %v", err)
+	}
+}
+
+// ExecRemote runs a command in the container of a job of another node, over an exec stream to the node
+func (s *Service) ExecRemote(ctx context.Context, nodeID string, jobID string, opts ExecOptions) (ExecSession, error) {
+	id, err := peer.Decode(nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode peer ID: %w", err)
+	}
+
+	stream, err := s.host.NewStream(ctx, id, ExecProtocolID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open exec stream: %w", err)
+	}
+	if err := json.NewEncoder(stream).Encode(execRequest{JobID: jobID, Options: opts}); err != nil {
+		stream.Reset()
+		return nil, fmt.Errorf("failed to write exec request: %w", err)
+	}
+	reader := bufio.NewReaderSize(stream, maxExecRequestSize)
+	var response execResponse
+	if err := readJSONLine(reader, &response); err != nil {
+		stream.Reset()
+		return nil, fmt.Errorf("failed to read exec response: %w", err)
+	}
+	if response.Error != "" {
+		stream.Close()
+		return nil, fmt.Errorf("failed to exec on node %s: %s", nodeID, response.Error)
+	}
+	return newRemoteExecSession(ctx, stream, reader, opts), nil
+}
+
+// remoteExecSession is the session of a command run by another node, over an exec stream
+// stream: The exec stream
+// mutex: The mutex to protect the writes to the stream
+// stdin: Writes the input of the command as frames, nil unless it reads one
+// stdout: The output of the command read from the stream
+// stderr: The error output of the command read from the stream
+// done: Closed once the status of the command was read, or the stream broke
+// status: The status of the command
+// err: The error that broke the stream
+// stop: Stops resetting the stream once the context of the session is done
+type remoteExecSession struct {
+	stream network.Stream
+	mutex  sync.Mutex
+	stdin  io.WriteCloser
+	stdout *io.PipeReader
+	stderr *io.PipeReader
+	done   chan struct{}
+	status ExecStatus
+	err    error
+	stop   func() bool
+}
+
+// newRemoteExecSession creates the session of a command run over an exec stream, and reads its output
+func newRemoteExecSession(ctx context.Context, stream network.Stream, reader io.Reader, opts ExecOptions) *remoteExecSession {
+	stdout, stdoutWriter := io.Pipe()
+	stderr, stderrWriter := io.Pipe()
+	session := &remoteExecSession{
+		stream: stream,
+		stdout: stdout,
+		stderr: stderr,
+		done:   make(chan struct{}),
+	}
+	if opts.Stdin {
+		session.stdin = &remoteExecInput{session: session}
+	}
+	session.stop = context.AfterFunc(ctx, func() {
+		stream.Reset()
+	})
+
+	go func() {
+		defer close(session.done)
+		for {
+			channel, data, err := readExecFrame(reader)
+			if err != nil {
+				session.err = fmt.Errorf("exec stream broke: %w", err)
+				stdoutWriter.CloseWithError(session.err)
+				stderrWriter.CloseWithError(session.err)
+				return
+			}
+			switch channel {
+			case ExecChannelStdout:
+				stdoutWriter.Write(data)
+			case ExecChannelStderr:
+				stderrWriter.Write(data)
+			case ExecChannelStatus:
+				stdoutWriter.Close()
+				stderrWriter.Close()
+				if err := json.Unmarshal(data, &session.status); err != nil {
+					session.err = fmt.Errorf("failed to decode exec status: %w", err)
+				}
+				return
+			}
+		}
+	}()
+	return session
+}
+
+// Stdin returns the input of the command, nil unless it reads one
+func (rs *remoteExecSession) Stdin() io.WriteCloser {
+	return rs.stdin
+}
+
+// Stdout returns the output of the command
+func (rs *remoteExecSession) Stdout() io.Reader {
+	return rs.stdout
+}
+
+// Stderr returns the error output of the command
+func (rs *remoteExecSession) Stderr() io.Reader {
+	return rs.stderr
+}
+
+// Resize sends the new size of the TTY to the node that runs the command
+func (rs *remoteExecSession) Resize(_ context.Context, size TerminalSize) error {
+	data, err := json.Marshal(size)
+	if err != nil {
+		return err
+	}
+	return rs.write(ExecChannelResize, data)
+}
+
+// Wait waits for the status of the command from the node that runs it
+func (rs *remoteExecSession) Wait(ctx context.Context) (int, error) {
+	select {
+	case <-rs.done:
+	case <-ctx.Done():
+		return exitCodeUnknown, ctx.Err()
+	}
+	if rs.err != nil {
+		return exitCodeUnknown, rs.err
+	}
+	if rs.status.Error != "" {
+		return exitCodeUnknown, fmt.Errorf("%s", rs.status.Error)
+	}
+	return rs.status.ExitCode, nil
+}
+
+// Close closes the exec stream
+func (rs *remoteExecSession) Close() error {
+	rs.stop()
+	return rs.stream.Close()
+}
+
+// write writes a frame to the exec stream
+func (rs *remoteExecSession) write(channel byte, data []byte) error {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	return writeExecFrame(rs.stream, channel, data)
+}
+
+// remoteExecInput writes the input of a command run by another node as input frames
+type remoteExecInput struct {
+	session *remoteExecSession
+}
+
+// Write sends the input as input frames
+func (ri *remoteExecInput) Write(data []byte) (int, error) {
+	for sent := 0; sent < len(data); sent += maxExecFrameSize {
+		if err := ri.session.write(ExecChannelStdin, data[sent:min(sent+maxExecFrameSize, len(data))]); err != nil {
+			return sent, err
+		}
+	}
+	return len(data), nil
+}
+
+// Close sends the empty input frame that closes the input of the command
+func (ri *remoteExecInput) Close() error {
+	return ri.session.write(ExecChannelStdin, nil)
+}
+
+// readJSONLine decodes a JSON line, longer lines than the buffer of the reader are rejected
+func readJSONLine(reader *bufio.Reader, v any) error {
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(line, v)
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"container-manager/types"
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+// runningJob deploys a job on the queue and returns its record once it runs
+func runningJob(t *testing.T, jobQueue *QueueHandler, jobID string) types.Job {
+	t.Helper()
+
+	require.NoError(t, jobQueue.Enqueue(jobID, types.Container{
+		Image:         "busybox",
+		RestartPolicy: &types.RestartPolicy{Name: types.RestartPolicyNever},
+	}))
+	jobQueue.executeJob(<-jobQueue.jobs)
+	record, _ := jobQueue.GetJob(jobID)
+	require.Equal(t, types.JobStatusRunning, record.Status)
+	return record
+}
+
+func TestExecRouter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	runtime, err := NewFakeRuntime(FakeRuntimeOptions{}, nil)
+	require.NoError(t, err)
+	jobQueue := NewQueue(10, runtime, WithNodeID("local"))
+	router := NewExecRouter(jobQueue, runtime, "local")
+	runningJob(t, jobQueue, "job")
+
+	// the command gets its input and its output is collected once it exited
+	session, err := router.Exec(ctx, "job", ExecOptions{Command: []string{"cat"}, Stdin: true})
+	require.NoError(t, err)
+	result, err := CollectExec(ctx, session, "hello\n")
+	require.NoError(t, err)
+	require.Equal(t, ExecResult{Stdout: "hello\n"}, result)
+
+	session, err = router.Exec(ctx, "job", ExecOptions{Command: []string{"ls", "/"}})
+	require.NoError(t, err)
+	result, err = CollectExec(ctx, session, "")
+	require.NoError(t, err)
+	require.Equal(t, ExecResult{Stderr: "ls: command not found\n", ExitCode: 127}, result)
+
+	_, err = router.Exec(ctx, "job", ExecOptions{})
+	require.Error(t, err)
+	_, err = router.Exec(ctx, "missing", ExecOptions{Command: []string{"true"}})
+	require.ErrorIs(t, err, ErrJobNotFound)
+
+	// jobs of other nodes can't be reached without a remote executor
+	jobQueue.Track(types.Job{ID: "remote", Node: "remote", Status: types.JobStatusRunning, ContainerID: "container-id"})
+	_, err = router.Exec(ctx, "remote", ExecOptions{Command: []string{"true"}})
+	require.ErrorIs(t, err, ErrJobNotRunning)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	router = NewExecRouter(jobQueue, NewMockDockerService(ctrl), "local")
+	_, err = router.Exec(ctx, "job", ExecOptions{Command: []string{"true"}})
+	require.ErrorIs(t, err, ErrExecNotSupported)
+}
+
+func TestP2PServiceExecRemote(t *testing.T) {
+	t.Parallel()
+
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	nodeID, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+	otherKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	otherID, err := peer.IDFromPrivateKey(otherKey)
+	require.NoError(t, err)
+
+	runtime, err := NewFakeRuntime(FakeRuntimeOptions{}, nil)
+	require.NoError(t, err)
+	jobQueue1 := NewQueue(10, runtime, WithNodeID(nodeID.String()))
+	require.NoError(t, jobQueue1.EnqueueFrom("job", types.Container{
+		Image:         "busybox",
+		RestartPolicy: &types.RestartPolicy{Name: types.RestartPolicyNever},
+	}, otherID.String()))
+	jobQueue1.executeJob(<-jobQueue1.jobs)
+	record, _ := jobQueue1.GetJob("job")
+	local := runningJob(t, jobQueue1, "local")
+	jobQueue2 := NewQueue(10, nil, WithNodeID(otherID.String()))
+	jobQueue2.Track(record)
+	jobQueue2.Track(local)
+
+	router1 := NewExecRouter(jobQueue1, runtime, nodeID.String())
+	service1, err := NewP2PService(jobQueue1, 4058, WithIdentity(key), WithExecRouter(router1))
+	require.NoError(t, err)
+	service2, err := NewP2PService(jobQueue2, 4059, WithIdentity(otherKey))
+	require.NoError(t, err)
+	router2 := NewExecRouter(jobQueue2, nil, otherID.String())
+	router2.SetRemote(service2)
+
+	go service1.Start(t.Name())
+	go service2.Start(t.Name())
+	defer service1.Stop()
+	defer service2.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, service2.host.Connect(ctx, peer.AddrInfo{
+		ID:    service1.host.ID(),
+		Addrs: service1.host.Addrs(),
+	}))
+
+	// the command runs on the node of the job, with its input and output sent over the stream
+	var session ExecSession
+	require.Eventually(t, func() bool {
+		session, err = router2.Exec(ctx, "job", ExecOptions{Command: []string{"cat"}, Stdin: true})
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	result, err := CollectExec(ctx, session, "hello\n")
+	require.NoError(t, err)
+	require.Equal(t, ExecResult{Stdout: "hello\n"}, result)
+
+	session, err = router2.Exec(ctx, "job", ExecOptions{Command: []string{"false"}})
+	require.NoError(t, err)
+	result, err = CollectExec(ctx, session, "")
+	require.NoError(t, err)
+	require.Equal(t, 1, result.ExitCode)
+
+	// only the peer that handed the job to the node runs commands in its container
+	_, err = router2.Exec(ctx, "local", ExecOptions{Command: []string{"true"}})
+	require.ErrorContains(t, err, ErrJobNotFound.Error())
+
+	// the node of the job reports why the command can't run
+	require.NoError(t, jobQueue1.Cancel("job"))
+	_, err = router2.Exec(ctx, "job", ExecOptions{Command: []string{"true"}})
+	require.ErrorContains(t, err, ErrJobNotRunning.Error())
+}
@@ -0,0 +1,675 @@
+package services
+
+import (
+	"archive/tar"
+	"bytes"
+	"container-manager/types"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// fakeStopExitCode is the exit code of a stopped fake container, as after SIGTERM
+	fakeStopExitCode = 143
+	// fakeKillExitCode is the exit code of a killed fake container, as after SIGKILL
+	fakeKillExitCode = 137
+	// fakeEventsBuffer is the number of events buffered for each subscriber, further events are dropped
+	fakeEventsBuffer = 64
+	// fakeCPUShare is the share of a core a running fake container uses
+	fakeCPUShare = 0.1
+	// fakeMemoryBytes is the memory a running fake container uses
+	fakeMemoryBytes = 32 << 20
+	// fakeStatsInterval is the interval between the samples of the stats of a fake container
+	fakeStatsInterval = time.Second
+)
+
+// FakeRuntimeOptions configure the simulated containers of the fake runtime.
+// PullLatency: How long pulling an image that isn't cached takes
+// FailureRate: The probability of a deploy failing, between 0 and 1
+// ExitCode: The exit code of containers once they ran for the run duration
+// RunDuration: How long containers run before they exit, they run until they are stopped when zero
+// LogOutput: The output logged by every container when it starts
+type FakeRuntimeOptions struct {
+	PullLatency time.Duration
+	FailureRate float64
+	ExitCode    int
+	RunDuration time.Duration
+	LogOutput   string
+}
+
+// fakeContainer is a simulated container.
+// status: The status of the container, running or exited
+// exitCode: The exit code of the container once it exited
+// startedAt: The time the container started
+// files: The contents of the files in the container by path, without a leading slash
+// logs: The output of the container
+// healthCheck: Whether the container has a command health check, which always passes
+// labels: The labels of the container
+// env: The environment variables of the container, as KEY=value, seen by the commands run in it
+// readOnly: Whether the root filesystem of the container is read-only, which rejects copies into it
+type fakeContainer struct {
+	status      string
+	exitCode    int
+	startedAt   time.Time
+	files       map[string][]byte
+	logs        string
+	healthCheck bool
+	labels      map[string]string
+	env         []string
+	readOnly    bool
+}
+
+// FakeRuntime is an in-memory implementation of the DockerService interface. Containers don't run any
+// command: they run for the configured duration and exit with the configured exit code, and their files
+// are the inputs copied into them.
+// opts: The behaviour of the simulated containers
+// artifacts: The store the input artifacts of containers are copied from
+// secrets: Resolves the secrets of containers, nil rejects containers with secrets
+// seccompProfiles: The directory of the seccomp profiles containers reference by name
+// images: The pulled images
+// containers: The containers by ID
+// subscribers: The label each subscriber to the container events filters on, by channel
+// mutex: The mutex to protect the images, containers and subscribers
+type FakeRuntime struct {
+	opts            FakeRuntimeOptions
+	artifacts       *ArtifactStore
+	secrets         *SecretInjector
+	seccompProfiles string
+	images          map[string]struct{}
+	containers      map[string]*fakeContainer
+	subscribers     map[chan ContainerEvent]string
+	mutex           sync.Mutex
+}
+
+// NewFakeRuntime creates a new FakeRuntime
+func NewFakeRuntime(opts FakeRuntimeOptions, artifacts *ArtifactStore) (*FakeRuntime, error) {
+	if opts.FailureRate < 0 || opts.FailureRate > 1 {
+		return nil, fmt.Errorf("failure rate must be between 0 and 1")
+	}
+	if opts.PullLatency < 0 || opts.RunDuration < 0 {
+		return nil, fmt.Errorf("pull latency and run duration must not be negative")
+	}
+	return &FakeRuntime{
+		opts:        opts,
+		artifacts:   artifacts,
+		images:      make(map[string]struct{}),
+		containers:  make(map[string]*fakeContainer),
+		subscribers: make(map[chan ContainerEvent]string),
+	}, nil
+}
+
+// newFakeRuntime creates the fake runtime
+func newFakeRuntime(opts RuntimeOptions) (DockerService, error) {
+	runtime, err := NewFakeRuntime(opts.Fake, opts.Artifacts)
+	if err != nil {
+		return nil, err
+	}
+	runtime.secrets = opts.Secrets
+	runtime.seccompProfiles = opts.SeccompProfiles
+	return runtime, nil
+}
+
+// DeployContainer simulates the deploy of a container. Images that weren't pulled yet take the pull
+// latency, within the pull timeout of the container.
+func (fr *FakeRuntime) DeployContainer(ctx context.Context, container types.Container) (string, error) {
+	logrus.WithField("container", container.Redacted()).Debug("Deploying container")
+
+	if container.Image == "" {
+		return "", fmt.Errorf("failed to pull image: image is required")
+	}
+	if err := fr.pullImage(ctx, container.Image, container.PullTimeout()); err != nil {
+		return "", err
+	}
+	if rand.Float64() < fr.opts.FailureRate {
+		return "", fmt.Errorf("failed to start container: simulated failure")
+	}
+
+	// named seccomp profiles must exist on the node, like with the real runtimes
+	if container.Security != nil && container.Security.SeccompProfile != "" &&
+		container.Security.SeccompProfile != types.ProfileUnconfined {
+		if _, err := loadSeccompProfile(fr.seccompProfiles, container.Security.SeccompProfile); err != nil {
+			return "", err
+		}
+	}
+
+	secretEnv, secretFiles, err := fr.secrets.Resolve(ctx, container.Secrets)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve secrets: %w", err)
+	}
+	env := make([]string, 0, len(container.Env)+len(secretEnv))
+	for key, value := range container.Env {
+		env = append(env, key+"="+value)
+	}
+	for key, value := range secretEnv {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+
+	files := make(map[string][]byte)
+	for _, input := range container.Inputs {
+		if err := fr.copyArtifact(files, input); err != nil {
+			return "", fmt.Errorf("failed to copy input: %w", err)
+		}
+	}
+	for target, value := range secretFiles {
+		files[strings.TrimPrefix(target, "/")] = value
+	}
+
+	id := strings.ReplaceAll(uuid.NewString(), "-", "")
+	fr.mutex.Lock()
+	fr.containers[id] = &fakeContainer{
+		status:      "running",
+		startedAt:   time.Now(),
+		files:       files,
+		logs:        fr.opts.LogOutput,
+		healthCheck: container.HealthCheck != nil && len(container.HealthCheck.Command) > 0,
+		labels:      maps.Clone(container.Labels),
+		env:         env,
+		readOnly:    container.ReadOnlyRootFS(),
+	}
+	if fr.containers[id].healthCheck {
+		fr.publish(id, ContainerEvent{Action: ContainerEventHealthStatus, Health: string(types.HealthStatusHealthy)})
+	}
+	fr.mutex.Unlock()
+
+	// the container exits on its own once it ran, even if nothing looks at it
+	if fr.opts.RunDuration > 0 {
+		time.AfterFunc(fr.opts.RunDuration, func() {
+			fr.mutex.Lock()
+			defer fr.mutex.Unlock()
+
+			_, _ = fr.container(id)
+		})
+	}
+
+	if fr.opts.LogOutput != "" {
+		for _, line := range strings.Split(strings.TrimRight(fr.opts.LogOutput, "\n"), "\n") {
+			logrus.WithField("container_id", id).Info(line)
+		}
+	}
+	return id, nil
+}
+
+// pullImage simulates the pull of an image within the timeout
+func (fr *FakeRuntime) pullImage(ctx context.Context, image string, timeout time.Duration) error {
+	fr.mutex.Lock()
+	_, pulled := fr.images[image]
+	fr.mutex.Unlock()
+	if pulled || fr.opts.PullLatency == 0 {
+		fr.addImage(image)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	select {
+	case <-time.After(fr.opts.PullLatency):
+	case <-ctx.Done():
+		return deployError(ctx, "failed to pull image", ctx.Err())
+	}
+	fr.addImage(image)
+	return nil
+}
+
+// addImage adds an image to the pulled images
+func (fr *FakeRuntime) addImage(image string) {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+
+	fr.images[image] = struct{}{}
+}
+
+// copyArtifact copies the files of the artifact of an input into the files of a container
+func (fr *FakeRuntime) copyArtifact(files map[string][]byte, input types.ArtifactInput) error {
+	if fr.artifacts == nil {
+		return fmt.Errorf("artifacts are not enabled")
+	}
+
+	artifact, err := fr.artifacts.Open(input.Digest)
+	if err != nil {
+		return fmt.Errorf("failed to open artifact: %w", err)
+	}
+	defer artifact.Close()
+
+	return extractArchive(files, artifact, input.Path)
+}
+
+// extractArchive extracts the regular files of a tar archive rooted at the base name of a path into the files
+// of a container at the path
+func extractArchive(files map[string][]byte, archive io.Reader, target string) error {
+	relocated := relocateArchive(archive, target)
+	defer relocated.Close()
+
+	tr := tar.NewReader(relocated)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		files[header.Name] = content
+	}
+}
+
+// container returns a container by ID, with its status updated to exited once it ran for the run duration.
+// The mutex must be held.
+func (fr *FakeRuntime) container(containerID string) (*fakeContainer, error) {
+	container, ok := fr.containers[containerID]
+	if !ok {
+		return nil, fmt.Errorf("no such container: %s", containerID)
+	}
+	if container.status == "running" && fr.opts.RunDuration > 0 && time.Since(container.startedAt) >= fr.opts.RunDuration {
+		container.status = "exited"
+		container.exitCode = fr.opts.ExitCode
+		fr.publish(containerID, ContainerEvent{Action: ContainerEventDie, ExitCode: container.exitCode})
+	}
+	return container, nil
+}
+
+// GetContainerStatus gets the status of a container by container ID
+func (fr *FakeRuntime) GetContainerStatus(_ context.Context, containerID string) (string, error) {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+
+	container, err := fr.container(containerID)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect container: %w", err)
+	}
+	return container.status, nil
+}
+
+// InspectContainer gets the state of a container by container ID
+func (fr *FakeRuntime) InspectContainer(_ context.Context, containerID string) (ContainerInfo, error) {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+
+	container, err := fr.container(containerID)
+	if err != nil {
+		return ContainerInfo{}, fmt.Errorf("failed to inspect container: %w", err)
+	}
+	info := ContainerInfo{
+		Status:    container.status,
+		IPAddress: "127.0.0.1",
+		ExitCode:  container.exitCode,
+	}
+	if container.healthCheck && container.status == "running" {
+		info.Health = string(types.HealthStatusHealthy)
+	}
+	return info, nil
+}
+
+// CopyFromContainer returns a tar archive of a path in a container, rooted at the base name of the path
+func (fr *FakeRuntime) CopyFromContainer(_ context.Context, containerID string, target string) (io.ReadCloser, error) {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+
+	container, err := fr.container(containerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy from container: %w", err)
+	}
+
+	target = strings.TrimPrefix(path.Clean("/"+target), "/")
+	base := path.Base(target)
+	var names []string
+	for name := range container.files {
+		if name == target || strings.HasPrefix(name, target+"/") {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("failed to copy from container: /%s: no such file or directory", target)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range names {
+		content := container.files[name]
+		header := &tar.Header{
+			Name: path.Join(base, strings.TrimPrefix(name, target)),
+			Mode: 0o644,
+			Size: int64(len(content)),
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, fmt.Errorf("failed to copy from container: %w", err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			return nil, fmt.Errorf("failed to copy from container: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to copy from container: %w", err)
+	}
+	return io.NopCloser(&buf), nil
+}
+
+// CopyToContainer extracts a tar archive rooted at the base name of a path into a container at the path
+func (fr *FakeRuntime) CopyToContainer(_ context.Context, containerID string, target string, archive io.Reader) error {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+
+	container, err := fr.container(containerID)
+	if err != nil {
+		return fmt.Errorf("failed to copy to container: %w", err)
+	}
+	if container.readOnly {
+		return fmt.Errorf("failed to copy to container: %w", ErrReadOnlyRootFS)
+	}
+	if err := extractArchive(container.files, archive, target); err != nil {
+		return fmt.Errorf("failed to copy to container: %w", err)
+	}
+	return nil
+}
+
+// StopContainer stops a container by container ID
+func (fr *FakeRuntime) StopContainer(_ context.Context, containerID string) error {
+	if err := fr.exit(containerID, fakeStopExitCode, "SIGTERM"); err != nil {
+		return fmt.Errorf("failed to stop container: %w", err)
+	}
+	return nil
+}
+
+// KillContainer kills a container by container ID
+func (fr *FakeRuntime) KillContainer(_ context.Context, containerID string) error {
+	if err := fr.exit(containerID, fakeKillExitCode, "SIGKILL"); err != nil {
+		return fmt.Errorf("failed to kill container: %w", err)
+	}
+	return nil
+}
+
+// exit exits a running container with the exit code, as if it was sent the signal
+func (fr *FakeRuntime) exit(containerID string, exitCode int, signal string) error {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+
+	container, err := fr.container(containerID)
+	if err != nil {
+		return err
+	}
+	if container.status == "running" {
+		container.status = "exited"
+		container.exitCode = exitCode
+		fr.publish(containerID, ContainerEvent{Action: ContainerEventKill, Signal: signal})
+		fr.publish(containerID, ContainerEvent{Action: ContainerEventDie, ExitCode: exitCode})
+	}
+	return nil
+}
+
+// RemoveContainer removes a stopped container by container ID
+func (fr *FakeRuntime) RemoveContainer(_ context.Context, containerID string) error {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+
+	container, err := fr.container(containerID)
+	if err != nil {
+		return fmt.Errorf("failed to remove container: %w", err)
+	}
+	if container.status == "running" {
+		return fmt.Errorf("failed to remove container: container %s is running", containerID)
+	}
+	delete(fr.containers, containerID)
+	return nil
+}
+
+// ListContainers lists the containers with the label
+func (fr *FakeRuntime) ListContainers(_ context.Context, label string) ([]ContainerSummary, error) {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+
+	var containers []ContainerSummary
+	for id := range fr.containers {
+		container, _ := fr.container(id)
+		if _, ok := container.labels[label]; !ok {
+			continue
+		}
+		containers = append(containers, ContainerSummary{
+			ID:     id,
+			Status: container.status,
+			Labels: maps.Clone(container.labels),
+		})
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].ID < containers[j].ID
+	})
+	return containers, nil
+}
+
+// ContainerEvents streams the events of the containers with the label until the context is done. Events
+// a slow subscriber has no room for are dropped.
+func (fr *FakeRuntime) ContainerEvents(ctx context.Context, label string) (<-chan ContainerEvent, <-chan error) {
+	events := make(chan ContainerEvent, fakeEventsBuffer)
+	fr.mutex.Lock()
+	fr.subscribers[events] = label
+	fr.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		fr.mutex.Lock()
+		defer fr.mutex.Unlock()
+
+		delete(fr.subscribers, events)
+		close(events)
+	}()
+	return events, make(chan error)
+}
+
+// publish sends an event of a container to the subscribers whose label it has. The mutex must be held.
+func (fr *FakeRuntime) publish(containerID string, event ContainerEvent) {
+	event.ContainerID = containerID
+	for events, label := range fr.subscribers {
+		if _, ok := fr.containers[containerID].labels[label]; !ok {
+			continue
+		}
+		select {
+		case events <- event:
+		default:
+			logrus.WithField("container_id", containerID).Warn("dropping container event of a slow subscriber")
+		}
+	}
+}
+
+// ContainerStats gets a sample of the simulated resource use of a container. Running containers use a share
+// of a core and a fixed amount of memory, and wrote their inputs; stopped containers use nothing, as in Docker.
+func (fr *FakeRuntime) ContainerStats(_ context.Context, containerID string) (types.ContainerStats, error) {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+
+	container, err := fr.container(containerID)
+	if err != nil {
+		return types.ContainerStats{}, fmt.Errorf("failed to get container stats: %w", err)
+	}
+	stats := types.ContainerStats{Time: time.Now()}
+	if container.status != "running" {
+		return stats, nil
+	}
+
+	stats.CPUPercent = fakeCPUShare * 100
+	stats.CPUSeconds = time.Since(container.startedAt).Seconds() * fakeCPUShare
+	stats.MemoryBytes = fakeMemoryBytes
+	for _, content := range container.files {
+		stats.BlockWriteBytes += uint64(len(content))
+	}
+	return stats, nil
+}
+
+// StreamContainerStats streams a sample of the simulated resource use of a container every second, until
+// the context is done or the container stopped
+func (fr *FakeRuntime) StreamContainerStats(
+	ctx context.Context,
+	containerID string,
+) (<-chan types.ContainerStats, <-chan error) {
+	samples := make(chan types.ContainerStats)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(samples)
+
+		ticker := time.NewTicker(fakeStatsInterval)
+		defer ticker.Stop()
+		for {
+			stats, err := fr.ContainerStats(ctx, containerID)
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case samples <- stats:
+			case <-ctx.Done():
+				return
+			}
+			if stats.MemoryBytes == 0 {
+				return
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return samples, errs
+}
+
+// Exec simulates a command in a running container. echo writes its arguments, cat copies its input, env
+// writes the environment of the command, and true and false exit with 0 and 1; other commands aren't found.
+func (fr *FakeRuntime) Exec(_ context.Context, containerID string, opts ExecOptions) (ExecSession, error) {
+	fr.mutex.Lock()
+	container, err := fr.container(containerID)
+	running := err == nil && container.status == "running"
+	var env []string
+	if err == nil {
+		env = container.env
+	}
+	fr.mutex.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exec: %w", err)
+	}
+	if !running {
+		return nil, fmt.Errorf("failed to create exec: container %s is not running", containerID)
+	}
+	if len(opts.Command) == 0 {
+		return nil, fmt.Errorf("failed to create exec: command is required")
+	}
+
+	stdin, stdinWriter := io.Pipe()
+	stdout, stdoutWriter := io.Pipe()
+	stderr, stderrWriter := io.Pipe()
+	done := make(chan struct{})
+	var exitCode int
+	go func() {
+		defer close(done)
+		exitCode = fakeCommand(opts, env, stdin, stdoutWriter, stderrWriter)
+		stdin.Close()
+		stdoutWriter.Close()
+		stderrWriter.Close()
+	}()
+
+	session := &execSession{
+		stdout: stdout,
+		stderr: stderr,
+		resize: func(context.Context, TerminalSize) error {
+			return nil
+		},
+		wait: func(ctx context.Context) (int, error) {
+			select {
+			case <-done:
+				return exitCode, nil
+			case <-ctx.Done():
+				return exitCodeUnknown, ctx.Err()
+			}
+		},
+		close: func() error {
+			stdinWriter.Close()
+			stdout.Close()
+			stderr.Close()
+			return nil
+		},
+	}
+	if opts.Stdin {
+		session.stdin = stdinWriter
+	} else {
+		stdinWriter.Close()
+	}
+	return session, nil
+}
+
+// fakeCommand runs a simulated command in a container with the environment variables and returns its exit code,
+// the error output is the output with a TTY
+func fakeCommand(opts ExecOptions, env []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	if opts.TTY {
+		stderr = stdout
+	}
+
+	switch name, args := opts.Command[0], opts.Command[1:]; name {
+	case "echo":
+		fmt.Fprintln(stdout, strings.Join(args, " "))
+	case "cat":
+		io.Copy(stdout, stdin)
+	case "env":
+		for _, variable := range append(slices.Clip(env), opts.Env...) {
+			fmt.Fprintln(stdout, variable)
+		}
+	case "true":
+	case "false":
+		return 1
+	default:
+		fmt.Fprintf(stderr, "%s: command not found\n", name)
+		return 127
+	}
+	return 0
+}
+
+// ResolveImageDigest resolves an image reference to a digest derived from the reference
+func (fr *FakeRuntime) ResolveImageDigest(_ context.Context, image string) (string, error) {
+	sum := sha256.Sum256([]byte(image))
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// ListImages lists the pulled images
+func (fr *FakeRuntime) ListImages(_ context.Context) ([]string, error) {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+
+	images := make([]string, 0, len(fr.images))
+	for image := range fr.images {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+// Logs returns the output of a container by container ID
+func (fr *FakeRuntime) Logs(containerID string) (string, error) {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+
+	container, err := fr.container(containerID)
+	if err != nil {
+		return "", err
+	}
+	return container.logs, nil
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"archive/tar"
+	"bytes"
+	"container-manager/types"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeRuntime(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store, err := NewArtifactStore(t.TempDir())
+	require.NoError(t, err)
+	runtime, err := NewFakeRuntime(FakeRuntimeOptions{LogOutput: "ready\n"}, store)
+	require.NoError(t, err)
+
+	digest, _, err := store.Put(bytes.NewReader(newTestArchive(t, map[string]string{"data/hello.txt": "hello"})))
+	require.NoError(t, err)
+
+	// containers run until they are stopped, with their inputs in place
+	containerID, err := runtime.DeployContainer(ctx, types.Container{
+		Image:  "busybox",
+		Inputs: []types.ArtifactInput{{Digest: digest, Path: "/in"}},
+	})
+	require.NoError(t, err)
+	status, err := runtime.GetContainerStatus(ctx, containerID)
+	require.NoError(t, err)
+	require.Equal(t, "running", status)
+	logs, err := runtime.Logs(containerID)
+	require.NoError(t, err)
+	require.Equal(t, "ready\n", logs)
+
+	reader, err := runtime.CopyFromContainer(ctx, containerID, "/in")
+	require.NoError(t, err)
+	tr := tar.NewReader(reader)
+	header, err := tr.Next()
+	require.NoError(t, err)
+	require.Equal(t, "in/hello.txt", header.Name)
+	content, err := io.ReadAll(tr)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(content))
+	_, err = runtime.CopyFromContainer(ctx, containerID, "/missing")
+	require.Error(t, err)
+
+	// running containers can't be removed
+	require.Error(t, runtime.RemoveContainer(ctx, containerID))
+	require.NoError(t, runtime.StopContainer(ctx, containerID))
+	info, err := runtime.InspectContainer(ctx, containerID)
+	require.NoError(t, err)
+	require.Equal(t, "exited", info.Status)
+	require.Equal(t, fakeStopExitCode, info.ExitCode)
+	require.NoError(t, runtime.RemoveContainer(ctx, containerID))
+	_, err = runtime.GetContainerStatus(ctx, containerID)
+	require.Error(t, err)
+
+	images, err := runtime.ListImages(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"busybox"}, images)
+	digest, err = runtime.ResolveImageDigest(ctx, "busybox")
+	require.NoError(t, err)
+	require.NoError(t, types.ValidateArtifactDigest(digest))
+}
+
+func TestFakeRuntimeRunDuration(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	runtime, err := NewFakeRuntime(FakeRuntimeOptions{RunDuration: 50 * time.Millisecond, ExitCode: 3}, nil)
+	require.NoError(t, err)
+
+	containerID, err := runtime.DeployContainer(ctx, types.Container{Image: "busybox"})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		info, err := runtime.InspectContainer(ctx, containerID)
+		return err == nil && info.Status == "exited" && info.ExitCode == 3
+	}, time.Second, 10*time.Millisecond)
+
+	// killing an exited container keeps its exit code
+	require.NoError(t, runtime.KillContainer(ctx, containerID))
+	info, err := runtime.InspectContainer(ctx, containerID)
+	require.NoError(t, err)
+	require.Equal(t, 3, info.ExitCode)
+}
+
+func TestFakeRuntimeFailures(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	_, err := NewFakeRuntime(FakeRuntimeOptions{FailureRate: 2}, nil)
+	require.Error(t, err)
+
+	runtime, err := NewFakeRuntime(FakeRuntimeOptions{FailureRate: 1}, nil)
+	require.NoError(t, err)
+	_, err = runtime.DeployContainer(ctx, types.Container{Image: "busybox"})
+	require.ErrorContains(t, err, "simulated failure")
+
+	// pulls that take longer than the pull timeout time out, pulled images are cached
+	runtime, err = NewFakeRuntime(FakeRuntimeOptions{PullLatency: 50 * time.Millisecond}, nil)
+	require.NoError(t, err)
+	_, err = runtime.DeployContainer(ctx, types.Container{
+		Image:    "busybox",
+		Timeouts: &types.Timeouts{Pull: types.Duration(time.Millisecond)},
+	})
+	require.ErrorIs(t, err, ErrDeployTimeout)
+	_, err = runtime.DeployContainer(ctx, types.Container{Image: "busybox"})
+	require.NoError(t, err)
+	_, err = runtime.DeployContainer(ctx, types.Container{
+		Image:    "busybox",
+		Timeouts: &types.Timeouts{Pull: types.Duration(time.Millisecond)},
+	})
+	require.NoError(t, err)
+}
+
+func TestJobQueueWithFakeRuntime(t *testing.T) {
+	t.Parallel()
+
+	store, err := NewArtifactStore(t.TempDir())
+	require.NoError(t, err)
+	runtime, err := NewFakeRuntime(FakeRuntimeOptions{RunDuration: time.Millisecond}, store)
+	require.NoError(t, err)
+	jobQueue := NewQueue(10, runtime, WithNodeID("local"), WithArtifacts(store))
+
+	digest, _, err := store.Put(bytes.NewReader(newTestArchive(t, map[string]string{"data/hello.txt": "hello"})))
+	require.NoError(t, err)
+	container := types.Container{
+		Image:         "busybox",
+		RestartPolicy: &types.RestartPolicy{Name: types.RestartPolicyNever},
+		Inputs:        []types.ArtifactInput{{Digest: digest, Node: "local", Path: "/in"}},
+		Outputs:       []types.ArtifactOutput{{Name: "result", Path: "/in/hello.txt"}},
+	}
+	require.NoError(t, jobQueue.Enqueue("job", container))
+
+	// the container exits once it ran, and its outputs are collected
+	jobQueue.executeJob(<-jobQueue.jobs)
+	require.Eventually(t, func() bool {
+		jobQueue.checkRunningJobs()
+		record, _ := jobQueue.GetJob("job")
+		return record.Status == types.JobStatusComplete
+	}, time.Second, 10*time.Millisecond)
+
+	record, _ := jobQueue.GetJob("job")
+	require.Len(t, record.Artifacts, 1)
+	require.True(t, store.Has(record.Artifacts[0].Digest))
+	images, err := runtime.ListImages(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"busybox"}, images)
+}
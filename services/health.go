@@ -0,0 +1,176 @@
+package services
+
+import (
+	"container-manager/types"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// watchHealth runs the health check of a job for as long as the job runs its container.
+// A healthy job that isn't monitored completes, an unhealthy job is restarted.
+func (q *QueueHandler) watchHealth(job types.Job) {
+	defer q.wg.Done()
+
+	check := job.Container.HealthCheck.WithDefaults()
+	started := time.Now()
+	var failures int
+
+	ticker := time.NewTicker(time.Duration(check.Interval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-q.quit:
+			return
+		}
+
+		health, err := q.checkHealth(job.ContainerID, check)
+		if err != nil {
+			logrus.WithField("job_id", job.ID).Debugf("health check failed: %v", err)
+		}
+
+		// probes run by the manager count failures themselves, Docker does it for commands
+		if len(check.Command) == 0 {
+			switch {
+			case err == nil:
+				failures = 0
+			case time.Since(started) < time.Duration(check.StartPeriod):
+				health = types.HealthStatusStarting
+			default:
+				failures++
+				health = types.HealthStatusStarting
+				if failures >= check.Retries {
+					health = types.HealthStatusUnhealthy
+				}
+			}
+		}
+
+		if !q.applyHealth(job, health) {
+			return
+		}
+	}
+}
+
+// applyHealth records the health of the container of a job. A healthy job that isn't monitored completes,
+// an unhealthy job is restarted. It returns false once the health of the container isn't watched anymore.
+func (q *QueueHandler) applyHealth(job types.Job, health types.HealthStatus) bool {
+	switch health {
+	case types.HealthStatusHealthy:
+		status := types.JobStatusRunning
+		if !job.Monitored() {
+			status = types.JobStatusComplete
+		}
+		_, ok := q.setHealth(job.ID, job.ContainerID, status, health)
+		return ok && status != types.JobStatusComplete
+	case types.HealthStatusUnhealthy:
+		if _, ok := q.setHealth(job.ID, job.ContainerID, types.JobStatusRunning, health); ok {
+			q.restart(job.ID, job.ContainerID, exitCodeUnknown, "container is unhealthy")
+		}
+		return false
+	default:
+		_, ok := q.setHealth(job.ID, job.ContainerID, types.JobStatusRunning, types.HealthStatusStarting)
+		return ok
+	}
+}
+
+// checkHealth runs one health check of a container. Docker health checks are read from the container state,
+// probes report healthy or an error.
+func (q *QueueHandler) checkHealth(containerID string, check types.HealthCheck) (types.HealthStatus, error) {
+	info, err := q.dockerService.InspectContainer(q.ctx, containerID)
+	if err != nil {
+		return types.HealthStatusStarting, err
+	}
+
+	if len(check.Command) > 0 {
+		switch types.HealthStatus(info.Health) {
+		case types.HealthStatusHealthy, types.HealthStatusUnhealthy:
+			return types.HealthStatus(info.Health), nil
+		default:
+			return types.HealthStatusStarting, nil
+		}
+	}
+
+	if err := probe(check, info.IPAddress); err != nil {
+		return types.HealthStatusUnhealthy, err
+	}
+	return types.HealthStatusHealthy, nil
+}
+
+// setHealth sets the status and health of a job while it runs the container, it returns false
+// if the job was cancelled or runs another container
+func (q *QueueHandler) setHealth(
+	jobID string,
+	containerID string,
+	status types.JobStatus,
+	health types.HealthStatus,
+) (types.Job, bool) {
+	q.mutex.Lock()
+	record, exists := q.jobRecords[jobID]
+	if !exists || record.ContainerID != containerID || record.Status == types.JobStatusCancelled {
+		q.mutex.Unlock()
+		return types.Job{}, false
+	}
+	if record.Status == status && record.Health == health {
+		snapshot := *record
+		q.mutex.Unlock()
+		return snapshot, true
+	}
+
+	record.Status = status
+	record.Health = health
+	if status == types.JobStatusComplete {
+		q.endUsage(record)
+	}
+	snapshot := *record
+	q.mutex.Unlock()
+
+	logrus.WithFields(logrus.Fields{
+		"job_id": jobID,
+		"health": health,
+	}).Info("job health changed")
+	q.notify(snapshot)
+	return snapshot, true
+}
+
+// probe runs the HTTP or TCP probe of a health check against a container address
+func probe(check types.HealthCheck, address string) error {
+	if address == "" {
+		return fmt.Errorf("container has no IP address")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(check.Timeout))
+	defer cancel()
+
+	if check.TCP != nil {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(address, strconv.Itoa(check.TCP.Port)))
+		if err != nil {
+			return fmt.Errorf("failed to connect: %w", err)
+		}
+		return conn.Close()
+	}
+
+	url := fmt.Sprintf("http://%s%s", net.JoinHostPort(address, strconv.Itoa(check.HTTP.Port)), check.HTTP.Path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"container-manager/types"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+// newProbeServer starts an HTTP server on 127.0.0.1 that responds with the status code, and returns its port
+func newProbeServer(t *testing.T, statusCode int) int {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(statusCode)
+	}))
+	t.Cleanup(server.Close)
+
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+	number, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	return number
+}
+
+func TestProbe(t *testing.T) {
+	t.Parallel()
+
+	healthy := newProbeServer(t, http.StatusOK)
+	unhealthy := newProbeServer(t, http.StatusInternalServerError)
+	timeout := types.Duration(time.Second)
+
+	require.NoError(t, probe(types.HealthCheck{HTTP: &types.HTTPProbe{Port: healthy, Path: "/healthz"}, Timeout: timeout}, "127.0.0.1"))
+	require.Error(t, probe(types.HealthCheck{HTTP: &types.HTTPProbe{Port: unhealthy}, Timeout: timeout}, "127.0.0.1"))
+	require.NoError(t, probe(types.HealthCheck{TCP: &types.TCPProbe{Port: healthy}, Timeout: timeout}, "127.0.0.1"))
+	require.Error(t, probe(types.HealthCheck{TCP: &types.TCPProbe{Port: healthy}, Timeout: timeout}, ""))
+}
+
+func TestJobQueueHealthyJobCompletes(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	port := newProbeServer(t, http.StatusOK)
+	mockDockerService := NewMockDockerService(ctrl)
+	jobQueue := NewQueue(10, mockDockerService)
+	defer jobQueue.Stop()
+
+	container := types.Container{
+		Image: "nginx",
+		HealthCheck: &types.HealthCheck{
+			HTTP:     &types.HTTPProbe{Port: port},
+			Interval: types.Duration(10 * time.Millisecond),
+		},
+	}
+	mockDockerService.EXPECT().DeployContainer(gomock.Any(), deployOf(container)).Return("container-id", nil)
+	mockDockerService.EXPECT().GetContainerStatus(gomock.Any(), "container-id").Return("running", nil)
+	mockDockerService.EXPECT().InspectContainer(gomock.Any(), "container-id").Return(ContainerInfo{
+		Status:    "running",
+		IPAddress: "127.0.0.1",
+	}, nil)
+	require.NoError(t, jobQueue.Enqueue("job", container))
+	jobQueue.executeJob(job{id: "job", container: container})
+
+	// the job runs until its container is healthy
+	record, _ := jobQueue.GetJob("job")
+	require.Equal(t, types.JobStatusRunning, record.Status)
+	require.Equal(t, types.HealthStatusStarting, record.Health)
+
+	require.Eventually(t, func() bool {
+		record, _ := jobQueue.GetJob("job")
+		return record.Status == types.JobStatusComplete && record.Health == types.HealthStatusHealthy
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestJobQueueUnhealthyJobFails(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	port := newProbeServer(t, http.StatusServiceUnavailable)
+	mockDockerService := NewMockDockerService(ctrl)
+	jobQueue := NewQueue(10, mockDockerService)
+	defer jobQueue.Stop()
+
+	replica := types.Service{Name: "web", Container: types.Container{
+		Image: "nginx",
+		HealthCheck: &types.HealthCheck{
+			HTTP:     &types.HTTPProbe{Port: port},
+			Interval: types.Duration(10 * time.Millisecond),
+			Retries:  2,
+		},
+	}}.ReplicaContainer()
+	mockDockerService.EXPECT().DeployContainer(gomock.Any(), deployOf(replica)).Return("container-id", nil)
+	mockDockerService.EXPECT().GetContainerStatus(gomock.Any(), "container-id").Return("running", nil)
+	mockDockerService.EXPECT().InspectContainer(gomock.Any(), "container-id").Return(ContainerInfo{
+		Status:    "running",
+		IPAddress: "127.0.0.1",
+	}, nil).Times(2)
+	mockDockerService.EXPECT().StopContainer(gomock.Any(), "container-id").Return(nil)
+	mockDockerService.EXPECT().RemoveContainer(gomock.Any(), "container-id").Return(nil)
+	require.NoError(t, jobQueue.Enqueue("replica", replica))
+	jobQueue.executeJob(job{id: "replica", container: replica})
+
+	// without retries the unhealthy replica fails and its container is removed
+	require.Eventually(t, func() bool {
+		record, _ := jobQueue.GetJob("replica")
+		return record.Status == types.JobStatusFailed
+	}, time.Second, 10*time.Millisecond)
+}
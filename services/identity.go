@@ -0,0 +1,51 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/sirupsen/logrus"
+)
+
+// IdentityKeyFile is the name of the node identity key file in the data directory
+const IdentityKeyFile = "identity.key"
+
+// LoadOrCreateIdentity loads the node identity key from the data directory, generating
+// and saving a new Ed25519 key when it doesn't exist yet
+func LoadOrCreateIdentity(dataDir string) (crypto.PrivKey, error) {
+	path := filepath.Join(dataDir, IdentityKeyFile)
+
+	key, err := LoadPrivateKey(path)
+	if err == nil {
+		logrus.WithField("path", path).Debug("Loaded node identity")
+		return key, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to load node identity: %w", err)
+	}
+
+	key, _, err = crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate node identity: %w", err)
+	}
+
+	data, err := crypto.MarshalPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal node identity: %w", err)
+	}
+
+	if err := os.MkdirAll(dataDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write node identity: %w", err)
+	}
+
+	logrus.WithField("path", path).Info("Generated new node identity")
+	return key, nil
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestLoadOrCreateIdentity(t *testing.T) {
+	t.Parallel()
+	dataDir := filepath.Join(t.TempDir(), "data")
+
+	key, err := LoadOrCreateIdentity(dataDir)
+	require.NoError(t, err)
+	require.FileExists(t, filepath.Join(dataDir, IdentityKeyFile))
+
+	loaded, err := LoadOrCreateIdentity(dataDir)
+	require.NoError(t, err)
+	require.True(t, key.Equals(loaded))
+}
+
+func TestLoadOrCreateIdentity_Corrupt(t *testing.T) {
+	t.Parallel()
+	dataDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, IdentityKeyFile), []byte("corrupt"), 0o600))
+
+	_, err := LoadOrCreateIdentity(dataDir)
+	require.Error(t, err)
+}
+
+func TestP2PServiceWithIdentity(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	key, err := LoadOrCreateIdentity(t.TempDir())
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+
+	service, err := NewP2PService(NewMockQueue(ctrl), 4052, WithIdentity(key))
+	require.NoError(t, err)
+	defer service.Stop()
+
+	require.Equal(t, id.String(), service.ID())
+}
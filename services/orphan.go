@@ -0,0 +1,102 @@
+package services
+
+import (
+	"container-manager/types"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ReconcileOrphans finds the containers the node created before it restarted, and adopts them back into their
+// jobs or removes them according to the policy. Containers created by other nodes sharing the runtime are left alone.
+func (q *QueueHandler) ReconcileOrphans(policy types.OrphanPolicy) error {
+	if !policy.Valid() {
+		return fmt.Errorf("unknown orphan policy %q", policy)
+	}
+	if policy == types.OrphanPolicyIgnore {
+		return nil
+	}
+
+	containers, err := q.dockerService.ListContainers(q.ctx, types.ManagedJobLabel)
+	if err != nil {
+		return fmt.Errorf("failed to list orphaned containers: %w", err)
+	}
+
+	for _, container := range containers {
+		if container.Labels[types.ManagedNodeLabel] != q.nodeID {
+			continue
+		}
+
+		logger := logrus.WithFields(logrus.Fields{
+			"job_id":       container.Labels[types.ManagedJobLabel],
+			"container_id": container.ID,
+			"version":      container.Labels[types.ManagedVersionLabel],
+		})
+		if policy == types.OrphanPolicyAdopt {
+			err := q.adopt(container)
+			if err == nil {
+				logger.Info("adopted orphaned container")
+				continue
+			}
+			logger.Warnf("failed to adopt orphaned container: %v", err)
+		}
+
+		logger.Info("removing orphaned container")
+		q.removeContainer(container.ID)
+	}
+	return nil
+}
+
+// adopt records the job of an orphaned container again. Jobs whose container is watched run again and
+// are restarted by the monitor if the container stopped, other jobs are complete as after their deploy.
+// The job keeps its origin and run time from the labels, and its status is reported to its origin again.
+func (q *QueueHandler) adopt(container ContainerSummary) error {
+	jobID := container.Labels[types.ManagedJobLabel]
+	if jobID == "" {
+		return fmt.Errorf("container has no job ID")
+	}
+	spec, err := types.ManagedSpec(container.Labels)
+	if err != nil {
+		return err
+	}
+	if version := container.Labels[types.ManagedVersionLabel]; version != types.Version {
+		logrus.WithField("job_id", jobID).Warnf("adopting container created by manager version %s", version)
+	}
+
+	// the run time of the adopted container counts from its deploy
+	runTime, deployed := types.ManagedRun(container.Labels, time.Now())
+	record := &types.Job{
+		ID:          jobID,
+		Container:   spec,
+		Status:      types.JobStatusComplete,
+		Node:        q.nodeID,
+		Origin:      container.Labels[types.ManagedOriginLabel],
+		ContainerID: container.ID,
+		StartedAt:   deployed,
+		RunTime:     runTime,
+	}
+	watchHealth := spec.HealthCheck != nil && container.Status == "running"
+	if record.Monitored() || spec.HealthCheck != nil {
+		record.Status = types.JobStatusRunning
+	}
+	if watchHealth {
+		record.Health = types.HealthStatusStarting
+	}
+
+	q.mutex.Lock()
+	if _, exists := q.jobRecords[jobID]; exists {
+		q.mutex.Unlock()
+		return fmt.Errorf("job is already known to the node")
+	}
+	q.jobRecords[jobID] = record
+	snapshot := *record
+	q.mutex.Unlock()
+
+	if watchHealth {
+		q.wg.Add(1)
+		go q.watchHealth(snapshot)
+	}
+	q.notify(snapshot)
+	return nil
+}
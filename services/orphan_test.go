@@ -0,0 +1,153 @@
+package services
+
+import (
+	"container-manager/types"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestJobQueueReconcileOrphans(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDockerService := NewMockDockerService(ctrl)
+	jobQueue := NewQueue(10, mockDockerService, WithNodeID("local"))
+
+	replica := types.Container{
+		Image:         "nginx",
+		RestartPolicy: &types.RestartPolicy{Name: types.RestartPolicyAlways},
+	}
+	oneOff := types.Container{Image: "busybox"}
+	broken := oneOff.Managed("broken", "local")
+	broken.Labels[types.ManagedSpecLabel] = "{"
+	mockDockerService.EXPECT().ListContainers(gomock.Any(), types.ManagedJobLabel).Return([]ContainerSummary{
+		{ID: "replica-container", Status: "running", Labels: replica.Managed("replica", "local").Labels},
+		{ID: "one-off-container", Status: "running", Labels: oneOff.Managed("one-off", "local").Labels},
+		{ID: "broken-container", Status: "running", Labels: broken.Labels},
+		{ID: "remote-container", Status: "running", Labels: oneOff.Managed("remote", "remote").Labels},
+	}, nil)
+
+	// containers that can't be adopted are removed, containers of other nodes are left alone
+	mockDockerService.EXPECT().StopContainer(gomock.Any(), "broken-container").Return(nil)
+	mockDockerService.EXPECT().RemoveContainer(gomock.Any(), "broken-container").Return(nil)
+	require.NoError(t, jobQueue.ReconcileOrphans(types.OrphanPolicyAdopt))
+
+	record, ok := jobQueue.GetJob("replica")
+	require.True(t, ok)
+	require.Equal(t, types.JobStatusRunning, record.Status)
+	require.Equal(t, "replica-container", record.ContainerID)
+	require.Equal(t, "local", record.Node)
+	require.Equal(t, replica, record.Container)
+	record, ok = jobQueue.GetJob("one-off")
+	require.True(t, ok)
+	require.Equal(t, types.JobStatusComplete, record.Status)
+	_, ok = jobQueue.GetJob("broken")
+	require.False(t, ok)
+	_, ok = jobQueue.GetJob("remote")
+	require.False(t, ok)
+
+	// adopted jobs whose container stopped are restarted by the monitor
+	mockDockerService.EXPECT().GetContainerStatus(gomock.Any(), "replica-container").Return("exited", nil)
+	mockDockerService.EXPECT().InspectContainer(gomock.Any(), "replica-container").Return(ContainerInfo{Status: "exited"}, nil)
+	mockDockerService.EXPECT().StopContainer(gomock.Any(), "replica-container").Return(nil)
+	mockDockerService.EXPECT().RemoveContainer(gomock.Any(), "replica-container").Return(nil)
+	jobQueue.checkRunningJobs()
+	status, _ := jobQueue.GetStatus("replica")
+	require.Equal(t, types.JobStatusPending, status)
+	jobQueue.Stop()
+}
+
+func TestJobQueueReconcileOrphansPolicies(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDockerService := NewMockDockerService(ctrl)
+	jobQueue := NewQueue(10, mockDockerService, WithNodeID("local"))
+	container := types.Container{Image: "busybox"}
+
+	// ignored containers aren't even listed
+	require.NoError(t, jobQueue.ReconcileOrphans(types.OrphanPolicyIgnore))
+	require.Error(t, jobQueue.ReconcileOrphans("delete"))
+
+	mockDockerService.EXPECT().ListContainers(gomock.Any(), types.ManagedJobLabel).Return([]ContainerSummary{
+		{ID: "container-id", Status: "running", Labels: container.Managed("job", "local").Labels},
+	}, nil)
+	mockDockerService.EXPECT().StopContainer(gomock.Any(), "container-id").Return(nil)
+	mockDockerService.EXPECT().RemoveContainer(gomock.Any(), "container-id").Return(nil)
+	require.NoError(t, jobQueue.ReconcileOrphans(types.OrphanPolicyRemove))
+	_, ok := jobQueue.GetJob("job")
+	require.False(t, ok)
+}
+
+func TestJobQueueAdoptsAfterRestart(t *testing.T) {
+	t.Parallel()
+
+	runtime, err := NewFakeRuntime(FakeRuntimeOptions{}, nil)
+	require.NoError(t, err)
+	container := types.Container{
+		Image:         "nginx",
+		RestartPolicy: &types.RestartPolicy{Name: types.RestartPolicyAlways},
+		Timeouts:      &types.Timeouts{Run: types.Duration(time.Hour)},
+	}
+
+	jobQueue := NewQueue(10, runtime, WithNodeID("local"))
+	require.NoError(t, jobQueue.Enqueue("job", container))
+	jobQueue.executeJob(<-jobQueue.jobs)
+	deployed, _ := jobQueue.GetJob("job")
+	require.Equal(t, types.JobStatusRunning, deployed.Status)
+
+	// the queue of the restarted node takes the running container back
+	restarted := NewQueue(10, runtime, WithNodeID("local"))
+	require.NoError(t, restarted.ReconcileOrphans(types.OrphanPolicyAdopt))
+	adopted, ok := restarted.GetJob("job")
+	require.True(t, ok)
+	require.Equal(t, types.JobStatusRunning, adopted.Status)
+	require.Equal(t, deployed.ContainerID, adopted.ContainerID)
+	require.Equal(t, container, adopted.Container)
+}
+
+func TestJobQueueAdoptsOriginAndRunTime(t *testing.T) {
+	t.Parallel()
+
+	runtime, err := NewFakeRuntime(FakeRuntimeOptions{}, nil)
+	require.NoError(t, err)
+	container := types.Container{
+		Image:         "nginx",
+		RestartPolicy: &types.RestartPolicy{Name: types.RestartPolicyAlways},
+	}
+
+	jobQueue := NewQueue(10, runtime, WithNodeID("local"))
+	require.NoError(t, jobQueue.EnqueueFrom("job", container, "origin"))
+	jobQueue.executeJob(<-jobQueue.jobs)
+	time.Sleep(20 * time.Millisecond)
+
+	// the adopted job keeps reporting to the node that handed it over, and its run time counts from its deploy
+	restarted := NewQueue(10, runtime, WithNodeID("local"))
+	updates := make(chan types.Job, 1)
+	restarted.OnStatusChange(func(job types.Job) {
+		updates <- job
+	})
+	require.NoError(t, restarted.ReconcileOrphans(types.OrphanPolicyAdopt))
+
+	update := <-updates
+	require.Equal(t, "job", update.ID)
+	require.Equal(t, "origin", update.Origin)
+	require.Equal(t, types.JobStatusRunning, update.Status)
+	require.GreaterOrEqual(t, update.RunningFor(), 20*time.Millisecond)
+	adopted, _ := restarted.GetJob("job")
+	require.Equal(t, "origin", adopted.Origin)
+
+	// the time the previous containers of the job ran is restored as well
+	deployed := time.Now().Add(-time.Minute)
+	labels := types.Job{ID: "job", Container: container, RunTime: types.Duration(time.Hour)}.Managed("local", deployed).Labels
+	runTime, started := types.ManagedRun(labels, time.Now())
+	require.Equal(t, types.Duration(time.Hour), runTime)
+	require.True(t, deployed.Equal(started))
+}
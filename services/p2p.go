@@ -5,15 +5,20 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/pnet"
 	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
 	"github.com/multiformats/go-multiaddr"
 	"github.com/sirupsen/logrus"
@@ -22,16 +27,44 @@ import (
 const (
 	// ProtocolID is the protocol ID for the container manager p2p service
 	ProtocolID = "/container-manager/1.0.0"
+	// TrustProtocolID is the protocol ID peers use to exchange their cluster CA certificates
+	TrustProtocolID = "/container-manager/trust/1.0.0"
+	// ArtifactProtocolID is the protocol ID peers use to fetch artifacts from each other
+	ArtifactProtocolID = "/container-manager/artifacts/1.0.0"
+	// ExecProtocolID is the protocol ID peers use to run commands in the containers of each other's jobs
+	ExecProtocolID = "/container-manager/exec/1.0.0"
+	// CopyProtocolID is the protocol ID peers use to copy files into and out of the containers of each other's jobs
+	CopyProtocolID = "/container-manager/copy/1.0.0"
+	// StatsProtocolID is the protocol ID peers use to get the stats of the containers of each other's jobs
+	StatsProtocolID = "/container-manager/stats/1.0.0"
+	// SecretProtocolID is the protocol ID peers use to fetch the secrets of the containers they run from each other
+	SecretProtocolID = "/container-manager/secrets/1.0.0"
+	// maxMessageSize is the maximum size of a P2P message
+	maxMessageSize = 4 << 20
 )
 
 // Message is a P2P message sent between peers
 // Type is the message type
 // JobID is the identifier of the job
 // Data is the message data
+// From is the peer ID of the sender
+// Client is the address of the client that submitted the job to the sender
+// Certificate is the certificate issued to the sender by the cluster CA
+// Signature is the signature of the sender over the message
 type Message struct {
-	Type  types.P2PMessageType `json:"type"`
-	JobID string               `json:"job_id"`
-	Data  json.RawMessage      `json:"data"`
+	Type        types.P2PMessageType `json:"type"`
+	JobID       string               `json:"job_id"`
+	Data        json.RawMessage      `json:"data"`
+	From        string               `json:"from"`
+	Client      string               `json:"client,omitempty"`
+	Certificate []byte               `json:"certificate,omitempty"`
+	Signature   []byte               `json:"signature"`
+}
+
+// signingBytes returns the bytes covered by the message signature
+func (m Message) signingBytes() ([]byte, error) {
+	m.Signature = nil
+	return json.Marshal(m)
 }
 
 // peerNotifee is a notifee for peer discovery
@@ -54,55 +87,252 @@ func newPeerNotifee(handler *Service) *peerNotifee {
 // Start starts the service
 // Stop stops the service
 // Broadcast broadcasts a message to all peers
+// Send sends a message to a single peer
 // ID returns the ID of the p2p host
+// Peers returns the IDs of the connected peers
 type P2PService interface {
 	ID() string
 	Start(serviceName string)
 	Broadcast(msg Message) error
+	Send(peerID string, msg Message) error
+	Peers() []string
 	Stop()
 }
 
+// p2pOptions holds the optional settings of the P2P service
+// admission is the admission controller applied to jobs received from peers
+// trust decides which peers are allowed to connect and send jobs
+// certificate is the certificate issued to this node by the cluster CA
+// psk is the pre-shared key of the private network
+// identity is the private key of the node, a new key is generated when nil
+// bootstrapPeers are the peers connected to on start
+// discovery are the peer discovery mechanisms, mDNS when empty
+// capacitySource provides the capacity record gossiped to peers
+// capacityStore keeps the capacity records received from peers
+// scheduler checks the placement constraints of jobs received from peers
+// serviceStore keeps the service specs received from peers
+// artifacts is the artifact store served to peers
+// exec runs the commands peers route to the containers of the jobs of this node
+// copier copies the files peers route into and out of the containers of the jobs of this node
+// stats serves the stats of the containers of the jobs of this node to peers
+// secrets is the secret store served to peers
+type p2pOptions struct {
+	admission      *AdmissionController
+	trust          *PeerTrust
+	certificate    []byte
+	psk            pnet.PSK
+	identity       crypto.PrivKey
+	bootstrapPeers []peer.AddrInfo
+	discovery      []DiscoveryMode
+	capacitySource CapacitySource
+	capacityStore  *CapacityStore
+	scheduler      *Scheduler
+	serviceStore   *ServiceStore
+	artifacts      *ArtifactStore
+	exec           *ExecRouter
+	copier         *CopyRouter
+	stats          *StatsRouter
+	secrets        *SecretStore
+}
+
+// P2POption configures optional behaviour of the P2P service
+type P2POption func(*p2pOptions)
+
+// WithAdmission sets the admission controller applied to jobs received from peers
+func WithAdmission(admission *AdmissionController) P2POption {
+	return func(o *p2pOptions) {
+		o.admission = admission
+	}
+}
+
+// WithPeerTrust restricts the peers allowed to connect and send jobs
+func WithPeerTrust(trust *PeerTrust) P2POption {
+	return func(o *p2pOptions) {
+		o.trust = trust
+	}
+}
+
+// WithCertificate sets the certificate issued to this node by the cluster CA
+func WithCertificate(certificate []byte) P2POption {
+	return func(o *p2pOptions) {
+		o.certificate = certificate
+	}
+}
+
+// WithPrivateNetwork restricts the host to a private network of nodes sharing the pre-shared key
+func WithPrivateNetwork(psk pnet.PSK) P2POption {
+	return func(o *p2pOptions) {
+		o.psk = psk
+	}
+}
+
+// WithIdentity sets the private key the node identifies itself with
+func WithIdentity(key crypto.PrivKey) P2POption {
+	return func(o *p2pOptions) {
+		o.identity = key
+	}
+}
+
+// WithBootstrapPeers sets the peers connected to on start, which also bootstrap the DHT
+func WithBootstrapPeers(peers []peer.AddrInfo) P2POption {
+	return func(o *p2pOptions) {
+		o.bootstrapPeers = peers
+	}
+}
+
+// WithDiscovery sets the peer discovery mechanisms started with the service
+func WithDiscovery(modes ...DiscoveryMode) P2POption {
+	return func(o *p2pOptions) {
+		o.discovery = modes
+	}
+}
+
+// WithCapacityGossip gossips the capacity record of the node to its peers and keeps
+// the records received from them in the store
+func WithCapacityGossip(source CapacitySource, store *CapacityStore) P2POption {
+	return func(o *p2pOptions) {
+		o.capacitySource = source
+		o.capacityStore = store
+	}
+}
+
+// WithScheduler checks that jobs received from peers satisfy their placement constraints on this node
+func WithScheduler(scheduler *Scheduler) P2POption {
+	return func(o *p2pOptions) {
+		o.scheduler = scheduler
+	}
+}
+
+// WithServiceStore keeps the service specs received from peers in the store
+func WithServiceStore(store *ServiceStore) P2POption {
+	return func(o *p2pOptions) {
+		o.serviceStore = store
+	}
+}
+
+// WithArtifactStore serves the artifacts of the store to peers
+func WithArtifactStore(store *ArtifactStore) P2POption {
+	return func(o *p2pOptions) {
+		o.artifacts = store
+	}
+}
+
+// LoadPrivateNetworkKey reads a pre-shared key in the libp2p V1 PSK format from a file
+func LoadPrivateNetworkKey(path string) (pnet.PSK, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pre-shared key: %w", err)
+	}
+	defer file.Close()
+
+	psk, err := pnet.DecodeV1PSK(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode pre-shared key: %w", err)
+	}
+	return psk, nil
+}
+
 // Service is a P2P service
 // host is the libp2p host
-// peers is a map of discovered peers
-// peersLock is a mutex for peers
 // ctx is the service context
 // cancel is the cancel function for the service context
+// jobQueue is the queue jobs received from peers are enqueued to
+// admission is the admission controller applied to jobs received from peers
+// trust decides which peers are allowed to connect and send jobs
+// certificate is the certificate issued to this node by the cluster CA
+// bootstrapPeers are the peers connected to on start
+// discovery are the peer discovery mechanisms
+// mdns is the mDNS discovery service, nil unless mDNS discovery is enabled
+// dht is the cluster DHT, nil unless DHT discovery is enabled
+// capacitySource provides the capacity record gossiped to peers
+// capacityStore keeps the capacity records received from peers
+// scheduler checks the placement constraints of jobs received from peers, nil skips the check
+// serviceStore keeps the service specs received from peers
+// artifacts is the artifact store served to peers, nil serves no artifacts
+// exec runs the commands peers route to the containers of the jobs of this node, nil runs none
+// copier copies the files peers route into and out of the containers of the jobs of this node, nil copies none
+// stats serves the stats of the containers of the jobs of this node to peers, nil serves none
+// secrets is the secret store served to peers, nil serves no secrets
 type Service struct {
-	host     host.Host
-	ctx      context.Context
-	cancel   context.CancelFunc
-	jobQueue Queue
+	host           host.Host
+	ctx            context.Context
+	cancel         context.CancelFunc
+	jobQueue       Queue
+	admission      *AdmissionController
+	trust          *PeerTrust
+	certificate    []byte
+	bootstrapPeers []peer.AddrInfo
+	discovery      []DiscoveryMode
+	mdns           mdns.Service
+	dht            *dht.IpfsDHT
+	capacitySource CapacitySource
+	capacityStore  *CapacityStore
+	scheduler      *Scheduler
+	serviceStore   *ServiceStore
+	artifacts      *ArtifactStore
+	exec           *ExecRouter
+	copier         *CopyRouter
+	stats          *StatsRouter
+	secrets        *SecretStore
 }
 
 // NewP2PService creates a new P2P service
-func NewP2PService(jobQueue Queue, port int) (*Service, error) {
+func NewP2PService(jobQueue Queue, port int, opts ...P2POption) (*Service, error) {
+	var options p2pOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
-	containerIP, err := getHostIP()
+	listenAddr, err := ListenMultiaddr(port)
 	if err != nil {
 		cancel()
-		return nil, fmt.Errorf("failed to get container IP: %w", err)
+		return nil, err
 	}
 
-	listenAddr, err := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/%s/tcp/%d", containerIP, port))
-	if err != nil {
-		cancel()
-		return nil, fmt.Errorf("failed to create multiaddr: %w", err)
+	hostOptions := []libp2p.Option{
+		libp2p.ListenAddrs(listenAddr),
+	}
+	if options.identity != nil {
+		hostOptions = append(hostOptions, libp2p.Identity(options.identity))
+	}
+	if options.trust.Enabled() {
+		hostOptions = append(hostOptions, libp2p.ConnectionGater(&trustGater{trust: options.trust}))
+	}
+	if len(options.psk) > 0 {
+		hostOptions = append(hostOptions, libp2p.PrivateNetwork(options.psk))
 	}
 
-	p2pHost, err := libp2p.New(
-		libp2p.ListenAddrs(listenAddr),
-	)
+	p2pHost, err := libp2p.New(hostOptions...)
 	if err != nil {
 		cancel()
 		return nil, fmt.Errorf("failed to create libp2p host: %w", err)
 	}
+	discovery := options.discovery
+	if len(discovery) == 0 {
+		discovery = []DiscoveryMode{DiscoveryMDNS}
+	}
+
 	service := &Service{
-		host:     p2pHost,
-		ctx:      ctx,
-		cancel:   cancel,
-		jobQueue: jobQueue,
+		host:           p2pHost,
+		ctx:            ctx,
+		cancel:         cancel,
+		jobQueue:       jobQueue,
+		admission:      options.admission,
+		trust:          options.trust,
+		certificate:    options.certificate,
+		bootstrapPeers: options.bootstrapPeers,
+		discovery:      discovery,
+		capacitySource: options.capacitySource,
+		capacityStore:  options.capacityStore,
+		scheduler:      options.scheduler,
+		serviceStore:   options.serviceStore,
+		artifacts:      options.artifacts,
+		exec:           options.exec,
+		copier:         options.copier,
+		stats:          options.stats,
+		secrets:        options.secrets,
 	}
 
 	return service, nil
@@ -113,23 +343,62 @@ func (s *Service) ID() string {
 	return s.host.ID().String()
 }
 
+// Peers returns the IDs of the connected peers
+func (s *Service) Peers() []string {
+	var peers []string
+	for _, pi := range s.host.Network().Peers() {
+		peers = append(peers, pi.String())
+	}
+	return peers
+}
+
 // Start starts the P2P service
 func (s *Service) Start(serviceName string) {
 	logrus.Trace("Starting P2P Service")
-	// Set up mDNS for peer discovery
-	notifee := newPeerNotifee(s)
-	service := mdns.NewMdnsService(s.host, serviceName, notifee)
-	if err := service.Start(); err != nil {
-		logrus.Fatalf("failed to start mDNS service: %v", err)
+	s.host.SetStreamHandler(ProtocolID, s.handleStream)
+	s.host.SetStreamHandler(TrustProtocolID, s.handleTrustStream)
+	if s.artifacts != nil {
+		s.host.SetStreamHandler(ArtifactProtocolID, s.handleArtifactStream)
+	}
+	if s.exec != nil {
+		s.host.SetStreamHandler(ExecProtocolID, s.handleExecStream)
+	}
+	if s.copier != nil {
+		s.host.SetStreamHandler(CopyProtocolID, s.handleCopyStream)
+	}
+	if s.stats != nil {
+		s.host.SetStreamHandler(StatsProtocolID, s.handleStatsStream)
+	}
+	if s.secrets != nil {
+		s.host.SetStreamHandler(SecretProtocolID, s.handleSecretStream)
+	}
+	if s.trust.Enabled() {
+		s.host.Network().Notify(&network.NotifyBundle{
+			ConnectedF: func(_ network.Network, conn network.Conn) {
+				go s.verifyPeer(conn.RemotePeer())
+			},
+		})
 	}
 
-	s.host.SetStreamHandler(ProtocolID, s.handleStream)
+	if err := s.startDiscovery(serviceName); err != nil {
+		logrus.Fatalf("failed to start peer discovery: %v", err)
+	}
+	if s.capacitySource != nil {
+		go s.gossipCapacity()
+	}
 	logrus.Infof("P2P Service started with ID: %s", s.host.ID().String())
 }
 
 // Broadcast broadcasts a message to all peers in the peerstore
 func (s *Service) Broadcast(msg Message) error {
-	logrus.WithField("message", msg).Debug("Broadcasting message")
+	logrus.WithFields(logrus.Fields{
+		"type":   msg.Type,
+		"job_id": msg.JobID,
+	}).Debug("Broadcasting message")
+
+	if err := s.sign(&msg); err != nil {
+		return fmt.Errorf("failed to sign message: %w", err)
+	}
 
 	msgBytes, err := json.Marshal(msg)
 	if err != nil {
@@ -142,77 +411,492 @@ func (s *Service) Broadcast(msg Message) error {
 			continue
 		}
 
-		stream, err := s.host.NewStream(s.ctx, pi, ProtocolID)
-		if err != nil {
-			logrus.Errorf("failed to create stream to peer %s: %v", pi, err)
+		if err := s.write(pi, msgBytes); err != nil {
+			logrus.Error(err)
 			continue
 		}
+	}
+
+	return nil
+}
+
+// Send sends a message to a single peer
+func (s *Service) Send(peerID string, msg Message) error {
+	logrus.WithFields(logrus.Fields{
+		"peer":   peerID,
+		"type":   msg.Type,
+		"job_id": msg.JobID,
+	}).Debug("Sending message")
+
+	pi, err := peer.Decode(peerID)
+	if err != nil {
+		return fmt.Errorf("invalid peer ID %q: %w", peerID, err)
+	}
+
+	if err := s.sign(&msg); err != nil {
+		return fmt.Errorf("failed to sign message: %w", err)
+	}
+
+	msgBytes, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	return s.write(pi, msgBytes)
+}
+
+// write writes a marshalled message to a new stream to the peer
+func (s *Service) write(pi peer.ID, msgBytes []byte) error {
+	stream, err := s.host.NewStream(s.ctx, pi, ProtocolID)
+	if err != nil {
+		return fmt.Errorf("failed to create stream to peer %s: %w", pi, err)
+	}
+	defer stream.Close()
+
+	if _, err := stream.Write(msgBytes); err != nil {
+		return fmt.Errorf("failed to write to stream: %w", err)
+	}
+	return nil
+}
+
+// PublishJobStatus reports the status of a local job to the node that handed the job to this node.
+// Jobs submitted to this node have no other node to report to.
+func (s *Service) PublishJobStatus(job types.Job) {
+	if job.Origin == "" || job.Origin == s.ID() {
+		return
+	}
+	s.sendJobStatus(job.Origin, job.StatusUpdate())
+}
+
+// sendJobStatus sends the status of a job of this node to a peer
+func (s *Service) sendJobStatus(peerID string, update types.JobStatusUpdate) {
+	data, err := json.Marshal(update)
+	if err != nil {
+		logrus.Errorf("failed to marshal job status: %v", err)
+		return
+	}
+
+	msg := Message{
+		Type:  types.P2PMessageTypeJobStatus,
+		JobID: update.ID,
+		Data:  data,
+	}
+	if err := s.Send(peerID, msg); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"job_id": update.ID,
+			"peer":   peerID,
+		}).Errorf("failed to send job status: %v", err)
+	}
+}
+
+// gossipCapacity periodically broadcasts the capacity record of the node
+func (s *Service) gossipCapacity() {
+	ticker := time.NewTicker(capacityGossipInterval)
+	defer ticker.Stop()
+
+	for {
+		if refresher, ok := s.capacitySource.(CapacityRefresher); ok {
+			refresher.Refresh(s.ctx)
+		}
+		record := s.capacitySource.Capacity()
+		record.NodeID = s.host.ID().String()
 
-		_, err = stream.Write(msgBytes)
+		data, err := json.Marshal(record)
 		if err != nil {
-			logrus.Errorf("failed to write to stream: %v", err)
-			continue
+			logrus.Errorf("failed to marshal capacity record: %v", err)
+		} else if err := s.Broadcast(Message{Type: types.P2PMessageTypeCapacity, Data: data}); err != nil {
+			logrus.Errorf("failed to gossip capacity record: %v", err)
 		}
+
+		select {
+		case <-ticker.C:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// sign signs the message with the host key
+func (s *Service) sign(msg *Message) error {
+	msg.From = s.host.ID().String()
+	msg.Certificate = s.certificate
+
+	data, err := msg.signingBytes()
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	key := s.host.Peerstore().PrivKey(s.host.ID())
+	if key == nil {
+		return fmt.Errorf("no private key for host %s", s.host.ID())
 	}
 
+	msg.Signature, err = key.Sign(data)
+	if err != nil {
+		return fmt.Errorf("failed to sign message: %w", err)
+	}
 	return nil
 }
 
-// handleStream handles an incoming stream
-func (s *Service) handleStream(stream network.Stream) {
-	logrus.Trace("Handling incoming stream")
+// verify checks that the message was signed by the remote peer and that the peer is trusted
+func (s *Service) verify(msg Message, remote peer.ID) error {
+	if msg.From != remote.String() {
+		return fmt.Errorf("%w: sender %s does not match peer %s", ErrInvalidSignature, msg.From, remote)
+	}
+
+	pubKey := s.host.Peerstore().PubKey(remote)
+	if pubKey == nil {
+		return fmt.Errorf("%w: no public key for peer %s", ErrInvalidSignature, remote)
+	}
+
+	data, err := msg.signingBytes()
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	ok, err := pubKey.Verify(data, msg.Signature)
+	if err != nil || !ok {
+		return fmt.Errorf("%w: from peer %s", ErrInvalidSignature, remote)
+	}
+
+	return s.trust.Verify(remote, msg.Certificate)
+}
+
+// handleTrustStream answers a certificate request with the certificate of this node
+func (s *Service) handleTrustStream(stream network.Stream) {
 	defer stream.Close()
-	buf := make([]byte, 1024)
-	n, err := stream.Read(buf)
+
+	if _, err := stream.Write(s.certificate); err != nil {
+		logrus.Errorf("failed to write certificate to stream: %v", err)
+	}
+}
+
+// verifyPeer requests the certificate of a newly connected peer and disconnects it if it isn't trusted
+func (s *Service) verifyPeer(id peer.ID) {
+	if s.trust.Allowlisted(id) {
+		return
+	}
+
+	certificate, err := func() ([]byte, error) {
+		stream, err := s.host.NewStream(s.ctx, id, TrustProtocolID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open trust stream: %w", err)
+		}
+		defer stream.Close()
+
+		certificate, err := io.ReadAll(io.LimitReader(stream, maxMessageSize))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read certificate: %w", err)
+		}
+		return certificate, nil
+	}()
+	// the peer may still be starting or the network broke, so it isn't rejected and can connect again
 	if err != nil {
-		logrus.Errorf("failed to read from stream: %v", err)
+		logrus.WithField("peer", id).Warnf("disconnecting unverified peer: %v", err)
+		s.host.Network().ClosePeer(id)
 		return
 	}
+	// peers with an invalid certificate are rejected by Verify
+	if err := s.trust.Verify(id, certificate); err != nil {
+		logrus.WithField("peer", id).Warnf("disconnecting untrusted peer: %v", err)
+		s.host.Network().ClosePeer(id)
+	}
+}
+
+// handleStream handles an incoming stream
+func (s *Service) handleStream(stream network.Stream) {
+	logrus.Trace("Handling incoming stream")
+	defer stream.Close()
 
 	var msg Message
-	err = json.Unmarshal(buf[:n], &msg)
+	err := json.NewDecoder(io.LimitReader(stream, maxMessageSize)).Decode(&msg)
 	if err != nil {
 		logrus.Errorf("failed to unmarshal message: %v", err)
 		return
 	}
-	logrus.WithField("message", msg).Trace("Received p2p message")
+	logrus.WithFields(logrus.Fields{
+		"type":   msg.Type,
+		"job_id": msg.JobID,
+		"from":   msg.From,
+	}).Trace("Received p2p message")
+
+	if err := s.verify(msg, stream.Conn().RemotePeer()); err != nil {
+		logrus.WithField("peer", stream.Conn().RemotePeer()).Errorf("rejected p2p message: %v", err)
+		return
+	}
 
+	switch msg.Type {
+	case types.P2PMessageTypeDeployContainer:
+		s.handleDeployContainer(msg)
+	case types.P2PMessageTypeJobStatus:
+		s.handleJobStatus(msg)
+	case types.P2PMessageTypeCapacity:
+		s.handleCapacity(msg)
+	case types.P2PMessageTypeCancelJob:
+		s.handleCancelJob(msg)
+	case types.P2PMessageTypeServiceSpec:
+		s.handleServiceSpec(msg)
+	case types.P2PMessageTypeServiceReplica:
+		s.handleServiceReplica(msg)
+	default:
+		logrus.Warnf("unknown message type: %s", msg.Type)
+	}
+}
+
+// handleDeployContainer enqueues a job placed on this node by a peer
+func (s *Service) handleDeployContainer(msg Message) {
 	// skip if job is already seen
 	if _, ok := s.jobQueue.GetStatus(msg.JobID); ok {
 		logrus.WithField("job_id", msg.JobID).Trace("Job has already entered the queue")
 		return
 	}
 
-	switch msg.Type {
-	case types.P2PMessageTypeDeployContainer:
-		var container types.Container
-		if err := json.Unmarshal(msg.Data, &container); err != nil {
-			logrus.Errorf("failed to unmarshal container data: %v", err)
+	var container types.Container
+	if err := json.Unmarshal(msg.Data, &container); err != nil {
+		logrus.Errorf("failed to unmarshal container data: %v", err)
+		return
+	}
+
+	container, err := s.admission.Admit(s.ctx, container)
+	if err != nil {
+		logrus.WithField("job_id", msg.JobID).Errorf("job rejected by admission: %v", err)
+		return
+	}
+
+	// the peer placed the job with its own view of the cluster, check the constraints hold here
+	if s.scheduler != nil {
+		if err := s.scheduler.Fits(container); err != nil {
+			logrus.WithField("job_id", msg.JobID).Warnf("job rejected by placement constraints: %v", err)
+			s.sendJobStatus(msg.From, types.JobStatusUpdate{
+				ID:       msg.JobID,
+				Status:   types.JobStatusFailed,
+				Node:     s.ID(),
+				ExitCode: exitCodeUnknown,
+			})
 			return
 		}
+	}
 
-		if err := container.Validate(); err != nil {
-			logrus.Errorf("invalid container data: %v", err)
-			return
+	if err := s.jobQueue.EnqueueFrom(msg.JobID, container, msg.From); err != nil {
+		logrus.Errorf("failed to enqueue job: %v", err)
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"job_id": msg.JobID,
+		"peer":   msg.From,
+		"client": msg.Client,
+	}).Info("enqueued job received from peer")
+}
+
+// handleJobStatus updates the status of a job this node handed to the sending peer
+func (s *Service) handleJobStatus(msg Message) {
+	var update types.JobStatusUpdate
+	if err := json.Unmarshal(msg.Data, &update); err != nil {
+		logrus.Errorf("failed to unmarshal job status: %v", err)
+		return
+	}
+
+	// peers can only report the status of the jobs handed to them
+	update.Node = msg.From
+	if !s.jobQueue.UpdateRemote(update) {
+		logrus.WithFields(logrus.Fields{
+			"job_id": update.ID,
+			"peer":   msg.From,
+		}).Warn("ignored status of a job not handed to the peer")
+	}
+}
+
+// handleCapacity stores the capacity record of the sending peer
+func (s *Service) handleCapacity(msg Message) {
+	if s.capacityStore == nil {
+		return
+	}
+
+	var record types.NodeCapacity
+	if err := json.Unmarshal(msg.Data, &record); err != nil {
+		logrus.Errorf("failed to unmarshal capacity record: %v", err)
+		return
+	}
+
+	// peers can only report their own capacity, and records expire by the local clock
+	record.NodeID = msg.From
+	record.UpdatedAt = time.Now()
+	s.capacityStore.Update(record)
+}
+
+// handleCancelJob cancels a job of this node on behalf of the peer that handed it over, or of the owner of its service
+func (s *Service) handleCancelJob(msg Message) {
+	job, exists := s.jobQueue.GetJob(msg.JobID)
+	if !exists || !s.mayCancel(job, msg.From) {
+		logrus.WithFields(logrus.Fields{
+			"job_id": msg.JobID,
+			"peer":   msg.From,
+		}).Warn("rejected cancellation of a job the peer didn't hand over")
+		return
+	}
+
+	if err := s.jobQueue.Cancel(msg.JobID); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"job_id": msg.JobID,
+			"peer":   msg.From,
+		}).Warnf("failed to cancel job: %v", err)
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"job_id": msg.JobID,
+		"peer":   msg.From,
+	}).Info("cancelled job on behalf of peer")
+}
+
+// mayAccess reports whether the peer may use the container of the job, which it may if it handed the job to
+// this node or is a trusted peer
+func (s *Service) mayAccess(job types.Job, peerID peer.ID) bool {
+	return (job.Origin != "" && job.Origin == peerID.String()) || s.trust.Trusted(peerID)
+}
+
+// mayCancel reports whether the peer may cancel the job, which it may if it handed the job to this node or owns
+// the service the job is a replica of
+func (s *Service) mayCancel(job types.Job, peerID string) bool {
+	if job.Origin != "" && job.Origin == peerID {
+		return true
+	}
+	name, ok := job.Container.Labels[types.ServiceLabel]
+	if !ok || s.serviceStore == nil {
+		return false
+	}
+	spec, ok := s.serviceStore.Get(name)
+	return ok && spec.Owner == peerID
+}
+
+// handleServiceSpec stores a service spec published by its owner
+func (s *Service) handleServiceSpec(msg Message) {
+	if s.serviceStore == nil {
+		return
+	}
+
+	var spec types.Service
+	if err := json.Unmarshal(msg.Data, &spec); err != nil {
+		logrus.Errorf("failed to unmarshal service spec: %v", err)
+		return
+	}
+
+	// peers can only publish the services they own
+	if spec.Owner != msg.From {
+		logrus.WithFields(logrus.Fields{
+			"service": spec.Name,
+			"peer":    msg.From,
+		}).Warn("rejected service spec published by a node that doesn't own it")
+		return
+	}
+	if err := spec.Validate(); err != nil {
+		logrus.WithField("service", spec.Name).Errorf("rejected invalid service spec: %v", err)
+		return
+	}
+
+	previous, known := s.serviceStore.Get(spec.Name)
+	if !s.serviceStore.Apply(spec) {
+		return
+	}
+	// a node that takes the service over only knows the replicas it placed itself
+	if spec.Owner != s.ID() && (!known || previous.Owner != spec.Owner) {
+		s.handOverReplicas(spec)
+	}
+}
+
+// handOverReplicas reports the replicas of a service that run on this node to the owner of the service,
+// which becomes their origin so their status is reported to it from now on
+func (s *Service) handOverReplicas(spec types.Service) {
+	for _, job := range s.jobQueue.Jobs() {
+		if job.Container.Labels[types.ServiceLabel] != spec.Name || !job.Status.Active() {
+			continue
+		}
+		job, ok := s.jobQueue.HandOver(job.ID, spec.Owner)
+		if !ok {
+			continue
 		}
 
-		if err := s.jobQueue.Enqueue(msg.JobID, container); err != nil {
-			logrus.Errorf("failed to enqueue job: %v", err)
-			return
+		logger := logrus.WithFields(logrus.Fields{
+			"service": spec.Name,
+			"job_id":  job.ID,
+			"owner":   spec.Owner,
+		})
+		data, err := json.Marshal(job)
+		if err != nil {
+			logger.Errorf("failed to marshal replica: %v", err)
+			continue
+		}
+		msg := Message{
+			Type:  types.P2PMessageTypeServiceReplica,
+			JobID: job.ID,
+			Data:  data,
+		}
+		if err := s.Send(spec.Owner, msg); err != nil {
+			logger.Errorf("failed to hand over replica: %v", err)
+			continue
 		}
+		logger.Info("handed over replica to the owner of its service")
+	}
+}
 
-	default:
-		logrus.Warnf("unknown message type: %s", msg.Type)
+// handleServiceReplica tracks a replica of a service this node owns that runs on the sending peer
+func (s *Service) handleServiceReplica(msg Message) {
+	if s.serviceStore == nil {
+		return
+	}
+
+	var job types.Job
+	if err := json.Unmarshal(msg.Data, &job); err != nil {
+		logrus.Errorf("failed to unmarshal replica: %v", err)
+		return
 	}
+
+	// peers can only hand over their own replicas of the services this node owns
+	job.ID = msg.JobID
+	job.Node = msg.From
+	name := job.Container.Labels[types.ServiceLabel]
+	if spec, ok := s.serviceStore.Get(name); !ok || spec.Owner != s.ID() {
+		logrus.WithFields(logrus.Fields{
+			"service": name,
+			"job_id":  job.ID,
+			"peer":    msg.From,
+		}).Warn("ignored replica of a service the node doesn't own")
+		return
+	}
+	s.jobQueue.Track(job)
+	logrus.WithFields(logrus.Fields{
+		"service": name,
+		"job_id":  job.ID,
+		"peer":    msg.From,
+	}).Info("adopted replica handed over by peer")
 }
 
 // Stop stops the P2P service
 func (s *Service) Stop() {
 	logrus.Trace("Stopping P2P Service")
 	s.cancel()
+	if s.mdns != nil {
+		s.mdns.Close()
+	}
+	if s.dht != nil {
+		s.dht.Close()
+	}
 	s.host.Close()
 }
 
+// ListenMultiaddr returns the multiaddr the P2P service listens on for the port
+func ListenMultiaddr(port int) (multiaddr.Multiaddr, error) {
+	containerIP, err := getHostIP()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get container IP: %w", err)
+	}
+
+	listenAddr, err := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/%s/tcp/%d", containerIP, port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create multiaddr: %w", err)
+	}
+	return listenAddr, nil
+}
+
 // getHostIP retrieves the host's IP address
 func getHostIP() (string, error) {
 	hostname, err := os.Hostname()
@@ -2,9 +2,13 @@ package services
 
 import (
 	"container-manager/types"
+	"crypto/rand"
 	"encoding/json"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -79,7 +83,7 @@ func TestP2PServiceBroadcast(t *testing.T) {
 	require.NoError(t, err)
 
 	jobQueue2.EXPECT().GetStatus("job-1").Times(1).Return(types.JobStatusPending, false)
-	jobQueue2.EXPECT().Enqueue("job-1", container).Times(1)
+	jobQueue2.EXPECT().EnqueueFrom("job-1", container, service1.ID()).Times(1)
 
 	msg := Message{
 		JobID: "job-1",
@@ -93,6 +97,264 @@ func TestP2PServiceBroadcast(t *testing.T) {
 	service2.Stop()
 }
 
+func TestP2PServiceJobStatus(t *testing.T) {
+	t.Parallel()
+
+	jobQueue1 := NewQueue(10, nil)
+	service1, err := NewP2PService(jobQueue1, 4064)
+	require.NoError(t, err)
+	service2, err := NewP2PService(NewQueue(10, nil), 4065)
+	require.NoError(t, err)
+
+	go service1.Start(t.Name())
+	go service2.Start(t.Name())
+	defer service1.Stop()
+	defer service2.Stop()
+
+	time.Sleep(2 * time.Second)
+
+	jobQueue1.Track(types.Job{ID: "job-1", Status: types.JobStatusPending, Node: service2.ID()})
+	jobQueue1.Track(types.Job{ID: "job-2", Status: types.JobStatusPending, Node: "other"})
+
+	// the status of a job is only sent to the node that handed it over
+	service2.PublishJobStatus(types.Job{
+		ID:        "job-1",
+		Container: types.Container{Image: "alpine", Env: map[string]string{"TOKEN": "secret"}},
+		Status:    types.JobStatusComplete,
+		Node:      service2.ID(),
+		Origin:    service1.ID(),
+	})
+	require.Eventually(t, func() bool {
+		status, _ := jobQueue1.GetStatus("job-1")
+		return status == types.JobStatusComplete
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// peers can't report the status of jobs handed to other nodes
+	service2.PublishJobStatus(types.Job{ID: "job-2", Status: types.JobStatusFailed, Node: service2.ID(), Origin: service1.ID()})
+	time.Sleep(500 * time.Millisecond)
+	status, _ := jobQueue1.GetStatus("job-2")
+	require.Equal(t, types.JobStatusPending, status)
+}
+
+func TestP2PServiceCancelJob(t *testing.T) {
+	t.Parallel()
+
+	jobQueue := NewQueue(10, nil)
+	store := NewServiceStore()
+	store.Apply(types.Service{Name: "web", Replicas: 1, Container: types.Container{Image: "nginx"}, Owner: "owner"})
+	service, err := NewP2PService(jobQueue, 4066, WithServiceStore(store))
+	require.NoError(t, err)
+	defer service.Stop()
+
+	require.NoError(t, jobQueue.EnqueueFrom("job", types.Container{Image: "alpine"}, "origin"))
+	require.NoError(t, jobQueue.Enqueue("local", types.Container{Image: "alpine"}))
+	replica := types.Service{Name: "web", Container: types.Container{Image: "nginx"}}.ReplicaContainer()
+	require.NoError(t, jobQueue.EnqueueFrom("replica", replica, "origin"))
+
+	// only the node that handed a job over, or the owner of its service, can cancel it
+	service.handleCancelJob(Message{Type: types.P2PMessageTypeCancelJob, JobID: "job", From: "other"})
+	service.handleCancelJob(Message{Type: types.P2PMessageTypeCancelJob, JobID: "local", From: "origin"})
+	for _, jobID := range []string{"job", "local"} {
+		status, _ := jobQueue.GetStatus(jobID)
+		require.Equal(t, types.JobStatusPending, status)
+	}
+
+	service.handleCancelJob(Message{Type: types.P2PMessageTypeCancelJob, JobID: "job", From: "origin"})
+	service.handleCancelJob(Message{Type: types.P2PMessageTypeCancelJob, JobID: "replica", From: "owner"})
+	for _, jobID := range []string{"job", "replica"} {
+		status, _ := jobQueue.GetStatus(jobID)
+		require.Equal(t, types.JobStatusCancelled, status)
+	}
+}
+
+func TestP2PServiceHandOverReplicas(t *testing.T) {
+	t.Parallel()
+
+	ownerQueue := NewQueue(10, nil)
+	ownerStore := NewServiceStore()
+	owner, err := NewP2PService(ownerQueue, 4072, WithServiceStore(ownerStore))
+	require.NoError(t, err)
+	nodeQueue := NewQueue(10, nil)
+	nodeStore := NewServiceStore()
+	node, err := NewP2PService(nodeQueue, 4073, WithServiceStore(nodeStore))
+	require.NoError(t, err)
+
+	go owner.Start(t.Name())
+	go node.Start(t.Name())
+	defer owner.Stop()
+	defer node.Stop()
+
+	time.Sleep(2 * time.Second)
+
+	// the node runs a replica handed to it by the owner that left
+	spec := types.Service{Name: "web", Container: types.Container{Image: "nginx"}, Replicas: 1, Owner: "gone", Version: 1}
+	nodeStore.Apply(spec)
+	require.NoError(t, nodeQueue.EnqueueFrom("replica", spec.ReplicaContainer(), "gone"))
+	require.NoError(t, nodeQueue.EnqueueFrom("job", types.Container{Image: "alpine"}, "gone"))
+
+	// the node learns the new owner and hands the replica over to it
+	spec.Owner = owner.ID()
+	spec.Version++
+	ownerStore.Apply(spec)
+	data, err := json.Marshal(spec)
+	require.NoError(t, err)
+	node.handleServiceSpec(Message{Type: types.P2PMessageTypeServiceSpec, Data: data, From: owner.ID()})
+
+	require.Eventually(t, func() bool {
+		record, ok := ownerQueue.GetJob("replica")
+		return ok && record.Node == node.ID() && record.Status == types.JobStatusPending
+	}, 2*time.Second, 10*time.Millisecond)
+	record, _ := nodeQueue.GetJob("replica")
+	require.Equal(t, owner.ID(), record.Origin)
+
+	// other jobs aren't handed over, and only the owner of a service can adopt its replicas
+	record, _ = nodeQueue.GetJob("job")
+	require.Equal(t, "gone", record.Origin)
+	_, ok := ownerQueue.GetJob("job")
+	require.False(t, ok)
+	replica, err := json.Marshal(types.Job{Container: types.Service{Name: "api"}.ReplicaContainer()})
+	require.NoError(t, err)
+	owner.handleServiceReplica(Message{
+		Type:  types.P2PMessageTypeServiceReplica,
+		JobID: "api",
+		Data:  replica,
+		From:  node.ID(),
+	})
+	_, ok = ownerQueue.GetJob("api")
+	require.False(t, ok)
+}
+
+func TestP2PServiceRejectsUntrustedPeer(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// no calls are expected on the queue of the receiving node
+	jobQueue1 := NewMockQueue(ctrl)
+	jobQueue2 := NewMockQueue(ctrl)
+
+	trust, err := NewPeerTrust([]string{newTestPeerID(t).String()}, nil)
+	require.NoError(t, err)
+
+	service1, err := NewP2PService(jobQueue1, 4046)
+	require.NoError(t, err)
+
+	service2, err := NewP2PService(jobQueue2, 4047, WithPeerTrust(trust))
+	require.NoError(t, err)
+
+	go service1.Start(t.Name())
+	go service2.Start(t.Name())
+
+	time.Sleep(2 * time.Second)
+
+	data, err := json.Marshal(types.Container{Image: "alpine"})
+	require.NoError(t, err)
+	err = service1.Broadcast(Message{
+		JobID: "job-1",
+		Type:  types.P2PMessageTypeDeployContainer,
+		Data:  data,
+	})
+	require.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+	require.Empty(t, service2.host.Network().ConnsToPeer(service1.host.ID()))
+	service1.Stop()
+	service2.Stop()
+}
+
+func TestP2PServiceVerify(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	service, err := NewP2PService(NewMockQueue(ctrl), 4048)
+	require.NoError(t, err)
+	defer service.Stop()
+
+	msg := Message{
+		JobID:  "job-1",
+		Type:   types.P2PMessageTypeDeployContainer,
+		Data:   json.RawMessage(`{"image":"alpine"}`),
+		Client: "alice",
+	}
+	require.NoError(t, service.sign(&msg))
+	require.NoError(t, service.verify(msg, service.host.ID()))
+
+	// the message has to come from the peer that signed it
+	require.ErrorIs(t, service.verify(msg, newTestPeerID(t)), ErrInvalidSignature)
+
+	// tampering with any field invalidates the signature
+	tampered := msg
+	tampered.Client = "mallory"
+	require.ErrorIs(t, service.verify(tampered, service.host.ID()), ErrInvalidSignature)
+}
+
+func TestP2PServicePrivateNetwork(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// no calls are expected on the queue of the node with a different key
+	jobQueue1 := NewMockQueue(ctrl)
+	jobQueue2 := NewMockQueue(ctrl)
+	jobQueue3 := NewMockQueue(ctrl)
+
+	psk := make([]byte, 32)
+	_, err := rand.Read(psk)
+	require.NoError(t, err)
+	otherPSK := make([]byte, 32)
+	_, err = rand.Read(otherPSK)
+	require.NoError(t, err)
+
+	service1, err := NewP2PService(jobQueue1, 4049, WithPrivateNetwork(psk))
+	require.NoError(t, err)
+	service2, err := NewP2PService(jobQueue2, 4050, WithPrivateNetwork(psk))
+	require.NoError(t, err)
+	service3, err := NewP2PService(jobQueue3, 4051, WithPrivateNetwork(otherPSK))
+	require.NoError(t, err)
+
+	go service1.Start(t.Name())
+	go service2.Start(t.Name())
+	go service3.Start(t.Name())
+
+	time.Sleep(2 * time.Second)
+
+	container := types.Container{Image: "alpine"}
+	data, err := json.Marshal(container)
+	require.NoError(t, err)
+
+	jobQueue2.EXPECT().GetStatus("job-1").Times(1).Return(types.JobStatusPending, false)
+	jobQueue2.EXPECT().EnqueueFrom("job-1", container, service1.ID()).Times(1)
+
+	err = service1.Broadcast(Message{
+		JobID: "job-1",
+		Type:  types.P2PMessageTypeDeployContainer,
+		Data:  data,
+	})
+	require.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+	require.NotEmpty(t, service1.host.Network().ConnsToPeer(service2.host.ID()))
+	require.Empty(t, service1.host.Network().ConnsToPeer(service3.host.ID()))
+	service1.Stop()
+	service2.Stop()
+	service3.Stop()
+}
+
+func TestLoadPrivateNetworkKey(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "swarm.key")
+	key := "/key/swarm/psk/1.0.0/\n/base16/\n" + strings.Repeat("ab", 32) + "\n"
+	require.NoError(t, os.WriteFile(path, []byte(key), 0o600))
+
+	psk, err := LoadPrivateNetworkKey(path)
+	require.NoError(t, err)
+	require.Len(t, psk, 32)
+
+	_, err = LoadPrivateNetworkKey(filepath.Join(t.TempDir(), "missing.key"))
+	require.Error(t, err)
+}
+
 func TestGetHostIP(t *testing.T) {
 	t.Parallel()
 	ip, err := getHostIP()
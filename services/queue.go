@@ -2,8 +2,12 @@ package services
 
 import (
 	"container-manager/types"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -11,6 +15,27 @@ import (
 var (
 	// ErrQueueFull is the error returned when the queue is full
 	ErrQueueFull = fmt.Errorf("job queue is full")
+	// ErrJobNotFound is the error returned when a job isn't known to the node
+	ErrJobNotFound = fmt.Errorf("job not found")
+	// ErrJobNotActive is the error returned when cancelling a job that isn't pending or running
+	ErrJobNotActive = fmt.Errorf("job is not pending or running")
+)
+
+const (
+	// monitorInterval is the interval between checks of the containers of monitored jobs
+	monitorInterval = 5 * time.Second
+	// restartBackoff is the delay before the first restart of a crashing container, doubled on every crash
+	restartBackoff = time.Second
+	// maxRestartBackoff is the longest delay before a crashing container is restarted
+	maxRestartBackoff = 5 * time.Minute
+	// crashWindow is how long a container must run before it stopping doesn't count as a crash
+	crashWindow = time.Minute
+	// DefaultCrashLoopThreshold is the default number of consecutive crashes before a job fails
+	DefaultCrashLoopThreshold = 5
+	// exitCodeUnknown is the exit code of failures without an exited container
+	exitCodeUnknown = -1
+	// remoteJobRetention is how long the records of jobs of other nodes are kept once they ended
+	remoteJobRetention = time.Hour
 )
 
 // job is the object that represents a job to be run.
@@ -21,51 +46,179 @@ type job struct {
 	container types.Container
 }
 
+// QueueStats is a snapshot of the load of the job queue.
+// Workers: The number of workers
+// BusyWorkers: The number of workers running a job
+// Depth: The number of jobs waiting in the queue
+// Size: The maximum number of jobs the queue holds
+type QueueStats struct {
+	Workers     int
+	BusyWorkers int
+	Depth       int
+	Size        int
+}
+
 // Queue is the interface that represents a job queue.
 // Enqueue: Enqueues a job to be run
+// EnqueueFrom: Enqueues a job handed to the node by another node
 // GetStatus: Gets the status of a job
+// GetJob: Gets the record of a job
+// Track: Records a job owned by another node
+// UpdateRemote: Applies the status reported by the node a job was handed to
+// Cancel: Cancels a job owned by the node
+// Jobs: Gets the records of all jobs known to the node
+// Stats: Gets the load of the queue
+// ContainerStats: Gets a sample of the resource use of the container of a job running on the node
+// StreamContainerStats: Streams samples of the resource use of the container of a job running on the node
+// CopyToJob: Copies an archive into the container of a job of the node, or adds it to the inputs of a pending job
+// CopyFromJob: Copies a path out of the container of a job of the node
 // Run: Runs the job queue
 // Stop: Stops the job queue
 type Queue interface {
 	Enqueue(jobID string, container types.Container) error
+	EnqueueFrom(jobID string, container types.Container, origin string) error
 	GetStatus(jobID string) (types.JobStatus, bool)
+	GetJob(jobID string) (types.Job, bool)
+	Track(job types.Job)
+	UpdateRemote(update types.JobStatusUpdate) bool
+	HandOver(jobID string, origin string) (types.Job, bool)
+	Cancel(jobID string) error
+	Jobs() []types.Job
+	Stats() QueueStats
+	ContainerStats(ctx context.Context, jobID string) (types.ContainerStats, error)
+	StreamContainerStats(ctx context.Context, jobID string) (<-chan types.ContainerStats, <-chan error, error)
+	CopyToJob(ctx context.Context, jobID string, target string, archive []byte) error
+	CopyFromJob(ctx context.Context, jobID string, target string) (io.ReadCloser, error)
 	Run(workerCount int)
 	Stop()
 }
 
+// QueueOption configures optional behaviour of the job queue
+type QueueOption func(*QueueHandler)
+
+// WithRetries sets the number of times a job without a restart policy is restarted after its container
+// failed to deploy, stopped or became unhealthy
+func WithRetries(retries int) QueueOption {
+	return func(q *QueueHandler) {
+		q.retries = retries
+	}
+}
+
+// WithCrashLoopThreshold sets the number of consecutive crashes of the containers of a job before it fails
+func WithCrashLoopThreshold(threshold int) QueueOption {
+	return func(q *QueueHandler) {
+		q.crashLoopThreshold = threshold
+	}
+}
+
+// WithArtifacts sets the store the outputs of containers are collected into and their inputs fetched into
+func WithArtifacts(store *ArtifactStore) QueueOption {
+	return func(q *QueueHandler) {
+		q.artifacts = store
+	}
+}
+
+// WithMaxOutputSize sets the size limit of the archive of each output collected from a container,
+// DefaultMaxCopySize when zero
+func WithMaxOutputSize(size int64) QueueOption {
+	return func(q *QueueHandler) {
+		if size > 0 {
+			q.maxOutputSize = size
+		}
+	}
+}
+
+// WithNodeID sets the ID of the node the queue runs jobs for
+func WithNodeID(nodeID string) QueueOption {
+	return func(q *QueueHandler) {
+		q.nodeID = nodeID
+	}
+}
+
 // QueueHandler is the implementation of the job queue interface.
 // jobs: The queue of jobs to be run
-// jobStatus: The status of each job
-// mutex: The mutex to protect the job status
+// jobRecords: The record of each job known to the node
+// mutex: The mutex to protect the job records
 // wg: The wait group to wait for all workers to finish
 // quit: The channel to signal workers to quit
+// nodeID: The ID of the node the queue runs jobs for
+// workers: The number of workers
+// busyWorkers: The number of workers running a job
+// listeners: The functions called when the status of a local job changes
+// monitorOnce: Starts the monitor of running jobs once
+// retries: The number of times a failed job without a restart policy is restarted
+// crashLoopThreshold: The number of consecutive crashes before a job fails
+// backoff: The delay before the first restart of a crashing container
+// artifacts: The store of the outputs and inputs of containers
+// maxOutputSize: The size limit of the archive of each output, jobs with a larger output fail
+// ctx: The context of the Docker calls, cancelled when the queue stops
+// stop: Cancels the context of the queue
+// deploys: Cancels the deploy of each job being deployed
+// claims: The containers whose stop is being handled, so a stop reported twice is handled once
+// usage: The resource use of the containers of running jobs by container ID, from the samples of their stats
+// ended: The time the jobs of other nodes ended by job ID, their records are pruned after remoteJobRetention
 type QueueHandler struct {
-	jobs          chan job
-	jobStatus     map[string]types.JobStatus
-	mutex         sync.Mutex
-	wg            sync.WaitGroup
-	quit          chan bool
-	dockerService DockerService
+	jobs               chan job
+	jobRecords         map[string]*types.Job
+	mutex              sync.Mutex
+	wg                 sync.WaitGroup
+	quit               chan bool
+	dockerService      DockerService
+	nodeID             string
+	workers            int
+	busyWorkers        int
+	listeners          []func(types.Job)
+	monitorOnce        sync.Once
+	retries            int
+	crashLoopThreshold int
+	backoff            time.Duration
+	artifacts          *ArtifactStore
+	maxOutputSize      int64
+	ctx                context.Context
+	stop               context.CancelFunc
+	deploys            map[string]context.CancelFunc
+	claims             map[string]struct{}
+	usage              map[string]types.ResourceUsage
+	ended              map[string]time.Time
 }
 
 // NewQueue creates a new job queue.
-func NewQueue(size int, ds DockerService) *QueueHandler {
-	return &QueueHandler{
-		jobs:          make(chan job, size),
-		jobStatus:     make(map[string]types.JobStatus),
-		quit:          make(chan bool),
-		dockerService: ds,
+func NewQueue(size int, ds DockerService, opts ...QueueOption) *QueueHandler {
+	ctx, stop := context.WithCancel(context.Background())
+	q := &QueueHandler{
+		jobs:               make(chan job, size),
+		jobRecords:         make(map[string]*types.Job),
+		quit:               make(chan bool),
+		dockerService:      ds,
+		crashLoopThreshold: DefaultCrashLoopThreshold,
+		backoff:            restartBackoff,
+		maxOutputSize:      DefaultMaxCopySize,
+		ctx:                ctx,
+		stop:               stop,
+		deploys:            make(map[string]context.CancelFunc),
+		claims:             make(map[string]struct{}),
+		usage:              make(map[string]types.ResourceUsage),
+		ended:              make(map[string]time.Time),
 	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	return q
 }
 
 // Enqueue enqueues a job to be run.
 func (q *QueueHandler) Enqueue(jobID string, container types.Container) error {
+	return q.EnqueueFrom(jobID, container, "")
+}
+
+// EnqueueFrom enqueues a job handed to the node by the origin node, which the status of the job is reported to.
+func (q *QueueHandler) EnqueueFrom(jobID string, container types.Container, origin string) error {
 	q.mutex.Lock()
-	defer q.mutex.Unlock()
 
 	logrus.WithField("job_id", jobID).Debug("enqueuing job")
 
 	if len(q.jobs) == cap(q.jobs) {
+		q.mutex.Unlock()
 		return ErrQueueFull
 	}
 
@@ -74,7 +227,18 @@ func (q *QueueHandler) Enqueue(jobID string, container types.Container) error {
 		container: container,
 	}
 	q.jobs <- jobToQueue
-	q.jobStatus[jobToQueue.id] = types.JobStatusPending
+	record := &types.Job{
+		ID:        jobID,
+		Container: container,
+		Status:    types.JobStatusPending,
+		Node:      q.nodeID,
+		Origin:    origin,
+	}
+	q.jobRecords[jobID] = record
+	snapshot := *record
+	q.mutex.Unlock()
+
+	q.notify(snapshot)
 	return nil
 }
 
@@ -83,8 +247,173 @@ func (q *QueueHandler) GetStatus(jobID string) (types.JobStatus, bool) {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
 
-	status, exists := q.jobStatus[jobID]
-	return status, exists
+	record, exists := q.jobRecords[jobID]
+	if !exists {
+		return "", false
+	}
+	return record.Status, true
+}
+
+// GetJob gets the record of a job.
+func (q *QueueHandler) GetJob(jobID string) (types.Job, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	record, exists := q.jobRecords[jobID]
+	if !exists {
+		return types.Job{}, false
+	}
+	return *record, true
+}
+
+// Track records a job owned by another node. Records of jobs owned by this node are never overwritten.
+func (q *QueueHandler) Track(job types.Job) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if record, exists := q.jobRecords[job.ID]; exists && record.Node == q.nodeID {
+		return
+	}
+	if job.Node == q.nodeID {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"job_id": job.ID,
+		"node":   job.Node,
+		"status": job.Status,
+	}).Trace("tracking remote job")
+	q.jobRecords[job.ID] = &job
+	q.trackEnd(job)
+}
+
+// HandOver rewrites the origin of a job of this node, so its status is reported to that node from now on.
+// It returns the record of the job, and false if the job isn't a job of this node.
+func (q *QueueHandler) HandOver(jobID string, origin string) (types.Job, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	record, exists := q.jobRecords[jobID]
+	if !exists || record.Node != q.nodeID {
+		return types.Job{}, false
+	}
+	record.Origin = origin
+	return *record, true
+}
+
+// UpdateRemote applies the status reported by the node that owns a job handed to it. Updates of jobs the node
+// didn't hand over, or from another node than the one that owns the job, are ignored.
+func (q *QueueHandler) UpdateRemote(update types.JobStatusUpdate) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	record, exists := q.jobRecords[update.ID]
+	if !exists || record.Node == q.nodeID || record.Node != update.Node {
+		return false
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"job_id": update.ID,
+		"node":   update.Node,
+		"status": update.Status,
+	}).Trace("updating remote job")
+	record.Status = update.Status
+	record.ExitCode = update.ExitCode
+	record.Health = update.Health
+	record.Restarts = update.Restarts
+	record.Artifacts = update.Artifacts
+	record.Usage = update.Usage
+	q.trackEnd(*record)
+	return true
+}
+
+// trackEnd records when a job of another node ended, so its record is pruned once it was kept long enough
+func (q *QueueHandler) trackEnd(job types.Job) {
+	if job.Status.Active() {
+		delete(q.ended, job.ID)
+		return
+	}
+	if _, ok := q.ended[job.ID]; !ok {
+		q.ended[job.ID] = time.Now()
+	}
+}
+
+// pruneRemoteJobs removes the records of the jobs of other nodes that ended more than remoteJobRetention ago
+func (q *QueueHandler) pruneRemoteJobs() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for jobID, endedAt := range q.ended {
+		if time.Since(endedAt) < remoteJobRetention {
+			continue
+		}
+		delete(q.ended, jobID)
+		if record, exists := q.jobRecords[jobID]; exists && record.Node != q.nodeID && !record.Status.Active() {
+			delete(q.jobRecords, jobID)
+		}
+	}
+}
+
+// Cancel cancels a job owned by the node. Pending jobs are skipped by the workers,
+// the container of a running job is stopped and removed.
+func (q *QueueHandler) Cancel(jobID string) error {
+	q.mutex.Lock()
+	record, exists := q.jobRecords[jobID]
+	if !exists || record.Node != q.nodeID {
+		q.mutex.Unlock()
+		return ErrJobNotFound
+	}
+	if !record.Status.Active() {
+		q.mutex.Unlock()
+		return ErrJobNotActive
+	}
+	record.Status = types.JobStatusCancelled
+	q.endUsage(record)
+	snapshot := *record
+	if cancelDeploy, ok := q.deploys[jobID]; ok {
+		cancelDeploy()
+	}
+	q.mutex.Unlock()
+
+	logrus.WithField("job_id", jobID).Info("cancelled job")
+	q.notify(snapshot)
+	if snapshot.ContainerID != "" {
+		q.removeContainer(snapshot.ContainerID)
+	}
+	return nil
+}
+
+// Jobs gets the records of all jobs known to the node.
+func (q *QueueHandler) Jobs() []types.Job {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	jobs := make([]types.Job, 0, len(q.jobRecords))
+	for _, record := range q.jobRecords {
+		jobs = append(jobs, *record)
+	}
+	return jobs
+}
+
+// Stats gets the load of the queue.
+func (q *QueueHandler) Stats() QueueStats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return QueueStats{
+		Workers:     q.workers,
+		BusyWorkers: q.busyWorkers,
+		Depth:       len(q.jobs),
+		Size:        cap(q.jobs),
+	}
+}
+
+// OnStatusChange registers a function called with the record of a local job whenever its status changes.
+func (q *QueueHandler) OnStatusChange(listener func(types.Job)) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.listeners = append(q.listeners, listener)
 }
 
 // worker runs the jobs in the job queue.
@@ -95,55 +424,477 @@ func (q *QueueHandler) worker() {
 		select {
 		case newJob := <-q.jobs:
 			logrus.WithField("job_id", newJob.id).Info("running job")
+			q.setBusy(1)
 			q.executeJob(newJob)
+			q.setBusy(-1)
 		case <-q.quit:
 			return
 		}
 	}
 }
 
+// setBusy adjusts the number of busy workers.
+func (q *QueueHandler) setBusy(delta int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.busyWorkers += delta
+}
+
 // Run runs the job queue.
 func (q *QueueHandler) Run(workerCount int) {
+	q.mutex.Lock()
+	q.workers += workerCount
+	q.mutex.Unlock()
+
 	for i := 0; i < workerCount; i++ {
 		q.wg.Add(1)
 		go q.worker()
 	}
+
+	q.monitorOnce.Do(func() {
+		q.wg.Add(1)
+		go q.monitor()
+		if source, ok := q.dockerService.(EventSource); ok {
+			q.wg.Add(1)
+			go q.watchEvents(source)
+		}
+		if source, ok := q.dockerService.(StatsSource); ok {
+			q.wg.Add(1)
+			go q.watchStats(source)
+		}
+	})
 }
 
-// Stop stops the job queue.
+// Stop stops the job queue, the Docker calls in progress are interrupted.
 func (q *QueueHandler) Stop() {
+	q.stop()
 	close(q.quit)
 	q.wg.Wait()
 }
 
 // executeJob executes a job.
 func (q *QueueHandler) executeJob(job job) {
-	// TODO: retry mechanism
-	// If a container is deployed and the status is not running, we need to remove the container and retry
-	// If the container is deployed, and the health check times out, we need to stop, remove the container and retry
-	// We also need to keep track of the number of retries and stop after a certain number of retries
-	containerID, err := q.dockerService.DeployContainer(job.container)
+	if status, _ := q.GetStatus(job.id); status == types.JobStatusCancelled {
+		logrus.WithField("job_id", job.id).Info("skipping cancelled job")
+		return
+	}
+	if job.container.Expired(time.Now()) {
+		q.timeOut(job.id, "", "the deadline of the job passed before it started")
+		return
+	}
+
+	ctx, done := q.startDeploy(job.id)
+	defer done()
+
+	// archives copied to the job while it was pending are inputs of its record
+	managed := types.Job{ID: job.id}
+	if record, exists := q.GetJob(job.id); exists {
+		job.container.Inputs = record.Container.Inputs
+		managed.Origin = record.Origin
+		managed.RunTime = record.RunTime
+	}
+	if err := q.fetchInputs(job.container); err != nil {
+		q.restart(job.id, "", exitCodeUnknown, err.Error())
+		return
+	}
+
+	// the labels link the container back to its job if the node restarts while it runs
+	managed.Container = job.container
+	containerID, err := q.dockerService.DeployContainer(ctx, managed.Managed(q.nodeID, time.Now()))
+	if err != nil && ctx.Err() != nil {
+		logrus.WithField("job_id", job.id).Infof("deploy interrupted: %v", err)
+		return
+	}
+	if errors.Is(err, ErrDeployTimeout) {
+		q.timeOut(job.id, "", err.Error())
+		return
+	}
 	if err != nil {
-		logrus.WithField("job_id", job.id).Errorf("failed to deploy container: %v", err)
+		q.restart(job.id, "", exitCodeUnknown, fmt.Sprintf("failed to deploy container: %v", err))
+		return
+	}
+	if !q.setContainerID(job.id, containerID) {
+		// the job was cancelled while its container was deployed
+		q.removeContainer(containerID)
+		return
+	}
+
+	status, err := q.dockerService.GetContainerStatus(ctx, containerID)
+	switch {
+	case err != nil:
+		q.restart(job.id, containerID, exitCodeUnknown, fmt.Sprintf("failed to get container status: %v", err))
+		return
+	case status != "running":
+		q.restart(job.id, containerID, q.exitCode(containerID, status), fmt.Sprintf("container is %s after deploy", status))
+		return
+	case job.container.HealthCheck != nil:
+		// the job runs until the health check decides whether the container is healthy
+		if record, ok := q.setHealth(job.id, containerID, types.JobStatusRunning, types.HealthStatusStarting); ok {
+			q.wg.Add(1)
+			go q.watchHealth(record)
+		}
+	case (types.Job{Container: job.container}).Monitored():
+		q.updateJobStatus(job.id, types.JobStatusRunning)
+	default:
+		q.updateJobStatus(job.id, types.JobStatusComplete)
+	}
+	logrus.WithField("job_id", job.id).Infof("container deployed successfully")
+}
+
+// startDeploy returns the context of the deploy of a job, cancelled when the job is cancelled or the queue stops,
+// and the function that ends the deploy
+func (q *QueueHandler) startDeploy(jobID string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(q.ctx)
+
+	q.mutex.Lock()
+	q.deploys[jobID] = cancel
+	q.mutex.Unlock()
+
+	return ctx, func() {
+		q.mutex.Lock()
+		delete(q.deploys, jobID)
+		q.mutex.Unlock()
+		cancel()
+	}
+}
+
+// restart removes the container of a job that stopped or failed, and restarts the job with a backoff
+// when its restart policy allows it. Jobs without a restart policy are restarted after failures until
+// they run out of retries. Failures of a container the job doesn't run anymore are ignored.
+func (q *QueueHandler) restart(jobID string, containerID string, exitCode int, reason string) {
+	if containerID != "" {
+		if !q.claim(containerID) {
+			return
+		}
+		defer q.release(containerID)
+	}
+
+	var artifacts []types.Artifact
+	// outputs over the size limit would be as large after a restart
+	oversized := false
+	if containerID != "" && exitCode == 0 {
+		collected, err := q.collectOutputs(jobID, containerID)
+		if err != nil {
+			exitCode = exitCodeUnknown
+			reason = err.Error()
+			oversized = errors.Is(err, ErrCopyTooLarge)
+		}
+		artifacts = collected
+	}
+	if containerID != "" {
+		q.sampleContainer(containerID)
+		q.removeContainer(containerID)
+	}
+
+	q.mutex.Lock()
+	record, exists := q.jobRecords[jobID]
+	if !exists || record.Status == types.JobStatusCancelled || record.ContainerID != containerID {
+		q.mutex.Unlock()
+		return
+	}
+	q.endUsage(record)
+
+	if containerID != "" {
+		record.RunTime += types.Duration(time.Since(record.StartedAt))
+	}
+	record.ContainerID = ""
+	record.Health = ""
+	if exitCode != exitCodeUnknown {
+		record.ExitCode = exitCode
+	}
+	if artifacts != nil {
+		record.Artifacts = artifacts
+	}
+	if !record.StartedAt.IsZero() && time.Since(record.StartedAt) >= crashWindow {
+		record.Crashes = 0
+	}
+
+	// containers of jobs without a restart policy aren't expected to exit, unless they run until their run timeout
+	policy := record.Container.RestartPolicy
+	failed := exitCode != 0 || (policy == nil && record.Container.RunTimeout() == 0)
+	restart := failed && record.Restarts < q.retries
+	if policy != nil {
+		restart = policy.Restarts(failed, record.Restarts)
+	}
+	restart = restart && !oversized
+
+	logger := logrus.WithFields(logrus.Fields{
+		"job_id":   jobID,
+		"restarts": record.Restarts,
+		"crashes":  record.Crashes,
+	})
+	switch {
+	case !restart && !failed:
+		logger.Infof("job completed: %s", reason)
+		record.Status = types.JobStatusComplete
+	case !restart:
+		logger.Errorf("job failed: %s", reason)
+		record.Status = types.JobStatusFailed
+	case record.Crashes >= q.crashLoopThreshold:
+		logger.Errorf("job failed, the container is crash looping: %s", reason)
+		record.Status = types.JobStatusFailed
+	default:
+		delay := min(q.backoff<<record.Crashes, maxRestartBackoff)
+		logger.Warnf("restarting job in %s: %s", delay, reason)
+		record.Crashes++
+		record.Restarts++
+		record.Status = types.JobStatusPending
+		q.wg.Add(1)
+		go q.requeue(job{id: jobID, container: record.Container}, delay)
+	}
+	snapshot := *record
+	q.mutex.Unlock()
+
+	q.notify(snapshot)
+}
+
+// timeOut kills and removes the container of a job that hit one of its time limits, the job times out
+// without being restarted. Timeouts of a container the job doesn't run anymore are ignored.
+func (q *QueueHandler) timeOut(jobID string, containerID string, reason string) {
+	if containerID != "" {
+		if !q.claim(containerID) {
+			return
+		}
+		defer q.release(containerID)
+
+		q.sampleContainer(containerID)
+
+		// the container is killed even while the queue stops, so it doesn't outlive its limit
+		ctx := context.WithoutCancel(q.ctx)
+		if err := q.dockerService.KillContainer(ctx, containerID); err != nil {
+			logrus.WithField("container_id", containerID).Warnf("failed to kill container: %v", err)
+		}
+		if err := q.dockerService.RemoveContainer(ctx, containerID); err != nil {
+			logrus.WithField("container_id", containerID).Warnf("failed to remove container: %v", err)
+		}
+	}
+
+	q.mutex.Lock()
+	record, exists := q.jobRecords[jobID]
+	if !exists || record.Status == types.JobStatusCancelled || record.ContainerID != containerID {
+		q.mutex.Unlock()
+		return
+	}
+	q.endUsage(record)
+	record.ContainerID = ""
+	record.Health = ""
+	record.Status = types.JobStatusTimedOut
+	snapshot := *record
+	q.mutex.Unlock()
+
+	logrus.WithField("job_id", jobID).Errorf("job timed out: %s", reason)
+	q.notify(snapshot)
+}
+
+// claim marks the stop of a container as handled by the caller, it returns false if it is handled already
+func (q *QueueHandler) claim(containerID string) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if _, claimed := q.claims[containerID]; claimed {
+		return false
+	}
+	q.claims[containerID] = struct{}{}
+	return true
+}
+
+// release ends the handling of the stop of a container
+func (q *QueueHandler) release(containerID string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	delete(q.claims, containerID)
+}
+
+// requeue enqueues a job again after a delay, the job fails if the queue is full.
+func (q *QueueHandler) requeue(job job, delay time.Duration) {
+	defer q.wg.Done()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-q.quit:
+		return
+	}
+
+	select {
+	case q.jobs <- job:
+	default:
+		logrus.WithField("job_id", job.id).Error("job failed, the queue is full to restart it")
 		q.updateJobStatus(job.id, types.JobStatusFailed)
-	} else {
-		status, err := q.dockerService.GetContainerStatus(containerID)
-		switch {
-		case err != nil:
-			q.updateJobStatus(job.id, types.JobStatusFailed)
-		case status == "running":
-			q.updateJobStatus(job.id, types.JobStatusComplete)
-		default:
-			q.updateJobStatus(job.id, types.JobStatusFailed)
+	}
+}
+
+// fetchInputs fetches the input artifacts of a container from the nodes that hold them
+func (q *QueueHandler) fetchInputs(container types.Container) error {
+	if len(container.Inputs) == 0 {
+		return nil
+	}
+	if q.artifacts == nil {
+		return fmt.Errorf("artifacts are not enabled")
+	}
+
+	for _, input := range container.Inputs {
+		if err := q.artifacts.Ensure(input); err != nil {
+			return fmt.Errorf("failed to fetch input %s: %w", input.Path, err)
 		}
-		logrus.WithField("job_id", job.id).Infof("container deployed successfully")
 	}
+	return nil
 }
 
-// updateJobStatus updates the status of a job.
-func (q *QueueHandler) updateJobStatus(jobID string, status types.JobStatus) {
+// collectOutputs copies the outputs of a job out of its container into the artifact store
+func (q *QueueHandler) collectOutputs(jobID string, containerID string) ([]types.Artifact, error) {
+	record, exists := q.GetJob(jobID)
+	if !exists || len(record.Container.Outputs) == 0 {
+		return nil, nil
+	}
+	if q.artifacts == nil {
+		return nil, fmt.Errorf("artifacts are not enabled")
+	}
+
+	artifacts := make([]types.Artifact, 0, len(record.Container.Outputs))
+	for _, output := range record.Container.Outputs {
+		archive, err := q.dockerService.CopyFromContainer(q.ctx, containerID, output.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to collect output %q: %w", output.Name, err)
+		}
+		digest, size, err := q.artifacts.Put(&sizeLimitReader{reader: archive, remaining: q.maxOutputSize})
+		archive.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to collect output %q: %w", output.Name, err)
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"job_id": jobID,
+			"output": output.Name,
+			"digest": digest,
+		}).Info("collected output")
+		artifacts = append(artifacts, types.Artifact{
+			Name:   output.Name,
+			Digest: digest,
+			Size:   size,
+			Node:   q.nodeID,
+		})
+	}
+	return artifacts, nil
+}
+
+// exitCode returns the exit code of a container that exited, or exitCodeUnknown.
+func (q *QueueHandler) exitCode(containerID string, status string) int {
+	if status != "exited" {
+		return exitCodeUnknown
+	}
+
+	info, err := q.dockerService.InspectContainer(q.ctx, containerID)
+	if err != nil {
+		logrus.WithField("container_id", containerID).Warnf("failed to inspect container: %v", err)
+		return exitCodeUnknown
+	}
+	return info.ExitCode
+}
+
+// setContainerID records the container of a job, it returns false if the job was cancelled.
+func (q *QueueHandler) setContainerID(jobID string, containerID string) bool {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
 
-	q.jobStatus[jobID] = status
+	record, exists := q.jobRecords[jobID]
+	if !exists || record.Status == types.JobStatusCancelled {
+		return false
+	}
+	record.ContainerID = containerID
+	record.StartedAt = time.Now()
+	return true
+}
+
+// monitor periodically checks the containers of running jobs, and restarts the jobs whose container stopped.
+// It also prunes the records of the jobs of other nodes that ended.
+func (q *QueueHandler) monitor() {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(monitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			q.checkRunningJobs()
+			q.pruneRemoteJobs()
+		case <-q.quit:
+			return
+		}
+	}
+}
+
+// checkRunningJobs restarts the running jobs whose container isn't running anymore,
+// and times out the jobs whose containers ran longer than their run timeout.
+func (q *QueueHandler) checkRunningJobs() {
+	q.mutex.Lock()
+	var running []types.Job
+	for _, record := range q.jobRecords {
+		if record.Node == q.nodeID && record.Status == types.JobStatusRunning {
+			running = append(running, *record)
+		}
+	}
+	q.mutex.Unlock()
+
+	for _, job := range running {
+		// the run timeout covers the containers of the job before its restarts
+		if timeout := job.Container.RunTimeout(); timeout > 0 && job.RunningFor() > timeout {
+			q.timeOut(job.ID, job.ContainerID, fmt.Sprintf("container ran longer than %s", timeout))
+			continue
+		}
+
+		status, err := q.dockerService.GetContainerStatus(q.ctx, job.ContainerID)
+		if err == nil && status == "running" {
+			continue
+		}
+
+		if err != nil {
+			q.restart(job.ID, job.ContainerID, exitCodeUnknown, fmt.Sprintf("failed to get container status: %v", err))
+			continue
+		}
+		q.restart(job.ID, job.ContainerID, q.exitCode(job.ContainerID, status), fmt.Sprintf("container is %s", status))
+	}
+}
+
+// removeContainer stops and removes a container, failures are logged. The removal isn't interrupted
+// when the queue stops, so the container isn't left behind.
+func (q *QueueHandler) removeContainer(containerID string) {
+	ctx := context.WithoutCancel(q.ctx)
+	if err := q.dockerService.StopContainer(ctx, containerID); err != nil {
+		logrus.WithField("container_id", containerID).Warnf("failed to stop container: %v", err)
+	}
+	if err := q.dockerService.RemoveContainer(ctx, containerID); err != nil {
+		logrus.WithField("container_id", containerID).Warnf("failed to remove container: %v", err)
+	}
+}
+
+// updateJobStatus updates the status of a job. Cancelled jobs keep their status.
+func (q *QueueHandler) updateJobStatus(jobID string, status types.JobStatus) {
+	q.mutex.Lock()
+	record, exists := q.jobRecords[jobID]
+	if !exists || record.Status == types.JobStatusCancelled {
+		q.mutex.Unlock()
+		return
+	}
+	record.Status = status
+	snapshot := *record
+	q.mutex.Unlock()
+
+	q.notify(snapshot)
+}
+
+// notify calls the status listeners with the record of a job.
+func (q *QueueHandler) notify(job types.Job) {
+	q.mutex.Lock()
+	listeners := append([]func(types.Job){}, q.listeners...)
+	q.mutex.Unlock()
+
+	for _, listener := range listeners {
+		listener(job)
+	}
 }
@@ -11,6 +11,8 @@ package services
 
 import (
 	types "container-manager/types"
+	context "context"
+	io "io"
 	reflect "reflect"
 
 	gomock "go.uber.org/mock/gomock"
@@ -75,6 +77,218 @@ func (mr *MockQueueMockRecorder) GetStatus(jobID any) *gomock.Call {
 		jobID)
 }
 
+// GetJob mocks base method.
+func (m *MockQueue) GetJob(jobID string) (types.Job, bool) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetJob", jobID)
+	ret0, _ := ret[0].(types.Job)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+// GetJob indicates an expected call of GetJob.
+func (mr *MockQueueMockRecorder) GetJob(jobID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock,
+		"GetJob",
+		reflect.TypeOf((*MockQueue)(nil).GetJob),
+		jobID)
+}
+
+// EnqueueFrom mocks base method.
+func (m *MockQueue) EnqueueFrom(jobID string, container types.Container, origin string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EnqueueFrom", jobID, container, origin)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EnqueueFrom indicates an expected call of EnqueueFrom.
+func (mr *MockQueueMockRecorder) EnqueueFrom(jobID, container, origin any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock,
+		"EnqueueFrom",
+		reflect.TypeOf((*MockQueue)(nil).EnqueueFrom),
+		jobID, container, origin)
+}
+
+// UpdateRemote mocks base method.
+func (m *MockQueue) UpdateRemote(update types.JobStatusUpdate) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateRemote", update)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// UpdateRemote indicates an expected call of UpdateRemote.
+func (mr *MockQueueMockRecorder) UpdateRemote(update any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock,
+		"UpdateRemote",
+		reflect.TypeOf((*MockQueue)(nil).UpdateRemote),
+		update)
+}
+
+// HandOver mocks base method.
+func (m *MockQueue) HandOver(jobID string, origin string) (types.Job, bool) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HandOver", jobID, origin)
+	ret0, _ := ret[0].(types.Job)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+// HandOver indicates an expected call of HandOver.
+func (mr *MockQueueMockRecorder) HandOver(jobID, origin any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock,
+		"HandOver",
+		reflect.TypeOf((*MockQueue)(nil).HandOver),
+		jobID, origin)
+}
+
+// Track mocks base method.
+func (m *MockQueue) Track(job types.Job) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Track", job)
+}
+
+// Track indicates an expected call of Track.
+func (mr *MockQueueMockRecorder) Track(job any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock,
+		"Track",
+		reflect.TypeOf((*MockQueue)(nil).Track),
+		job)
+}
+
+// Cancel mocks base method.
+func (m *MockQueue) Cancel(jobID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Cancel", jobID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Cancel indicates an expected call of Cancel.
+func (mr *MockQueueMockRecorder) Cancel(jobID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock,
+		"Cancel",
+		reflect.TypeOf((*MockQueue)(nil).Cancel),
+		jobID)
+}
+
+// Jobs mocks base method.
+func (m *MockQueue) Jobs() []types.Job {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Jobs")
+	ret0, _ := ret[0].([]types.Job)
+	return ret0
+}
+
+// Jobs indicates an expected call of Jobs.
+func (mr *MockQueueMockRecorder) Jobs() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Jobs", reflect.TypeOf((*MockQueue)(nil).Jobs))
+}
+
+// Stats mocks base method.
+func (m *MockQueue) Stats() QueueStats {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stats")
+	ret0, _ := ret[0].(QueueStats)
+	return ret0
+}
+
+// Stats indicates an expected call of Stats.
+func (mr *MockQueueMockRecorder) Stats() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stats", reflect.TypeOf((*MockQueue)(nil).Stats))
+}
+
+// ContainerStats mocks base method.
+func (m *MockQueue) ContainerStats(ctx context.Context, jobID string) (types.ContainerStats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ContainerStats", ctx, jobID)
+	ret0, _ := ret[0].(types.ContainerStats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ContainerStats indicates an expected call of ContainerStats.
+func (mr *MockQueueMockRecorder) ContainerStats(ctx, jobID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock,
+		"ContainerStats",
+		reflect.TypeOf((*MockQueue)(nil).ContainerStats),
+		ctx,
+		jobID)
+}
+
+// CopyToJob mocks base method.
+func (m *MockQueue) CopyToJob(ctx context.Context, jobID, target string, archive []byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CopyToJob", ctx, jobID, target, archive)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CopyToJob indicates an expected call of CopyToJob.
+func (mr *MockQueueMockRecorder) CopyToJob(ctx, jobID, target, archive any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock,
+		"CopyToJob",
+		reflect.TypeOf((*MockQueue)(nil).CopyToJob),
+		ctx,
+		jobID,
+		target,
+		archive)
+}
+
+// CopyFromJob mocks base method.
+func (m *MockQueue) CopyFromJob(ctx context.Context, jobID, target string) (io.ReadCloser, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CopyFromJob", ctx, jobID, target)
+	ret0, _ := ret[0].(io.ReadCloser)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CopyFromJob indicates an expected call of CopyFromJob.
+func (mr *MockQueueMockRecorder) CopyFromJob(ctx, jobID, target any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock,
+		"CopyFromJob",
+		reflect.TypeOf((*MockQueue)(nil).CopyFromJob),
+		ctx,
+		jobID,
+		target)
+}
+
+// StreamContainerStats mocks base method.
+func (m *MockQueue) StreamContainerStats(
+	ctx context.Context,
+	jobID string,
+) (<-chan types.ContainerStats, <-chan error, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StreamContainerStats", ctx, jobID)
+	ret0, _ := ret[0].(<-chan types.ContainerStats)
+	ret1, _ := ret[1].(<-chan error)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// StreamContainerStats indicates an expected call of StreamContainerStats.
+func (mr *MockQueueMockRecorder) StreamContainerStats(ctx, jobID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock,
+		"StreamContainerStats",
+		reflect.TypeOf((*MockQueue)(nil).StreamContainerStats),
+		ctx,
+		jobID)
+}
+
 // Run mocks base method.
 func (m *MockQueue) Run(workerCount int) {
 	m.ctrl.T.Helper()
@@ -1,9 +1,15 @@
 package services
 
 import (
+	"bytes"
 	"container-manager/types"
+	"context"
+	"encoding/json"
 	"fmt"
 	"go.uber.org/mock/gomock"
+	"io"
+	"maps"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
@@ -11,6 +17,29 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// deployOf matches the container of a job as deployed by the queue, with the labels that link it back to the job
+func deployOf(container types.Container) gomock.Matcher {
+	spec, _ := json.Marshal(container)
+	return gomock.Cond(func(x any) bool {
+		deployed, ok := x.(types.Container)
+		if !ok || deployed.Labels[types.ManagedJobLabel] == "" || deployed.Labels[types.ManagedSpecLabel] != string(spec) {
+			return false
+		}
+		labels := maps.Clone(deployed.Labels)
+		for _, label := range []string{
+			types.ManagedJobLabel, types.ManagedNodeLabel, types.ManagedVersionLabel, types.ManagedSpecLabel,
+			types.ManagedOriginLabel, types.ManagedRunTimeLabel, types.ManagedDeployedLabel,
+		} {
+			delete(labels, label)
+		}
+		if len(labels) == 0 {
+			labels = nil
+		}
+		deployed.Labels = labels
+		return reflect.DeepEqual(deployed, container)
+	})
+}
+
 func TestJobQueueImpl(t *testing.T) {
 	t.Parallel()
 	queueSize := 10
@@ -22,8 +51,8 @@ func TestJobQueueImpl(t *testing.T) {
 
 	// Create a mock Docker service
 	mockDockerService := NewMockDockerService(ctrl)
-	mockDockerService.EXPECT().DeployContainer(types.Container{}).Times(jobCount).Return("container-id", nil)
-	mockDockerService.EXPECT().GetContainerStatus("container-id").Times(jobCount).Return("running", nil)
+	mockDockerService.EXPECT().DeployContainer(gomock.Any(), deployOf(types.Container{})).Times(jobCount).Return("container-id", nil)
+	mockDockerService.EXPECT().GetContainerStatus(gomock.Any(), "container-id").Times(jobCount).Return("running", nil)
 
 	// Create a new job queue
 	jobQueue := NewQueue(queueSize, mockDockerService)
@@ -64,8 +93,8 @@ func TestJobQueueImplConcurrentEnqueue(t *testing.T) {
 
 	// Create a mock Docker service
 	mockDockerService := NewMockDockerService(ctrl)
-	mockDockerService.EXPECT().DeployContainer(types.Container{}).Times(jobCount).Return("container-id", nil)
-	mockDockerService.EXPECT().GetContainerStatus("container-id").Times(jobCount).Return("running", nil)
+	mockDockerService.EXPECT().DeployContainer(gomock.Any(), deployOf(types.Container{})).Times(jobCount).Return("container-id", nil)
+	mockDockerService.EXPECT().GetContainerStatus(gomock.Any(), "container-id").Times(jobCount).Return("running", nil)
 
 	// Create a new job queue
 	jobQueue := NewQueue(queueSize, mockDockerService)
@@ -102,3 +131,488 @@ func TestJobQueueImplConcurrentEnqueue(t *testing.T) {
 		require.Equal(t, types.JobStatusComplete, status)
 	}
 }
+
+func TestJobQueueTrack(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDockerService := NewMockDockerService(ctrl)
+	jobQueue := NewQueue(10, mockDockerService, WithNodeID("local"))
+
+	var changes []types.Job
+	jobQueue.OnStatusChange(func(job types.Job) {
+		changes = append(changes, job)
+	})
+
+	require.NoError(t, jobQueue.Enqueue("local-job", types.Container{}))
+	require.Len(t, changes, 1)
+	require.Equal(t, types.Job{ID: "local-job", Status: types.JobStatusPending, Node: "local"}, changes[0])
+
+	// jobs owned by peers are tracked without notifying listeners
+	jobQueue.Track(types.Job{ID: "remote-job", Status: types.JobStatusComplete, Node: "peer"})
+	job, exists := jobQueue.GetJob("remote-job")
+	require.True(t, exists)
+	require.Equal(t, "peer", job.Node)
+	require.Equal(t, types.JobStatusComplete, job.Status)
+	require.Len(t, changes, 1)
+
+	// records of local jobs are never overwritten by peers
+	jobQueue.Track(types.Job{ID: "local-job", Status: types.JobStatusFailed, Node: "peer"})
+	status, exists := jobQueue.GetStatus("local-job")
+	require.True(t, exists)
+	require.Equal(t, types.JobStatusPending, status)
+
+	stats := jobQueue.Stats()
+	require.Equal(t, QueueStats{Depth: 1, Size: 10}, stats)
+}
+
+func TestJobQueueUpdateRemote(t *testing.T) {
+	t.Parallel()
+
+	jobQueue := NewQueue(10, nil, WithNodeID("local"))
+	require.NoError(t, jobQueue.EnqueueFrom("local-job", types.Container{}, "origin"))
+	job, _ := jobQueue.GetJob("local-job")
+	require.Equal(t, "origin", job.Origin)
+	require.Equal(t, "local-job", job.StatusUpdate().ID)
+
+	// only the node a job was handed to reports its status
+	jobQueue.Track(types.Job{ID: "remote", Container: types.Container{Image: "nginx"}, Status: types.JobStatusPending, Node: "peer"})
+	require.False(t, jobQueue.UpdateRemote(types.JobStatusUpdate{ID: "remote", Status: types.JobStatusFailed, Node: "other"}))
+	require.False(t, jobQueue.UpdateRemote(types.JobStatusUpdate{ID: "local-job", Status: types.JobStatusFailed, Node: "peer"}))
+	require.False(t, jobQueue.UpdateRemote(types.JobStatusUpdate{ID: "unknown", Status: types.JobStatusFailed, Node: "peer"}))
+	usage := &types.ResourceUsage{CPUSeconds: 1.5, PeakMemoryBytes: 64 << 20}
+	require.True(t, jobQueue.UpdateRemote(types.JobStatusUpdate{
+		ID:       "remote",
+		Status:   types.JobStatusComplete,
+		Node:     "peer",
+		ExitCode: 3,
+		Usage:    usage,
+	}))
+	job, _ = jobQueue.GetJob("remote")
+	require.Equal(t, types.JobStatusComplete, job.Status)
+	require.Equal(t, 3, job.ExitCode)
+	require.Equal(t, "nginx", job.Container.Image)
+	require.Equal(t, usage, job.Usage)
+	require.Equal(t, usage, job.StatusUpdate().Usage)
+
+	// finished jobs of peers are pruned once they were kept long enough
+	jobQueue.pruneRemoteJobs()
+	_, exists := jobQueue.GetJob("remote")
+	require.True(t, exists)
+	jobQueue.ended["remote"] = time.Now().Add(-remoteJobRetention)
+	jobQueue.pruneRemoteJobs()
+	_, exists = jobQueue.GetJob("remote")
+	require.False(t, exists)
+	_, exists = jobQueue.GetJob("local-job")
+	require.True(t, exists)
+}
+
+func TestJobQueueCancel(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDockerService := NewMockDockerService(ctrl)
+	jobQueue := NewQueue(10, mockDockerService, WithNodeID("local"))
+
+	// cancelled pending jobs are never deployed
+	require.NoError(t, jobQueue.Enqueue("pending", types.Container{}))
+	require.NoError(t, jobQueue.Cancel("pending"))
+	jobQueue.executeJob(job{id: "pending"})
+	status, _ := jobQueue.GetStatus("pending")
+	require.Equal(t, types.JobStatusCancelled, status)
+	require.ErrorIs(t, jobQueue.Cancel("pending"), ErrJobNotActive)
+
+	// the containers of running jobs are stopped and removed
+	replica := types.Service{Name: "web", Container: types.Container{Image: "nginx"}}.ReplicaContainer()
+	mockDockerService.EXPECT().DeployContainer(gomock.Any(), deployOf(replica)).Return("container-id", nil)
+	mockDockerService.EXPECT().GetContainerStatus(gomock.Any(), "container-id").Return("running", nil)
+	require.NoError(t, jobQueue.Enqueue("running", replica))
+	jobQueue.executeJob(job{id: "running", container: replica})
+	status, _ = jobQueue.GetStatus("running")
+	require.Equal(t, types.JobStatusRunning, status)
+
+	mockDockerService.EXPECT().StopContainer(gomock.Any(), "container-id").Return(nil)
+	mockDockerService.EXPECT().RemoveContainer(gomock.Any(), "container-id").Return(nil)
+	require.NoError(t, jobQueue.Cancel("running"))
+	status, _ = jobQueue.GetStatus("running")
+	require.Equal(t, types.JobStatusCancelled, status)
+
+	// only local jobs can be cancelled
+	jobQueue.Track(types.Job{ID: "remote", Status: types.JobStatusRunning, Node: "peer"})
+	require.ErrorIs(t, jobQueue.Cancel("remote"), ErrJobNotFound)
+	require.ErrorIs(t, jobQueue.Cancel("unknown"), ErrJobNotFound)
+}
+
+func TestJobQueueMonitor(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDockerService := NewMockDockerService(ctrl)
+	jobQueue := NewQueue(10, mockDockerService, WithNodeID("local"))
+
+	replica := types.Service{Name: "web", Container: types.Container{Image: "nginx"}}.ReplicaContainer()
+	mockDockerService.EXPECT().DeployContainer(gomock.Any(), deployOf(replica)).Return("container-id", nil)
+	mockDockerService.EXPECT().GetContainerStatus(gomock.Any(), "container-id").Return("running", nil).Times(2)
+	require.NoError(t, jobQueue.Enqueue("replica", replica))
+	jobQueue.executeJob(job{id: "replica", container: replica})
+
+	// running containers are left alone
+	jobQueue.checkRunningJobs()
+	status, _ := jobQueue.GetStatus("replica")
+	require.Equal(t, types.JobStatusRunning, status)
+
+	// crashed containers fail the job and are removed
+	mockDockerService.EXPECT().GetContainerStatus(gomock.Any(), "container-id").Return("exited", nil)
+	mockDockerService.EXPECT().InspectContainer(gomock.Any(), "container-id").Return(ContainerInfo{Status: "exited", ExitCode: 1}, nil)
+	mockDockerService.EXPECT().StopContainer(gomock.Any(), "container-id").Return(nil)
+	mockDockerService.EXPECT().RemoveContainer(gomock.Any(), "container-id").Return(nil)
+	jobQueue.checkRunningJobs()
+	status, _ = jobQueue.GetStatus("replica")
+	require.Equal(t, types.JobStatusFailed, status)
+}
+
+func TestJobQueueRetry(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDockerService := NewMockDockerService(ctrl)
+	jobQueue := NewQueue(10, mockDockerService, WithRetries(1))
+	jobQueue.backoff = 0
+
+	container := types.Container{Image: "nginx"}
+	mockDockerService.EXPECT().DeployContainer(gomock.Any(), deployOf(container)).Return("", fmt.Errorf("failed to pull image")).Times(2)
+	require.NoError(t, jobQueue.Enqueue("job", container))
+
+	// the first failure queues the job again
+	jobQueue.executeJob(<-jobQueue.jobs)
+	record, _ := jobQueue.GetJob("job")
+	require.Equal(t, types.JobStatusPending, record.Status)
+	require.Equal(t, 1, record.Restarts)
+
+	// the job fails once it ran out of retries
+	jobQueue.executeJob(<-jobQueue.jobs)
+	record, _ = jobQueue.GetJob("job")
+	require.Equal(t, types.JobStatusFailed, record.Status)
+	require.Empty(t, jobQueue.jobs)
+}
+
+func TestJobQueueRestartPolicy(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDockerService := NewMockDockerService(ctrl)
+	jobQueue := NewQueue(10, mockDockerService)
+	jobQueue.backoff = 0
+
+	container := types.Container{
+		Image:         "busybox",
+		RestartPolicy: &types.RestartPolicy{Name: types.RestartPolicyOnFailure, MaxRestarts: 1},
+	}
+	mockDockerService.EXPECT().DeployContainer(gomock.Any(), deployOf(container)).Return("container-id", nil).Times(2)
+	mockDockerService.EXPECT().GetContainerStatus(gomock.Any(), "container-id").Return("running", nil)
+	mockDockerService.EXPECT().StopContainer(gomock.Any(), "container-id").Return(nil).Times(2)
+	mockDockerService.EXPECT().RemoveContainer(gomock.Any(), "container-id").Return(nil).Times(2)
+	require.NoError(t, jobQueue.Enqueue("job", container))
+
+	// containers with a restart policy are watched while they run
+	jobQueue.executeJob(<-jobQueue.jobs)
+	status, _ := jobQueue.GetStatus("job")
+	require.Equal(t, types.JobStatusRunning, status)
+
+	// a failed container is restarted
+	mockDockerService.EXPECT().GetContainerStatus(gomock.Any(), "container-id").Return("exited", nil)
+	mockDockerService.EXPECT().InspectContainer(gomock.Any(), "container-id").Return(ContainerInfo{Status: "exited", ExitCode: 2}, nil)
+	jobQueue.checkRunningJobs()
+	record, _ := jobQueue.GetJob("job")
+	require.Equal(t, types.JobStatusPending, record.Status)
+	require.Equal(t, 1, record.Restarts)
+	require.Equal(t, 2, record.ExitCode)
+
+	// a container that exited successfully completes the job
+	mockDockerService.EXPECT().GetContainerStatus(gomock.Any(), "container-id").Return("running", nil)
+	jobQueue.executeJob(<-jobQueue.jobs)
+	mockDockerService.EXPECT().GetContainerStatus(gomock.Any(), "container-id").Return("exited", nil)
+	mockDockerService.EXPECT().InspectContainer(gomock.Any(), "container-id").Return(ContainerInfo{Status: "exited"}, nil)
+	jobQueue.checkRunningJobs()
+	record, _ = jobQueue.GetJob("job")
+	require.Equal(t, types.JobStatusComplete, record.Status)
+	require.Equal(t, 0, record.ExitCode)
+	require.Empty(t, jobQueue.jobs)
+}
+
+func TestJobQueueCrashLoop(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDockerService := NewMockDockerService(ctrl)
+	jobQueue := NewQueue(10, mockDockerService, WithCrashLoopThreshold(2))
+	jobQueue.backoff = 0
+
+	container := types.Container{
+		Image:         "busybox",
+		RestartPolicy: &types.RestartPolicy{Name: types.RestartPolicyAlways},
+	}
+	mockDockerService.EXPECT().DeployContainer(gomock.Any(), deployOf(container)).Return("container-id", nil).Times(3)
+	mockDockerService.EXPECT().GetContainerStatus(gomock.Any(), "container-id").Return("exited", nil).Times(3)
+	mockDockerService.EXPECT().InspectContainer(gomock.Any(), "container-id").Return(ContainerInfo{Status: "exited"}, nil).Times(3)
+	mockDockerService.EXPECT().StopContainer(gomock.Any(), "container-id").Return(nil).Times(3)
+	mockDockerService.EXPECT().RemoveContainer(gomock.Any(), "container-id").Return(nil).Times(3)
+	require.NoError(t, jobQueue.Enqueue("job", container))
+
+	// containers that keep exiting right after they started are restarted until the threshold
+	for crashes := 1; crashes <= 2; crashes++ {
+		jobQueue.executeJob(<-jobQueue.jobs)
+		record, _ := jobQueue.GetJob("job")
+		require.Equal(t, types.JobStatusPending, record.Status)
+		require.Equal(t, crashes, record.Crashes)
+	}
+
+	jobQueue.executeJob(<-jobQueue.jobs)
+	record, _ := jobQueue.GetJob("job")
+	require.Equal(t, types.JobStatusFailed, record.Status)
+	require.Equal(t, 2, record.Restarts)
+}
+
+func TestJobQueueCollectsOutputs(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store, err := NewArtifactStore(t.TempDir())
+	require.NoError(t, err)
+	mockDockerService := NewMockDockerService(ctrl)
+	jobQueue := NewQueue(10, mockDockerService, WithNodeID("local"), WithArtifacts(store))
+
+	container := types.Container{
+		Image:         "busybox",
+		RestartPolicy: &types.RestartPolicy{Name: types.RestartPolicyNever},
+		Outputs:       []types.ArtifactOutput{{Name: "result", Path: "/out/result.txt"}},
+	}
+	archive := newTestArchive(t, map[string]string{"result.txt": "42"})
+	mockDockerService.EXPECT().DeployContainer(gomock.Any(), deployOf(container)).Return("container-id", nil)
+	mockDockerService.EXPECT().GetContainerStatus(gomock.Any(), "container-id").Return("exited", nil)
+	mockDockerService.EXPECT().InspectContainer(gomock.Any(), "container-id").Return(ContainerInfo{Status: "exited"}, nil)
+	mockDockerService.EXPECT().CopyFromContainer(gomock.Any(), "container-id", "/out/result.txt").
+		Return(io.NopCloser(bytes.NewReader(archive)), nil)
+	mockDockerService.EXPECT().StopContainer(gomock.Any(), "container-id").Return(nil)
+	mockDockerService.EXPECT().RemoveContainer(gomock.Any(), "container-id").Return(nil)
+	require.NoError(t, jobQueue.Enqueue("job", container))
+
+	// the outputs are collected before the container is removed
+	jobQueue.executeJob(<-jobQueue.jobs)
+	record, _ := jobQueue.GetJob("job")
+	require.Equal(t, types.JobStatusComplete, record.Status)
+	require.Len(t, record.Artifacts, 1)
+	require.Equal(t, "result", record.Artifacts[0].Name)
+	require.Equal(t, "local", record.Artifacts[0].Node)
+	require.True(t, store.Has(record.Artifacts[0].Digest))
+
+	// outputs over the size limit fail the job
+	limited := NewQueue(10, mockDockerService, WithNodeID("local"), WithArtifacts(store), WithMaxOutputSize(int64(len(archive)-1)))
+	container.RestartPolicy = &types.RestartPolicy{Name: types.RestartPolicyOnFailure}
+	mockDockerService.EXPECT().DeployContainer(gomock.Any(), deployOf(container)).Return("large-id", nil)
+	mockDockerService.EXPECT().GetContainerStatus(gomock.Any(), "large-id").Return("exited", nil)
+	mockDockerService.EXPECT().InspectContainer(gomock.Any(), "large-id").Return(ContainerInfo{Status: "exited"}, nil)
+	mockDockerService.EXPECT().CopyFromContainer(gomock.Any(), "large-id", "/out/result.txt").
+		Return(io.NopCloser(bytes.NewReader(archive)), nil)
+	mockDockerService.EXPECT().StopContainer(gomock.Any(), "large-id").Return(nil)
+	mockDockerService.EXPECT().RemoveContainer(gomock.Any(), "large-id").Return(nil)
+	require.NoError(t, limited.Enqueue("large", container))
+
+	limited.executeJob(<-limited.jobs)
+	record, _ = limited.GetJob("large")
+	require.Equal(t, types.JobStatusFailed, record.Status)
+	require.Empty(t, record.Artifacts)
+	require.Empty(t, limited.jobs)
+}
+
+func TestJobQueueTimeouts(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDockerService := NewMockDockerService(ctrl)
+	jobQueue := NewQueue(10, mockDockerService, WithNodeID("local"), WithRetries(3))
+
+	// jobs whose deadline passed are dropped instead of started
+	deadline := time.Now().Add(-time.Minute)
+	expired := types.Container{Image: "busybox", Deadline: &deadline}
+	require.NoError(t, jobQueue.Enqueue("expired", expired))
+	jobQueue.executeJob(<-jobQueue.jobs)
+	status, _ := jobQueue.GetStatus("expired")
+	require.Equal(t, types.JobStatusTimedOut, status)
+
+	// deploys that hit their time limit aren't retried
+	container := types.Container{Image: "busybox"}
+	mockDockerService.EXPECT().DeployContainer(gomock.Any(), deployOf(container)).
+		Return("", fmt.Errorf("failed to pull image: %w", ErrDeployTimeout))
+	require.NoError(t, jobQueue.Enqueue("slow-pull", container))
+	jobQueue.executeJob(<-jobQueue.jobs)
+	status, _ = jobQueue.GetStatus("slow-pull")
+	require.Equal(t, types.JobStatusTimedOut, status)
+	require.Empty(t, jobQueue.jobs)
+
+	// containers that run longer than their run timeout are killed
+	limited := types.Container{
+		Image:         "busybox",
+		RestartPolicy: &types.RestartPolicy{Name: types.RestartPolicyAlways},
+		Timeouts:      &types.Timeouts{Run: types.Duration(time.Millisecond)},
+	}
+	mockDockerService.EXPECT().DeployContainer(gomock.Any(), deployOf(limited)).Return("container-id", nil)
+	mockDockerService.EXPECT().GetContainerStatus(gomock.Any(), "container-id").Return("running", nil)
+	require.NoError(t, jobQueue.Enqueue("long-run", limited))
+	jobQueue.executeJob(<-jobQueue.jobs)
+
+	time.Sleep(10 * time.Millisecond)
+	mockDockerService.EXPECT().KillContainer(gomock.Any(), "container-id").Return(nil)
+	mockDockerService.EXPECT().RemoveContainer(gomock.Any(), "container-id").Return(nil)
+	jobQueue.checkRunningJobs()
+	record, _ := jobQueue.GetJob("long-run")
+	require.Equal(t, types.JobStatusTimedOut, record.Status)
+	require.Empty(t, record.ContainerID)
+}
+
+func TestJobQueueRunTimeoutAcrossRestarts(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDockerService := NewMockDockerService(ctrl)
+	jobQueue := NewQueue(10, mockDockerService, WithNodeID("local"))
+	jobQueue.backoff = 0
+
+	container := types.Container{
+		Image:         "busybox",
+		RestartPolicy: &types.RestartPolicy{Name: types.RestartPolicyAlways},
+		Timeouts:      &types.Timeouts{Run: types.Duration(100 * time.Millisecond)},
+	}
+	gomock.InOrder(
+		mockDockerService.EXPECT().DeployContainer(gomock.Any(), deployOf(container)).Return("first", nil),
+		mockDockerService.EXPECT().DeployContainer(gomock.Any(), deployOf(container)).Return("second", nil),
+	)
+	mockDockerService.EXPECT().GetContainerStatus(gomock.Any(), "first").Return("running", nil)
+	mockDockerService.EXPECT().GetContainerStatus(gomock.Any(), "second").Return("running", nil).AnyTimes()
+	mockDockerService.EXPECT().StopContainer(gomock.Any(), "first").Return(nil)
+	mockDockerService.EXPECT().RemoveContainer(gomock.Any(), "first").Return(nil)
+	require.NoError(t, jobQueue.Enqueue("job", container))
+	jobQueue.executeJob(<-jobQueue.jobs)
+
+	// the first container stops before the run timeout and the job restarts
+	time.Sleep(60 * time.Millisecond)
+	jobQueue.restart("job", "first", 1, "container exited")
+	jobQueue.executeJob(<-jobQueue.jobs)
+	record, _ := jobQueue.GetJob("job")
+	require.Equal(t, types.JobStatusRunning, record.Status)
+	require.Equal(t, 1, record.Restarts)
+	require.GreaterOrEqual(t, time.Duration(record.RunTime), 60*time.Millisecond)
+
+	// the time the first container ran counts towards the run timeout of the second
+	time.Sleep(60 * time.Millisecond)
+	mockDockerService.EXPECT().KillContainer(gomock.Any(), "second").Return(nil)
+	mockDockerService.EXPECT().RemoveContainer(gomock.Any(), "second").Return(nil)
+	jobQueue.checkRunningJobs()
+	record, _ = jobQueue.GetJob("job")
+	require.Equal(t, types.JobStatusTimedOut, record.Status)
+}
+
+func TestJobQueueRunTimeoutWithoutRestartPolicy(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDockerService := NewMockDockerService(ctrl)
+	jobQueue := NewQueue(10, mockDockerService, WithNodeID("local"))
+
+	// a job with a run timeout keeps running after its deploy instead of completing
+	container := types.Container{
+		Image:    "busybox",
+		Timeouts: &types.Timeouts{Run: types.Duration(50 * time.Millisecond)},
+	}
+	require.NoError(t, container.Validate())
+	mockDockerService.EXPECT().DeployContainer(gomock.Any(), deployOf(container)).Return("long", nil)
+	mockDockerService.EXPECT().GetContainerStatus(gomock.Any(), "long").Return("running", nil).Times(2)
+	require.NoError(t, jobQueue.Enqueue("long", container))
+	jobQueue.executeJob(<-jobQueue.jobs)
+	status, _ := jobQueue.GetStatus("long")
+	require.Equal(t, types.JobStatusRunning, status)
+
+	// it is watched until its run timeout kills it
+	jobQueue.checkRunningJobs()
+	status, _ = jobQueue.GetStatus("long")
+	require.Equal(t, types.JobStatusRunning, status)
+
+	time.Sleep(60 * time.Millisecond)
+	mockDockerService.EXPECT().KillContainer(gomock.Any(), "long").Return(nil)
+	mockDockerService.EXPECT().RemoveContainer(gomock.Any(), "long").Return(nil)
+	jobQueue.checkRunningJobs()
+	status, _ = jobQueue.GetStatus("long")
+	require.Equal(t, types.JobStatusTimedOut, status)
+
+	// a container that exits before its run timeout completes the job
+	container.Timeouts = &types.Timeouts{Run: types.Duration(time.Minute)}
+	mockDockerService.EXPECT().DeployContainer(gomock.Any(), deployOf(container)).Return("short", nil)
+	mockDockerService.EXPECT().GetContainerStatus(gomock.Any(), "short").Return("running", nil)
+	mockDockerService.EXPECT().StopContainer(gomock.Any(), "short").Return(nil)
+	mockDockerService.EXPECT().RemoveContainer(gomock.Any(), "short").Return(nil)
+	require.NoError(t, jobQueue.Enqueue("short", container))
+	jobQueue.executeJob(<-jobQueue.jobs)
+	jobQueue.restart("short", "short", 0, "container exited")
+	record, _ := jobQueue.GetJob("short")
+	require.Equal(t, types.JobStatusComplete, record.Status)
+	require.Zero(t, record.Restarts)
+}
+
+func TestJobQueueInterruptsDeploy(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDockerService := NewMockDockerService(ctrl)
+	jobQueue := NewQueue(10, mockDockerService, WithNodeID("local"), WithRetries(3))
+
+	// a pull in progress blocks until its context is done
+	deploying := make(chan struct{}, 2)
+	container := types.Container{Image: "busybox"}
+	mockDockerService.EXPECT().DeployContainer(gomock.Any(), deployOf(container)).
+		DoAndReturn(func(ctx context.Context, _ types.Container) (string, error) {
+			deploying <- struct{}{}
+			<-ctx.Done()
+			return "", fmt.Errorf("failed to pull image: %w", ctx.Err())
+		}).Times(2)
+
+	// cancelling the job interrupts its deploy, the job isn't retried
+	require.NoError(t, jobQueue.Enqueue("cancelled", container))
+	go func() {
+		<-deploying
+		require.NoError(t, jobQueue.Cancel("cancelled"))
+	}()
+	jobQueue.executeJob(<-jobQueue.jobs)
+	status, _ := jobQueue.GetStatus("cancelled")
+	require.Equal(t, types.JobStatusCancelled, status)
+	require.Empty(t, jobQueue.jobs)
+
+	// stopping the queue interrupts the deploys in progress
+	require.NoError(t, jobQueue.Enqueue("stopped", container))
+	jobQueue.Run(1)
+	<-deploying
+	jobQueue.Stop()
+	status, _ = jobQueue.GetStatus("stopped")
+	require.Equal(t, types.JobStatusPending, status)
+}
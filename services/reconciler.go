@@ -0,0 +1,564 @@
+package services
+
+import (
+	"container-manager/types"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrServiceNotFound is the error returned when a service doesn't exist
+	ErrServiceNotFound = fmt.Errorf("service not found")
+	// ErrServiceExists is the error returned when creating a service that already exists
+	ErrServiceExists = fmt.Errorf("service already exists")
+	// ErrUpdateInProgress is the error returned when updating a service that is being updated
+	ErrUpdateInProgress = fmt.Errorf("service update in progress")
+)
+
+const (
+	// reconcileInterval is the interval between reconciliations of the services
+	reconcileInterval = 5 * time.Second
+	// updateProgressDeadline is how long an update may take before it is rolled back
+	updateProgressDeadline = 5 * time.Minute
+	// replicaHandOverPeriod is how long a node that took a service over waits for the peers to hand over
+	// the replicas of the service before it reconciles it
+	replicaHandOverPeriod = reconcileInterval
+)
+
+// ServiceStore keeps the latest spec of each service known to the node.
+// specs: The latest spec of each service, deleted services are kept so they aren't revived by stale specs
+// mutex: The mutex to protect the specs
+type ServiceStore struct {
+	specs map[string]types.Service
+	mutex sync.Mutex
+}
+
+// NewServiceStore creates a new service store
+func NewServiceStore() *ServiceStore {
+	return &ServiceStore{
+		specs: make(map[string]types.Service),
+	}
+}
+
+// Apply stores a spec if it supersedes the stored spec of the service, it returns whether the spec was stored
+func (ss *ServiceStore) Apply(spec types.Service) bool {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	if current, ok := ss.specs[spec.Name]; ok && !spec.Newer(current) {
+		return false
+	}
+	ss.specs[spec.Name] = spec
+	return true
+}
+
+// Get returns the spec of a service, including deleted services
+func (ss *ServiceStore) Get(name string) (types.Service, bool) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	spec, ok := ss.specs[name]
+	return spec, ok
+}
+
+// List returns the specs of all services, including deleted services, sorted by name
+func (ss *ServiceStore) List() []types.Service {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	specs := make([]types.Service, 0, len(ss.specs))
+	for _, spec := range ss.specs {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool {
+		return specs[i].Name < specs[j].Name
+	})
+	return specs
+}
+
+// ServiceReconciler keeps the desired number of replicas of each service running across the cluster.
+// Each service is reconciled by its owner, when the owner leaves the node with the lowest ID takes it over.
+// nodeID: The ID of the local node
+// store: The service specs
+// queue: The job queue, which also tracks the replicas on other nodes
+// p2pService: The P2P service used to publish specs and stop remote replicas
+// dispatcher: The dispatcher that places new replicas
+// takeovers: When the node took over each service whose replicas may still be handed over, only used by the loop
+// wake: Triggers a reconciliation before the next interval
+// quit: The channel to signal the reconciliation loop to quit
+// wg: The wait group to wait for the reconciliation loop to finish
+type ServiceReconciler struct {
+	nodeID     string
+	store      *ServiceStore
+	queue      Queue
+	p2pService P2PService
+	dispatcher *Dispatcher
+	takeovers  map[string]time.Time
+	wake       chan struct{}
+	quit       chan bool
+	wg         sync.WaitGroup
+}
+
+// NewServiceReconciler creates a new service reconciler
+func NewServiceReconciler(
+	store *ServiceStore,
+	queue Queue,
+	p2pService P2PService,
+	dispatcher *Dispatcher,
+) *ServiceReconciler {
+	return &ServiceReconciler{
+		nodeID:     p2pService.ID(),
+		store:      store,
+		queue:      queue,
+		p2pService: p2pService,
+		dispatcher: dispatcher,
+		takeovers:  make(map[string]time.Time),
+		wake:       make(chan struct{}, 1),
+		quit:       make(chan bool),
+	}
+}
+
+// Create creates a service owned by this node
+func (r *ServiceReconciler) Create(spec types.Service) (types.Service, error) {
+	if err := spec.Validate(); err != nil {
+		return types.Service{}, err
+	}
+
+	current, exists := r.store.Get(spec.Name)
+	if exists && !current.Deleted {
+		return types.Service{}, ErrServiceExists
+	}
+
+	spec.Version = current.Version + 1
+	spec.Revision = current.NextRevision()
+	spec.LastRevision = spec.Revision
+	spec.Deleted = false
+	return r.update(spec), nil
+}
+
+// Update replaces the container of a service with a rolling update, the update config is kept when nil
+func (r *ServiceReconciler) Update(name string, container types.Container, config *types.UpdateConfig) (types.Service, error) {
+	spec, err := r.Get(name)
+	if err != nil {
+		return types.Service{}, err
+	}
+	if spec.Updating() {
+		return types.Service{}, ErrUpdateInProgress
+	}
+
+	previous := spec.Container
+	spec.Container = container
+	if config != nil {
+		spec.Update = *config
+	}
+	if err := spec.Validate(); err != nil {
+		return types.Service{}, err
+	}
+
+	spec.PreviousContainer = &previous
+	spec.PreviousRevision = spec.Revision
+	spec.Revision = spec.NextRevision()
+	spec.LastRevision = spec.Revision
+	spec.UpdateStatus = types.UpdateStatusUpdating
+	spec.UpdateStartedAt = time.Now()
+	spec.Version++
+	return r.update(spec), nil
+}
+
+// Scale changes the desired number of replicas of a service
+func (r *ServiceReconciler) Scale(name string, replicas int) (types.Service, error) {
+	spec, err := r.Get(name)
+	if err != nil {
+		return types.Service{}, err
+	}
+
+	spec.Replicas = replicas
+	if err := spec.Validate(); err != nil {
+		return types.Service{}, err
+	}
+	spec.Version++
+	return r.update(spec), nil
+}
+
+// Delete deletes a service and stops its replicas
+func (r *ServiceReconciler) Delete(name string) error {
+	spec, err := r.Get(name)
+	if err != nil {
+		return err
+	}
+
+	spec.Deleted = true
+	spec.Version++
+	r.update(spec)
+	return nil
+}
+
+// Get returns the spec of a service
+func (r *ServiceReconciler) Get(name string) (types.Service, error) {
+	spec, ok := r.store.Get(name)
+	if !ok || spec.Deleted {
+		return types.Service{}, ErrServiceNotFound
+	}
+	return spec, nil
+}
+
+// List returns the specs of the services, sorted by name
+func (r *ServiceReconciler) List() []types.Service {
+	var specs []types.Service
+	for _, spec := range r.store.List() {
+		if !spec.Deleted {
+			specs = append(specs, spec)
+		}
+	}
+	return specs
+}
+
+// Replicas returns the records of the replicas of a service known to the node, sorted by job ID
+func (r *ServiceReconciler) Replicas(name string) []types.Job {
+	var replicas []types.Job
+	for _, job := range r.queue.Jobs() {
+		if job.Container.Labels[types.ServiceLabel] == name {
+			replicas = append(replicas, job)
+		}
+	}
+	sort.Slice(replicas, func(i, j int) bool {
+		return replicas[i].ID < replicas[j].ID
+	})
+	return replicas
+}
+
+// Run runs the reconciliation loop
+func (r *ServiceReconciler) Run() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(reconcileInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-r.wake:
+			case <-r.quit:
+				return
+			}
+			r.reconcile()
+		}
+	}()
+}
+
+// Stop stops the reconciliation loop
+func (r *ServiceReconciler) Stop() {
+	close(r.quit)
+	r.wg.Wait()
+}
+
+// update takes ownership of a spec, stores and publishes it, and triggers a reconciliation
+func (r *ServiceReconciler) update(spec types.Service) types.Service {
+	spec.Owner = r.nodeID
+	spec.UpdatedAt = time.Now()
+	r.store.Apply(spec)
+	r.publish(spec)
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return spec
+}
+
+// publish broadcasts a spec to the peers
+func (r *ServiceReconciler) publish(spec types.Service) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		logrus.Errorf("failed to marshal service spec: %v", err)
+		return
+	}
+
+	msg := Message{
+		Type: types.P2PMessageTypeServiceSpec,
+		Data: data,
+	}
+	if err := r.p2pService.Broadcast(msg); err != nil {
+		logrus.WithField("service", spec.Name).Errorf("failed to publish service spec: %v", err)
+	}
+}
+
+// reconcile reconciles the services this node leads, and republishes their specs so new peers learn them
+func (r *ServiceReconciler) reconcile() {
+	live := r.liveNodes()
+	for _, spec := range r.store.List() {
+		if !r.leads(spec, live) {
+			continue
+		}
+
+		if spec.Owner != r.nodeID {
+			// the peers hand over the replicas they run once they learn the new owner
+			logrus.WithFields(logrus.Fields{
+				"service":  spec.Name,
+				"previous": spec.Owner,
+			}).Info("taking over service from node that left")
+			spec.Version++
+			r.update(spec)
+			r.takeovers[spec.Name] = time.Now()
+			continue
+		}
+		r.publish(spec)
+
+		if takenOver, ok := r.takeovers[spec.Name]; ok {
+			if time.Since(takenOver) < replicaHandOverPeriod {
+				continue
+			}
+			delete(r.takeovers, spec.Name)
+		}
+		r.reconcileService(spec, live)
+	}
+}
+
+// reconcileService starts or stops replicas of a service until the desired number of the current revision is active
+func (r *ServiceReconciler) reconcileService(spec types.Service, live map[string]bool) {
+	replicas := r.Replicas(spec.Name)
+	active := r.activeReplicas(spec, replicas, live)
+	if spec.Deleted {
+		for _, job := range active {
+			r.stopReplica(spec, job)
+		}
+		return
+	}
+
+	if spec.UpdateStatus == types.UpdateStatusUpdating {
+		if err := updateFailure(spec, replicas); err != nil {
+			r.rollback(spec, err)
+			return
+		}
+	}
+	if spec.UpdateStatus == types.UpdateStatusRollingBack && time.Since(spec.UpdateStartedAt) > updateProgressDeadline {
+		spec = r.failRollback(spec)
+	}
+
+	var current, outdated []types.Job
+	for _, job := range active {
+		if job.Container.Labels[types.ServiceRevisionLabel] == spec.RevisionLabel() {
+			current = append(current, job)
+		} else {
+			outdated = append(outdated, job)
+		}
+	}
+
+	if len(outdated) > 0 {
+		r.roll(spec, current, outdated)
+		return
+	}
+
+	for i := len(current); i < spec.Replicas; i++ {
+		r.startReplica(spec)
+	}
+	if len(current) > spec.Replicas {
+		// stop pending replicas before running ones
+		sortPendingFirst(current)
+		for _, job := range current[:len(current)-spec.Replicas] {
+			r.stopReplica(spec, job)
+		}
+	}
+
+	if spec.Updating() && len(current) == spec.Replicas && countRunning(current) == spec.Replicas {
+		r.finishUpdate(spec)
+	}
+}
+
+// activeReplicas returns the pending and running replicas of a service, replicas of nodes that left are failed
+func (r *ServiceReconciler) activeReplicas(spec types.Service, replicas []types.Job, live map[string]bool) []types.Job {
+	var active []types.Job
+	for _, job := range replicas {
+		if !job.Status.Active() {
+			continue
+		}
+		if !live[job.Node] {
+			// the node left, the replica is replaced
+			logrus.WithFields(logrus.Fields{
+				"service": spec.Name,
+				"job_id":  job.ID,
+				"node":    job.Node,
+			}).Warn("replica lost with its node")
+			job.Status = types.JobStatusFailed
+			r.queue.Track(job)
+			continue
+		}
+		active = append(active, job)
+	}
+	return active
+}
+
+// roll replaces outdated replicas with replicas of the current revision in batches. A batch is only
+// started once the replicas of the previous batch are running, and outdated replicas are only stopped
+// while the service keeps the desired number of replicas minus the max unavailable running.
+func (r *ServiceReconciler) roll(spec types.Service, current []types.Job, outdated []types.Job) {
+	surge, unavailable := spec.Update.Limits()
+
+	if countRunning(current) == len(current) {
+		room := spec.Replicas + surge - len(current) - len(outdated)
+		for i := 0; i < min(room, spec.Replicas-len(current)); i++ {
+			r.startReplica(spec)
+		}
+	}
+
+	// pending outdated replicas don't serve, they are stopped first
+	surplus := countRunning(current) + countRunning(outdated) - (spec.Replicas - unavailable)
+	sortPendingFirst(outdated)
+	for _, job := range outdated {
+		if job.Ready() {
+			if surplus <= 0 {
+				break
+			}
+			surplus--
+		}
+		r.stopReplica(spec, job)
+	}
+}
+
+// updateFailure returns why the update of a service failed, nil while it is progressing
+func updateFailure(spec types.Service, replicas []types.Job) error {
+	for _, job := range replicas {
+		if job.Container.Labels[types.ServiceRevisionLabel] == spec.RevisionLabel() && job.Status.Failed() {
+			return fmt.Errorf("replica %s failed on node %s", job.ID, job.Node)
+		}
+	}
+	if time.Since(spec.UpdateStartedAt) > updateProgressDeadline {
+		return fmt.Errorf("update didn't complete within %s", updateProgressDeadline)
+	}
+	return nil
+}
+
+// rollback restores the previous container of a service with its revision, so only the replicas of the failed
+// revision are replaced
+func (r *ServiceReconciler) rollback(spec types.Service, reason error) {
+	logrus.WithFields(logrus.Fields{
+		"service":  spec.Name,
+		"revision": spec.Revision,
+	}).Warnf("rolling back failed update: %v", reason)
+
+	if spec.PreviousContainer != nil {
+		spec.Container = *spec.PreviousContainer
+		spec.PreviousContainer = nil
+		spec.Revision = spec.PreviousRevision
+		spec.PreviousRevision = 0
+	}
+	spec.UpdateStatus = types.UpdateStatusRollingBack
+	spec.UpdateStartedAt = time.Now()
+	spec.Version++
+	r.update(spec)
+}
+
+// failRollback records that the rollback of a service didn't complete within the update deadline, the replicas
+// of the restored revision are still reconciled but a new update is let through
+func (r *ServiceReconciler) failRollback(spec types.Service) types.Service {
+	logrus.WithFields(logrus.Fields{
+		"service":  spec.Name,
+		"revision": spec.Revision,
+	}).Errorf("rollback didn't complete within %s", updateProgressDeadline)
+
+	spec.UpdateStatus = types.UpdateStatusRollbackFailed
+	spec.Version++
+	return r.update(spec)
+}
+
+// finishUpdate records that the update or rollback of a service completed
+func (r *ServiceReconciler) finishUpdate(spec types.Service) {
+	if spec.UpdateStatus == types.UpdateStatusRollingBack {
+		spec.UpdateStatus = types.UpdateStatusRolledBack
+	} else {
+		spec.UpdateStatus = types.UpdateStatusCompleted
+	}
+	spec.Version++
+	r.update(spec)
+
+	logrus.WithFields(logrus.Fields{
+		"service":  spec.Name,
+		"revision": spec.Revision,
+		"status":   spec.UpdateStatus,
+	}).Info("service update finished")
+}
+
+// startReplica places a new replica of a service
+func (r *ServiceReconciler) startReplica(spec types.Service) {
+	jobID, err := uuid.NewUUID()
+	if err != nil {
+		logrus.Errorf("failed to generate job ID: %v", err)
+		return
+	}
+
+	nodeID, err := r.dispatcher.Dispatch(jobID.String(), spec.ReplicaContainer(), "service/"+spec.Name)
+	if err != nil {
+		logrus.WithField("service", spec.Name).Warnf("failed to start replica: %v", err)
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"service": spec.Name,
+		"job_id":  jobID.String(),
+		"node":    nodeID,
+	}).Info("started replica")
+}
+
+// stopReplica cancels a replica of a service on the node that runs it
+func (r *ServiceReconciler) stopReplica(spec types.Service, job types.Job) {
+	logger := logrus.WithFields(logrus.Fields{
+		"service": spec.Name,
+		"job_id":  job.ID,
+		"node":    job.Node,
+	})
+
+	if err := r.dispatcher.Cancel(job); err != nil {
+		logger.Warnf("failed to stop replica: %v", err)
+		return
+	}
+	logger.Info("stopped replica")
+}
+
+// sortPendingFirst sorts pending replicas before running ones
+func sortPendingFirst(jobs []types.Job) {
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].Status == types.JobStatusPending && jobs[j].Status != types.JobStatusPending
+	})
+}
+
+// countRunning returns the number of running replicas that passed their health check
+func countRunning(jobs []types.Job) int {
+	var running int
+	for _, job := range jobs {
+		if job.Ready() {
+			running++
+		}
+	}
+	return running
+}
+
+// liveNodes returns the IDs of this node and its connected peers
+func (r *ServiceReconciler) liveNodes() map[string]bool {
+	live := map[string]bool{r.nodeID: true}
+	for _, peerID := range r.p2pService.Peers() {
+		live[peerID] = true
+	}
+	return live
+}
+
+// leads reports whether this node reconciles the service, which is the owner while it is live
+// and otherwise the live node with the lowest ID
+func (r *ServiceReconciler) leads(spec types.Service, live map[string]bool) bool {
+	if live[spec.Owner] {
+		return spec.Owner == r.nodeID
+	}
+
+	for nodeID := range live {
+		if nodeID < r.nodeID {
+			return false
+		}
+	}
+	return true
+}
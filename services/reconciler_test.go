@@ -0,0 +1,383 @@
+package services
+
+import (
+	"container-manager/types"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+// newTestReconciler creates a reconciler for the "local" node that places every replica locally
+func newTestReconciler(ctrl *gomock.Controller, peers ...string) (*ServiceReconciler, *MockQueue, *MockP2PService) {
+	mockQueue := NewMockQueue(ctrl)
+	mockP2PService := NewMockP2PService(ctrl)
+	mockP2PService.EXPECT().ID().Return("local").AnyTimes()
+	mockP2PService.EXPECT().Peers().Return(peers).AnyTimes()
+
+	scheduler := NewScheduler(
+		staticCapacity(types.NodeCapacity{NodeID: "local", Workers: 1, QueueSize: 10}),
+		NewCapacityStore(CapacityRecordTTL),
+		staticJobs(nil),
+		ScoringStrategyFunc(leastLoadedScore),
+	)
+	dispatcher := NewDispatcher(mockQueue, mockP2PService, scheduler)
+	return NewServiceReconciler(NewServiceStore(), mockQueue, mockP2PService, dispatcher), mockQueue, mockP2PService
+}
+
+// replica returns the record of a replica of the first revision of the web service
+func replica(id string, node string, status types.JobStatus) types.Job {
+	return revisionReplica(id, node, status, 1)
+}
+
+// revisionReplica returns the record of a replica of a revision of the web service
+func revisionReplica(id string, node string, status types.JobStatus, revision uint64) types.Job {
+	spec := types.Service{Name: "web", Container: types.Container{Image: "nginx"}, Revision: revision}
+	return types.Job{
+		ID:        id,
+		Node:      node,
+		Status:    status,
+		Container: spec.ReplicaContainer(),
+	}
+}
+
+func TestServiceStoreApply(t *testing.T) {
+	t.Parallel()
+
+	store := NewServiceStore()
+	require.True(t, store.Apply(types.Service{Name: "web", Version: 2, Replicas: 2, Owner: "a"}))
+	require.False(t, store.Apply(types.Service{Name: "web", Version: 1, Replicas: 5, Owner: "b"}))
+	require.True(t, store.Apply(types.Service{Name: "web", Version: 2, Replicas: 3, Owner: "b"}))
+	require.True(t, store.Apply(types.Service{Name: "api", Version: 1, Owner: "a"}))
+
+	spec, ok := store.Get("web")
+	require.True(t, ok)
+	require.Equal(t, 3, spec.Replicas)
+
+	specs := store.List()
+	require.Len(t, specs, 2)
+	require.Equal(t, "api", specs[0].Name)
+}
+
+func TestServiceReconcilerCreate(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	reconciler, mockQueue, mockP2PService := newTestReconciler(ctrl)
+	mockP2PService.EXPECT().Broadcast(gomock.Any()).Return(nil).AnyTimes()
+
+	spec, err := reconciler.Create(types.Service{Name: "web", Container: types.Container{Image: "nginx"}, Replicas: 2})
+	require.NoError(t, err)
+	require.Equal(t, "local", spec.Owner)
+	require.Equal(t, uint64(1), spec.Version)
+
+	_, err = reconciler.Create(types.Service{Name: "web", Container: types.Container{Image: "nginx"}, Replicas: 1})
+	require.ErrorIs(t, err, ErrServiceExists)
+	_, err = reconciler.Create(types.Service{Name: "Web!", Container: types.Container{Image: "nginx"}})
+	require.Error(t, err)
+
+	// missing replicas are started with the service label
+	mockQueue.EXPECT().Jobs().Return(nil)
+	mockQueue.EXPECT().Enqueue(gomock.Any(), gomock.Any()).Times(2).DoAndReturn(
+		func(_ string, container types.Container) error {
+			require.Equal(t, "web", container.Labels[types.ServiceLabel])
+			return nil
+		},
+	)
+	reconciler.reconcile()
+
+	require.NoError(t, reconciler.Delete("web"))
+	_, err = reconciler.Get("web")
+	require.ErrorIs(t, err, ErrServiceNotFound)
+	require.Empty(t, reconciler.List())
+
+	// deleted services can be created again
+	spec, err = reconciler.Create(types.Service{Name: "web", Container: types.Container{Image: "nginx"}, Replicas: 1})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), spec.Version)
+}
+
+func TestServiceReconcilerReplacesLostReplicas(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	reconciler, mockQueue, mockP2PService := newTestReconciler(ctrl, "peer")
+	mockP2PService.EXPECT().Broadcast(gomock.Any()).Return(nil).AnyTimes()
+	_, err := reconciler.Create(types.Service{Name: "web", Container: types.Container{Image: "nginx"}, Replicas: 3})
+	require.NoError(t, err)
+
+	lost := replica("1", "gone", types.JobStatusRunning)
+	mockQueue.EXPECT().Jobs().Return([]types.Job{
+		lost,
+		replica("2", "peer", types.JobStatusRunning),
+		replica("3", "local", types.JobStatusPending),
+		replica("4", "local", types.JobStatusFailed),
+		{ID: "other", Node: "local", Status: types.JobStatusRunning},
+	})
+	lost.Status = types.JobStatusFailed
+	mockQueue.EXPECT().Track(lost)
+	mockQueue.EXPECT().Enqueue(gomock.Any(), gomock.Any()).Return(nil)
+	reconciler.reconcile()
+}
+
+func TestServiceReconcilerScalesDown(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	reconciler, mockQueue, mockP2PService := newTestReconciler(ctrl, "peer")
+	mockP2PService.EXPECT().Broadcast(gomock.Any()).Return(nil).AnyTimes()
+	_, err := reconciler.Create(types.Service{Name: "web", Container: types.Container{Image: "nginx"}, Replicas: 3})
+	require.NoError(t, err)
+	_, err = reconciler.Scale("web", 1)
+	require.NoError(t, err)
+
+	pending := replica("2", "peer", types.JobStatusPending)
+	mockQueue.EXPECT().Jobs().Return([]types.Job{
+		replica("1", "local", types.JobStatusRunning),
+		pending,
+		replica("3", "peer", types.JobStatusRunning),
+	})
+
+	// pending replicas are stopped first
+	mockP2PService.EXPECT().Send("peer", Message{Type: types.P2PMessageTypeCancelJob, JobID: "2"}).Return(nil)
+	pending.Status = types.JobStatusCancelled
+	mockQueue.EXPECT().Track(pending)
+	mockQueue.EXPECT().Cancel("1").Return(nil)
+	reconciler.reconcile()
+}
+
+func TestServiceReconcilerTakeover(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// a live owner keeps the service
+	reconciler, _, _ := newTestReconciler(ctrl, "peer")
+	spec := types.Service{
+		Name:      "web",
+		Container: types.Container{Image: "nginx"},
+		Replicas:  1,
+		Revision:  1,
+		Owner:     "peer",
+		Version:   1,
+	}
+	reconciler.store.Apply(spec)
+	reconciler.reconcile()
+
+	// a node with a lower ID takes over from an owner that left
+	reconciler, _, _ = newTestReconciler(ctrl, "abc")
+	spec.Owner = "gone"
+	reconciler.store.Apply(spec)
+	reconciler.reconcile()
+
+	// the live node with the lowest ID takes over, and waits for the peers to hand over their replicas
+	reconciler, mockQueue, mockP2PService := newTestReconciler(ctrl, "peer")
+	reconciler.store.Apply(spec)
+	mockP2PService.EXPECT().Broadcast(gomock.Any()).DoAndReturn(func(msg Message) error {
+		require.Equal(t, types.P2PMessageTypeServiceSpec, msg.Type)
+		return nil
+	}).Times(3)
+	reconciler.reconcile()
+	reconciler.reconcile()
+
+	taken, err := reconciler.Get("web")
+	require.NoError(t, err)
+	require.Equal(t, "local", taken.Owner)
+	require.Equal(t, uint64(2), taken.Version)
+
+	// the replica handed over by the third node is kept instead of starting another one
+	reconciler.takeovers["web"] = time.Now().Add(-replicaHandOverPeriod)
+	mockQueue.EXPECT().Jobs().Return([]types.Job{replica("1", "peer", types.JobStatusRunning)})
+	reconciler.reconcile()
+}
+
+func TestServiceReconcilerRollingUpdate(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	reconciler, mockQueue, mockP2PService := newTestReconciler(ctrl)
+	mockP2PService.EXPECT().Broadcast(gomock.Any()).Return(nil).AnyTimes()
+	_, err := reconciler.Create(types.Service{Name: "web", Container: types.Container{Image: "nginx"}, Replicas: 2})
+	require.NoError(t, err)
+
+	spec, err := reconciler.Update("web", types.Container{Image: "nginx:1.27"}, &types.UpdateConfig{MaxSurge: 1})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), spec.Revision)
+	require.Equal(t, types.UpdateStatusUpdating, spec.UpdateStatus)
+	require.Equal(t, "nginx", spec.PreviousContainer.Image)
+
+	_, err = reconciler.Update("web", types.Container{Image: "nginx:1.28"}, nil)
+	require.ErrorIs(t, err, ErrUpdateInProgress)
+
+	// a batch of one surged replica is started, no old replica is stopped while it isn't running
+	mockQueue.EXPECT().Jobs().Return([]types.Job{
+		revisionReplica("1", "local", types.JobStatusRunning, 1),
+		revisionReplica("2", "local", types.JobStatusRunning, 1),
+	})
+	mockQueue.EXPECT().Enqueue(gomock.Any(), gomock.Any()).DoAndReturn(func(_ string, container types.Container) error {
+		require.Equal(t, "nginx:1.27", container.Image)
+		require.Equal(t, "2", container.Labels[types.ServiceRevisionLabel])
+		return nil
+	})
+	reconciler.reconcile()
+
+	// the next batch waits until the new replica runs
+	mockQueue.EXPECT().Jobs().Return([]types.Job{
+		revisionReplica("1", "local", types.JobStatusRunning, 1),
+		revisionReplica("2", "local", types.JobStatusRunning, 1),
+		revisionReplica("3", "local", types.JobStatusPending, 2),
+	})
+	reconciler.reconcile()
+
+	// once it runs an old replica is stopped
+	mockQueue.EXPECT().Jobs().Return([]types.Job{
+		revisionReplica("1", "local", types.JobStatusRunning, 1),
+		revisionReplica("2", "local", types.JobStatusRunning, 1),
+		revisionReplica("3", "local", types.JobStatusRunning, 2),
+	})
+	mockQueue.EXPECT().Cancel("1").Return(nil)
+	reconciler.reconcile()
+
+	// the update completes once every replica of the new revision runs
+	mockQueue.EXPECT().Jobs().Return([]types.Job{
+		revisionReplica("3", "local", types.JobStatusRunning, 2),
+		revisionReplica("4", "local", types.JobStatusRunning, 2),
+	})
+	reconciler.reconcile()
+
+	spec, err = reconciler.Get("web")
+	require.NoError(t, err)
+	require.Equal(t, types.UpdateStatusCompleted, spec.UpdateStatus)
+}
+
+func TestServiceReconcilerMaxUnavailable(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	reconciler, mockQueue, mockP2PService := newTestReconciler(ctrl)
+	mockP2PService.EXPECT().Broadcast(gomock.Any()).Return(nil).AnyTimes()
+	_, err := reconciler.Create(types.Service{Name: "web", Container: types.Container{Image: "nginx"}, Replicas: 3})
+	require.NoError(t, err)
+	_, err = reconciler.Update("web", types.Container{Image: "nginx:1.27"}, &types.UpdateConfig{MaxUnavailable: 1})
+	require.NoError(t, err)
+
+	// without surge an old replica is stopped before its replacement starts
+	mockQueue.EXPECT().Jobs().Return([]types.Job{
+		revisionReplica("1", "local", types.JobStatusRunning, 1),
+		revisionReplica("2", "local", types.JobStatusRunning, 1),
+		revisionReplica("3", "local", types.JobStatusRunning, 1),
+	})
+	mockQueue.EXPECT().Cancel("1").Return(nil)
+	reconciler.reconcile()
+
+	mockQueue.EXPECT().Jobs().Return([]types.Job{
+		revisionReplica("2", "local", types.JobStatusRunning, 1),
+		revisionReplica("3", "local", types.JobStatusRunning, 1),
+	})
+	mockQueue.EXPECT().Enqueue(gomock.Any(), gomock.Any()).Return(nil)
+	reconciler.reconcile()
+}
+
+func TestServiceReconcilerRollback(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	reconciler, mockQueue, mockP2PService := newTestReconciler(ctrl)
+	mockP2PService.EXPECT().Broadcast(gomock.Any()).Return(nil).AnyTimes()
+	_, err := reconciler.Create(types.Service{Name: "web", Container: types.Container{Image: "nginx"}, Replicas: 1})
+	require.NoError(t, err)
+	_, err = reconciler.Update("web", types.Container{Image: "nginx:broken"}, nil)
+	require.NoError(t, err)
+
+	// a failed replica of the new revision rolls the update back
+	mockQueue.EXPECT().Jobs().Return([]types.Job{
+		revisionReplica("1", "local", types.JobStatusRunning, 1),
+		revisionReplica("2", "local", types.JobStatusFailed, 2),
+	})
+	reconciler.reconcile()
+
+	spec, err := reconciler.Get("web")
+	require.NoError(t, err)
+	require.Equal(t, types.UpdateStatusRollingBack, spec.UpdateStatus)
+	require.Equal(t, "nginx", spec.Container.Image)
+	require.Equal(t, uint64(1), spec.Revision)
+	require.Nil(t, spec.PreviousContainer)
+
+	// the replicas of the previous revision are kept, only the failed ones are replaced
+	mockQueue.EXPECT().Jobs().Return([]types.Job{
+		revisionReplica("1", "local", types.JobStatusRunning, 1),
+		revisionReplica("2", "local", types.JobStatusFailed, 2),
+	})
+	reconciler.reconcile()
+
+	spec, err = reconciler.Get("web")
+	require.NoError(t, err)
+	require.Equal(t, types.UpdateStatusRolledBack, spec.UpdateStatus)
+
+	// the revision rolled back from isn't issued again
+	spec, err = reconciler.Update("web", types.Container{Image: "nginx:fixed"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), spec.Revision)
+	require.Equal(t, uint64(1), spec.PreviousRevision)
+}
+
+func TestServiceReconcilerRollbackDeadline(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	reconciler, mockQueue, mockP2PService := newTestReconciler(ctrl)
+	mockP2PService.EXPECT().Broadcast(gomock.Any()).Return(nil).AnyTimes()
+	_, err := reconciler.Create(types.Service{Name: "web", Container: types.Container{Image: "nginx"}, Replicas: 1})
+	require.NoError(t, err)
+	_, err = reconciler.Update("web", types.Container{Image: "nginx:broken"}, nil)
+	require.NoError(t, err)
+
+	mockQueue.EXPECT().Jobs().Return([]types.Job{
+		revisionReplica("1", "local", types.JobStatusFailed, 1),
+		revisionReplica("2", "local", types.JobStatusFailed, 2),
+	})
+	reconciler.reconcile()
+
+	// a rollback that doesn't converge blocks updates until the deadline
+	_, err = reconciler.Update("web", types.Container{Image: "nginx:fixed"}, nil)
+	require.ErrorIs(t, err, ErrUpdateInProgress)
+
+	spec, err := reconciler.Get("web")
+	require.NoError(t, err)
+	spec.UpdateStartedAt = time.Now().Add(-updateProgressDeadline - time.Minute)
+	spec.Version++
+	reconciler.store.Apply(spec)
+
+	// the restored revision is still reconciled once the rollback failed
+	mockQueue.EXPECT().Jobs().Return([]types.Job{
+		revisionReplica("1", "local", types.JobStatusFailed, 1),
+		revisionReplica("2", "local", types.JobStatusFailed, 2),
+	})
+	mockQueue.EXPECT().Enqueue(gomock.Any(), gomock.Any()).Return(nil)
+	reconciler.reconcile()
+
+	spec, err = reconciler.Get("web")
+	require.NoError(t, err)
+	require.Equal(t, types.UpdateStatusRollbackFailed, spec.UpdateStatus)
+
+	spec, err = reconciler.Update("web", types.Container{Image: "nginx:fixed"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, types.UpdateStatusUpdating, spec.UpdateStatus)
+	require.Equal(t, "nginx:fixed", spec.Container.Image)
+}
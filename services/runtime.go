@@ -0,0 +1,127 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	// RuntimeDocker runs containers with the Docker engine
+	RuntimeDocker = "docker"
+	// RuntimeContainerd runs containers with containerd over its socket
+	RuntimeContainerd = "containerd"
+	// RuntimePodman runs containers with Podman through its Docker-compatible API
+	RuntimePodman = "podman"
+	// RuntimeFake simulates containers in memory, for local development and tests without a container runtime
+	RuntimeFake = "fake"
+)
+
+// RuntimeOptions configure a container runtime.
+// Endpoint: The address of the runtime API, the default of the runtime when empty
+// Namespace: The namespace the containers are created in, for runtimes that have namespaces
+// Artifacts: The store the input artifacts of containers are copied from
+// Secrets: Resolves the secrets of containers, nil rejects containers with secrets
+// SeccompProfiles: The directory of the seccomp profiles containers reference by name, as <name>.json
+// Fake: The behaviour of the containers of the fake runtime
+type RuntimeOptions struct {
+	Endpoint        string
+	Namespace       string
+	Artifacts       *ArtifactStore
+	Secrets         *SecretInjector
+	SeccompProfiles string
+	Fake            FakeRuntimeOptions
+}
+
+// RuntimeFactory creates a container runtime
+type RuntimeFactory func(opts RuntimeOptions) (DockerService, error)
+
+var (
+	// runtimes are the registered container runtimes by name
+	runtimes = map[string]RuntimeFactory{
+		RuntimeDocker:     newDockerRuntime,
+		RuntimeContainerd: newContainerdRuntime,
+		RuntimePodman:     newPodmanRuntime,
+		RuntimeFake:       newFakeRuntime,
+	}
+	// runtimesLock is a mutex for runtimes
+	runtimesLock sync.RWMutex
+)
+
+// RegisterRuntime registers a container runtime under a name
+func RegisterRuntime(name string, factory RuntimeFactory) {
+	runtimesLock.Lock()
+	defer runtimesLock.Unlock()
+
+	runtimes[name] = factory
+}
+
+// NewRuntime creates the container runtime registered under the name
+func NewRuntime(name string, opts RuntimeOptions) (DockerService, error) {
+	runtimesLock.RLock()
+	factory, ok := runtimes[name]
+	runtimesLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown container runtime %q", name)
+	}
+
+	runtime, err := factory(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s runtime: %w", name, err)
+	}
+	return runtime, nil
+}
+
+// Runtimes returns the names of the registered container runtimes
+func Runtimes() []string {
+	runtimesLock.RLock()
+	defer runtimesLock.RUnlock()
+
+	names := make([]string, 0, len(runtimes))
+	for name := range runtimes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newDockerRuntime creates the Docker runtime, the endpoint defaults to DOCKER_HOST
+func newDockerRuntime(opts RuntimeOptions) (DockerService, error) {
+	return NewDockerService(
+		WithHost(opts.Endpoint),
+		WithInputArtifacts(opts.Artifacts),
+		WithSecrets(opts.Secrets),
+		WithSeccompProfiles(opts.SeccompProfiles),
+	)
+}
+
+// newPodmanRuntime creates the Podman runtime. Podman serves the Docker API, so it is driven by the Docker client.
+func newPodmanRuntime(opts RuntimeOptions) (DockerService, error) {
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		endpoint = defaultPodmanEndpoint()
+	}
+	return NewDockerService(
+		WithHost(endpoint),
+		WithInputArtifacts(opts.Artifacts),
+		WithSecrets(opts.Secrets),
+		WithSeccompProfiles(opts.SeccompProfiles),
+	)
+}
+
+// defaultPodmanEndpoint returns the address of the Podman API: CONTAINER_HOST when set,
+// the socket of the rootless service when it exists, and the socket of the rootful service otherwise
+func defaultPodmanEndpoint() string {
+	if host := os.Getenv("CONTAINER_HOST"); host != "" {
+		return host
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		socket := filepath.Join(dir, "podman", "podman.sock")
+		if _, err := os.Stat(socket); err == nil {
+			return "unix://" + socket
+		}
+	}
+	return "unix:///run/podman/podman.sock"
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"archive/tar"
+	"bytes"
+	"container-manager/types"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// conformanceImage is the image the conformance suite runs
+const conformanceImage = "docker.io/library/busybox:latest"
+
+// newConformanceRuntime creates a runtime for the conformance suite, skipping the test when it isn't reachable
+func newConformanceRuntime(t *testing.T, name string, opts RuntimeOptions) DockerService {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("runtime conformance requires a container runtime")
+	}
+	runtime, err := NewRuntime(name, opts)
+	if err != nil {
+		t.Skipf("%s runtime isn't available: %v", name, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := runtime.ListImages(ctx); err != nil {
+		t.Skipf("%s runtime isn't available: %v", name, err)
+	}
+	return runtime
+}
+
+// waitForStatus polls a container until it has the status
+func waitForStatus(t *testing.T, runtime DockerService, containerID string, status string) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		current, err := runtime.GetContainerStatus(context.Background(), containerID)
+		return err == nil && current == status
+	}, time.Minute, 100*time.Millisecond)
+}
+
+// testRuntimeConformance runs the behaviour every container runtime has to provide against a runtime
+func testRuntimeConformance(t *testing.T, runtime DockerService, artifacts *ArtifactStore) {
+	ctx := context.Background()
+
+	archive := newTestArchive(t, map[string]string{"data/hello.txt": "hello"})
+	digest, _, err := artifacts.Put(bytes.NewReader(archive))
+	require.NoError(t, err)
+
+	// inputs are in place when the command runs, and outputs are copied once it exited
+	containerID, err := runtime.DeployContainer(ctx, types.Container{
+		Image:     conformanceImage,
+		Arguments: []string{"sh", "-c", "cp /in/hello.txt /out.txt && test \"$GREETING\" = hi"},
+		Env:       map[string]string{"GREETING": "hi"},
+		Inputs:    []types.ArtifactInput{{Digest: digest, Path: "/in"}},
+	})
+	require.NoError(t, err)
+	waitForStatus(t, runtime, containerID, "exited")
+
+	info, err := runtime.InspectContainer(ctx, containerID)
+	require.NoError(t, err)
+	require.Equal(t, "exited", info.Status)
+	require.Equal(t, 0, info.ExitCode)
+
+	reader, err := runtime.CopyFromContainer(ctx, containerID, "/out.txt")
+	require.NoError(t, err)
+	tr := tar.NewReader(reader)
+	header, err := tr.Next()
+	require.NoError(t, err)
+	require.Equal(t, "out.txt", strings.TrimPrefix(header.Name, "./"))
+	content, err := io.ReadAll(tr)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(content))
+	require.NoError(t, reader.Close())
+
+	require.NoError(t, runtime.RemoveContainer(ctx, containerID))
+	_, err = runtime.GetContainerStatus(ctx, containerID)
+	require.Error(t, err)
+
+	images, err := runtime.ListImages(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, images)
+
+	// a killed container exits with a failure, containers are found by their labels
+	containerID, err = runtime.DeployContainer(ctx, types.Container{
+		Image:     conformanceImage,
+		Arguments: []string{"sleep", "300"},
+		Labels:    map[string]string{"conformance": "kill"},
+	})
+	require.NoError(t, err)
+	waitForStatus(t, runtime, containerID, "running")
+	containers, err := runtime.ListContainers(ctx, "conformance")
+	require.NoError(t, err)
+	require.Condition(t, func() bool {
+		for _, container := range containers {
+			if container.ID == containerID {
+				return container.Status == "running" && container.Labels["conformance"] == "kill"
+			}
+		}
+		return false
+	})
+	require.NoError(t, runtime.KillContainer(ctx, containerID))
+	waitForStatus(t, runtime, containerID, "exited")
+	info, err = runtime.InspectContainer(ctx, containerID)
+	require.NoError(t, err)
+	require.NotEqual(t, 0, info.ExitCode)
+	require.NoError(t, runtime.RemoveContainer(ctx, containerID))
+
+	// a stopped container exits
+	containerID, err = runtime.DeployContainer(ctx, types.Container{
+		Image:     conformanceImage,
+		Arguments: []string{"sleep", "300"},
+	})
+	require.NoError(t, err)
+	waitForStatus(t, runtime, containerID, "running")
+	require.NoError(t, runtime.StopContainer(ctx, containerID))
+	waitForStatus(t, runtime, containerID, "exited")
+	require.NoError(t, runtime.RemoveContainer(ctx, containerID))
+
+	// deploys that run out of time fail with a timeout
+	_, err = runtime.DeployContainer(ctx, types.Container{
+		Image:    "docker.io/library/busybox:1.36",
+		Timeouts: &types.Timeouts{Pull: types.Duration(time.Nanosecond)},
+	})
+	require.ErrorIs(t, err, ErrDeployTimeout)
+}
+
+func TestDockerRuntimeConformance(t *testing.T) {
+	artifacts, err := NewArtifactStore(t.TempDir())
+	require.NoError(t, err)
+
+	runtime := newConformanceRuntime(t, RuntimeDocker, RuntimeOptions{Artifacts: artifacts})
+	testRuntimeConformance(t, runtime, artifacts)
+}
+
+func TestPodmanRuntimeConformance(t *testing.T) {
+	artifacts, err := NewArtifactStore(t.TempDir())
+	require.NoError(t, err)
+
+	runtime := newConformanceRuntime(t, RuntimePodman, RuntimeOptions{Artifacts: artifacts})
+	testRuntimeConformance(t, runtime, artifacts)
+}
+
+func TestContainerdRuntimeConformance(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("containerd runtime requires root to mount snapshots")
+	}
+	if _, err := os.Stat(defaultContainerdEndpoint); err != nil {
+		t.Skipf("containerd runtime isn't available: %v", err)
+	}
+	artifacts, err := NewArtifactStore(t.TempDir())
+	require.NoError(t, err)
+
+	runtime := newConformanceRuntime(t, RuntimeContainerd, RuntimeOptions{
+		Namespace: "container-manager-test",
+		Artifacts: artifacts,
+	})
+	testRuntimeConformance(t, runtime, artifacts)
+}
+
+func TestNewRuntime(t *testing.T) {
+	t.Parallel()
+
+	require.Contains(t, Runtimes(), RuntimeDocker)
+	require.Contains(t, Runtimes(), RuntimeContainerd)
+	require.Contains(t, Runtimes(), RuntimePodman)
+
+	_, err := NewRuntime("unknown", RuntimeOptions{})
+	require.ErrorContains(t, err, `unknown container runtime "unknown"`)
+
+	mock := &MockDockerService{}
+	RegisterRuntime("test", func(opts RuntimeOptions) (DockerService, error) {
+		return mock, nil
+	})
+	runtime, err := NewRuntime("test", RuntimeOptions{})
+	require.NoError(t, err)
+	require.Same(t, mock, runtime)
+}
@@ -0,0 +1,365 @@
+package services
+
+import (
+	"container-manager/types"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrNoCapacity is the error returned when no node can take a job
+	ErrNoCapacity = fmt.Errorf("no node has capacity for the job")
+	// ErrUnschedulable is the error returned when no node satisfies the placement constraints of a job
+	ErrUnschedulable = fmt.Errorf("no node satisfies the placement constraints of the job")
+)
+
+// preferredAffinityScore is the score a node gets for satisfying every preferred affinity of a job.
+// It outweighs the strategy scores, so preferences are honoured before load and image locality.
+const preferredAffinityScore = 4
+
+// JobLister lists the jobs known to the node
+type JobLister interface {
+	Jobs() []types.Job
+}
+
+// ScoringStrategy scores how well a node suits a container, the node with the highest score is picked
+type ScoringStrategy interface {
+	Score(container types.Container, node types.NodeCapacity) float64
+}
+
+// ScoringStrategyFunc is a function that implements ScoringStrategy
+type ScoringStrategyFunc func(container types.Container, node types.NodeCapacity) float64
+
+// Score calls the function
+func (f ScoringStrategyFunc) Score(container types.Container, node types.NodeCapacity) float64 {
+	return f(container, node)
+}
+
+const (
+	// StrategyLeastLoaded places jobs on the node with the most free workers, CPU and memory
+	StrategyLeastLoaded = "least-loaded"
+	// StrategyBinPack places jobs on the busiest node that still has capacity
+	StrategyBinPack = "bin-pack"
+	// StrategyImageLocality places jobs on nodes that have the image cached
+	StrategyImageLocality = "image-locality"
+)
+
+var (
+	// strategies are the registered scoring strategies by name
+	strategies = map[string]ScoringStrategy{
+		StrategyLeastLoaded:   ScoringStrategyFunc(leastLoadedScore),
+		StrategyBinPack:       ScoringStrategyFunc(binPackScore),
+		StrategyImageLocality: ScoringStrategyFunc(imageLocalityScore),
+	}
+	// strategiesLock is a mutex for strategies
+	strategiesLock sync.RWMutex
+)
+
+// RegisterScoringStrategy registers a scoring strategy under a name
+func RegisterScoringStrategy(name string, strategy ScoringStrategy) {
+	strategiesLock.Lock()
+	defer strategiesLock.Unlock()
+
+	strategies[name] = strategy
+}
+
+// GetScoringStrategy returns the scoring strategy registered under the name
+func GetScoringStrategy(name string) (ScoringStrategy, error) {
+	strategiesLock.RLock()
+	defer strategiesLock.RUnlock()
+
+	strategy, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown placement strategy %q", name)
+	}
+	return strategy, nil
+}
+
+// Scheduler places jobs on the best node of the cluster.
+// local: The source of the capacity record of the local node
+// store: The capacity records gossiped by peers
+// jobs: The jobs known to the node, used by anti-affinity and spread constraints
+// strategy: The strategy used to score nodes
+type Scheduler struct {
+	local    CapacitySource
+	store    *CapacityStore
+	jobs     JobLister
+	strategy ScoringStrategy
+}
+
+// NewScheduler creates a new scheduler
+func NewScheduler(local CapacitySource, store *CapacityStore, jobs JobLister, strategy ScoringStrategy) *Scheduler {
+	return &Scheduler{
+		local:    local,
+		store:    store,
+		jobs:     jobs,
+		strategy: strategy,
+	}
+}
+
+// Place returns the ID of the node the container should run on
+func (s *Scheduler) Place(container types.Container) (string, error) {
+	nodes := s.nodes()
+	local := nodes[0]
+
+	feasible := s.filter(container, nodes)
+	if len(feasible) == 0 {
+		return "", ErrUnschedulable
+	}
+
+	candidates := make([]types.NodeCapacity, 0, len(feasible))
+	for _, node := range feasible {
+		if !node.QueueFull() {
+			candidates = append(candidates, node)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", ErrNoCapacity
+	}
+
+	scores := make(map[string]float64, len(candidates))
+	for _, node := range candidates {
+		scores[node.NodeID] = s.strategy.Score(container, node) + preferenceScore(container, node.Labels)
+	}
+
+	// the local node wins ties, then the lowest node ID
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if scores[a.NodeID] != scores[b.NodeID] {
+			return scores[a.NodeID] > scores[b.NodeID]
+		}
+		if a.NodeID == local.NodeID || b.NodeID == local.NodeID {
+			return a.NodeID == local.NodeID
+		}
+		return a.NodeID < b.NodeID
+	})
+
+	logrus.WithFields(logrus.Fields{
+		"node":       candidates[0].NodeID,
+		"score":      scores[candidates[0].NodeID],
+		"candidates": len(candidates),
+	}).Debug("placed job")
+	return candidates[0].NodeID, nil
+}
+
+// Fits checks that the local node satisfies the placement constraints of the container
+func (s *Scheduler) Fits(container types.Container) error {
+	nodes := s.nodes()
+	for _, node := range s.filter(container, nodes) {
+		if node.NodeID == nodes[0].NodeID {
+			return nil
+		}
+	}
+	return ErrUnschedulable
+}
+
+// nodes returns the capacity records of the local node, first, and of the peers,
+// with every node labelled with its ID
+func (s *Scheduler) nodes() []types.NodeCapacity {
+	local := s.local.Capacity()
+	nodes := []types.NodeCapacity{local}
+	for _, record := range s.store.Records() {
+		if record.NodeID != local.NodeID {
+			nodes = append(nodes, record)
+		}
+	}
+
+	for i, node := range nodes {
+		labels := make(map[string]string, len(node.Labels)+1)
+		for key, value := range node.Labels {
+			labels[key] = value
+		}
+		labels[types.NodeIDLabel] = node.NodeID
+		nodes[i].Labels = labels
+	}
+	return nodes
+}
+
+// placedJob is an active job with the labels of the node it runs on
+type placedJob struct {
+	labels     map[string]string
+	nodeLabels map[string]string
+}
+
+// filter returns the nodes that satisfy the placement constraints of the container
+func (s *Scheduler) filter(container types.Container, nodes []types.NodeCapacity) []types.NodeCapacity {
+	eligible := make([]types.NodeCapacity, 0, len(nodes))
+	for _, node := range nodes {
+		if matchesNode(container, node.Labels) {
+			eligible = append(eligible, node)
+		}
+	}
+	if len(container.AntiAffinity) == 0 && len(container.Spread) == 0 {
+		return eligible
+	}
+
+	jobs := s.placedJobs(nodes)
+	candidates := make([]types.NodeCapacity, 0, len(eligible))
+	for _, node := range eligible {
+		if !antiAffinityAllows(container.AntiAffinity, node.Labels, jobs) {
+			continue
+		}
+		if !spreadAllows(container.Spread, node.Labels, eligible, jobs) {
+			continue
+		}
+		candidates = append(candidates, node)
+	}
+	return candidates
+}
+
+// placedJobs returns the jobs that haven't failed or been cancelled on the known nodes
+func (s *Scheduler) placedJobs(nodes []types.NodeCapacity) []placedJob {
+	nodeLabels := make(map[string]map[string]string, len(nodes))
+	for _, node := range nodes {
+		nodeLabels[node.NodeID] = node.Labels
+	}
+
+	var jobs []placedJob
+	for _, job := range s.jobs.Jobs() {
+		labels, ok := nodeLabels[job.Node]
+		if !ok || job.Status.Failed() || job.Status == types.JobStatusCancelled {
+			continue
+		}
+		jobs = append(jobs, placedJob{labels: job.Container.Labels, nodeLabels: labels})
+	}
+	return jobs
+}
+
+// matchesNode reports whether the node labels satisfy the node selector and required affinity of the container
+func matchesNode(container types.Container, labels map[string]string) bool {
+	if !types.SelectorMatches(container.NodeSelector, labels) {
+		return false
+	}
+	if container.Affinity == nil {
+		return true
+	}
+	for _, requirement := range container.Affinity.Required {
+		if !requirement.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// antiAffinityAllows reports whether the node shares no domain with the jobs the container must avoid
+func antiAffinityAllows(terms []types.AntiAffinityTerm, labels map[string]string, jobs []placedJob) bool {
+	for _, term := range terms {
+		key := term.TopologyKey
+		if key == "" {
+			key = types.NodeIDLabel
+		}
+		domain, ok := labels[key]
+		if !ok {
+			continue
+		}
+
+		for _, job := range jobs {
+			if job.nodeLabels[key] == domain && types.SelectorMatches(term.Selector, job.labels) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// spreadAllows reports whether placing the container on the node keeps every spread constraint within its skew.
+// Domains are the values of the topology key on the eligible nodes, nodes without the key can't take the job.
+func spreadAllows(
+	constraints []types.SpreadConstraint,
+	labels map[string]string,
+	eligible []types.NodeCapacity,
+	jobs []placedJob,
+) bool {
+	for _, constraint := range constraints {
+		domain, ok := labels[constraint.TopologyKey]
+		if !ok {
+			return false
+		}
+
+		counts := make(map[string]int)
+		for _, node := range eligible {
+			if value, ok := node.Labels[constraint.TopologyKey]; ok {
+				counts[value] = 0
+			}
+		}
+		for _, job := range jobs {
+			value, ok := job.nodeLabels[constraint.TopologyKey]
+			if _, eligible := counts[value]; ok && eligible && types.SelectorMatches(constraint.Selector, job.labels) {
+				counts[value]++
+			}
+		}
+
+		minCount := counts[domain]
+		for _, count := range counts {
+			minCount = min(minCount, count)
+		}
+		if counts[domain]+1-minCount > constraint.MaxSkew {
+			return false
+		}
+	}
+	return true
+}
+
+// preferenceScore scores the share of the preferred affinity weight the node labels satisfy
+func preferenceScore(container types.Container, labels map[string]string) float64 {
+	if container.Affinity == nil {
+		return 0
+	}
+
+	var total, matched int
+	for _, preference := range container.Affinity.Preferred {
+		total += preference.Weight
+		if preference.Matches(labels) {
+			matched += preference.Weight
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return preferredAffinityScore * float64(matched) / float64(total)
+}
+
+// loadScore returns the share of free capacity of the node between 0 and 1
+func loadScore(node types.NodeCapacity) float64 {
+	var score, weights float64
+	if node.Workers > 0 {
+		queued := float64(node.QueueDepth) / float64(node.Workers)
+		score += float64(node.FreeWorkers)/float64(node.Workers) - min(queued, 1)
+		weights++
+	}
+	if node.CPUs > 0 {
+		score += node.CPUHeadroom / node.CPUs
+		weights++
+	}
+	if node.MemoryTotal > 0 {
+		score += float64(node.MemoryHeadroom) / float64(node.MemoryTotal)
+		weights++
+	}
+
+	if weights == 0 {
+		return 0
+	}
+	return score / weights
+}
+
+// leastLoadedScore prefers nodes with the most free capacity
+func leastLoadedScore(_ types.Container, node types.NodeCapacity) float64 {
+	return loadScore(node)
+}
+
+// binPackScore prefers the busiest nodes so idle nodes stay free
+func binPackScore(_ types.Container, node types.NodeCapacity) float64 {
+	return -loadScore(node)
+}
+
+// imageLocalityScore prefers nodes that have the image cached, then the least loaded ones
+func imageLocalityScore(container types.Container, node types.NodeCapacity) float64 {
+	score := loadScore(node)
+	if node.HasImage(normalizeImage(container.Image)) {
+		score += 2
+	}
+	return score
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"container-manager/types"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// staticCapacity is a capacity source that returns a fixed record
+type staticCapacity types.NodeCapacity
+
+func (s staticCapacity) Capacity() types.NodeCapacity {
+	return types.NodeCapacity(s)
+}
+
+// staticJobs is a job lister that returns a fixed list of jobs
+type staticJobs []types.Job
+
+func (s staticJobs) Jobs() []types.Job {
+	return s
+}
+
+// newTestScheduler creates a scheduler with a local record and peer records
+func newTestScheduler(t *testing.T, strategy string, local types.NodeCapacity, peers ...types.NodeCapacity) *Scheduler {
+	t.Helper()
+	return newTestSchedulerWithJobs(t, strategy, nil, local, peers...)
+}
+
+// newTestSchedulerWithJobs creates a scheduler with a local record, peer records and known jobs
+func newTestSchedulerWithJobs(
+	t *testing.T,
+	strategy string,
+	jobs []types.Job,
+	local types.NodeCapacity,
+	peers ...types.NodeCapacity,
+) *Scheduler {
+	t.Helper()
+
+	scoring, err := GetScoringStrategy(strategy)
+	require.NoError(t, err)
+
+	store := NewCapacityStore(CapacityRecordTTL)
+	for _, record := range peers {
+		record.UpdatedAt = time.Now()
+		store.Update(record)
+	}
+	return NewScheduler(staticCapacity(local), store, staticJobs(jobs), scoring)
+}
+
+func TestSchedulerLeastLoaded(t *testing.T) {
+	t.Parallel()
+
+	local := types.NodeCapacity{NodeID: "local", Workers: 4, FreeWorkers: 1, QueueSize: 10}
+	idle := types.NodeCapacity{NodeID: "idle", Workers: 4, FreeWorkers: 4, QueueSize: 10}
+	busy := types.NodeCapacity{NodeID: "busy", Workers: 4, QueueDepth: 4, QueueSize: 10}
+
+	scheduler := newTestScheduler(t, StrategyLeastLoaded, local, idle, busy)
+	nodeID, err := scheduler.Place(types.Container{Image: "nginx"})
+	require.NoError(t, err)
+	require.Equal(t, "idle", nodeID)
+}
+
+func TestSchedulerBinPack(t *testing.T) {
+	t.Parallel()
+
+	local := types.NodeCapacity{NodeID: "local", Workers: 4, FreeWorkers: 4, QueueSize: 10}
+	busy := types.NodeCapacity{NodeID: "busy", Workers: 4, FreeWorkers: 1, QueueSize: 10}
+
+	scheduler := newTestScheduler(t, StrategyBinPack, local, busy)
+	nodeID, err := scheduler.Place(types.Container{Image: "nginx"})
+	require.NoError(t, err)
+	require.Equal(t, "busy", nodeID)
+}
+
+func TestSchedulerImageLocality(t *testing.T) {
+	t.Parallel()
+
+	local := types.NodeCapacity{NodeID: "local", Workers: 4, FreeWorkers: 4, QueueSize: 10}
+	cached := types.NodeCapacity{
+		NodeID:      "cached",
+		Workers:     4,
+		FreeWorkers: 1,
+		QueueSize:   10,
+		Images:      []string{"docker.io/library/nginx:latest"},
+	}
+
+	scheduler := newTestScheduler(t, StrategyImageLocality, local, cached)
+	nodeID, err := scheduler.Place(types.Container{Image: "nginx"})
+	require.NoError(t, err)
+	require.Equal(t, "cached", nodeID)
+
+	nodeID, err = scheduler.Place(types.Container{Image: "redis"})
+	require.NoError(t, err)
+	require.Equal(t, "local", nodeID)
+}
+
+func TestSchedulerSkipsFullQueues(t *testing.T) {
+	t.Parallel()
+
+	local := types.NodeCapacity{NodeID: "local", Workers: 4, QueueDepth: 10, QueueSize: 10}
+	full := types.NodeCapacity{NodeID: "full", Workers: 4, FreeWorkers: 4, QueueDepth: 10, QueueSize: 10}
+	busy := types.NodeCapacity{NodeID: "busy", Workers: 4, QueueDepth: 9, QueueSize: 10}
+
+	scheduler := newTestScheduler(t, StrategyLeastLoaded, local, full, busy)
+	nodeID, err := scheduler.Place(types.Container{Image: "nginx"})
+	require.NoError(t, err)
+	require.Equal(t, "busy", nodeID)
+
+	scheduler = newTestScheduler(t, StrategyLeastLoaded, local, full)
+	_, err = scheduler.Place(types.Container{Image: "nginx"})
+	require.ErrorIs(t, err, ErrNoCapacity)
+}
+
+func TestSchedulerPrefersLocalOnTie(t *testing.T) {
+	t.Parallel()
+
+	local := types.NodeCapacity{NodeID: "local", Workers: 4, FreeWorkers: 4, QueueSize: 10}
+	peerA := types.NodeCapacity{NodeID: "a", Workers: 4, FreeWorkers: 4, QueueSize: 10}
+
+	scheduler := newTestScheduler(t, StrategyLeastLoaded, local, peerA)
+	nodeID, err := scheduler.Place(types.Container{Image: "nginx"})
+	require.NoError(t, err)
+	require.Equal(t, "local", nodeID)
+}
+
+func TestSchedulerIgnoresStaleRecords(t *testing.T) {
+	t.Parallel()
+
+	local := types.NodeCapacity{NodeID: "local", Workers: 4, QueueSize: 10}
+	store := NewCapacityStore(CapacityRecordTTL)
+	store.Update(types.NodeCapacity{
+		NodeID:      "stale",
+		Workers:     4,
+		FreeWorkers: 4,
+		QueueSize:   10,
+		UpdatedAt:   time.Now().Add(-2 * CapacityRecordTTL),
+	})
+
+	scoring, err := GetScoringStrategy(StrategyLeastLoaded)
+	require.NoError(t, err)
+	scheduler := NewScheduler(staticCapacity(local), store, staticJobs(nil), scoring)
+
+	nodeID, err := scheduler.Place(types.Container{Image: "nginx"})
+	require.NoError(t, err)
+	require.Equal(t, "local", nodeID)
+}
+
+func TestGetScoringStrategy(t *testing.T) {
+	t.Parallel()
+
+	_, err := GetScoringStrategy("unknown")
+	require.Error(t, err)
+
+	RegisterScoringStrategy("test-always-zero", ScoringStrategyFunc(func(types.Container, types.NodeCapacity) float64 {
+		return 0
+	}))
+	strategy, err := GetScoringStrategy("test-always-zero")
+	require.NoError(t, err)
+	require.Zero(t, strategy.Score(types.Container{}, types.NodeCapacity{}))
+}
+
+func TestSchedulerNodeSelector(t *testing.T) {
+	t.Parallel()
+
+	local := types.NodeCapacity{NodeID: "local", Workers: 4, FreeWorkers: 4, QueueSize: 10}
+	gpu := types.NodeCapacity{
+		NodeID:    "gpu",
+		Workers:   4,
+		QueueSize: 10,
+		Labels:    map[string]string{"gpu": "true"},
+	}
+
+	scheduler := newTestScheduler(t, StrategyLeastLoaded, local, gpu)
+	nodeID, err := scheduler.Place(types.Container{Image: "nginx", NodeSelector: map[string]string{"gpu": "true"}})
+	require.NoError(t, err)
+	require.Equal(t, "gpu", nodeID)
+
+	_, err = scheduler.Place(types.Container{Image: "nginx", NodeSelector: map[string]string{"gpu": "false"}})
+	require.ErrorIs(t, err, ErrUnschedulable)
+
+	// jobs can be pinned to a node by its ID
+	nodeID, err = scheduler.Place(types.Container{
+		Image:        "nginx",
+		NodeSelector: map[string]string{types.NodeIDLabel: "gpu"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "gpu", nodeID)
+}
+
+func TestSchedulerAffinity(t *testing.T) {
+	t.Parallel()
+
+	local := types.NodeCapacity{
+		NodeID:      "local",
+		Workers:     4,
+		FreeWorkers: 4,
+		QueueSize:   10,
+		Labels:      map[string]string{"zone": "a", "disk": "hdd"},
+	}
+	zoneB := types.NodeCapacity{
+		NodeID:    "b",
+		Workers:   4,
+		QueueSize: 10,
+		Labels:    map[string]string{"zone": "b", "disk": "ssd"},
+	}
+	zoneC := types.NodeCapacity{
+		NodeID:    "c",
+		Workers:   4,
+		QueueSize: 10,
+		Labels:    map[string]string{"zone": "c", "disk": "hdd"},
+	}
+	scheduler := newTestScheduler(t, StrategyLeastLoaded, local, zoneB, zoneC)
+
+	// required affinity excludes nodes, preferred affinity outweighs the load
+	nodeID, err := scheduler.Place(types.Container{
+		Image: "nginx",
+		Affinity: &types.Affinity{
+			Required: []types.LabelRequirement{
+				{Key: "zone", Operator: types.LabelOpNotIn, Values: []string{"a"}},
+			},
+			Preferred: []types.WeightedLabelRequirement{
+				{Weight: 10, LabelRequirement: types.LabelRequirement{
+					Key:      "disk",
+					Operator: types.LabelOpIn,
+					Values:   []string{"ssd"},
+				}},
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "b", nodeID)
+
+	_, err = scheduler.Place(types.Container{
+		Image: "nginx",
+		Affinity: &types.Affinity{
+			Required: []types.LabelRequirement{{Key: "rack", Operator: types.LabelOpExists}},
+		},
+	})
+	require.ErrorIs(t, err, ErrUnschedulable)
+}
+
+func TestSchedulerAntiAffinity(t *testing.T) {
+	t.Parallel()
+
+	local := types.NodeCapacity{
+		NodeID:      "local",
+		Workers:     4,
+		FreeWorkers: 4,
+		QueueSize:   10,
+		Labels:      map[string]string{"zone": "a"},
+	}
+	sameZone := types.NodeCapacity{NodeID: "a2", Workers: 4, QueueSize: 10, Labels: map[string]string{"zone": "a"}}
+	otherZone := types.NodeCapacity{NodeID: "b", Workers: 4, QueueSize: 10, Labels: map[string]string{"zone": "b"}}
+	jobs := []types.Job{
+		{ID: "db", Node: "local", Status: types.JobStatusComplete, Container: types.Container{
+			Labels: map[string]string{"app": "db"},
+		}},
+		{ID: "failed-db", Node: "b", Status: types.JobStatusFailed, Container: types.Container{
+			Labels: map[string]string{"app": "db"},
+		}},
+	}
+	scheduler := newTestSchedulerWithJobs(t, StrategyLeastLoaded, jobs, local, sameZone, otherZone)
+
+	container := types.Container{
+		Image:        "postgres",
+		AntiAffinity: []types.AntiAffinityTerm{{Selector: map[string]string{"app": "db"}}},
+	}
+	nodeID, err := scheduler.Place(container)
+	require.NoError(t, err)
+	require.Equal(t, "a2", nodeID)
+	require.ErrorIs(t, scheduler.Fits(container), ErrUnschedulable)
+
+	container.AntiAffinity[0].TopologyKey = "zone"
+	nodeID, err = scheduler.Place(container)
+	require.NoError(t, err)
+	require.Equal(t, "b", nodeID)
+}
+
+func TestSchedulerSpread(t *testing.T) {
+	t.Parallel()
+
+	local := types.NodeCapacity{
+		NodeID:      "local",
+		Workers:     4,
+		FreeWorkers: 4,
+		QueueSize:   10,
+		Labels:      map[string]string{"zone": "a"},
+	}
+	zoneB := types.NodeCapacity{NodeID: "b", Workers: 4, QueueSize: 10, Labels: map[string]string{"zone": "b"}}
+	unlabelled := types.NodeCapacity{NodeID: "c", Workers: 4, FreeWorkers: 4, QueueSize: 10}
+	jobs := []types.Job{
+		{ID: "web-1", Node: "local", Status: types.JobStatusPending, Container: types.Container{
+			Labels: map[string]string{"app": "web"},
+		}},
+	}
+	scheduler := newTestSchedulerWithJobs(t, StrategyLeastLoaded, jobs, local, zoneB, unlabelled)
+
+	container := types.Container{
+		Image:  "nginx",
+		Labels: map[string]string{"app": "web"},
+		Spread: []types.SpreadConstraint{{TopologyKey: "zone", MaxSkew: 1, Selector: map[string]string{"app": "web"}}},
+	}
+	nodeID, err := scheduler.Place(container)
+	require.NoError(t, err)
+	require.Equal(t, "b", nodeID)
+
+	container.Spread[0].MaxSkew = 2
+	nodeID, err = scheduler.Place(container)
+	require.NoError(t, err)
+	require.Equal(t, "local", nodeID)
+}
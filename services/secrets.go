@@ -0,0 +1,474 @@
+package services
+
+import (
+	"bufio"
+	"container-manager/types"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrSecretNotFound is the error returned when a secret isn't in the store
+	ErrSecretNotFound = fmt.Errorf("secret not found")
+	// ErrSecretsNotEnabled is the error returned when a container references secrets on a node without secrets
+	ErrSecretsNotEnabled = fmt.Errorf("secrets are not enabled")
+)
+
+const (
+	// SecretKeyFile is the name of the key the secrets are encrypted with in the data directory
+	SecretKeyFile = "secrets.key"
+	// secretKeySize is the size of the AES-256 key of the secret store
+	secretKeySize = 32
+	// MaxSecretSize is the maximum size of the value of a secret
+	MaxSecretSize = 64 << 10
+	// maxSecretMessageSize is the maximum size of the request and response lines of a secret stream
+	maxSecretMessageSize = 2*MaxSecretSize + 1024
+	// secretsLabel is the label of a container with the ID of the directory of its secret files
+	secretsLabel = "container-manager.secrets"
+)
+
+// SecretSource resolves the values of secrets
+type SecretSource interface {
+	Resolve(ctx context.Context, name string) ([]byte, error)
+}
+
+// SecretFetcher fetches secrets from the nodes that hold them
+type SecretFetcher interface {
+	FetchSecret(ctx context.Context, name string) ([]byte, error)
+}
+
+// LoadOrCreateSecretKey loads the key the secrets are encrypted with, generating and saving a new key
+// when it doesn't exist yet
+func LoadOrCreateSecretKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err == nil {
+		if len(key) != secretKeySize {
+			return nil, fmt.Errorf("secret key %s must be %d bytes", path, secretKeySize)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to load secret key: %w", err)
+	}
+
+	key = make([]byte, secretKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate secret key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create secret key directory: %w", err)
+	}
+	if err := os.WriteFile(path, key, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write secret key: %w", err)
+	}
+
+	logrus.WithField("path", path).Info("Generated new secret key")
+	return key, nil
+}
+
+// SecretStore keeps the secrets of the node, each encrypted at rest with AES-256-GCM in its own file.
+// dir: The directory the secrets are stored in
+// aead: Encrypts and decrypts the secrets, with their name as additional data
+// fetcher: Fetches the secrets held by other nodes
+// mutex: The mutex to protect the files of the secrets and the fetcher
+type SecretStore struct {
+	dir     string
+	aead    cipher.AEAD
+	fetcher SecretFetcher
+	mutex   sync.Mutex
+}
+
+// NewSecretStore creates a new secret store in the directory, encrypted with the key
+func NewSecretStore(dir string, key []byte) (*SecretStore, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create secret cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create secret cipher: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create secret directory: %w", err)
+	}
+	return &SecretStore{dir: dir, aead: aead}, nil
+}
+
+// SetFetcher sets the fetcher used for secrets held by other nodes
+func (ss *SecretStore) SetFetcher(fetcher SecretFetcher) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	ss.fetcher = fetcher
+}
+
+// Put sets the value of a secret
+func (ss *SecretStore) Put(name string, value []byte) error {
+	if err := types.ValidateSecretName(name); err != nil {
+		return err
+	}
+	if len(value) > MaxSecretSize {
+		return fmt.Errorf("secret %q exceeds %d bytes", name, MaxSecretSize)
+	}
+
+	nonce := make([]byte, ss.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to encrypt secret: %w", err)
+	}
+	sealed := ss.aead.Seal(nonce, nonce, value, []byte(name))
+
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	file, err := os.CreateTemp(ss.dir, ".put-*")
+	if err != nil {
+		return fmt.Errorf("failed to create secret file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(sealed); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write secret: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write secret: %w", err)
+	}
+	if err := os.Rename(file.Name(), filepath.Join(ss.dir, name)); err != nil {
+		return fmt.Errorf("failed to store secret: %w", err)
+	}
+	return nil
+}
+
+// Get returns the value of a secret of the node
+func (ss *SecretStore) Get(name string) ([]byte, error) {
+	if err := types.ValidateSecretName(name); err != nil {
+		return nil, err
+	}
+
+	ss.mutex.Lock()
+	sealed, err := os.ReadFile(filepath.Join(ss.dir, name))
+	ss.mutex.Unlock()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret: %w", err)
+	}
+
+	nonceSize := ss.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, fmt.Errorf("failed to decrypt secret %q: it is truncated", name)
+	}
+	value, err := ss.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secret %q: %w", name, err)
+	}
+	return value, nil
+}
+
+// Delete deletes a secret of the node
+func (ss *SecretStore) Delete(name string) error {
+	if err := types.ValidateSecretName(name); err != nil {
+		return err
+	}
+
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	err := os.Remove(filepath.Join(ss.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete secret: %w", err)
+	}
+	return nil
+}
+
+// List returns the secrets of the node sorted by name, without their values
+func (ss *SecretStore) List() ([]types.SecretInfo, error) {
+	ss.mutex.Lock()
+	entries, err := os.ReadDir(ss.dir)
+	ss.mutex.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+
+	secrets := make([]types.SecretInfo, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || types.ValidateSecretName(entry.Name()) != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		secrets = append(secrets, types.SecretInfo{Name: entry.Name(), UpdatedAt: info.ModTime().UTC()})
+	}
+	sort.Slice(secrets, func(i, j int) bool {
+		return secrets[i].Name < secrets[j].Name
+	})
+	return secrets, nil
+}
+
+// Resolve returns the value of a secret, from the node or else from the nodes that hold it
+func (ss *SecretStore) Resolve(ctx context.Context, name string) ([]byte, error) {
+	value, err := ss.Get(name)
+	if !errors.Is(err, ErrSecretNotFound) {
+		return value, err
+	}
+
+	ss.mutex.Lock()
+	fetcher := ss.fetcher
+	ss.mutex.Unlock()
+	if fetcher == nil {
+		return nil, err
+	}
+
+	logrus.WithField("secret", name).Debug("fetching secret from peers")
+	return fetcher.FetchSecret(ctx, name)
+}
+
+// secretMounts are the secrets of a container resolved by the node that runs it.
+// id: The ID of the directory of the secret files, empty without files
+// env: The environment variables of the secrets, as KEY=value
+// files: The secret files and where they are mounted in the container
+type secretMounts struct {
+	id    string
+	env   []string
+	files []secretFile
+}
+
+// secretFile is a secret file written on the tmpfs of the node and mounted read-only in a container.
+// source: The path of the file on the node
+// target: The path of the file in the container
+type secretFile struct {
+	source string
+	target string
+}
+
+// SecretInjector resolves the secrets of the containers of the node when they are deployed, and writes
+// their files to a directory on a tmpfs, so their values never touch a disk.
+// source: Resolves the values of the secrets
+// dir: The tmpfs directory the secret files are written to, shared with the container runtime
+type SecretInjector struct {
+	source SecretSource
+	dir    string
+}
+
+// NewSecretInjector creates a new secret injector that writes the secret files to the directory
+func NewSecretInjector(source SecretSource, dir string) *SecretInjector {
+	return &SecretInjector{source: source, dir: dir}
+}
+
+// Resolve returns the environment variables and the files of the secrets of a container, by file path
+func (si *SecretInjector) Resolve(ctx context.Context, refs []types.SecretRef) (map[string]string, map[string][]byte, error) {
+	if len(refs) == 0 {
+		return nil, nil, nil
+	}
+	if si == nil {
+		return nil, nil, ErrSecretsNotEnabled
+	}
+
+	env := make(map[string]string)
+	files := make(map[string][]byte)
+	for _, ref := range refs {
+		value, err := si.source.Resolve(ctx, ref.Name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to resolve secret %q: %w", ref.Name, err)
+		}
+		if ref.Env != "" {
+			env[ref.Env] = string(value)
+		}
+		if ref.File != "" {
+			files[ref.File] = value
+		}
+	}
+	return env, files, nil
+}
+
+// mount resolves the secrets of a container and writes its secret files, the files are removed with release
+func (si *SecretInjector) mount(ctx context.Context, refs []types.SecretRef) (secretMounts, error) {
+	env, files, err := si.Resolve(ctx, refs)
+	if err != nil {
+		return secretMounts{}, err
+	}
+
+	var mounts secretMounts
+	for key, value := range env {
+		mounts.env = append(mounts.env, key+"="+value)
+	}
+	if len(files) == 0 {
+		return mounts, nil
+	}
+
+	if si.dir == "" {
+		return secretMounts{}, fmt.Errorf("secret files are not enabled")
+	}
+	mounts.id = uuid.NewString()
+	dir := filepath.Join(si.dir, mounts.id)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return secretMounts{}, fmt.Errorf("failed to create secret directory: %w", err)
+	}
+	targets := make([]string, 0, len(files))
+	for target := range files {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	for i, target := range targets {
+		source := filepath.Join(dir, strconv.Itoa(i))
+		if err := os.WriteFile(source, files[target], 0o444); err != nil {
+			si.release(mounts.id)
+			return secretMounts{}, fmt.Errorf("failed to write secret file: %w", err)
+		}
+		mounts.files = append(mounts.files, secretFile{source: source, target: target})
+	}
+	return mounts, nil
+}
+
+// release removes the secret files of a container
+func (si *SecretInjector) release(id string) {
+	if si == nil || id == "" || strings.ContainsAny(id, `/\`) || id == ".." {
+		return
+	}
+	if err := os.RemoveAll(filepath.Join(si.dir, id)); err != nil {
+		logrus.WithField("secrets", id).Warnf("failed to remove secret files: %v", err)
+	}
+}
+
+// secretRequest is the request of a secret stream.
+// name: The name of the secret
+type secretRequest struct {
+	Name string `json:"name"`
+}
+
+// secretResponse is the answer to a secret request.
+// value: The value of the secret
+// error: Why the secret couldn't be served, empty when it was
+type secretResponse struct {
+	Value []byte `json:"value,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// WithSecretStore serves the secrets of the store to peers. Only closed clusters, with peer trust or a private
+// network, should serve secrets.
+func WithSecretStore(store *SecretStore) P2POption {
+	return func(o *p2pOptions) {
+		o.secrets = store
+	}
+}
+
+// handleSecretStream serves a secret of the node to a peer that runs a container referencing it
+func (s *Service) handleSecretStream(stream network.Stream) {
+	defer stream.Close()
+	remote := stream.Conn().RemotePeer()
+
+	reader := bufio.NewReaderSize(io.LimitReader(stream, maxSecretMessageSize), maxSecretMessageSize)
+	var request secretRequest
+	if err := readJSONLine(reader, &request); err != nil {
+		logrus.WithField("peer", remote).Errorf("failed to read secret request: %v", err)
+		return
+	}
+
+	// secrets are only served to trusted peers that run a job of this node referencing them
+	if !s.trust.Trusted(remote) || !s.assignedSecret(remote.String(), request.Name) {
+		logrus.WithFields(logrus.Fields{
+			"secret": request.Name,
+			"peer":   remote,
+		}).Warn("rejected secret request of peer")
+		json.NewEncoder(stream).Encode(secretResponse{Error: fmt.Sprintf("%v: %s", ErrSecretNotFound, request.Name)})
+		return
+	}
+
+	value, err := s.secrets.Get(request.Name)
+	if err != nil {
+		json.NewEncoder(stream).Encode(secretResponse{Error: err.Error()})
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"secret": request.Name,
+		"peer":   remote,
+	}).Info("serving secret to peer")
+	if err := json.NewEncoder(stream).Encode(secretResponse{Value: value}); err != nil {
+		logrus.WithField("peer", remote).Errorf("failed to write secret response: %v", err)
+	}
+}
+
+// assignedSecret reports whether this node handed a job referencing the secret to the peer, which is yet to
+// run or running
+func (s *Service) assignedSecret(peerID string, name string) bool {
+	for _, job := range s.jobQueue.Jobs() {
+		if job.Node != peerID || (job.Status != types.JobStatusPending && job.Status != types.JobStatusRunning) {
+			continue
+		}
+		for _, ref := range job.Container.Secrets {
+			if ref.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// FetchSecret fetches a secret from the connected trusted peers that hold it
+func (s *Service) FetchSecret(ctx context.Context, name string) ([]byte, error) {
+	for _, id := range s.host.Network().Peers() {
+		if !s.trust.Trusted(id) {
+			continue
+		}
+		value, err := s.fetchSecret(ctx, id, name)
+		if err == nil {
+			return value, nil
+		}
+		logrus.WithFields(logrus.Fields{
+			"secret": name,
+			"peer":   id,
+		}).Debugf("failed to fetch secret: %v", err)
+	}
+	return nil, fmt.Errorf("%w: %s on any peer", ErrSecretNotFound, name)
+}
+
+// fetchSecret fetches a secret from a peer over a secret stream
+func (s *Service) fetchSecret(ctx context.Context, id peer.ID, name string) ([]byte, error) {
+	stream, err := s.host.NewStream(ctx, id, SecretProtocolID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open secret stream: %w", err)
+	}
+	defer stream.Close()
+	stop := context.AfterFunc(ctx, func() {
+		stream.Reset()
+	})
+	defer stop()
+
+	if err := json.NewEncoder(stream).Encode(secretRequest{Name: name}); err != nil {
+		stream.Reset()
+		return nil, fmt.Errorf("failed to write secret request: %w", err)
+	}
+	reader := bufio.NewReaderSize(io.LimitReader(stream, maxSecretMessageSize), maxSecretMessageSize)
+	var response secretResponse
+	if err := readJSONLine(reader, &response); err != nil {
+		stream.Reset()
+		return nil, fmt.Errorf("failed to read secret response: %w", err)
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("%s", response.Error)
+	}
+	return response.Value, nil
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"bytes"
+	"container-manager/types"
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSecretStore creates a secret store with a new key in a temporary directory
+func newTestSecretStore(t *testing.T) *SecretStore {
+	t.Helper()
+
+	dir := t.TempDir()
+	key, err := LoadOrCreateSecretKey(filepath.Join(dir, SecretKeyFile))
+	require.NoError(t, err)
+	store, err := NewSecretStore(filepath.Join(dir, "secrets"), key)
+	require.NoError(t, err)
+	return store
+}
+
+func TestSecretStore(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, SecretKeyFile)
+	key, err := LoadOrCreateSecretKey(keyFile)
+	require.NoError(t, err)
+	loaded, err := LoadOrCreateSecretKey(keyFile)
+	require.NoError(t, err)
+	require.Equal(t, key, loaded)
+
+	store, err := NewSecretStore(filepath.Join(dir, "secrets"), key)
+	require.NoError(t, err)
+	require.NoError(t, store.Put("db-password", []byte("hunter2")))
+	require.NoError(t, store.Put("api.token", []byte("token")))
+	require.Error(t, store.Put("../escape", []byte("x")))
+	require.Error(t, store.Put("large", make([]byte, MaxSecretSize+1)))
+
+	value, err := store.Get("db-password")
+	require.NoError(t, err)
+	require.Equal(t, "hunter2", string(value))
+	_, err = store.Get("missing")
+	require.ErrorIs(t, err, ErrSecretNotFound)
+
+	secrets, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, secrets, 2)
+	require.Equal(t, "api.token", secrets[0].Name)
+	require.Equal(t, "db-password", secrets[1].Name)
+
+	// the values are encrypted at rest, and only the key decrypts them
+	sealed, err := os.ReadFile(filepath.Join(dir, "secrets", "db-password"))
+	require.NoError(t, err)
+	require.NotContains(t, string(sealed), "hunter2")
+	other, err := NewSecretStore(filepath.Join(dir, "secrets"), make([]byte, secretKeySize))
+	require.NoError(t, err)
+	_, err = other.Get("db-password")
+	require.Error(t, err)
+
+	require.NoError(t, store.Delete("db-password"))
+	require.ErrorIs(t, store.Delete("db-password"), ErrSecretNotFound)
+	_, err = store.Resolve(context.Background(), "db-password")
+	require.ErrorIs(t, err, ErrSecretNotFound)
+}
+
+func TestSecretInjector(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := newTestSecretStore(t)
+	require.NoError(t, store.Put("db-password", []byte("hunter2")))
+	dir := t.TempDir()
+	injector := NewSecretInjector(store, dir)
+
+	mounts, err := injector.mount(ctx, []types.SecretRef{
+		{Name: "db-password", Env: "DB_PASSWORD", File: "/run/secrets/db"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"DB_PASSWORD=hunter2"}, mounts.env)
+	require.Len(t, mounts.files, 1)
+	require.Equal(t, "/run/secrets/db", mounts.files[0].target)
+	content, err := os.ReadFile(mounts.files[0].source)
+	require.NoError(t, err)
+	require.Equal(t, "hunter2", string(content))
+
+	injector.release(mounts.id)
+	_, err = os.Stat(filepath.Join(dir, mounts.id))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = injector.mount(ctx, []types.SecretRef{{Name: "missing", Env: "MISSING"}})
+	require.ErrorIs(t, err, ErrSecretNotFound)
+	_, err = NewSecretInjector(store, "").mount(ctx, []types.SecretRef{{Name: "db-password", File: "/run/secrets/db"}})
+	require.Error(t, err)
+
+	// containers without secrets deploy on nodes without secrets
+	var disabled *SecretInjector
+	_, err = disabled.mount(ctx, nil)
+	require.NoError(t, err)
+	_, err = disabled.mount(ctx, []types.SecretRef{{Name: "db-password", Env: "DB_PASSWORD"}})
+	require.ErrorIs(t, err, ErrSecretsNotEnabled)
+}
+
+func TestFakeRuntimeSecrets(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := newTestSecretStore(t)
+	require.NoError(t, store.Put("db-password", []byte("hunter2")))
+	ds, err := newFakeRuntime(RuntimeOptions{Secrets: NewSecretInjector(store, t.TempDir())})
+	require.NoError(t, err)
+	runtime := ds.(*FakeRuntime)
+
+	container := types.Container{
+		Image: "busybox",
+		Env:   map[string]string{"MODE": "test"},
+		Secrets: []types.SecretRef{
+			{Name: "db-password", Env: "DB_PASSWORD"},
+			{Name: "db-password", File: "/run/secrets/db"},
+		},
+	}
+	require.NoError(t, container.Validate())
+	require.Equal(t, map[string]string{"MODE": types.RedactedValue}, container.Redacted().Env)
+	require.Equal(t, "test", container.Env["MODE"])
+
+	id, err := runtime.DeployContainer(ctx, container)
+	require.NoError(t, err)
+
+	session, err := runtime.Exec(ctx, id, ExecOptions{Command: []string{"env"}})
+	require.NoError(t, err)
+	result, err := CollectExec(ctx, session, "")
+	require.NoError(t, err)
+	require.Equal(t, "DB_PASSWORD=hunter2\nMODE=test\n", result.Stdout)
+
+	reader, err := runtime.CopyFromContainer(ctx, id, "/run/secrets/db")
+	require.NoError(t, err)
+	archive, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	reader.Close()
+	require.Equal(t, map[string]string{"db": "hunter2"}, archiveContents(t, archive))
+
+	// secrets are validated with the container
+	container.Secrets = append(container.Secrets, types.SecretRef{Name: "other", Env: "MODE"})
+	require.Error(t, container.Validate())
+	container.Secrets = []types.SecretRef{{Name: "db-password", File: "relative"}}
+	require.Error(t, container.Validate())
+	container.Secrets = []types.SecretRef{{Name: "db-password"}}
+	require.Error(t, container.Validate())
+}
+
+func TestContainerRedacted(t *testing.T) {
+	t.Parallel()
+
+	// the containers deployed by the runtimes carry their spec in a label, with the values of their env
+	container := types.Container{
+		Image:  "app",
+		Env:    map[string]string{"DB_PASSWORD": "hunter2"},
+		Labels: map[string]string{"team": "data"},
+	}.Managed("job", "node")
+	require.Contains(t, container.Labels[types.ManagedSpecLabel], "hunter2")
+
+	var logs bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&logs)
+	logger.WithField("container", container.Redacted()).Info("Deploying container")
+	require.NotContains(t, logs.String(), "hunter2")
+	require.Contains(t, logs.String(), "DB_PASSWORD")
+	require.Contains(t, logs.String(), "team:data")
+
+	// the container itself is left alone
+	require.Equal(t, "hunter2", container.Env["DB_PASSWORD"])
+	require.Contains(t, container.Labels[types.ManagedSpecLabel], "hunter2")
+	require.Equal(t, map[string]string{"PARAM": types.RedactedValue}, types.RedactedMatrix(map[string][]string{
+		"PARAM": {"hunter2"},
+	}))
+}
+
+func TestP2PServiceFetchSecret(t *testing.T) {
+	t.Parallel()
+
+	key1, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	nodeID1, err := peer.IDFromPrivateKey(key1)
+	require.NoError(t, err)
+	key2, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	nodeID2, err := peer.IDFromPrivateKey(key2)
+	require.NoError(t, err)
+	trust1, err := NewPeerTrust([]string{nodeID2.String()}, nil)
+	require.NoError(t, err)
+	trust2, err := NewPeerTrust([]string{nodeID1.String()}, nil)
+	require.NoError(t, err)
+
+	store1 := newTestSecretStore(t)
+	require.NoError(t, store1.Put("db-password", []byte("hunter2")))
+	require.NoError(t, store1.Put("api-token", []byte("token")))
+	store2 := newTestSecretStore(t)
+
+	jobQueue1 := NewQueue(10, nil, WithNodeID(nodeID1.String()))
+	service1, err := NewP2PService(jobQueue1, 4062, WithIdentity(key1), WithPeerTrust(trust1), WithSecretStore(store1))
+	require.NoError(t, err)
+	service2, err := NewP2PService(NewQueue(10, nil), 4063, WithIdentity(key2), WithPeerTrust(trust2), WithSecretStore(store2))
+	require.NoError(t, err)
+	store2.SetFetcher(service2)
+
+	go service1.Start(t.Name())
+	go service2.Start(t.Name())
+	defer service1.Stop()
+	defer service2.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, service2.host.Connect(ctx, peer.AddrInfo{
+		ID:    service1.host.ID(),
+		Addrs: service1.host.Addrs(),
+	}))
+
+	// secrets are only served to the peers that run a job referencing them
+	_, err = store2.Resolve(ctx, "db-password")
+	require.ErrorIs(t, err, ErrSecretNotFound)
+	jobQueue1.Track(types.Job{
+		ID:     "job",
+		Node:   nodeID2.String(),
+		Status: types.JobStatusPending,
+		Container: types.Container{
+			Image:   "app",
+			Secrets: []types.SecretRef{{Name: "db-password", Env: "DB_PASSWORD"}},
+		},
+	})
+
+	// secrets missing on the node are resolved from the peers that hold them
+	var value []byte
+	require.Eventually(t, func() bool {
+		value, err = store2.Resolve(ctx, "db-password")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, "hunter2", string(value))
+
+	_, err = store2.Resolve(ctx, "missing")
+	require.ErrorIs(t, err, ErrSecretNotFound)
+	_, err = store2.Resolve(ctx, "api-token")
+	require.ErrorIs(t, err, ErrSecretNotFound)
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"bytes"
+	"container-manager/types"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// ErrSecurityNotAllowed is the error returned when the security options of a container are rejected by the security policy
+var ErrSecurityNotAllowed = fmt.Errorf("security options are not allowed by the security policy")
+
+// SecurityPolicy caps the security options containers may request.
+// AllowedCapabilities: The capabilities containers may add, none when empty and every one with ALL
+// AllowUnconfined: Allow containers to run without the seccomp or AppArmor profile of the runtime
+// RequireNonRoot: Reject containers that don't set a user other than root
+// RequireNoNewPrivileges: Reject containers that don't set no_new_privileges
+// RequireReadOnlyRootFS: Reject containers that don't mount their root filesystem read-only
+type SecurityPolicy struct {
+	AllowedCapabilities    []string
+	AllowUnconfined        bool
+	RequireNonRoot         bool
+	RequireNoNewPrivileges bool
+	RequireReadOnlyRootFS  bool
+}
+
+// validate validates the capabilities of the policy
+func (sp SecurityPolicy) validate() error {
+	for _, capability := range sp.AllowedCapabilities {
+		if err := types.ValidateCapability(capability); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check checks the security options of a container against the policy
+func (sp SecurityPolicy) check(security *types.SecurityContext) error {
+	if security == nil {
+		security = &types.SecurityContext{}
+	}
+
+	if sp.RequireNonRoot && security.RunsAsRoot() {
+		return fmt.Errorf("%w: containers must run as a user other than root", ErrSecurityNotAllowed)
+	}
+	if sp.RequireNoNewPrivileges && !security.NoNewPrivileges {
+		return fmt.Errorf("%w: containers must set no_new_privileges", ErrSecurityNotAllowed)
+	}
+	if sp.RequireReadOnlyRootFS && !security.ReadOnlyRootFS {
+		return fmt.Errorf("%w: containers must have a read-only root filesystem", ErrSecurityNotAllowed)
+	}
+
+	allowed := make([]string, 0, len(sp.AllowedCapabilities))
+	for _, capability := range sp.AllowedCapabilities {
+		allowed = append(allowed, types.NormalizeCapability(capability))
+	}
+	for _, capability := range security.CapAdd {
+		capability = types.NormalizeCapability(capability)
+		if !slices.Contains(allowed, capability) && !slices.Contains(allowed, types.CapabilityAll) {
+			return fmt.Errorf("%w: capability %s can't be added", ErrSecurityNotAllowed, capability)
+		}
+	}
+
+	if !sp.AllowUnconfined &&
+		(security.SeccompProfile == types.ProfileUnconfined || security.AppArmorProfile == types.ProfileUnconfined) {
+		return fmt.Errorf("%w: containers can't run unconfined", ErrSecurityNotAllowed)
+	}
+	return nil
+}
+
+// loadSeccompProfile reads the seccomp profile of the name from the profile directory of the node, as compact JSON
+func loadSeccompProfile(dir string, name string) ([]byte, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("seccomp profile %q: seccomp profiles are not enabled", name)
+	}
+	profile, err := os.ReadFile(filepath.Join(dir, name+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load seccomp profile %q: %w", name, err)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, profile); err != nil {
+		return nil, fmt.Errorf("invalid seccomp profile %q: %w", name, err)
+	}
+	return compact.Bytes(), nil
+}
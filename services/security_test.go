@@ -0,0 +1,149 @@
+package services
+
+import (
+	"container-manager/types"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/oci"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSeccompProfile writes a seccomp profile of the name to the directory
+func writeSeccompProfile(t *testing.T, dir string, name string) {
+	t.Helper()
+
+	profile := "{\n  \"defaultAction\": \"SCMP_ACT_ERRNO\",\n  \"syscalls\": []\n}\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".json"), []byte(profile), 0o644))
+}
+
+func TestDockerSecurityOptions(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeSeccompProfile(t, dir, "strict")
+	ds := &DockerServiceHandler{seccompProfiles: dir}
+
+	hostConfig, err := ds.hostConfig(nil)
+	require.NoError(t, err)
+	require.Empty(t, hostConfig.SecurityOpt)
+	require.Empty(t, runAs(nil))
+
+	security := &types.SecurityContext{
+		User:            "1000",
+		Group:           "1000",
+		ReadOnlyRootFS:  true,
+		CapAdd:          []string{"cap_net_bind_service"},
+		CapDrop:         []string{"ALL"},
+		NoNewPrivileges: true,
+		SeccompProfile:  "strict",
+		AppArmorProfile: "docker-default",
+	}
+	hostConfig, err = ds.hostConfig(security)
+	require.NoError(t, err)
+	require.Equal(t, "1000:1000", runAs(security))
+	require.True(t, hostConfig.ReadonlyRootfs)
+	require.Equal(t, []string{"NET_BIND_SERVICE"}, []string(hostConfig.CapAdd))
+	require.Equal(t, []string{"ALL"}, []string(hostConfig.CapDrop))
+	require.Equal(t, []string{
+		"no-new-privileges:true",
+		`seccomp={"defaultAction":"SCMP_ACT_ERRNO","syscalls":[]}`,
+		"apparmor=docker-default",
+	}, hostConfig.SecurityOpt)
+
+	hostConfig, err = ds.hostConfig(&types.SecurityContext{SeccompProfile: "unconfined", AppArmorProfile: "unconfined"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"seccomp=unconfined", "apparmor=unconfined"}, hostConfig.SecurityOpt)
+
+	// profiles that aren't on the node fail the deploy
+	_, err = ds.hostConfig(&types.SecurityContext{SeccompProfile: "missing"})
+	require.Error(t, err)
+	_, err = (&DockerServiceHandler{}).hostConfig(&types.SecurityContext{SeccompProfile: "strict"})
+	require.Error(t, err)
+}
+
+func TestContainerdSecurityOptions(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeSeccompProfile(t, dir, "strict")
+	cs := &ContainerdServiceHandler{seccompProfiles: dir}
+
+	opts, err := cs.securityOpts(&types.SecurityContext{
+		ReadOnlyRootFS:  true,
+		CapAdd:          []string{"NET_BIND_SERVICE"},
+		CapDrop:         []string{"all"},
+		NoNewPrivileges: true,
+		SeccompProfile:  "strict",
+		AppArmorProfile: "restricted",
+	})
+	require.NoError(t, err)
+
+	spec := &oci.Spec{
+		Process: &specs.Process{Capabilities: &specs.LinuxCapabilities{Bounding: []string{"CAP_CHOWN", "CAP_KILL"}}},
+		Root:    &specs.Root{},
+		Linux:   &specs.Linux{},
+	}
+	require.NoError(t, oci.ApplyOpts(context.Background(), nil, nil, spec, opts...))
+	require.True(t, spec.Root.Readonly)
+	require.Equal(t, []string{"CAP_NET_BIND_SERVICE"}, spec.Process.Capabilities.Bounding)
+	require.Equal(t, []string{"CAP_NET_BIND_SERVICE"}, spec.Process.Capabilities.Effective)
+	require.True(t, spec.Process.NoNewPrivileges)
+	require.Equal(t, specs.ActErrno, spec.Linux.Seccomp.DefaultAction)
+	require.Equal(t, "restricted", spec.Process.ApparmorProfile)
+
+	opts, err = cs.securityOpts(&types.SecurityContext{
+		CapDrop:         []string{"CAP_KILL"},
+		SeccompProfile:  "unconfined",
+		AppArmorProfile: "unconfined",
+	})
+	require.NoError(t, err)
+	spec.Process.Capabilities.Bounding = []string{"CAP_CHOWN", "CAP_KILL"}
+	spec.Linux.Seccomp = nil
+	require.NoError(t, oci.ApplyOpts(context.Background(), nil, nil, spec, opts...))
+	require.Equal(t, []string{"CAP_CHOWN"}, spec.Process.Capabilities.Bounding)
+	require.Nil(t, spec.Linux.Seccomp)
+
+	_, err = cs.securityOpts(&types.SecurityContext{SeccompProfile: "missing"})
+	require.Error(t, err)
+
+	// containers without a seccomp profile run with the default profile of the runtime
+	opts, err = cs.securityOpts(&types.SecurityContext{AppArmorProfile: "unconfined"})
+	require.NoError(t, err)
+	require.NoError(t, oci.ApplyOpts(context.Background(), nil, nil, spec, opts...))
+	require.NotNil(t, spec.Linux.Seccomp)
+	require.Equal(t, specs.ActErrno, spec.Linux.Seccomp.DefaultAction)
+}
+
+func TestFakeRuntimeSecurity(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	writeSeccompProfile(t, dir, "strict")
+	ds, err := newFakeRuntime(RuntimeOptions{SeccompProfiles: dir})
+	require.NoError(t, err)
+	jobQueue := NewQueue(10, ds, WithNodeID("local"))
+
+	_, err = ds.DeployContainer(ctx, types.Container{Image: "busybox", Security: &types.SecurityContext{SeccompProfile: "missing"}})
+	require.Error(t, err)
+
+	// nothing can be copied into a container with a read-only root filesystem
+	require.NoError(t, jobQueue.Enqueue("job", types.Container{
+		Image:         "busybox",
+		RestartPolicy: &types.RestartPolicy{Name: types.RestartPolicyNever},
+		Security:      &types.SecurityContext{User: "1000", ReadOnlyRootFS: true, SeccompProfile: "strict"},
+	}))
+	archive, err := FileArchive("/etc/app/config.yaml", []byte("debug: true"), 0)
+	require.NoError(t, err)
+	require.ErrorIs(t, jobQueue.CopyToJob(ctx, "job", "/etc/app/config.yaml", archive), ErrReadOnlyRootFS)
+
+	jobQueue.executeJob(<-jobQueue.jobs)
+	record, _ := jobQueue.GetJob("job")
+	require.Equal(t, types.JobStatusRunning, record.Status)
+	require.ErrorIs(t, jobQueue.CopyToJob(ctx, "job", "/etc/app/config.yaml", archive), ErrReadOnlyRootFS)
+	require.ErrorIs(t, ds.CopyToContainer(ctx, record.ContainerID, "/etc/app/config.yaml", nil), ErrReadOnlyRootFS)
+}
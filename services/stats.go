@@ -0,0 +1,443 @@
+package services
+
+import (
+	"bufio"
+	"container-manager/types"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrStatsNotSupported is the error returned when the container runtime doesn't report resource stats
+	ErrStatsNotSupported = fmt.Errorf("container runtime doesn't report resource stats")
+	// ErrJobNotRunning is the error returned when the stats of a job that doesn't run on the node are requested
+	ErrJobNotRunning = fmt.Errorf("job is not running on the node")
+)
+
+const (
+	// statsInterval is the interval between the samples of the stats of the containers of running jobs
+	statsInterval = 10 * time.Second
+	// maxStatsRequestSize is the maximum size of the request and response lines of a stats stream
+	maxStatsRequestSize = 64 << 10
+)
+
+// StatsSource is implemented by the runtimes that report the resource use of their containers.
+// ContainerStats: Gets a sample of the stats of a container
+// StreamContainerStats: Streams samples of the stats of a container until the context is done, errors end the stream
+type StatsSource interface {
+	ContainerStats(ctx context.Context, containerID string) (types.ContainerStats, error)
+	StreamContainerStats(ctx context.Context, containerID string) (<-chan types.ContainerStats, <-chan error)
+}
+
+// RemoteStats gets the stats of the containers of the jobs of other nodes
+type RemoteStats interface {
+	ContainerStatsRemote(ctx context.Context, nodeID string, jobID string) (types.ContainerStats, error)
+	StreamContainerStatsRemote(
+		ctx context.Context,
+		nodeID string,
+		jobID string,
+	) (<-chan types.ContainerStats, <-chan error, error)
+}
+
+// StatsRouter gets the stats of the containers of jobs, from the node that owns the job.
+// jobQueue: The queue of the jobs
+// nodeID: The ID of the node
+// remote: Gets the stats of the jobs of other nodes
+// mutex: The mutex to protect the remote stats
+type StatsRouter struct {
+	jobQueue Queue
+	nodeID   string
+	remote   RemoteStats
+	mutex    sync.Mutex
+}
+
+// NewStatsRouter creates a new stats router
+func NewStatsRouter(jobQueue Queue, nodeID string) *StatsRouter {
+	return &StatsRouter{
+		jobQueue: jobQueue,
+		nodeID:   nodeID,
+	}
+}
+
+// SetRemote sets the source of the stats of the jobs of other nodes
+func (sr *StatsRouter) SetRemote(remote RemoteStats) {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	sr.remote = remote
+}
+
+// ContainerStats gets a sample of the resource use of the container of a job, from the node that owns the job
+func (sr *StatsRouter) ContainerStats(ctx context.Context, jobID string) (types.ContainerStats, error) {
+	job, remote, err := sr.route(jobID)
+	if err != nil {
+		return types.ContainerStats{}, err
+	}
+	if remote == nil {
+		return sr.jobQueue.ContainerStats(ctx, jobID)
+	}
+	return remote.ContainerStatsRemote(ctx, job.Node, jobID)
+}
+
+// StreamContainerStats streams samples of the resource use of the container of a job, from the node that
+// owns the job, until the context is done or the container is gone
+func (sr *StatsRouter) StreamContainerStats(
+	ctx context.Context,
+	jobID string,
+) (<-chan types.ContainerStats, <-chan error, error) {
+	job, remote, err := sr.route(jobID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if remote == nil {
+		return sr.jobQueue.StreamContainerStats(ctx, jobID)
+	}
+	return remote.StreamContainerStatsRemote(ctx, job.Node, jobID)
+}
+
+// route returns the job and the remote stats of the node that owns it, nil when the node owns the job
+func (sr *StatsRouter) route(jobID string) (types.Job, RemoteStats, error) {
+	job, ok := sr.jobQueue.GetJob(jobID)
+	if !ok {
+		return types.Job{}, nil, ErrJobNotFound
+	}
+	if job.Node == sr.nodeID {
+		return job, nil, nil
+	}
+
+	sr.mutex.Lock()
+	remote := sr.remote
+	sr.mutex.Unlock()
+	if remote == nil {
+		return types.Job{}, nil, fmt.Errorf("%w: the job runs on node %s", ErrJobNotRunning, job.Node)
+	}
+	logrus.WithFields(logrus.Fields{"job_id": jobID, "node": job.Node}).Debug("routing stats to the node of the job")
+	return job, remote, nil
+}
+
+// ContainerStats gets a sample of the resource use of the container of a job running on the node
+func (q *QueueHandler) ContainerStats(ctx context.Context, jobID string) (types.ContainerStats, error) {
+	source, containerID, err := q.statsSource(jobID)
+	if err != nil {
+		return types.ContainerStats{}, err
+	}
+
+	stats, err := source.ContainerStats(ctx, containerID)
+	if err != nil {
+		return types.ContainerStats{}, fmt.Errorf("failed to get container stats: %w", err)
+	}
+	q.observe(containerID, stats)
+	return stats, nil
+}
+
+// StreamContainerStats streams samples of the resource use of the container of a job running on the node,
+// until the context is done or the container is gone
+func (q *QueueHandler) StreamContainerStats(
+	ctx context.Context,
+	jobID string,
+) (<-chan types.ContainerStats, <-chan error, error) {
+	source, containerID, err := q.statsSource(jobID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	samples, errs := source.StreamContainerStats(ctx, containerID)
+	observed := make(chan types.ContainerStats)
+	go func() {
+		defer close(observed)
+		for stats := range samples {
+			q.observe(containerID, stats)
+			select {
+			case observed <- stats:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return observed, errs, nil
+}
+
+// statsSource returns the stats source of the queue and the container of a job running on the node
+func (q *QueueHandler) statsSource(jobID string) (StatsSource, string, error) {
+	q.mutex.Lock()
+	record, exists := q.jobRecords[jobID]
+	if !exists {
+		q.mutex.Unlock()
+		return nil, "", ErrJobNotFound
+	}
+	containerID := record.ContainerID
+	running := record.Node == q.nodeID && record.Status == types.JobStatusRunning && containerID != ""
+	q.mutex.Unlock()
+
+	if !running {
+		return nil, "", ErrJobNotRunning
+	}
+	source, ok := q.dockerService.(StatsSource)
+	if !ok {
+		return nil, "", ErrStatsNotSupported
+	}
+	return source, containerID, nil
+}
+
+// watchStats periodically samples the stats of the containers of running jobs, so the usage of each job is
+// known when its container ends
+func (q *QueueHandler) watchStats(source StatsSource) {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			q.sampleRunningJobs(source)
+		case <-q.quit:
+			return
+		}
+	}
+}
+
+// sampleRunningJobs samples the stats of the containers of running jobs, and forgets the usage of the
+// containers that don't run a job anymore
+func (q *QueueHandler) sampleRunningJobs(source StatsSource) {
+	q.mutex.Lock()
+	running := make(map[string]struct{})
+	for _, record := range q.jobRecords {
+		if record.Node == q.nodeID && record.Status == types.JobStatusRunning && record.ContainerID != "" {
+			running[record.ContainerID] = struct{}{}
+		}
+	}
+	for containerID := range q.usage {
+		if _, ok := running[containerID]; !ok {
+			delete(q.usage, containerID)
+		}
+	}
+	q.mutex.Unlock()
+
+	for containerID := range running {
+		q.sample(source, containerID)
+	}
+}
+
+// sample samples the stats of a container into its usage, failures are logged
+func (q *QueueHandler) sample(source StatsSource, containerID string) {
+	stats, err := source.ContainerStats(q.ctx, containerID)
+	if err != nil {
+		logrus.WithField("container_id", containerID).Debugf("failed to get container stats: %v", err)
+		return
+	}
+	q.observe(containerID, stats)
+}
+
+// sampleContainer samples the stats of a container one last time before it is removed, when the runtime
+// reports stats
+func (q *QueueHandler) sampleContainer(containerID string) {
+	if source, ok := q.dockerService.(StatsSource); ok {
+		q.sample(source, containerID)
+	}
+}
+
+// observe updates the usage of a container with a sample of its stats
+func (q *QueueHandler) observe(containerID string, stats types.ContainerStats) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.usage[containerID] = q.usage[containerID].Observe(stats)
+}
+
+// endUsage adds the usage of the container of a job to the usage of the job, once the container ended.
+// The mutex must be held.
+func (q *QueueHandler) endUsage(record *types.Job) {
+	usage, ok := q.usage[record.ContainerID]
+	if !ok {
+		return
+	}
+	delete(q.usage, record.ContainerID)
+
+	total := usage
+	if record.Usage != nil {
+		total = record.Usage.Add(usage)
+	}
+	record.Usage = &total
+}
+
+// statsRequest is the request of a stats stream, a JSON line.
+// job_id: The ID of the job of the container
+// stream: Streams samples until the peer goes away or the container is gone, instead of a single sample
+type statsRequest struct {
+	JobID  string `json:"job_id"`
+	Stream bool   `json:"stream,omitempty"`
+}
+
+// statsResponse is a JSON line of the answer to a stats request, one per sample. A line with an error ends
+// the stream.
+// error: Why the stats couldn't be got
+// stats: The sample of the stats of the container
+type statsResponse struct {
+	Error string                `json:"error,omitempty"`
+	Stats *types.ContainerStats `json:"stats,omitempty"`
+}
+
+// WithStatsRouter serves the stats of the containers of the jobs of this node to the peers that route them
+func WithStatsRouter(router *StatsRouter) P2POption {
+	return func(o *p2pOptions) {
+		o.stats = router
+	}
+}
+
+// handleStatsStream answers a stats request with the stats of the container of a job of this node
+func (s *Service) handleStatsStream(stream network.Stream) {
+	defer stream.Close()
+	remote := stream.Conn().RemotePeer()
+
+	reader := bufio.NewReaderSize(stream, maxStatsRequestSize)
+	var request statsRequest
+	if err := readJSONLine(reader, &request); err != nil {
+		logrus.WithField("peer", remote).Errorf("failed to read stats request: %v", err)
+		return
+	}
+	encoder := json.NewEncoder(stream)
+
+	// only the peer that handed the job to this node and trusted peers see its stats
+	job, ok := s.jobQueue.GetJob(request.JobID)
+	if !ok || !s.mayAccess(job, remote) {
+		logrus.WithField("job_id", request.JobID).Warnf("rejected stats request of peer %s", remote)
+		encoder.Encode(statsResponse{Error: ErrJobNotFound.Error()})
+		return
+	}
+
+	if !request.Stream {
+		stats, err := s.stats.jobQueue.ContainerStats(s.ctx, request.JobID)
+		if err != nil {
+			encoder.Encode(statsResponse{Error: err.Error()})
+			return
+		}
+		encoder.Encode(statsResponse{Stats: &stats})
+		return
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	samples, errs, err := s.stats.jobQueue.StreamContainerStats(ctx, request.JobID)
+	if err != nil {
+		encoder.Encode(statsResponse{Error: err.Error()})
+		return
+	}
+
+	// the stream ends when the peer goes away
+	go func() {
+		io.Copy(io.Discard, reader)
+		cancel()
+	}()
+
+	for stats := range samples {
+		if err := encoder.Encode(statsResponse{Stats: &stats}); err != nil {
+			logrus.WithField("job_id", request.JobID).Debugf("failed to write stats: %v", err)
+			return
+		}
+	}
+	select {
+	case err := <-errs:
+		encoder.Encode(statsResponse{Error: err.Error()})
+	default:
+	}
+}
+
+// ContainerStatsRemote gets a sample of the stats of the container of a job of another node, over a stats
+// stream to the node
+func (s *Service) ContainerStatsRemote(ctx context.Context, nodeID string, jobID string) (types.ContainerStats, error) {
+	stream, reader, err := s.openStatsStream(ctx, nodeID, statsRequest{JobID: jobID})
+	if err != nil {
+		return types.ContainerStats{}, err
+	}
+	defer stream.Close()
+	stop := context.AfterFunc(ctx, func() {
+		stream.Reset()
+	})
+	defer stop()
+
+	var response statsResponse
+	if err := readJSONLine(reader, &response); err != nil {
+		stream.Reset()
+		return types.ContainerStats{}, fmt.Errorf("failed to read stats response: %w", err)
+	}
+	if response.Error != "" || response.Stats == nil {
+		return types.ContainerStats{}, fmt.Errorf("failed to get stats on node %s: %s", nodeID, response.Error)
+	}
+	return *response.Stats, nil
+}
+
+// StreamContainerStatsRemote streams samples of the stats of the container of a job of another node, over a
+// stats stream to the node, until the context is done or the container is gone
+func (s *Service) StreamContainerStatsRemote(
+	ctx context.Context,
+	nodeID string,
+	jobID string,
+) (<-chan types.ContainerStats, <-chan error, error) {
+	stream, reader, err := s.openStatsStream(ctx, nodeID, statsRequest{JobID: jobID, Stream: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		stream.Reset()
+	})
+
+	samples := make(chan types.ContainerStats)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(samples)
+		defer stream.Close()
+		defer stop()
+
+		for {
+			var response statsResponse
+			if err := readJSONLine(reader, &response); err != nil {
+				if !errors.Is(err, io.EOF) && ctx.Err() == nil {
+					errs <- fmt.Errorf("stats stream broke: %w", err)
+				}
+				return
+			}
+			if response.Error != "" || response.Stats == nil {
+				errs <- fmt.Errorf("failed to get stats on node %s: %s", nodeID, response.Error)
+				return
+			}
+			select {
+			case samples <- *response.Stats:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return samples, errs, nil
+}
+
+// openStatsStream opens a stats stream to a node and sends the request
+func (s *Service) openStatsStream(
+	ctx context.Context,
+	nodeID string,
+	request statsRequest,
+) (network.Stream, *bufio.Reader, error) {
+	id, err := peer.Decode(nodeID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode peer ID: %w", err)
+	}
+
+	stream, err := s.host.NewStream(ctx, id, StatsProtocolID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open stats stream: %w", err)
+	}
+	if err := json.NewEncoder(stream).Encode(request); err != nil {
+		stream.Reset()
+		return nil, nil, fmt.Errorf("failed to write stats request: %w", err)
+	}
+	return stream, bufio.NewReaderSize(stream, maxStatsRequestSize), nil
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"container-manager/types"
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestJobQueueContainerStats(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	runtime, err := NewFakeRuntime(FakeRuntimeOptions{}, nil)
+	require.NoError(t, err)
+	jobQueue := NewQueue(10, runtime, WithNodeID("local"))
+	container := types.Container{
+		Image:         "busybox",
+		RestartPolicy: &types.RestartPolicy{Name: types.RestartPolicyNever},
+	}
+
+	_, err = jobQueue.ContainerStats(ctx, "job")
+	require.ErrorIs(t, err, ErrJobNotFound)
+	require.NoError(t, jobQueue.Enqueue("job", container))
+	_, err = jobQueue.ContainerStats(ctx, "job")
+	require.ErrorIs(t, err, ErrJobNotRunning)
+
+	jobQueue.executeJob(<-jobQueue.jobs)
+	stats, err := jobQueue.ContainerStats(ctx, "job")
+	require.NoError(t, err)
+	require.Equal(t, uint64(fakeMemoryBytes), stats.MemoryBytes)
+	require.Greater(t, stats.CPUSeconds, 0.0)
+
+	// the stream ends with the request
+	streamCtx, cancel := context.WithCancel(ctx)
+	samples, _, err := jobQueue.StreamContainerStats(streamCtx, "job")
+	require.NoError(t, err)
+	sample := <-samples
+	require.Equal(t, uint64(fakeMemoryBytes), sample.MemoryBytes)
+	cancel()
+	for range samples {
+	}
+
+	// runtimes without stats report that they don't have any
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockDockerService := NewMockDockerService(ctrl)
+	other := NewQueue(10, mockDockerService, WithNodeID("local"))
+	other.jobRecords["job"] = &types.Job{ID: "job", Node: "local", Status: types.JobStatusRunning, ContainerID: "container-id"}
+	_, err = other.ContainerStats(ctx, "job")
+	require.ErrorIs(t, err, ErrStatsNotSupported)
+}
+
+func TestJobQueueRecordsUsage(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	runtime, err := NewFakeRuntime(FakeRuntimeOptions{}, nil)
+	require.NoError(t, err)
+	jobQueue := NewQueue(10, runtime, WithNodeID("local"))
+	jobQueue.backoff = 0
+	require.NoError(t, jobQueue.Enqueue("job", types.Container{
+		Image:         "busybox",
+		RestartPolicy: &types.RestartPolicy{Name: types.RestartPolicyOnFailure, MaxRestarts: 1},
+	}))
+
+	// the usage of every container of the job adds up once it ended
+	for i := 0; i < 2; i++ {
+		jobQueue.executeJob(<-jobQueue.jobs)
+		record, _ := jobQueue.GetJob("job")
+		jobQueue.sampleRunningJobs(runtime)
+		require.NoError(t, runtime.KillContainer(ctx, record.ContainerID))
+		jobQueue.checkRunningJobs()
+	}
+
+	record, _ := jobQueue.GetJob("job")
+	require.Equal(t, types.JobStatusFailed, record.Status)
+	require.NotNil(t, record.Usage)
+	require.Equal(t, uint64(fakeMemoryBytes), record.Usage.PeakMemoryBytes)
+	require.Greater(t, record.Usage.CPUSeconds, 0.0)
+	require.Empty(t, jobQueue.usage)
+	jobQueue.Stop()
+}
+
+func TestP2PServiceStatsRemote(t *testing.T) {
+	t.Parallel()
+
+	key1, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	nodeID1, err := peer.IDFromPrivateKey(key1)
+	require.NoError(t, err)
+	key2, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	nodeID2, err := peer.IDFromPrivateKey(key2)
+	require.NoError(t, err)
+
+	runtime, err := NewFakeRuntime(FakeRuntimeOptions{}, nil)
+	require.NoError(t, err)
+	jobQueue1 := NewQueue(10, runtime, WithNodeID(nodeID1.String()))
+	container := types.Container{
+		Image:         "busybox",
+		RestartPolicy: &types.RestartPolicy{Name: types.RestartPolicyNever},
+	}
+	require.NoError(t, jobQueue1.EnqueueFrom("job", container, nodeID2.String()))
+	jobQueue1.executeJob(<-jobQueue1.jobs)
+	record, _ := jobQueue1.GetJob("job")
+	other := runningJob(t, jobQueue1, "other")
+	jobQueue2 := NewQueue(10, nil, WithNodeID(nodeID2.String()))
+	jobQueue2.Track(record)
+	jobQueue2.Track(other)
+
+	router1 := NewStatsRouter(jobQueue1, nodeID1.String())
+	service1, err := NewP2PService(jobQueue1, 4068, WithIdentity(key1), WithStatsRouter(router1))
+	require.NoError(t, err)
+	service2, err := NewP2PService(jobQueue2, 4069, WithIdentity(key2))
+	require.NoError(t, err)
+	router2 := NewStatsRouter(jobQueue2, nodeID2.String())
+	router2.SetRemote(service2)
+
+	go service1.Start(t.Name())
+	go service2.Start(t.Name())
+	defer service1.Stop()
+	defer service2.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, service2.host.Connect(ctx, peer.AddrInfo{
+		ID:    service1.host.ID(),
+		Addrs: service1.host.Addrs(),
+	}))
+
+	// the stats are sampled on the node of the job
+	var stats types.ContainerStats
+	require.Eventually(t, func() bool {
+		stats, err = router2.ContainerStats(ctx, "job")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, uint64(fakeMemoryBytes), stats.MemoryBytes)
+
+	// the samples are streamed until the container is gone
+	samples, _, err := router2.StreamContainerStats(ctx, "job")
+	require.NoError(t, err)
+	sample := <-samples
+	require.Equal(t, uint64(fakeMemoryBytes), sample.MemoryBytes)
+	require.NoError(t, jobQueue1.Cancel("job"))
+	for range samples {
+	}
+
+	// only the peer that handed the job to the node sees its stats
+	_, err = router2.ContainerStats(ctx, "other")
+	require.ErrorContains(t, err, ErrJobNotFound.Error())
+	_, err = router2.ContainerStats(ctx, "job")
+	require.ErrorContains(t, err, ErrJobNotRunning.Error())
+}
@@ -0,0 +1,242 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrUntrustedPeer is the error returned when a peer is neither allowlisted nor certified by the cluster CA
+	ErrUntrustedPeer = fmt.Errorf("peer is not trusted")
+	// ErrInvalidSignature is the error returned when a message signature does not match its sender
+	ErrInvalidSignature = fmt.Errorf("invalid message signature")
+)
+
+const (
+	// peerCertificatePrefix is the domain separation prefix of the data signed by the cluster CA
+	peerCertificatePrefix = "container-manager/peer-certificate:"
+	// rejectionPeriod is how long the connections of a peer that failed certificate verification are refused
+	rejectionPeriod = 10 * time.Minute
+	// maxRejectedPeers is the most rejected peers remembered, the oldest rejections are forgotten first
+	maxRejectedPeers = 1024
+)
+
+// IssuePeerCertificate signs the peer ID with the cluster CA key
+func IssuePeerCertificate(caKey crypto.PrivKey, id peer.ID) ([]byte, error) {
+	certificate, err := caKey.Sign([]byte(peerCertificatePrefix + id.String()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign peer certificate: %w", err)
+	}
+	return certificate, nil
+}
+
+// VerifyPeerCertificate verifies that the certificate was issued to the peer ID by the cluster CA
+func VerifyPeerCertificate(ca crypto.PubKey, id peer.ID, certificate []byte) error {
+	if len(certificate) == 0 {
+		return fmt.Errorf("%w: no certificate presented by %s", ErrUntrustedPeer, id)
+	}
+
+	ok, err := ca.Verify([]byte(peerCertificatePrefix+id.String()), certificate)
+	if err != nil {
+		return fmt.Errorf("failed to verify peer certificate: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: invalid certificate presented by %s", ErrUntrustedPeer, id)
+	}
+	return nil
+}
+
+// PeerTrust decides which peers the node accepts connections and jobs from.
+// peers: The allowlisted peer IDs
+// ca: The cluster CA public key, peers presenting a certificate issued by it are trusted
+// rejected: The peers that failed certificate verification, and when
+// verified: The peers that presented a valid certificate
+// mutex: The mutex to protect rejected and verified
+//
+// A PeerTrust without allowlisted peers and CA trusts every peer.
+type PeerTrust struct {
+	peers    map[peer.ID]struct{}
+	ca       crypto.PubKey
+	rejected map[peer.ID]time.Time
+	verified map[peer.ID]struct{}
+	mutex    sync.RWMutex
+}
+
+// NewPeerTrust creates a new PeerTrust from a list of peer IDs and an optional cluster CA public key
+func NewPeerTrust(peers []string, ca crypto.PubKey) (*PeerTrust, error) {
+	trusted := make(map[peer.ID]struct{}, len(peers))
+	for _, p := range peers {
+		id, err := peer.Decode(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted peer ID %q: %w", p, err)
+		}
+		trusted[id] = struct{}{}
+	}
+
+	return &PeerTrust{
+		peers:    trusted,
+		ca:       ca,
+		rejected: make(map[peer.ID]time.Time),
+		verified: make(map[peer.ID]struct{}),
+	}, nil
+}
+
+// Enabled reports whether peers are restricted at all
+func (pt *PeerTrust) Enabled() bool {
+	return pt != nil && (len(pt.peers) > 0 || pt.ca != nil)
+}
+
+// Allowlisted reports whether the peer is in the trusted peer list
+func (pt *PeerTrust) Allowlisted(id peer.ID) bool {
+	_, ok := pt.peers[id]
+	return ok
+}
+
+// Verify checks that the peer is allowlisted or presents a valid certificate
+func (pt *PeerTrust) Verify(id peer.ID, certificate []byte) error {
+	if !pt.Enabled() || pt.Allowlisted(id) {
+		return nil
+	}
+	if pt.ca == nil {
+		return fmt.Errorf("%w: %s is not allowlisted", ErrUntrustedPeer, id)
+	}
+
+	if err := VerifyPeerCertificate(pt.ca, id, certificate); err != nil {
+		pt.reject(id)
+		return err
+	}
+
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	pt.verified[id] = struct{}{}
+	return nil
+}
+
+// Trusted reports whether the peer is a member of a closed cluster: it is allowlisted or presented a valid
+// certificate. No peer is trusted when peers aren't restricted.
+func (pt *PeerTrust) Trusted(id peer.ID) bool {
+	if !pt.Enabled() {
+		return false
+	}
+	if pt.Allowlisted(id) {
+		return true
+	}
+
+	pt.mutex.RLock()
+	defer pt.mutex.RUnlock()
+
+	_, verified := pt.verified[id]
+	return verified
+}
+
+// reject records that the peer failed verification so the gater refuses its connections for rejectionPeriod.
+// Expired rejections are forgotten, and the oldest ones once maxRejectedPeers are remembered.
+func (pt *PeerTrust) reject(id peer.ID) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	var oldest peer.ID
+	for rejected, at := range pt.rejected {
+		if time.Since(at) >= rejectionPeriod {
+			delete(pt.rejected, rejected)
+			continue
+		}
+		if oldest == "" || at.Before(pt.rejected[oldest]) {
+			oldest = rejected
+		}
+	}
+	if _, ok := pt.rejected[id]; !ok && len(pt.rejected) >= maxRejectedPeers {
+		delete(pt.rejected, oldest)
+	}
+	pt.rejected[id] = time.Now()
+}
+
+// allowConnection reports whether connections to or from the peer are allowed.
+// Peers that can only be trusted through a certificate are let through the
+// handshake and verified once connected.
+func (pt *PeerTrust) allowConnection(id peer.ID) bool {
+	if !pt.Enabled() || pt.Allowlisted(id) {
+		return true
+	}
+	if pt.ca == nil {
+		return false
+	}
+
+	pt.mutex.RLock()
+	defer pt.mutex.RUnlock()
+
+	rejectedAt, rejected := pt.rejected[id]
+	return !rejected || time.Since(rejectedAt) >= rejectionPeriod
+}
+
+// trustGater is a connection gater that rejects peers that aren't trusted
+type trustGater struct {
+	trust *PeerTrust
+}
+
+// InterceptPeerDial tests whether we're permitted to dial the peer
+func (tg *trustGater) InterceptPeerDial(p peer.ID) bool {
+	return tg.trust.allowConnection(p)
+}
+
+// InterceptAddrDial tests whether we're permitted to dial the peer on the address
+func (tg *trustGater) InterceptAddrDial(p peer.ID, _ multiaddr.Multiaddr) bool {
+	return tg.trust.allowConnection(p)
+}
+
+// InterceptAccept allows every inbound connection until the remote peer is known
+func (tg *trustGater) InterceptAccept(network.ConnMultiaddrs) bool {
+	return true
+}
+
+// InterceptSecured tests whether the authenticated peer is allowed
+func (tg *trustGater) InterceptSecured(_ network.Direction, p peer.ID, _ network.ConnMultiaddrs) bool {
+	allow := tg.trust.allowConnection(p)
+	if !allow {
+		logrus.WithField("peer", p).Debug("Rejected connection from untrusted peer")
+	}
+	return allow
+}
+
+// InterceptUpgraded allows every upgraded connection
+func (tg *trustGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+// LoadPublicKey reads a marshalled libp2p public key from a file
+func LoadPublicKey(path string) (crypto.PubKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+
+	key, err := crypto.UnmarshalPublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal public key: %w", err)
+	}
+	return key, nil
+}
+
+// LoadPrivateKey reads a marshalled libp2p private key from a file
+func LoadPrivateKey(path string) (crypto.PrivKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	key, err := crypto.UnmarshalPrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal private key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPeerID(t *testing.T) peer.ID {
+	t.Helper()
+	_, pubKey, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	id, err := peer.IDFromPublicKey(pubKey)
+	require.NoError(t, err)
+	return id
+}
+
+func TestPeerCertificate(t *testing.T) {
+	t.Parallel()
+	caKey, caPub, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	id := newTestPeerID(t)
+
+	certificate, err := IssuePeerCertificate(caKey, id)
+	require.NoError(t, err)
+	require.NoError(t, VerifyPeerCertificate(caPub, id, certificate))
+
+	// a certificate is bound to the peer it was issued to
+	require.ErrorIs(t, VerifyPeerCertificate(caPub, newTestPeerID(t), certificate), ErrUntrustedPeer)
+
+	// a certificate issued by another CA is rejected
+	_, otherPub, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	require.ErrorIs(t, VerifyPeerCertificate(otherPub, id, certificate), ErrUntrustedPeer)
+}
+
+func TestPeerTrust_Disabled(t *testing.T) {
+	t.Parallel()
+	var nilTrust *PeerTrust
+	require.False(t, nilTrust.Enabled())
+	require.NoError(t, nilTrust.Verify(newTestPeerID(t), nil))
+
+	trust, err := NewPeerTrust(nil, nil)
+	require.NoError(t, err)
+	require.False(t, trust.Enabled())
+	require.True(t, trust.allowConnection(newTestPeerID(t)))
+}
+
+func TestPeerTrust_Allowlist(t *testing.T) {
+	t.Parallel()
+	trusted := newTestPeerID(t)
+	untrusted := newTestPeerID(t)
+
+	_, err := NewPeerTrust([]string{"not-a-peer-id"}, nil)
+	require.Error(t, err)
+
+	trust, err := NewPeerTrust([]string{trusted.String()}, nil)
+	require.NoError(t, err)
+	require.True(t, trust.Enabled())
+
+	require.NoError(t, trust.Verify(trusted, nil))
+	require.ErrorIs(t, trust.Verify(untrusted, nil), ErrUntrustedPeer)
+	require.True(t, trust.allowConnection(trusted))
+	require.False(t, trust.allowConnection(untrusted))
+}
+
+func TestPeerTrust_ClusterCA(t *testing.T) {
+	t.Parallel()
+	caKey, caPub, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	certified := newTestPeerID(t)
+	uncertified := newTestPeerID(t)
+
+	certificate, err := IssuePeerCertificate(caKey, certified)
+	require.NoError(t, err)
+
+	trust, err := NewPeerTrust(nil, caPub)
+	require.NoError(t, err)
+
+	// peers are let through the handshake until they fail verification
+	require.True(t, trust.allowConnection(uncertified))
+
+	require.NoError(t, trust.Verify(certified, certificate))
+	require.ErrorIs(t, trust.Verify(uncertified, certificate), ErrUntrustedPeer)
+	require.True(t, trust.allowConnection(certified))
+	require.False(t, trust.allowConnection(uncertified))
+}
+
+func TestPeerTrust_RejectionExpires(t *testing.T) {
+	t.Parallel()
+	_, caPub, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	trust, err := NewPeerTrust(nil, caPub)
+	require.NoError(t, err)
+
+	// rejected peers can connect again once the rejection expired
+	rejected := newTestPeerID(t)
+	trust.reject(rejected)
+	require.False(t, trust.allowConnection(rejected))
+	trust.rejected[rejected] = time.Now().Add(-rejectionPeriod)
+	require.True(t, trust.allowConnection(rejected))
+
+	// the oldest rejections are forgotten once too many peers were rejected
+	for i := 0; i < maxRejectedPeers; i++ {
+		trust.reject(newTestPeerID(t))
+	}
+	require.Len(t, trust.rejected, maxRejectedPeers)
+	require.NotContains(t, trust.rejected, rejected)
+}
+
+func TestP2PServiceVerifyPeer(t *testing.T) {
+	t.Parallel()
+	caKey, caPub, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	trust, err := NewPeerTrust(nil, caPub)
+	require.NoError(t, err)
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+	certificate, err := IssuePeerCertificate(caKey, id)
+	require.NoError(t, err)
+
+	service1, err := NewP2PService(NewQueue(10, nil), 4070, WithPeerTrust(trust))
+	require.NoError(t, err)
+	defer service1.Stop()
+	// the peer doesn't serve its certificate yet, as while it starts
+	service2, err := NewP2PService(NewQueue(10, nil), 4071, WithIdentity(key), WithCertificate(certificate))
+	require.NoError(t, err)
+	defer service2.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addr := peer.AddrInfo{ID: service2.host.ID(), Addrs: service2.host.Addrs()}
+
+	// a peer whose certificate can't be read is disconnected, but not rejected
+	require.NoError(t, service1.host.Connect(ctx, addr))
+	service1.verifyPeer(id)
+	require.False(t, trust.Trusted(id))
+	require.True(t, trust.allowConnection(id))
+
+	// it is verified once it serves its certificate
+	service2.host.SetStreamHandler(TrustProtocolID, service2.handleTrustStream)
+	require.NoError(t, service1.host.Connect(ctx, addr))
+	service1.verifyPeer(id)
+	require.True(t, trust.Trusted(id))
+}
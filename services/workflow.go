@@ -0,0 +1,448 @@
+package services
+
+import (
+	"container-manager/types"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// workflowRetention is how long finished workflows are kept
+const workflowRetention = time.Hour
+
+var (
+	// ErrWorkflowNotFound is the error returned when a workflow doesn't exist
+	ErrWorkflowNotFound = fmt.Errorf("workflow not found")
+	// ErrWorkflowFinished is the error returned when cancelling a workflow that already finished
+	ErrWorkflowFinished = fmt.Errorf("workflow already finished")
+)
+
+// WorkflowEngine runs the workflows submitted to the node, releasing each step once its upstream steps finished.
+// The steps are placed and cancelled outside of the mutex, as that reaches other nodes.
+// nodeID: The ID of the local node
+// workflows: The workflows submitted to the node
+// queue: The job queue, which also tracks the steps on other nodes
+// p2pService: The P2P service used to find the nodes that left
+// dispatcher: The dispatcher that places and cancels the steps
+// mutex: The mutex to protect the workflows
+// wake: Triggers an advance of the workflows before the next interval
+// quit: The channel to signal the loop to quit
+// wg: The wait group to wait for the loop to finish
+type WorkflowEngine struct {
+	nodeID     string
+	workflows  map[string]*types.Workflow
+	queue      Queue
+	p2pService P2PService
+	dispatcher *Dispatcher
+	mutex      sync.Mutex
+	wake       chan struct{}
+	quit       chan bool
+	wg         sync.WaitGroup
+}
+
+// NewWorkflowEngine creates a new workflow engine
+func NewWorkflowEngine(queue Queue, p2pService P2PService, dispatcher *Dispatcher) *WorkflowEngine {
+	return &WorkflowEngine{
+		nodeID:     p2pService.ID(),
+		workflows:  make(map[string]*types.Workflow),
+		queue:      queue,
+		p2pService: p2pService,
+		dispatcher: dispatcher,
+		wake:       make(chan struct{}, 1),
+		quit:       make(chan bool),
+	}
+}
+
+// Submit validates a workflow and releases the steps without dependencies
+func (e *WorkflowEngine) Submit(workflow types.Workflow) (types.Workflow, error) {
+	if err := workflow.Validate(); err != nil {
+		return types.Workflow{}, err
+	}
+
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return types.Workflow{}, fmt.Errorf("failed to generate workflow ID: %w", err)
+	}
+
+	workflow.ID = id.String()
+	workflow.Status = types.WorkflowStatusRunning
+	workflow.CreatedAt = time.Now()
+	workflow.Steps = slices.Clone(workflow.Steps)
+	for i := range workflow.Steps {
+		workflow.Steps[i].JobID = ""
+		workflow.Steps[i].Node = ""
+		workflow.Steps[i].Status = types.StepStatusWaiting
+	}
+
+	e.mutex.Lock()
+	e.workflows[workflow.ID] = &workflow
+	logrus.WithField("workflow", workflow.ID).Info("submitted workflow")
+	releases := e.advance(&workflow)
+	e.mutex.Unlock()
+
+	e.dispatch(releases)
+	return e.Get(workflow.ID)
+}
+
+// Get returns a workflow
+func (e *WorkflowEngine) Get(id string) (types.Workflow, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	workflow, ok := e.workflows[id]
+	if !ok {
+		return types.Workflow{}, ErrWorkflowNotFound
+	}
+	return e.snapshot(workflow), nil
+}
+
+// List returns the workflows, sorted by submission time
+func (e *WorkflowEngine) List() []types.Workflow {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	workflows := make([]types.Workflow, 0, len(e.workflows))
+	for _, workflow := range e.workflows {
+		workflows = append(workflows, e.snapshot(workflow))
+	}
+	sort.Slice(workflows, func(i, j int) bool {
+		return workflows[i].CreatedAt.Before(workflows[j].CreatedAt)
+	})
+	return workflows
+}
+
+// Cancel cancels a running workflow, its released steps are cancelled and the waiting ones never run
+func (e *WorkflowEngine) Cancel(id string) (types.Workflow, error) {
+	e.mutex.Lock()
+	workflow, ok := e.workflows[id]
+	if !ok {
+		e.mutex.Unlock()
+		return types.Workflow{}, ErrWorkflowNotFound
+	}
+	if workflow.Status != types.WorkflowStatusRunning {
+		e.mutex.Unlock()
+		return types.Workflow{}, ErrWorkflowFinished
+	}
+
+	e.refresh(workflow)
+	var jobs []types.Job
+	for i := range workflow.Steps {
+		step := &workflow.Steps[i]
+		if step.Status.Finished() {
+			continue
+		}
+		if job, ok := e.queue.GetJob(step.JobID); ok && job.Status.Active() {
+			jobs = append(jobs, job)
+		}
+		step.Status = types.StepStatusCancelled
+	}
+	workflow.Status = types.WorkflowStatusCancelled
+	workflow.FinishedAt = time.Now()
+	snapshot := e.snapshot(workflow)
+	e.mutex.Unlock()
+
+	// the steps still being placed are cancelled once they were placed
+	for _, job := range jobs {
+		e.cancelStep(workflow.ID, job)
+	}
+	logrus.WithField("workflow", workflow.ID).Info("cancelled workflow")
+	return snapshot, nil
+}
+
+// cancelStep cancels the job of a step of a cancelled workflow, failures are logged
+func (e *WorkflowEngine) cancelStep(workflowID string, job types.Job) {
+	if err := e.dispatcher.Cancel(job); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"workflow": workflowID,
+			"step":     job.Container.Labels[types.WorkflowStepLabel],
+		}).Warnf("failed to cancel step: %v", err)
+	}
+}
+
+// OnJobStatus advances the workflows when a step changes status
+func (e *WorkflowEngine) OnJobStatus(job types.Job) {
+	if job.Container.Labels[types.WorkflowLabel] == "" {
+		return
+	}
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run runs the loop that advances the workflows
+func (e *WorkflowEngine) Run() {
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+
+		ticker := time.NewTicker(reconcileInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-e.wake:
+			case <-e.quit:
+				return
+			}
+			e.advanceAll()
+		}
+	}()
+}
+
+// Stop stops the loop
+func (e *WorkflowEngine) Stop() {
+	close(e.quit)
+	e.wg.Wait()
+}
+
+// advanceAll advances the running workflows and prunes the ones that finished more than workflowRetention ago
+func (e *WorkflowEngine) advanceAll() {
+	e.mutex.Lock()
+	var releases []stepRelease
+	for id, workflow := range e.workflows {
+		if workflow.Status == types.WorkflowStatusRunning {
+			releases = append(releases, e.advance(workflow)...)
+		} else if time.Since(workflow.FinishedAt) > workflowRetention {
+			logrus.WithField("workflow", id).Debug("pruned finished workflow")
+			delete(e.workflows, id)
+		}
+	}
+	e.mutex.Unlock()
+
+	e.dispatch(releases)
+}
+
+// stepRelease is a step released by an advance of its workflow, to be placed once the mutex is released.
+// workflowID: The ID of the workflow
+// step: The index of the step in the workflow
+// jobID: The ID of the job of the step
+// container: The container of the step, with its inputs resolved
+type stepRelease struct {
+	workflowID string
+	step       int
+	jobID      string
+	container  types.Container
+}
+
+// advance refreshes the steps of a workflow, releases or skips the waiting steps whose upstream steps finished,
+// and finishes the workflow once all its steps finished. It returns the released steps to place.
+// The mutex must be held.
+func (e *WorkflowEngine) advance(workflow *types.Workflow) []stepRelease {
+	e.refresh(workflow)
+
+	var releases []stepRelease
+
+	statuses := make(map[string]types.StepStatus, len(workflow.Steps))
+	for _, step := range workflow.Steps {
+		statuses[step.Name] = step.Status
+	}
+
+	// skipped and failed steps decide their downstream steps, so waiting steps are visited until nothing changes
+	for changed := true; changed; {
+		changed = false
+		for i := range workflow.Steps {
+			step := &workflow.Steps[i]
+			if step.Status != types.StepStatusWaiting {
+				continue
+			}
+
+			ready, skip := true, false
+			for _, dependency := range step.DependsOn {
+				status := statuses[dependency.Step]
+				if !status.Finished() {
+					ready = false
+				} else if !dependency.Allows(status) {
+					skip = true
+				}
+			}
+
+			switch {
+			case skip:
+				step.Status = types.StepStatusSkipped
+			case ready:
+				if release, ok := e.release(workflow, i); ok {
+					releases = append(releases, release)
+				}
+			default:
+				continue
+			}
+			statuses[step.Name] = step.Status
+			changed = true
+		}
+	}
+
+	failed := false
+	for _, step := range workflow.Steps {
+		if !step.Status.Finished() {
+			return releases
+		}
+		failed = failed || step.Status.Failed()
+	}
+
+	workflow.Status = types.WorkflowStatusComplete
+	if failed {
+		workflow.Status = types.WorkflowStatusFailed
+	}
+	workflow.FinishedAt = time.Now()
+	logrus.WithFields(logrus.Fields{
+		"workflow": workflow.ID,
+		"status":   workflow.Status,
+	}).Info("workflow finished")
+	return releases
+}
+
+// refresh updates the released steps of a workflow from their jobs, steps of nodes that left are failed
+func (e *WorkflowEngine) refresh(workflow *types.Workflow) {
+	peers := e.p2pService.Peers()
+
+	for i := range workflow.Steps {
+		step := &workflow.Steps[i]
+		if step.JobID == "" || step.Status.Finished() {
+			continue
+		}
+
+		job, ok := e.queue.GetJob(step.JobID)
+		if !ok {
+			continue
+		}
+		step.Node = job.Node
+		step.Status = types.StepStatus(job.Status)
+		if job.Status.Active() && job.Node != e.nodeID && !slices.Contains(peers, job.Node) {
+			logrus.WithFields(logrus.Fields{
+				"workflow": workflow.ID,
+				"step":     step.Name,
+				"node":     job.Node,
+			}).Warn("node of step left")
+			step.Status = types.StepStatusFailed
+		}
+	}
+}
+
+// release marks a step pending with a new job, to be placed by dispatch. The step fails if its job can't be
+// created. The mutex must be held.
+func (e *WorkflowEngine) release(workflow *types.Workflow, index int) (stepRelease, bool) {
+	step := &workflow.Steps[index]
+	logger := logrus.WithFields(logrus.Fields{
+		"workflow": workflow.ID,
+		"step":     step.Name,
+	})
+
+	jobID, err := uuid.NewUUID()
+	if err != nil {
+		logger.Errorf("failed to generate job ID: %v", err)
+		step.Status = types.StepStatusFailed
+		return stepRelease{}, false
+	}
+
+	container, err := e.resolveInputs(workflow, workflow.StepContainer(*step))
+	if err != nil {
+		logger.Warnf("failed to release step: %v", err)
+		step.Status = types.StepStatusFailed
+		return stepRelease{}, false
+	}
+
+	step.JobID = jobID.String()
+	step.Status = types.StepStatusPending
+	return stepRelease{workflowID: workflow.ID, step: index, jobID: step.JobID, container: container}, true
+}
+
+// dispatch places the jobs of released steps. Steps that can't be placed fail, and their workflows are advanced
+// again so their downstream steps are released or skipped. Steps of workflows cancelled meanwhile are cancelled.
+func (e *WorkflowEngine) dispatch(releases []stepRelease) {
+	for len(releases) > 0 {
+		placed := make([]string, len(releases))
+		errs := make([]error, len(releases))
+		for i, release := range releases {
+			placed[i], errs[i] = e.dispatcher.Dispatch(release.jobID, release.container, "workflow/"+release.workflowID)
+		}
+
+		e.mutex.Lock()
+		var cancelled []stepRelease
+		failed := make(map[string]*types.Workflow)
+		for i, release := range releases {
+			workflow, ok := e.workflows[release.workflowID]
+			if !ok {
+				continue
+			}
+			step := &workflow.Steps[release.step]
+			logger := logrus.WithFields(logrus.Fields{
+				"workflow": workflow.ID,
+				"step":     step.Name,
+				"job_id":   release.jobID,
+			})
+
+			running := workflow.Status == types.WorkflowStatusRunning
+			if errs[i] != nil {
+				logger.Warnf("failed to release step: %v", errs[i])
+				if running {
+					step.Status = types.StepStatusFailed
+					failed[workflow.ID] = workflow
+				}
+				continue
+			}
+			if !running {
+				cancelled = append(cancelled, release)
+				continue
+			}
+			step.Node = placed[i]
+			logger.WithField("node", placed[i]).Info("released step")
+		}
+		releases = nil
+		for _, workflow := range failed {
+			if workflow.Status == types.WorkflowStatusRunning {
+				releases = append(releases, e.advance(workflow)...)
+			}
+		}
+		e.mutex.Unlock()
+
+		for _, release := range cancelled {
+			if job, ok := e.queue.GetJob(release.jobID); ok && job.Status.Active() {
+				e.cancelStep(release.workflowID, job)
+			}
+		}
+	}
+}
+
+// resolveInputs resolves the inputs of a step container to the artifacts collected from its upstream steps
+func (e *WorkflowEngine) resolveInputs(workflow *types.Workflow, container types.Container) (types.Container, error) {
+	if len(container.Inputs) == 0 {
+		return container, nil
+	}
+
+	jobs := make(map[string]string, len(workflow.Steps))
+	for _, step := range workflow.Steps {
+		jobs[step.Name] = step.JobID
+	}
+
+	inputs := make([]types.ArtifactInput, 0, len(container.Inputs))
+	for _, input := range container.Inputs {
+		if input.Step != "" {
+			job, _ := e.queue.GetJob(jobs[input.Step])
+			index := slices.IndexFunc(job.Artifacts, func(artifact types.Artifact) bool {
+				return artifact.Name == input.Name
+			})
+			if index < 0 {
+				return types.Container{}, fmt.Errorf("step %q has no artifact %q", input.Step, input.Name)
+			}
+			input.Digest = job.Artifacts[index].Digest
+			input.Node = job.Artifacts[index].Node
+		}
+		inputs = append(inputs, input)
+	}
+	container.Inputs = inputs
+	return container, nil
+}
+
+// snapshot returns a copy of a workflow that doesn't share its steps
+func (e *WorkflowEngine) snapshot(workflow *types.Workflow) types.Workflow {
+	snapshot := *workflow
+	snapshot.Steps = slices.Clone(workflow.Steps)
+	return snapshot
+}
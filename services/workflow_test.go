@@ -0,0 +1,248 @@
+package services
+
+import (
+	"container-manager/types"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+// stepJobs records the jobs of the steps the engine enqueues, by step name
+type stepJobs map[string]*types.Job
+
+// newTestWorkflowEngine creates a workflow engine whose steps are enqueued on the local node
+func newTestWorkflowEngine(ctrl *gomock.Controller) (*WorkflowEngine, *MockQueue, stepJobs) {
+	reconciler, mockQueue, mockP2PService := newTestReconciler(ctrl)
+	engine := NewWorkflowEngine(mockQueue, mockP2PService, reconciler.dispatcher)
+	jobs := stepJobs{}
+
+	mockQueue.EXPECT().Enqueue(gomock.Any(), gomock.Any()).DoAndReturn(func(jobID string, container types.Container) error {
+		jobs[container.Labels[types.WorkflowStepLabel]] = &types.Job{
+			ID:        jobID,
+			Container: container,
+			Status:    types.JobStatusPending,
+			Node:      "local",
+		}
+		return nil
+	}).AnyTimes()
+	mockQueue.EXPECT().GetJob(gomock.Any()).DoAndReturn(func(jobID string) (types.Job, bool) {
+		for _, job := range jobs {
+			if job.ID == jobID {
+				return *job, true
+			}
+		}
+		return types.Job{}, false
+	}).AnyTimes()
+	return engine, mockQueue, jobs
+}
+
+// pipeline is a workflow that fetches, transforms and publishes, and notifies when the transform fails
+var pipeline = types.Workflow{Steps: []types.WorkflowStep{
+	{Name: "fetch", Container: types.Container{Image: "fetch"}},
+	{Name: "transform", Container: types.Container{Image: "transform"}, DependsOn: []types.StepDependency{
+		{Step: "fetch"},
+	}},
+	{Name: "publish", Container: types.Container{Image: "publish"}, DependsOn: []types.StepDependency{
+		{Step: "transform", Condition: types.StepConditionSuccess},
+	}},
+	{Name: "notify", Container: types.Container{Image: "notify"}, DependsOn: []types.StepDependency{
+		{Step: "transform", Condition: types.StepConditionFailure},
+	}},
+}}
+
+// stepStatuses returns the status of each step of a workflow by name
+func stepStatuses(workflow types.Workflow) map[string]types.StepStatus {
+	statuses := make(map[string]types.StepStatus, len(workflow.Steps))
+	for _, step := range workflow.Steps {
+		statuses[step.Name] = step.Status
+	}
+	return statuses
+}
+
+func TestWorkflowEngineReleasesSteps(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	engine, _, jobs := newTestWorkflowEngine(ctrl)
+
+	// only the step without dependencies is released on submit, as a container that isn't restarted
+	workflow, err := engine.Submit(pipeline)
+	require.NoError(t, err)
+	require.Equal(t, types.WorkflowStatusRunning, workflow.Status)
+	require.Equal(t, map[string]types.StepStatus{
+		"fetch":     types.StepStatusPending,
+		"transform": types.StepStatusWaiting,
+		"publish":   types.StepStatusWaiting,
+		"notify":    types.StepStatusWaiting,
+	}, stepStatuses(workflow))
+	require.Len(t, jobs, 1)
+	require.Equal(t, types.RestartPolicyNever, jobs["fetch"].Container.RestartPolicy.Name)
+	require.Equal(t, workflow.ID, jobs["fetch"].Container.Labels[types.WorkflowLabel])
+
+	// the downstream step is released once the upstream step completed
+	jobs["fetch"].Status = types.JobStatusComplete
+	engine.advanceAll()
+	workflow, err = engine.Get(workflow.ID)
+	require.NoError(t, err)
+	require.Equal(t, types.StepStatusPending, stepStatuses(workflow)["transform"])
+
+	// a failed step skips the steps waiting for its success and releases the ones waiting for its failure
+	jobs["transform"].Status = types.JobStatusFailed
+	engine.advanceAll()
+	workflow, err = engine.Get(workflow.ID)
+	require.NoError(t, err)
+	require.Equal(t, types.StepStatusSkipped, stepStatuses(workflow)["publish"])
+	require.Equal(t, types.StepStatusPending, stepStatuses(workflow)["notify"])
+	require.NotContains(t, jobs, "publish")
+
+	// the workflow fails once all steps finished
+	jobs["notify"].Status = types.JobStatusComplete
+	engine.advanceAll()
+	workflow, err = engine.Get(workflow.ID)
+	require.NoError(t, err)
+	require.Equal(t, types.WorkflowStatusFailed, workflow.Status)
+}
+
+func TestWorkflowEngineCancel(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	engine, mockQueue, jobs := newTestWorkflowEngine(ctrl)
+
+	workflow, err := engine.Submit(pipeline)
+	require.NoError(t, err)
+
+	// released steps are cancelled, waiting steps never run
+	mockQueue.EXPECT().Cancel(jobs["fetch"].ID).Return(nil)
+	workflow, err = engine.Cancel(workflow.ID)
+	require.NoError(t, err)
+	require.Equal(t, types.WorkflowStatusCancelled, workflow.Status)
+	for _, step := range workflow.Steps {
+		require.Equal(t, types.StepStatusCancelled, step.Status)
+	}
+
+	_, err = engine.Cancel(workflow.ID)
+	require.ErrorIs(t, err, ErrWorkflowFinished)
+
+	// finished workflows are pruned once they were kept long enough
+	require.False(t, workflow.FinishedAt.IsZero())
+	engine.advanceAll()
+	_, err = engine.Get(workflow.ID)
+	require.NoError(t, err)
+	engine.workflows[workflow.ID].FinishedAt = time.Now().Add(-workflowRetention - time.Second)
+	engine.advanceAll()
+	_, err = engine.Get(workflow.ID)
+	require.ErrorIs(t, err, ErrWorkflowNotFound)
+}
+
+func TestWorkflowEngineDispatchesUnlocked(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	reconciler, mockQueue, mockP2PService := newTestReconciler(ctrl)
+	engine := NewWorkflowEngine(mockQueue, mockP2PService, reconciler.dispatcher)
+
+	// the workflows can be read while their steps are placed
+	var listed []types.Workflow
+	mockQueue.EXPECT().GetJob(gomock.Any()).Return(types.Job{}, false).AnyTimes()
+	mockQueue.EXPECT().Enqueue(gomock.Any(), gomock.Any()).DoAndReturn(func(string, types.Container) error {
+		listed = engine.List()
+		return ErrQueueFull
+	})
+
+	// steps that can't be placed fail and their downstream steps are skipped
+	workflow, err := engine.Submit(types.Workflow{Steps: []types.WorkflowStep{
+		{Name: "fetch", Container: types.Container{Image: "fetch"}},
+		{Name: "transform", Container: types.Container{Image: "transform"}, DependsOn: []types.StepDependency{
+			{Step: "fetch"},
+		}},
+	}})
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	require.Equal(t, types.StepStatusPending, listed[0].Steps[0].Status)
+	require.Equal(t, types.WorkflowStatusFailed, workflow.Status)
+	require.Equal(t, map[string]types.StepStatus{
+		"fetch":     types.StepStatusFailed,
+		"transform": types.StepStatusSkipped,
+	}, stepStatuses(workflow))
+}
+
+func TestWorkflowEngineRejectsCycles(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	engine, _, _ := newTestWorkflowEngine(ctrl)
+
+	_, err := engine.Submit(types.Workflow{Steps: []types.WorkflowStep{
+		{Name: "a", Container: types.Container{Image: "a"}, DependsOn: []types.StepDependency{{Step: "b"}}},
+		{Name: "b", Container: types.Container{Image: "b"}, DependsOn: []types.StepDependency{{Step: "a"}}},
+	}})
+	require.Error(t, err)
+	require.Empty(t, engine.List())
+}
+
+func TestWorkflowEngineResolvesInputs(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	engine, _, jobs := newTestWorkflowEngine(ctrl)
+
+	workflow, err := engine.Submit(types.Workflow{Steps: []types.WorkflowStep{
+		{Name: "fetch", Container: types.Container{
+			Image:   "fetch",
+			Outputs: []types.ArtifactOutput{{Name: "dataset", Path: "/out/dataset"}},
+		}},
+		{Name: "transform", DependsOn: []types.StepDependency{{Step: "fetch"}}, Container: types.Container{
+			Image:  "transform",
+			Inputs: []types.ArtifactInput{{Step: "fetch", Name: "dataset", Path: "/in/dataset"}},
+		}},
+	}})
+	require.NoError(t, err)
+
+	// the input is resolved to the artifact collected from the upstream step
+	digest := "sha256:" + strings.Repeat("a", 64)
+	jobs["fetch"].Status = types.JobStatusComplete
+	jobs["fetch"].Artifacts = []types.Artifact{{Name: "dataset", Digest: digest, Node: "peer"}}
+	engine.advanceAll()
+	require.Equal(t, []types.ArtifactInput{
+		{Step: "fetch", Name: "dataset", Digest: digest, Node: "peer", Path: "/in/dataset"},
+	}, jobs["transform"].Container.Inputs)
+
+	workflow, err = engine.Get(workflow.ID)
+	require.NoError(t, err)
+	require.Equal(t, types.StepStatusPending, stepStatuses(workflow)["transform"])
+}
+
+func TestWorkflowEngineRejectsInputsOfUnrelatedSteps(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	engine, _, _ := newTestWorkflowEngine(ctrl)
+
+	_, err := engine.Submit(types.Workflow{Steps: []types.WorkflowStep{
+		{Name: "fetch", Container: types.Container{
+			Image:   "fetch",
+			Outputs: []types.ArtifactOutput{{Name: "dataset", Path: "/out/dataset"}},
+		}},
+		{Name: "transform", Container: types.Container{
+			Image:  "transform",
+			Inputs: []types.ArtifactInput{{Step: "fetch", Name: "dataset", Path: "/in/dataset"}},
+		}},
+	}})
+	require.Error(t, err)
+}
@@ -0,0 +1,89 @@
+package types
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+)
+
+// artifactDigestPattern is the pattern of artifact digests
+var artifactDigestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// ValidateArtifactDigest validates the format of an artifact digest
+func ValidateArtifactDigest(digest string) error {
+	if !artifactDigestPattern.MatchString(digest) {
+		return fmt.Errorf("invalid artifact digest %q", digest)
+	}
+	return nil
+}
+
+// ArtifactOutput is a path copied out of the container once it exited successfully.
+// name: The name of the artifact, unique in the container
+// path: The absolute path of the file or directory in the container
+type ArtifactOutput struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// ArtifactInput is an artifact copied into the container before it starts.
+// In workflows the artifact is named by the upstream step that produced it, otherwise by its digest.
+// step: The workflow step that produced the artifact
+// name: The name of the artifact produced by the step
+// digest: The digest of the artifact
+// node: The ID of the node that holds the artifact
+// path: The absolute path the artifact is copied to in the container
+type ArtifactInput struct {
+	Step   string `json:"step,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Digest string `json:"digest,omitempty"`
+	Node   string `json:"node,omitempty"`
+	Path   string `json:"path"`
+}
+
+// Artifact is an output collected from the container of a job.
+// name: The name of the output
+// digest: The digest of the artifact in the store of the node
+// size: The size of the artifact in bytes
+// node: The ID of the node that holds the artifact
+type Artifact struct {
+	Name   string `json:"name"`
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+	Node   string `json:"node"`
+}
+
+// validateArtifacts validates the outputs and inputs of a container
+func validateArtifacts(outputs []ArtifactOutput, inputs []ArtifactInput) error {
+	names := make(map[string]bool, len(outputs))
+	for _, output := range outputs {
+		if output.Name == "" {
+			return fmt.Errorf("output name is required")
+		}
+		if names[output.Name] {
+			return fmt.Errorf("duplicate output %q", output.Name)
+		}
+		if !path.IsAbs(output.Path) || path.Clean(output.Path) == "/" {
+			return fmt.Errorf("output %q requires an absolute path", output.Name)
+		}
+		names[output.Name] = true
+	}
+
+	for _, input := range inputs {
+		if !path.IsAbs(input.Path) || path.Clean(input.Path) == "/" {
+			return fmt.Errorf("input requires an absolute path")
+		}
+		if input.Step != "" {
+			if input.Name == "" {
+				return fmt.Errorf("input of step %q requires the name of the artifact", input.Step)
+			}
+			continue
+		}
+		if err := ValidateArtifactDigest(input.Digest); err != nil {
+			return err
+		}
+		if input.Node == "" {
+			return fmt.Errorf("input %s requires the node that holds it", input.Digest)
+		}
+	}
+	return nil
+}
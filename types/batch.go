@@ -0,0 +1,187 @@
+package types
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// BatchLabel is the label that identifies the jobs of a batch
+	BatchLabel = "batch.id"
+	// BatchIndexLabel is the label with the index of a job in its batch
+	BatchIndexLabel = "batch.index"
+	// JobIndexEnv is the environment variable with the index of a job in its batch
+	JobIndexEnv = "JOB_INDEX"
+	// MaxBatchSize is the maximum number of jobs a batch expands into
+	MaxBatchSize = 1000
+)
+
+// envNamePattern is the pattern of the parameter names of a batch matrix, set as environment variables
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// BatchStatus is the aggregate status of a batch
+type BatchStatus string
+
+const (
+	BatchStatusRunning   BatchStatus = "running"
+	BatchStatusComplete  BatchStatus = "complete"
+	BatchStatusFailed    BatchStatus = "failed"
+	BatchStatusCancelled BatchStatus = "cancelled"
+)
+
+// BatchCounts is the number of jobs of a batch in each status.
+// total: The number of jobs of the batch
+// pending: The jobs waiting to run
+// running: The running jobs
+// complete: The jobs that completed
+// failed: The jobs that failed
+// cancelled: The jobs that were cancelled
+// timed_out: The jobs that timed out
+type BatchCounts struct {
+	Total     int `json:"total"`
+	Pending   int `json:"pending"`
+	Running   int `json:"running"`
+	Complete  int `json:"complete"`
+	Failed    int `json:"failed"`
+	Cancelled int `json:"cancelled"`
+	TimedOut  int `json:"timed_out"`
+}
+
+// Add counts a job with the status
+func (bc *BatchCounts) Add(status JobStatus) {
+	bc.Total++
+	switch status {
+	case JobStatusPending:
+		bc.Pending++
+	case JobStatusRunning:
+		bc.Running++
+	case JobStatusComplete:
+		bc.Complete++
+	case JobStatusFailed:
+		bc.Failed++
+	case JobStatusCancelled:
+		bc.Cancelled++
+	case JobStatusTimedOut:
+		bc.TimedOut++
+	}
+}
+
+// Status returns the aggregate status of a batch with the counts, running until all its jobs finished
+func (bc BatchCounts) Status() BatchStatus {
+	switch {
+	case bc.Pending+bc.Running > 0:
+		return BatchStatusRunning
+	case bc.Cancelled > 0:
+		return BatchStatusCancelled
+	case bc.Failed+bc.TimedOut > 0:
+		return BatchStatusFailed
+	default:
+		return BatchStatusComplete
+	}
+}
+
+// Batch is a set of jobs expanded from one container template, either a number of copies or one job per
+// combination of the parameters of a matrix. Every job gets its index in the JOB_INDEX environment variable.
+// id: The ID of the batch
+// template: The container every job of the batch runs
+// count: The number of copies of the template
+// matrix: The values of each parameter, set as environment variables
+// jobs: The IDs of the jobs of the batch, by index
+// status: The aggregate status of the batch
+// counts: The number of jobs in each status
+// created_at: The time the batch was submitted
+// finished_at: The time the batch was seen finished, its counts are kept from then on
+type Batch struct {
+	ID         string              `json:"id"`
+	Template   Container           `json:"template"`
+	Count      int                 `json:"count,omitempty"`
+	Matrix     map[string][]string `json:"matrix,omitempty"`
+	Jobs       []string            `json:"jobs,omitempty"`
+	Status     BatchStatus         `json:"status"`
+	Counts     BatchCounts         `json:"counts"`
+	CreatedAt  time.Time           `json:"created_at"`
+	FinishedAt time.Time           `json:"finished_at,omitempty"`
+}
+
+// Size returns the number of jobs the batch expands into
+func (b Batch) Size() int {
+	if len(b.Matrix) == 0 {
+		return b.Count
+	}
+	size := 1
+	for _, values := range b.Matrix {
+		size *= len(values)
+		if size > MaxBatchSize {
+			return MaxBatchSize + 1
+		}
+	}
+	return size
+}
+
+// Validate validates the template and that the batch expands into at least one and at most MaxBatchSize jobs
+func (b Batch) Validate() error {
+	if err := b.Template.Validate(); err != nil {
+		return err
+	}
+	if b.Count < 0 {
+		return fmt.Errorf("batch count must not be negative")
+	}
+	if b.Count > 0 && len(b.Matrix) > 0 {
+		return fmt.Errorf("batch requires either a count or a matrix")
+	}
+	for name, values := range b.Matrix {
+		if !envNamePattern.MatchString(name) || name == JobIndexEnv {
+			return fmt.Errorf("invalid matrix parameter %q", name)
+		}
+		if len(values) == 0 {
+			return fmt.Errorf("matrix parameter %q requires at least one value", name)
+		}
+	}
+	if size := b.Size(); size == 0 {
+		return fmt.Errorf("batch requires a count or a matrix")
+	} else if size > MaxBatchSize {
+		return fmt.Errorf("batch expands into more than %d jobs", MaxBatchSize)
+	}
+	return nil
+}
+
+// Expand returns the container of each job of the batch. Matrix combinations are ordered
+// by the parameter names, the values of the last parameter changing fastest.
+func (b Batch) Expand() []Container {
+	names := make([]string, 0, len(b.Matrix))
+	for name := range b.Matrix {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	size := b.Size()
+	containers := make([]Container, 0, size)
+	for index := 0; index < size; index++ {
+		container := b.Template
+		container.Env = maps.Clone(b.Template.Env)
+		if container.Env == nil {
+			container.Env = make(map[string]string)
+		}
+		container.Labels = maps.Clone(b.Template.Labels)
+		if container.Labels == nil {
+			container.Labels = make(map[string]string)
+		}
+
+		// the index is decomposed into the position of each parameter, last parameter first
+		rest := index
+		for i := len(names) - 1; i >= 0; i-- {
+			values := b.Matrix[names[i]]
+			container.Env[names[i]] = values[rest%len(values)]
+			rest /= len(values)
+		}
+		container.Env[JobIndexEnv] = strconv.Itoa(index)
+		container.Labels[BatchLabel] = b.ID
+		container.Labels[BatchIndexLabel] = strconv.Itoa(index)
+		containers = append(containers, container)
+	}
+	return containers
+}
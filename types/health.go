@@ -0,0 +1,134 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultHealthInterval is the default interval between health checks
+	DefaultHealthInterval = 30 * time.Second
+	// DefaultHealthTimeout is the default timeout of a health check
+	DefaultHealthTimeout = 30 * time.Second
+	// DefaultHealthRetries is the default number of consecutive failures before a container is unhealthy
+	DefaultHealthRetries = 3
+)
+
+// HealthStatus is the health of the container of a job
+type HealthStatus string
+
+const (
+	HealthStatusStarting  HealthStatus = "starting"
+	HealthStatusHealthy   HealthStatus = "healthy"
+	HealthStatusUnhealthy HealthStatus = "unhealthy"
+)
+
+// Duration is a duration encoded in JSON as a string like "10s"
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON decodes a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string like \"10s\"")
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", value, err)
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// HTTPProbe checks the health of a container with an HTTP GET request, a 2xx or 3xx response is healthy.
+// port: The container port to send the request to
+// path: The path of the request
+type HTTPProbe struct {
+	Port int    `json:"port"`
+	Path string `json:"path,omitempty"`
+}
+
+// TCPProbe checks the health of a container by opening a TCP connection.
+// port: The container port to connect to
+type TCPProbe struct {
+	Port int `json:"port"`
+}
+
+// HealthCheck checks the health of a container, either with a command Docker runs in the container
+// or with an HTTP or TCP probe the manager runs against it.
+// command: The command Docker runs in the container, healthy when it exits with 0
+// http: The HTTP probe the manager runs
+// tcp: The TCP probe the manager runs
+// interval: The interval between checks
+// timeout: The timeout of a check
+// retries: The number of consecutive failures before the container is unhealthy
+// start_period: The time the container has to start, failures during it don't count
+type HealthCheck struct {
+	Command     []string   `json:"command,omitempty"`
+	HTTP        *HTTPProbe `json:"http,omitempty"`
+	TCP         *TCPProbe  `json:"tcp,omitempty"`
+	Interval    Duration   `json:"interval,omitempty"`
+	Timeout     Duration   `json:"timeout,omitempty"`
+	Retries     int        `json:"retries,omitempty"`
+	StartPeriod Duration   `json:"start_period,omitempty"`
+}
+
+// Validate validates the health check
+func (hc HealthCheck) Validate() error {
+	var checks int
+	if len(hc.Command) > 0 {
+		checks++
+	}
+	if hc.HTTP != nil {
+		checks++
+		if err := validatePort(hc.HTTP.Port); err != nil {
+			return err
+		}
+	}
+	if hc.TCP != nil {
+		checks++
+		if err := validatePort(hc.TCP.Port); err != nil {
+			return err
+		}
+	}
+	if checks != 1 {
+		return fmt.Errorf("health check requires exactly one of command, http or tcp")
+	}
+
+	if hc.Interval < 0 || hc.Timeout < 0 || hc.StartPeriod < 0 {
+		return fmt.Errorf("health check durations must not be negative")
+	}
+	if hc.Retries < 0 {
+		return fmt.Errorf("health check retries must not be negative")
+	}
+	return nil
+}
+
+// WithDefaults returns the health check with the defaults applied to unset values
+func (hc HealthCheck) WithDefaults() HealthCheck {
+	if hc.Interval == 0 {
+		hc.Interval = Duration(DefaultHealthInterval)
+	}
+	if hc.Timeout == 0 {
+		hc.Timeout = Duration(DefaultHealthTimeout)
+	}
+	if hc.Retries == 0 {
+		hc.Retries = DefaultHealthRetries
+	}
+	return hc
+}
+
+// validatePort validates a probe port
+func validatePort(port int) error {
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("probe port must be between 1 and 65535")
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"time"
+)
+
+const (
+	// ManagedJobLabel is the label with the ID of the job that created a container
+	ManagedJobLabel = "container-manager.job"
+	// ManagedNodeLabel is the label with the ID of the node that created a container
+	ManagedNodeLabel = "container-manager.node"
+	// ManagedVersionLabel is the label with the version of the manager that created a container
+	ManagedVersionLabel = "container-manager.version"
+	// ManagedSpecLabel is the label with the container of the job, so the job can be adopted again
+	ManagedSpecLabel = "container-manager.spec"
+	// ManagedOriginLabel is the label with the ID of the node that handed the job over to the node
+	ManagedOriginLabel = "container-manager.origin"
+	// ManagedRunTimeLabel is the label with the time the previous containers of the job ran
+	ManagedRunTimeLabel = "container-manager.run-time"
+	// ManagedDeployedLabel is the label with the time the deploy of the container started
+	ManagedDeployedLabel = "container-manager.deployed"
+)
+
+// Version is the version of the manager, set at build time with -ldflags "-X container-manager/types.Version=..."
+var Version = "dev"
+
+// OrphanPolicy is what a node does on startup with the containers it created before it restarted
+type OrphanPolicy string
+
+const (
+	// OrphanPolicyAdopt adopts the containers back into their jobs, the containers that can't be adopted are removed
+	OrphanPolicyAdopt OrphanPolicy = "adopt"
+	// OrphanPolicyRemove stops and removes the containers
+	OrphanPolicyRemove OrphanPolicy = "remove"
+	// OrphanPolicyIgnore leaves the containers alone
+	OrphanPolicyIgnore OrphanPolicy = "ignore"
+)
+
+// Valid reports whether the policy is known
+func (op OrphanPolicy) Valid() bool {
+	return op == OrphanPolicyAdopt || op == OrphanPolicyRemove || op == OrphanPolicyIgnore
+}
+
+// Managed returns the container with the labels that link it back to the job that runs it on the node
+func (c Container) Managed(jobID string, nodeID string) Container {
+	spec, _ := json.Marshal(c)
+
+	managed := c
+	managed.Labels = maps.Clone(c.Labels)
+	if managed.Labels == nil {
+		managed.Labels = make(map[string]string)
+	}
+	managed.Labels[ManagedJobLabel] = jobID
+	managed.Labels[ManagedNodeLabel] = nodeID
+	managed.Labels[ManagedVersionLabel] = Version
+	managed.Labels[ManagedSpecLabel] = string(spec)
+	return managed
+}
+
+// Managed returns the container of the job with the labels that link it back to the job, and with the origin and
+// run time of the job so they are restored when the job is adopted again
+func (j Job) Managed(nodeID string, deployed time.Time) Container {
+	managed := j.Container.Managed(j.ID, nodeID)
+	if j.Origin != "" {
+		managed.Labels[ManagedOriginLabel] = j.Origin
+	}
+	managed.Labels[ManagedRunTimeLabel] = time.Duration(j.RunTime).String()
+	managed.Labels[ManagedDeployedLabel] = deployed.UTC().Format(time.RFC3339Nano)
+	return managed
+}
+
+// ManagedRun returns the time the previous containers of a job ran and the time the deploy of its container
+// started from the labels of the container. Containers labelled by older versions report no run time and now.
+func ManagedRun(labels map[string]string, now time.Time) (Duration, time.Time) {
+	runTime, err := time.ParseDuration(labels[ManagedRunTimeLabel])
+	if err != nil {
+		runTime = 0
+	}
+	deployed, err := time.Parse(time.RFC3339Nano, labels[ManagedDeployedLabel])
+	if err != nil {
+		deployed = now
+	}
+	return Duration(runTime), deployed
+}
+
+// ManagedSpec returns the container of a job from the labels of the container that runs it
+func ManagedSpec(labels map[string]string) (Container, error) {
+	var container Container
+	if err := json.Unmarshal([]byte(labels[ManagedSpecLabel]), &container); err != nil {
+		return Container{}, fmt.Errorf("failed to decode container spec: %w", err)
+	}
+	return container, nil
+}
@@ -0,0 +1,46 @@
+package types
+
+import "time"
+
+// NodeCapacity is the capacity record a node gossips to its peers.
+// node_id: The ID of the node
+// workers: The number of queue workers
+// free_workers: The number of workers not running a job
+// queue_depth: The number of jobs waiting in the queue
+// queue_size: The maximum number of jobs the queue holds
+// cpus: The number of CPUs of the host
+// cpu_headroom: The number of idle CPUs, based on the load average
+// memory_total: The memory of the host in bytes
+// memory_headroom: The memory available for new containers in bytes
+// images: The images cached on the node
+// labels: The labels of the node
+// updated_at: The time the record was taken
+type NodeCapacity struct {
+	NodeID         string            `json:"node_id"`
+	Workers        int               `json:"workers"`
+	FreeWorkers    int               `json:"free_workers"`
+	QueueDepth     int               `json:"queue_depth"`
+	QueueSize      int               `json:"queue_size"`
+	CPUs           float64           `json:"cpus"`
+	CPUHeadroom    float64           `json:"cpu_headroom"`
+	MemoryTotal    uint64            `json:"memory_total"`
+	MemoryHeadroom uint64            `json:"memory_headroom"`
+	Images         []string          `json:"images"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+}
+
+// QueueFull reports whether the queue of the node can't take more jobs
+func (nc NodeCapacity) QueueFull() bool {
+	return nc.QueueSize > 0 && nc.QueueDepth >= nc.QueueSize
+}
+
+// HasImage reports whether the image is cached on the node
+func (nc NodeCapacity) HasImage(image string) bool {
+	for _, cached := range nc.Images {
+		if cached == image {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package types
+
+import "fmt"
+
+// NodeIDLabel is the label every node carries with its own ID, so constraints can target single nodes
+const NodeIDLabel = "node.id"
+
+// LabelOperator is the operator of a label requirement
+type LabelOperator string
+
+const (
+	// LabelOpIn requires the label to have one of the values
+	LabelOpIn LabelOperator = "In"
+	// LabelOpNotIn requires the label to be missing or have none of the values
+	LabelOpNotIn LabelOperator = "NotIn"
+	// LabelOpExists requires the label to be set
+	LabelOpExists LabelOperator = "Exists"
+	// LabelOpDoesNotExist requires the label to be missing
+	LabelOpDoesNotExist LabelOperator = "DoesNotExist"
+)
+
+// LabelRequirement is a requirement on the value of a node label.
+// key: The label key
+// operator: How the label value is compared to the values
+// values: The values for the In and NotIn operators
+type LabelRequirement struct {
+	Key      string        `json:"key"`
+	Operator LabelOperator `json:"operator"`
+	Values   []string      `json:"values,omitempty"`
+}
+
+// Validate validates the label requirement
+func (lr LabelRequirement) Validate() error {
+	if lr.Key == "" {
+		return fmt.Errorf("label key is required")
+	}
+	switch lr.Operator {
+	case LabelOpIn, LabelOpNotIn:
+		if len(lr.Values) == 0 {
+			return fmt.Errorf("operator %s on label %q requires values", lr.Operator, lr.Key)
+		}
+	case LabelOpExists, LabelOpDoesNotExist:
+		if len(lr.Values) != 0 {
+			return fmt.Errorf("operator %s on label %q takes no values", lr.Operator, lr.Key)
+		}
+	default:
+		return fmt.Errorf("unknown label operator %q", lr.Operator)
+	}
+	return nil
+}
+
+// Matches reports whether the labels satisfy the requirement
+func (lr LabelRequirement) Matches(labels map[string]string) bool {
+	value, ok := labels[lr.Key]
+	switch lr.Operator {
+	case LabelOpIn:
+		return ok && contains(lr.Values, value)
+	case LabelOpNotIn:
+		return !ok || !contains(lr.Values, value)
+	case LabelOpExists:
+		return ok
+	case LabelOpDoesNotExist:
+		return !ok
+	default:
+		return false
+	}
+}
+
+// WeightedLabelRequirement is a label requirement that is preferred rather than required.
+// weight: How much the requirement counts, between 1 and 100
+type WeightedLabelRequirement struct {
+	Weight int `json:"weight"`
+	LabelRequirement
+}
+
+// Affinity attracts a job to nodes by their labels.
+// required: The requirements a node must satisfy to run the job
+// preferred: The requirements that make a node a better fit for the job
+type Affinity struct {
+	Required  []LabelRequirement         `json:"required,omitempty"`
+	Preferred []WeightedLabelRequirement `json:"preferred,omitempty"`
+}
+
+// Validate validates the affinity
+func (a Affinity) Validate() error {
+	for _, requirement := range a.Required {
+		if err := requirement.Validate(); err != nil {
+			return fmt.Errorf("invalid required affinity: %w", err)
+		}
+	}
+	for _, preference := range a.Preferred {
+		if preference.Weight < 1 || preference.Weight > 100 {
+			return fmt.Errorf("preferred affinity weight must be between 1 and 100")
+		}
+		if err := preference.Validate(); err != nil {
+			return fmt.Errorf("invalid preferred affinity: %w", err)
+		}
+	}
+	return nil
+}
+
+// AntiAffinityTerm keeps a job away from the jobs matching the selector.
+// selector: The labels of the jobs to keep away from
+// topology_key: The node label that defines the domain the jobs can't share, the node itself when empty
+type AntiAffinityTerm struct {
+	Selector    map[string]string `json:"selector"`
+	TopologyKey string            `json:"topology_key,omitempty"`
+}
+
+// Validate validates the anti-affinity term
+func (at AntiAffinityTerm) Validate() error {
+	if len(at.Selector) == 0 {
+		return fmt.Errorf("anti-affinity selector is required")
+	}
+	return nil
+}
+
+// SpreadConstraint spreads the jobs matching the selector evenly over the values of a node label.
+// topology_key: The node label the jobs are spread over
+// max_skew: The maximum difference in matching jobs between two domains
+// selector: The labels of the jobs counted in each domain
+type SpreadConstraint struct {
+	TopologyKey string            `json:"topology_key"`
+	MaxSkew     int               `json:"max_skew"`
+	Selector    map[string]string `json:"selector"`
+}
+
+// Validate validates the spread constraint
+func (sc SpreadConstraint) Validate() error {
+	if sc.TopologyKey == "" {
+		return fmt.Errorf("spread topology key is required")
+	}
+	if sc.MaxSkew <= 0 {
+		return fmt.Errorf("spread max skew must be greater than 0")
+	}
+	if len(sc.Selector) == 0 {
+		return fmt.Errorf("spread selector is required")
+	}
+	return nil
+}
+
+// SelectorMatches reports whether the labels contain every key and value of the selector
+func SelectorMatches(selector map[string]string, labels map[string]string) bool {
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// contains reports whether the value is in the list
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
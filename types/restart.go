@@ -0,0 +1,51 @@
+package types
+
+import "fmt"
+
+// RestartPolicyName is the name of a restart policy
+type RestartPolicyName string
+
+const (
+	// RestartPolicyNever never restarts the container once it exited
+	RestartPolicyNever RestartPolicyName = "never"
+	// RestartPolicyOnFailure restarts the container when it exits with a non-zero code
+	RestartPolicyOnFailure RestartPolicyName = "on-failure"
+	// RestartPolicyAlways restarts the container whenever it exits
+	RestartPolicyAlways RestartPolicyName = "always"
+)
+
+// RestartPolicy decides whether the container of a job is restarted once it exited.
+// name: The name of the policy, never, on-failure or always
+// max_restarts: The number of restarts of an on-failure policy before the job fails, unlimited when 0
+type RestartPolicy struct {
+	Name        RestartPolicyName `json:"name"`
+	MaxRestarts int               `json:"max_restarts,omitempty"`
+}
+
+// Validate validates the restart policy
+func (rp RestartPolicy) Validate() error {
+	switch rp.Name {
+	case RestartPolicyNever, RestartPolicyOnFailure, RestartPolicyAlways:
+	default:
+		return fmt.Errorf("unknown restart policy %q", rp.Name)
+	}
+	if rp.MaxRestarts < 0 {
+		return fmt.Errorf("max restarts must not be negative")
+	}
+	if rp.MaxRestarts > 0 && rp.Name != RestartPolicyOnFailure {
+		return fmt.Errorf("max restarts requires the on-failure restart policy")
+	}
+	return nil
+}
+
+// Restarts reports whether a container that exited, successfully or not, is restarted after the given number of restarts
+func (rp RestartPolicy) Restarts(failed bool, restarts int) bool {
+	switch rp.Name {
+	case RestartPolicyAlways:
+		return true
+	case RestartPolicyOnFailure:
+		return failed && (rp.MaxRestarts == 0 || restarts < rp.MaxRestarts)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,110 @@
+package types
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"regexp"
+	"time"
+)
+
+// RedactedValue replaces the values of environment variables and matrix parameters in logs
+const RedactedValue = "[redacted]"
+
+// secretNamePattern is the pattern of secret names
+var secretNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,252}$`)
+
+// ValidateSecretName validates the format of a secret name
+func ValidateSecretName(name string) error {
+	if !secretNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid secret name %q", name)
+	}
+	return nil
+}
+
+// SecretRef is a secret of the secret store injected into the container, by name. Its value is only
+// resolved by the node that runs the container.
+// name: The name of the secret
+// env: The environment variable set to the value of the secret
+// file: The absolute path of the read-only file holding the value of the secret, backed by tmpfs
+type SecretRef struct {
+	Name string `json:"name"`
+	Env  string `json:"env,omitempty"`
+	File string `json:"file,omitempty"`
+}
+
+// SecretInfo describes a secret of the secret store, without its value.
+// name: The name of the secret
+// updated_at: The time the value of the secret was last set
+type SecretInfo struct {
+	Name      string    `json:"name"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// validateSecrets validates the secrets of a container
+func validateSecrets(secrets []SecretRef, env map[string]string) error {
+	envs := make(map[string]bool, len(secrets))
+	files := make(map[string]bool, len(secrets))
+	for _, secret := range secrets {
+		if err := ValidateSecretName(secret.Name); err != nil {
+			return err
+		}
+		if secret.Env == "" && secret.File == "" {
+			return fmt.Errorf("secret %q requires an env variable or a file", secret.Name)
+		}
+		if secret.Env != "" {
+			if !envNamePattern.MatchString(secret.Env) {
+				return fmt.Errorf("secret %q has an invalid env variable %q", secret.Name, secret.Env)
+			}
+			if _, set := env[secret.Env]; set || envs[secret.Env] {
+				return fmt.Errorf("env variable %q of secret %q is already set", secret.Env, secret.Name)
+			}
+			envs[secret.Env] = true
+		}
+		if secret.File != "" {
+			if !path.IsAbs(secret.File) || path.Clean(secret.File) != secret.File || secret.File == "/" {
+				return fmt.Errorf("secret %q requires an absolute file path", secret.Name)
+			}
+			if files[secret.File] {
+				return fmt.Errorf("file %q of secret %q is already mounted", secret.File, secret.Name)
+			}
+			files[secret.File] = true
+		}
+	}
+	return nil
+}
+
+// Redacted returns a copy of the container to log, with the values of its environment variables and the spec
+// label of managed containers, which holds them too, redacted
+func (c Container) Redacted() Container {
+	redacted := c
+	if len(c.Env) > 0 {
+		redacted.Env = make(map[string]string, len(c.Env))
+		for key := range c.Env {
+			redacted.Env[key] = RedactedValue
+		}
+	}
+	if _, ok := c.Labels[ManagedSpecLabel]; ok {
+		redacted.Labels = maps.Clone(c.Labels)
+		redacted.Labels[ManagedSpecLabel] = RedactedValue
+	}
+	return redacted
+}
+
+// RedactedEnv returns the names of environment variables to log, with their values redacted
+func RedactedEnv(env map[string]string) map[string]string {
+	return Container{Env: env}.Redacted().Env
+}
+
+// RedactedMatrix returns the parameters of a batch matrix to log, with their values redacted, as they end up in
+// the environment of the jobs
+func RedactedMatrix(matrix map[string][]string) map[string]string {
+	if len(matrix) == 0 {
+		return nil
+	}
+	redacted := make(map[string]string, len(matrix))
+	for name := range matrix {
+		redacted[name] = RedactedValue
+	}
+	return redacted
+}
@@ -0,0 +1,119 @@
+package types
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// CapabilityAll is the capability that stands for every capability
+	CapabilityAll = "ALL"
+	// ProfileUnconfined is the seccomp and AppArmor profile that disables the profile of the runtime
+	ProfileUnconfined = "unconfined"
+)
+
+var (
+	// userPattern is the pattern of the names and IDs of users and groups
+	userPattern = regexp.MustCompile(`^([a-z_][a-z0-9_.-]{0,31}|[0-9]{1,10})$`)
+	// capabilityPattern is the pattern of capability names without their CAP_ prefix
+	capabilityPattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+	// profilePattern is the pattern of seccomp and AppArmor profile names
+	profilePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,127}$`)
+)
+
+// SecurityContext is the security options of a container, the defaults of the runtime apply when empty.
+// user: The user the container runs as, a name or UID
+// group: The group the container runs as, a name or GID, requires a user
+// read_only_root_fs: Mounts the root filesystem of the container read-only
+// cap_add: The capabilities added to the default set of the runtime, e.g. NET_ADMIN
+// cap_drop: The capabilities dropped from the default set of the runtime, ALL drops every one
+// no_new_privileges: Prevents the processes of the container from gaining privileges, e.g. through setuid
+// seccomp_profile: The seccomp profile of the node the container runs with, unconfined disables seccomp
+// apparmor_profile: The AppArmor profile loaded on the node the container runs with, unconfined disables AppArmor
+type SecurityContext struct {
+	User            string   `json:"user,omitempty"`
+	Group           string   `json:"group,omitempty"`
+	ReadOnlyRootFS  bool     `json:"read_only_root_fs,omitempty"`
+	CapAdd          []string `json:"cap_add,omitempty"`
+	CapDrop         []string `json:"cap_drop,omitempty"`
+	NoNewPrivileges bool     `json:"no_new_privileges,omitempty"`
+	SeccompProfile  string   `json:"seccomp_profile,omitempty"`
+	AppArmorProfile string   `json:"apparmor_profile,omitempty"`
+}
+
+// Validate validates the security options
+func (s SecurityContext) Validate() error {
+	if s.User != "" && !userPattern.MatchString(s.User) {
+		return fmt.Errorf("invalid user %q", s.User)
+	}
+	if s.Group != "" {
+		if s.User == "" {
+			return fmt.Errorf("group requires a user")
+		}
+		if !userPattern.MatchString(s.Group) {
+			return fmt.Errorf("invalid group %q", s.Group)
+		}
+	}
+
+	dropped := make(map[string]bool, len(s.CapDrop))
+	for _, capability := range s.CapDrop {
+		if err := ValidateCapability(capability); err != nil {
+			return err
+		}
+		dropped[NormalizeCapability(capability)] = true
+	}
+	for _, capability := range s.CapAdd {
+		if err := ValidateCapability(capability); err != nil {
+			return err
+		}
+		if dropped[NormalizeCapability(capability)] {
+			return fmt.Errorf("capability %q is both added and dropped", capability)
+		}
+	}
+
+	if s.SeccompProfile != "" && !profilePattern.MatchString(s.SeccompProfile) {
+		return fmt.Errorf("invalid seccomp profile %q", s.SeccompProfile)
+	}
+	if s.AppArmorProfile != "" && !profilePattern.MatchString(s.AppArmorProfile) {
+		return fmt.Errorf("invalid AppArmor profile %q", s.AppArmorProfile)
+	}
+	return nil
+}
+
+// RunsAsRoot reports whether the container may run as root, which it does when it keeps the user of its image.
+// Numeric users are compared by value, as the runtimes read 00 as UID 0.
+func (s SecurityContext) RunsAsRoot() bool {
+	if s.User == "" || s.User == "root" {
+		return true
+	}
+	uid, err := strconv.ParseUint(s.User, 10, 64)
+	return err == nil && uid == 0
+}
+
+// RunAs returns the user the container runs as, as user or user:group, the user of the image when empty
+func (s SecurityContext) RunAs() string {
+	if s.Group == "" {
+		return s.User
+	}
+	return s.User + ":" + s.Group
+}
+
+// NormalizeCapability returns the name of a capability in upper case without its CAP_ prefix, e.g. NET_ADMIN
+func NormalizeCapability(name string) string {
+	return strings.TrimPrefix(strings.ToUpper(name), "CAP_")
+}
+
+// ValidateCapability validates the format of a capability name, with or without its CAP_ prefix
+func ValidateCapability(name string) error {
+	if !capabilityPattern.MatchString(NormalizeCapability(name)) {
+		return fmt.Errorf("invalid capability %q", name)
+	}
+	return nil
+}
+
+// ReadOnlyRootFS reports whether the root filesystem of the container is mounted read-only
+func (c Container) ReadOnlyRootFS() bool {
+	return c.Security != nil && c.Security.ReadOnlyRootFS
+}
@@ -0,0 +1,135 @@
+package types
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	// ServiceLabel is the label that identifies the replicas of a service
+	ServiceLabel = "service.name"
+	// ServiceRevisionLabel is the label with the revision of the service spec a replica runs
+	ServiceRevisionLabel = "service.revision"
+)
+
+// UpdateStatus is the status of the rolling update of a service
+type UpdateStatus string
+
+const (
+	UpdateStatusUpdating       UpdateStatus = "updating"
+	UpdateStatusCompleted      UpdateStatus = "completed"
+	UpdateStatusRollingBack    UpdateStatus = "rolling_back"
+	UpdateStatusRolledBack     UpdateStatus = "rolled_back"
+	UpdateStatusRollbackFailed UpdateStatus = "rollback_failed"
+)
+
+// UpdateConfig configures how the replicas of a service are replaced on updates.
+// When both are zero one replica is surged at a time.
+// max_unavailable: The number of replicas that may be unavailable during the update
+// max_surge: The number of replicas that may run above the desired count during the update
+type UpdateConfig struct {
+	MaxUnavailable int `json:"max_unavailable"`
+	MaxSurge       int `json:"max_surge"`
+}
+
+// Validate validates the update config
+func (uc UpdateConfig) Validate() error {
+	if uc.MaxUnavailable < 0 || uc.MaxSurge < 0 {
+		return fmt.Errorf("max unavailable and max surge must not be negative")
+	}
+	return nil
+}
+
+// Limits returns the max surge and max unavailable, with one surged replica when both are zero
+func (uc UpdateConfig) Limits() (int, int) {
+	if uc.MaxUnavailable == 0 && uc.MaxSurge == 0 {
+		return 1, 0
+	}
+	return uc.MaxSurge, uc.MaxUnavailable
+}
+
+// serviceNamePattern is the pattern of valid service names
+var serviceNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// Service is a long-running workload the cluster keeps a number of replicas of.
+// name: The unique name of the service
+// container: The container each replica runs
+// replicas: The desired number of replicas
+// update: How replicas are replaced when the container changes
+// revision: The revision of the container spec, a new one is issued when it changes
+// previous_container: The container of the previous revision, restored when an update fails
+// previous_revision: The revision of the previous container, restored with it
+// last_revision: The latest revision issued, revisions rolled back from aren't issued again
+// update_status: The status of the latest update
+// update_started_at: The time the latest update started
+// owner: The ID of the node that reconciles the service
+// version: The version of the spec, incremented on every change
+// deleted: Whether the service was deleted, its replicas are stopped
+// updated_at: The time the spec last changed
+type Service struct {
+	Name              string       `json:"name"`
+	Container         Container    `json:"container"`
+	Replicas          int          `json:"replicas"`
+	Update            UpdateConfig `json:"update"`
+	Revision          uint64       `json:"revision"`
+	PreviousContainer *Container   `json:"previous_container,omitempty"`
+	PreviousRevision  uint64       `json:"previous_revision,omitempty"`
+	LastRevision      uint64       `json:"last_revision,omitempty"`
+	UpdateStatus      UpdateStatus `json:"update_status,omitempty"`
+	UpdateStartedAt   time.Time    `json:"update_started_at,omitempty"`
+	Owner             string       `json:"owner"`
+	Version           uint64       `json:"version"`
+	Deleted           bool         `json:"deleted,omitempty"`
+	UpdatedAt         time.Time    `json:"updated_at"`
+}
+
+// Validate validates the service spec
+func (s Service) Validate() error {
+	if !serviceNamePattern.MatchString(s.Name) {
+		return fmt.Errorf("service name must be lowercase alphanumeric or '-', and at most 63 characters")
+	}
+	if s.Replicas < 0 {
+		return fmt.Errorf("replicas must not be negative")
+	}
+	if err := s.Update.Validate(); err != nil {
+		return err
+	}
+	return s.Container.Validate()
+}
+
+// Updating reports whether a rolling update or rollback of the service is in progress
+func (s Service) Updating() bool {
+	return s.UpdateStatus == UpdateStatusUpdating || s.UpdateStatus == UpdateStatusRollingBack
+}
+
+// NextRevision returns the revision issued to the next container of the service
+func (s Service) NextRevision() uint64 {
+	return max(s.Revision, s.LastRevision) + 1
+}
+
+// RevisionLabel returns the value of the revision label of the replicas of the current revision
+func (s Service) RevisionLabel() string {
+	return strconv.FormatUint(s.Revision, 10)
+}
+
+// Newer reports whether the spec supersedes the other spec of the same service
+func (s Service) Newer(other Service) bool {
+	if s.Version != other.Version {
+		return s.Version > other.Version
+	}
+	return s.Owner > other.Owner
+}
+
+// ReplicaContainer returns the container of a replica, labelled with the service name and revision
+func (s Service) ReplicaContainer() Container {
+	container := s.Container
+	container.Labels = make(map[string]string, len(s.Container.Labels)+2)
+	for key, value := range s.Container.Labels {
+		container.Labels[key] = value
+	}
+	container.Labels[ServiceLabel] = s.Name
+	container.Labels[ServiceRevisionLabel] = s.RevisionLabel()
+	return container
+}
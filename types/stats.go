@@ -0,0 +1,66 @@
+package types
+
+import "time"
+
+// ContainerStats is a sample of the resource use of a container.
+// time: The time of the sample
+// cpu_percent: The CPU use since the previous sample, 100 per busy core
+// cpu_seconds: The CPU time the container used since it started
+// memory_bytes: The memory the container uses, without the page cache
+// memory_limit_bytes: The memory the container may use
+// network_rx_bytes: The bytes the container received since it started
+// network_tx_bytes: The bytes the container sent since it started
+// block_read_bytes: The bytes the container read from block devices since it started
+// block_write_bytes: The bytes the container wrote to block devices since it started
+type ContainerStats struct {
+	Time             time.Time `json:"time"`
+	CPUPercent       float64   `json:"cpu_percent"`
+	CPUSeconds       float64   `json:"cpu_seconds"`
+	MemoryBytes      uint64    `json:"memory_bytes"`
+	MemoryLimitBytes uint64    `json:"memory_limit_bytes,omitempty"`
+	NetworkRxBytes   uint64    `json:"network_rx_bytes"`
+	NetworkTxBytes   uint64    `json:"network_tx_bytes"`
+	BlockReadBytes   uint64    `json:"block_read_bytes"`
+	BlockWriteBytes  uint64    `json:"block_write_bytes"`
+}
+
+// ResourceUsage is the summary of the resource use of the containers of a job.
+// cpu_seconds: The CPU time the containers used
+// peak_memory_bytes: The most memory a container used
+// network_rx_bytes: The bytes the containers received
+// network_tx_bytes: The bytes the containers sent
+// block_read_bytes: The bytes the containers read from block devices
+// block_write_bytes: The bytes the containers wrote to block devices
+type ResourceUsage struct {
+	CPUSeconds      float64 `json:"cpu_seconds"`
+	PeakMemoryBytes uint64  `json:"peak_memory_bytes"`
+	NetworkRxBytes  uint64  `json:"network_rx_bytes"`
+	NetworkTxBytes  uint64  `json:"network_tx_bytes"`
+	BlockReadBytes  uint64  `json:"block_read_bytes"`
+	BlockWriteBytes uint64  `json:"block_write_bytes"`
+}
+
+// Observe returns the usage of a container updated with a sample of its stats. The counters of a container
+// only grow, so the usage keeps the largest value seen, which also ignores the empty samples of stopped containers.
+func (u ResourceUsage) Observe(stats ContainerStats) ResourceUsage {
+	return ResourceUsage{
+		CPUSeconds:      max(u.CPUSeconds, stats.CPUSeconds),
+		PeakMemoryBytes: max(u.PeakMemoryBytes, stats.MemoryBytes),
+		NetworkRxBytes:  max(u.NetworkRxBytes, stats.NetworkRxBytes),
+		NetworkTxBytes:  max(u.NetworkTxBytes, stats.NetworkTxBytes),
+		BlockReadBytes:  max(u.BlockReadBytes, stats.BlockReadBytes),
+		BlockWriteBytes: max(u.BlockWriteBytes, stats.BlockWriteBytes),
+	}
+}
+
+// Add returns the usage of a job with the usage of one more of its containers
+func (u ResourceUsage) Add(other ResourceUsage) ResourceUsage {
+	return ResourceUsage{
+		CPUSeconds:      u.CPUSeconds + other.CPUSeconds,
+		PeakMemoryBytes: max(u.PeakMemoryBytes, other.PeakMemoryBytes),
+		NetworkRxBytes:  u.NetworkRxBytes + other.NetworkRxBytes,
+		NetworkTxBytes:  u.NetworkTxBytes + other.NetworkTxBytes,
+		BlockReadBytes:  u.BlockReadBytes + other.BlockReadBytes,
+		BlockWriteBytes: u.BlockWriteBytes + other.BlockWriteBytes,
+	}
+}
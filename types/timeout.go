@@ -0,0 +1,60 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultPullTimeout is the time the image of a container has to be pulled when the job sets no limit
+	DefaultPullTimeout = 5 * time.Minute
+	// DefaultStartTimeout is the time a container has to be created and started when the job sets no limit
+	DefaultStartTimeout = time.Minute
+)
+
+// Timeouts are the time limits of a job, the job times out when it hits one of them.
+// pull: The time the image has to be pulled, DefaultPullTimeout when empty
+// start: The time the container has to be created, receive its inputs and start, DefaultStartTimeout when empty
+// run: The time the containers of the job may run in total across restarts, without limit when empty
+type Timeouts struct {
+	Pull  Duration `json:"pull,omitempty"`
+	Start Duration `json:"start,omitempty"`
+	Run   Duration `json:"run,omitempty"`
+}
+
+// Validate validates the timeouts
+func (t Timeouts) Validate() error {
+	if t.Pull < 0 || t.Start < 0 || t.Run < 0 {
+		return fmt.Errorf("timeouts must not be negative")
+	}
+	return nil
+}
+
+// PullTimeout returns the time the image of the container has to be pulled
+func (c Container) PullTimeout() time.Duration {
+	if c.Timeouts == nil || c.Timeouts.Pull == 0 {
+		return DefaultPullTimeout
+	}
+	return time.Duration(c.Timeouts.Pull)
+}
+
+// StartTimeout returns the time the container has to be created and started
+func (c Container) StartTimeout() time.Duration {
+	if c.Timeouts == nil || c.Timeouts.Start == 0 {
+		return DefaultStartTimeout
+	}
+	return time.Duration(c.Timeouts.Start)
+}
+
+// RunTimeout returns the time the container may run, zero without limit
+func (c Container) RunTimeout() time.Duration {
+	if c.Timeouts == nil {
+		return 0
+	}
+	return time.Duration(c.Timeouts.Run)
+}
+
+// Expired reports whether the deadline of the container passed, so it must not be started anymore
+func (c Container) Expired(now time.Time) bool {
+	return c.Deadline != nil && now.After(*c.Deadline)
+}
@@ -1,40 +1,218 @@
 package types
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // Container is the object that represents a container to be run.
 // image: The container image to run
 // arguments: The arguments to pass to the container
 // env: The environment variables to set for the job
+// labels: The labels of the job, matched by anti-affinity and spread selectors
+// node_selector: The labels a node must have to run the job
+// affinity: The node label requirements and preferences of the job
+// anti_affinity: The jobs this job must not share a node or domain with
+// spread: The constraints that spread matching jobs over the cluster
+// health_check: The health check of the container
+// restart_policy: Whether the container is restarted once it exited, watched by the node when set
+// outputs: The paths copied out of the container once it exited successfully
+// inputs: The artifacts copied into the container before it starts
+// secrets: The secrets injected into the container as environment variables or files
+// security: The user, capabilities and profiles the container runs with
+// timeouts: The time limits to pull, start and run the container
+// deadline: The time after which the job is dropped instead of started
 type Container struct {
-	Image     string            `json:"image"`
-	Arguments []string          `json:"arguments"`
-	Env       map[string]string `json:"env"`
+	Image         string             `json:"image"`
+	Arguments     []string           `json:"arguments"`
+	Env           map[string]string  `json:"env"`
+	Labels        map[string]string  `json:"labels,omitempty"`
+	NodeSelector  map[string]string  `json:"node_selector,omitempty"`
+	Affinity      *Affinity          `json:"affinity,omitempty"`
+	AntiAffinity  []AntiAffinityTerm `json:"anti_affinity,omitempty"`
+	Spread        []SpreadConstraint `json:"spread,omitempty"`
+	HealthCheck   *HealthCheck       `json:"health_check,omitempty"`
+	RestartPolicy *RestartPolicy     `json:"restart_policy,omitempty"`
+	Outputs       []ArtifactOutput   `json:"outputs,omitempty"`
+	Inputs        []ArtifactInput    `json:"inputs,omitempty"`
+	Secrets       []SecretRef        `json:"secrets,omitempty"`
+	Security      *SecurityContext   `json:"security,omitempty"`
+	Timeouts      *Timeouts          `json:"timeouts,omitempty"`
+	Deadline      *time.Time         `json:"deadline,omitempty"`
 }
 
 func (c Container) Validate() error {
 	if c.Image == "" {
 		return fmt.Errorf("image is required")
 	}
-	return nil
+	if c.Affinity != nil {
+		if err := c.Affinity.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, term := range c.AntiAffinity {
+		if err := term.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, constraint := range c.Spread {
+		if err := constraint.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.HealthCheck != nil {
+		if err := c.HealthCheck.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.RestartPolicy != nil {
+		if err := c.RestartPolicy.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Timeouts != nil {
+		if err := c.Timeouts.Validate(); err != nil {
+			return err
+		}
+	}
+	if len(c.Outputs) > 0 && (c.RestartPolicy == nil || c.RestartPolicy.Name == RestartPolicyAlways) {
+		return fmt.Errorf("outputs require the never or on-failure restart policy")
+	}
+	if err := validateSecrets(c.Secrets, c.Env); err != nil {
+		return err
+	}
+	if c.Security != nil {
+		if err := c.Security.Validate(); err != nil {
+			return err
+		}
+		if c.Security.ReadOnlyRootFS && len(c.Inputs) > 0 {
+			return fmt.Errorf("inputs require a writable root filesystem")
+		}
+	}
+	return validateArtifacts(c.Outputs, c.Inputs)
 }
 
 type JobStatus string
 
 const (
-	JobStatusPending  JobStatus = "pending"
-	JobStatusComplete JobStatus = "complete"
-	JobStatusFailed   JobStatus = "failed"
+	JobStatusPending   JobStatus = "pending"
+	JobStatusRunning   JobStatus = "running"
+	JobStatusComplete  JobStatus = "complete"
+	JobStatusFailed    JobStatus = "failed"
+	JobStatusCancelled JobStatus = "cancelled"
+	JobStatusTimedOut  JobStatus = "timed_out"
 )
 
 func (js JobStatus) String() string {
 	return string(js)
 }
 
+// Active reports whether the job is waiting to run or running
+func (js JobStatus) Active() bool {
+	return js == JobStatusPending || js == JobStatusRunning
+}
+
+// Failed reports whether the job failed or timed out
+func (js JobStatus) Failed() bool {
+	return js == JobStatusFailed || js == JobStatusTimedOut
+}
+
+// Job is the record of a job known to the node.
+// id: The ID of the job
+// container: The container the job runs
+// status: The status of the job
+// node: The ID of the node that owns the job
+// origin: The ID of the node that handed the job to the node that owns it, empty for jobs submitted to their node
+// container_id: The ID of the container once it is deployed
+// health: The health of the container, empty without a health check
+// restarts: The number of times the container of the job was restarted
+// crashes: The number of consecutive restarts of containers that stopped shortly after they started
+// exit_code: The exit code of the last container of the job that exited
+// started_at: The time the current container of the job started
+// run_time: The time the previous containers of the job ran, the run timeout covers every container of the job
+// artifacts: The outputs collected from the container once it exited successfully
+// usage: The resource use of the containers of the job that ended, once one did
+type Job struct {
+	ID          string         `json:"id"`
+	Container   Container      `json:"container"`
+	Status      JobStatus      `json:"status"`
+	Node        string         `json:"node"`
+	Origin      string         `json:"origin,omitempty"`
+	ContainerID string         `json:"container_id,omitempty"`
+	Health      HealthStatus   `json:"health,omitempty"`
+	Restarts    int            `json:"restarts,omitempty"`
+	Crashes     int            `json:"crashes,omitempty"`
+	ExitCode    int            `json:"exit_code,omitempty"`
+	StartedAt   time.Time      `json:"started_at,omitempty"`
+	RunTime     Duration       `json:"run_time,omitempty"`
+	Artifacts   []Artifact     `json:"artifacts,omitempty"`
+	Usage       *ResourceUsage `json:"usage,omitempty"`
+}
+
+// RunningFor returns the time the containers of the job ran, including the current one
+func (j Job) RunningFor() time.Duration {
+	running := time.Duration(j.RunTime)
+	if j.ContainerID != "" && !j.StartedAt.IsZero() {
+		running += time.Since(j.StartedAt)
+	}
+	return running
+}
+
+// JobStatusUpdate is the status of a job reported by the node that owns it to the node that handed the job to it.
+// It leaves out the container of the job, so its env and inputs aren't sent back.
+// id: The ID of the job
+// status: The status of the job
+// node: The ID of the node that owns the job
+// exit_code: The exit code of the last container of the job that exited
+// health: The health of the container, for the readiness of service replicas
+// restarts: The number of times the container of the job was restarted
+// artifacts: The outputs collected from the container, for the steps of workflows that take them as inputs
+// usage: The resource use of the containers of the job that ended, for the clients of the node that handed it over
+type JobStatusUpdate struct {
+	ID        string         `json:"id"`
+	Status    JobStatus      `json:"status"`
+	Node      string         `json:"node"`
+	ExitCode  int            `json:"exit_code,omitempty"`
+	Health    HealthStatus   `json:"health,omitempty"`
+	Restarts  int            `json:"restarts,omitempty"`
+	Artifacts []Artifact     `json:"artifacts,omitempty"`
+	Usage     *ResourceUsage `json:"usage,omitempty"`
+}
+
+// StatusUpdate returns the status of the job to report to the node that handed it over
+func (j Job) StatusUpdate() JobStatusUpdate {
+	return JobStatusUpdate{
+		ID:        j.ID,
+		Status:    j.Status,
+		Node:      j.Node,
+		ExitCode:  j.ExitCode,
+		Health:    j.Health,
+		Restarts:  j.Restarts,
+		Artifacts: j.Artifacts,
+		Usage:     j.Usage,
+	}
+}
+
+// Monitored reports whether the container of the job is watched for as long as it runs,
+// which is the case for service replicas and containers with a restart policy or a run timeout
+func (j Job) Monitored() bool {
+	return j.Container.Labels[ServiceLabel] != "" || j.Container.RestartPolicy != nil || j.Container.RunTimeout() > 0
+}
+
+// Ready reports whether the job is running and its container passes its health check
+func (j Job) Ready() bool {
+	return j.Status == JobStatusRunning && (j.Health == "" || j.Health == HealthStatusHealthy)
+}
+
 type P2PMessageType string
 
 const (
 	P2PMessageTypeDeployContainer P2PMessageType = "deploy_container"
+	P2PMessageTypeJobStatus       P2PMessageType = "job_status"
+	P2PMessageTypeCapacity        P2PMessageType = "capacity"
+	P2PMessageTypeCancelJob       P2PMessageType = "cancel_job"
+	P2PMessageTypeServiceSpec     P2PMessageType = "service_spec"
+	P2PMessageTypeServiceReplica  P2PMessageType = "service_replica"
 )
 
 func (pm P2PMessageType) String() string {
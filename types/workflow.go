@@ -0,0 +1,235 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// WorkflowLabel is the label that identifies the steps of a workflow
+	WorkflowLabel = "workflow.id"
+	// WorkflowStepLabel is the label with the name of the step a job runs
+	WorkflowStepLabel = "workflow.step"
+)
+
+// StepCondition is the outcome of an upstream step a step waits for
+type StepCondition string
+
+const (
+	StepConditionSuccess StepCondition = "success"
+	StepConditionFailure StepCondition = "failure"
+	StepConditionAlways  StepCondition = "always"
+)
+
+// StepStatus is the status of a step of a workflow
+type StepStatus string
+
+const (
+	StepStatusWaiting   StepStatus = "waiting"
+	StepStatusPending   StepStatus = "pending"
+	StepStatusRunning   StepStatus = "running"
+	StepStatusComplete  StepStatus = "complete"
+	StepStatusFailed    StepStatus = "failed"
+	StepStatusSkipped   StepStatus = "skipped"
+	StepStatusCancelled StepStatus = "cancelled"
+	StepStatusTimedOut  StepStatus = "timed_out"
+)
+
+// Finished reports whether the step won't change anymore
+func (ss StepStatus) Finished() bool {
+	switch ss {
+	case StepStatusComplete, StepStatusFailed, StepStatusSkipped, StepStatusCancelled, StepStatusTimedOut:
+		return true
+	default:
+		return false
+	}
+}
+
+// Failed reports whether the step failed or timed out
+func (ss StepStatus) Failed() bool {
+	return ss == StepStatusFailed || ss == StepStatusTimedOut
+}
+
+// WorkflowStatus is the aggregate status of a workflow
+type WorkflowStatus string
+
+const (
+	WorkflowStatusRunning   WorkflowStatus = "running"
+	WorkflowStatusComplete  WorkflowStatus = "complete"
+	WorkflowStatusFailed    WorkflowStatus = "failed"
+	WorkflowStatusCancelled WorkflowStatus = "cancelled"
+)
+
+// StepDependency is an edge of a workflow, the step runs once the upstream step finished with the condition.
+// step: The name of the upstream step
+// condition: The outcome of the upstream step, success when empty
+type StepDependency struct {
+	Step      string        `json:"step"`
+	Condition StepCondition `json:"condition,omitempty"`
+}
+
+// Validate validates the dependency
+func (sd StepDependency) Validate() error {
+	switch sd.Condition {
+	case "", StepConditionSuccess, StepConditionFailure, StepConditionAlways:
+		return nil
+	default:
+		return fmt.Errorf("unknown step condition %q", sd.Condition)
+	}
+}
+
+// Allows reports whether the dependency lets its step run once the upstream step finished with the status
+func (sd StepDependency) Allows(status StepStatus) bool {
+	switch sd.Condition {
+	case StepConditionFailure:
+		return status.Failed()
+	case StepConditionAlways:
+		return status == StepStatusComplete || status.Failed()
+	default:
+		return status == StepStatusComplete
+	}
+}
+
+// WorkflowStep is a container of a workflow, run once its upstream steps finished.
+// name: The name of the step, unique in the workflow
+// container: The container the step runs, it finishes when the container exits
+// depends_on: The upstream steps and the outcome they must finish with
+// job_id: The ID of the job that runs the step once it was released
+// node: The ID of the node that runs the step
+// status: The status of the step
+type WorkflowStep struct {
+	Name      string           `json:"name"`
+	Container Container        `json:"container"`
+	DependsOn []StepDependency `json:"depends_on,omitempty"`
+	JobID     string           `json:"job_id,omitempty"`
+	Node      string           `json:"node,omitempty"`
+	Status    StepStatus       `json:"status"`
+}
+
+// WithDefaults returns the step with the never restart policy when its container has none,
+// so the step finishes when its container exits
+func (ws WorkflowStep) WithDefaults() WorkflowStep {
+	if ws.Container.RestartPolicy == nil {
+		ws.Container.RestartPolicy = &RestartPolicy{Name: RestartPolicyNever}
+	}
+	return ws
+}
+
+// dependsOn reports whether the step depends on the upstream step
+func (ws WorkflowStep) dependsOn(name string) bool {
+	for _, dependency := range ws.DependsOn {
+		if dependency.Step == name {
+			return true
+		}
+	}
+	return false
+}
+
+// outputs reports whether the step declares the output
+func (ws WorkflowStep) outputs(name string) bool {
+	for _, output := range ws.Container.Outputs {
+		if output.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Workflow is a DAG of container steps run by the node it was submitted to.
+// id: The ID of the workflow
+// steps: The steps of the workflow
+// status: The aggregate status of the workflow
+// created_at: The time the workflow was submitted
+// finished_at: The time the workflow finished or was cancelled
+type Workflow struct {
+	ID         string         `json:"id"`
+	Steps      []WorkflowStep `json:"steps"`
+	Status     WorkflowStatus `json:"status"`
+	CreatedAt  time.Time      `json:"created_at"`
+	FinishedAt time.Time      `json:"finished_at,omitempty"`
+}
+
+// Validate validates the steps of the workflow and that their dependencies form a DAG
+func (w Workflow) Validate() error {
+	if len(w.Steps) == 0 {
+		return fmt.Errorf("workflow requires at least one step")
+	}
+
+	steps := make(map[string]WorkflowStep, len(w.Steps))
+	for _, step := range w.Steps {
+		if !serviceNamePattern.MatchString(step.Name) {
+			return fmt.Errorf("invalid step name %q", step.Name)
+		}
+		if _, ok := steps[step.Name]; ok {
+			return fmt.Errorf("duplicate step %q", step.Name)
+		}
+		if err := step.WithDefaults().Container.Validate(); err != nil {
+			return fmt.Errorf("invalid step %q: %w", step.Name, err)
+		}
+		if policy := step.Container.RestartPolicy; policy != nil && policy.Name == RestartPolicyAlways {
+			return fmt.Errorf("step %q never finishes with the always restart policy", step.Name)
+		}
+		steps[step.Name] = step
+	}
+
+	for _, step := range w.Steps {
+		for _, dependency := range step.DependsOn {
+			if _, ok := steps[dependency.Step]; !ok {
+				return fmt.Errorf("step %q depends on unknown step %q", step.Name, dependency.Step)
+			}
+			if err := dependency.Validate(); err != nil {
+				return fmt.Errorf("invalid step %q: %w", step.Name, err)
+			}
+		}
+		for _, input := range step.Container.Inputs {
+			if input.Step != "" && !step.dependsOn(input.Step) {
+				return fmt.Errorf("step %q takes an input of step %q it doesn't depend on", step.Name, input.Step)
+			}
+			if input.Step != "" && !steps[input.Step].outputs(input.Name) {
+				return fmt.Errorf("step %q has no output %q", input.Step, input.Name)
+			}
+		}
+	}
+
+	// depth-first search for cycles, visiting marks steps on the current path
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int, len(w.Steps))
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("workflow has a cycle through step %q", name)
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, dependency := range steps[name].DependsOn {
+			if err := visit(dependency.Step); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for _, step := range w.Steps {
+		if err := visit(step.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StepContainer returns the container of a step with the defaults of the step and the labels of the workflow
+func (w Workflow) StepContainer(step WorkflowStep) Container {
+	container := step.WithDefaults().Container
+	container.Labels = make(map[string]string, len(step.Container.Labels)+2)
+	for key, value := range step.Container.Labels {
+		container.Labels[key] = value
+	}
+	container.Labels[WorkflowLabel] = w.ID
+	container.Labels[WorkflowStepLabel] = step.Name
+	return container
+}